@@ -0,0 +1,82 @@
+package pbft
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// errAggregatedSealUnverifiable is returned if a sealed proposal carries an aggregated seal,
+// but the backend cannot verify it since it does not implement SealAggregator
+var errAggregatedSealUnverifiable = errors.New("the backend cannot verify the aggregated seal")
+
+// SealAggregator is a Backend which aggregates the committed seals into a single seal (i.e. BLS signatures).
+// It is used if the seal aggregation is enabled in the config
+type SealAggregator interface {
+	Backend
+
+	// AggregateSeals aggregates the committed seals, sorted by their signers, into a single seal
+	AggregateSeals(seals [][]byte) ([]byte, error)
+
+	// VerifyAggregatedSeal checks the seal aggregated from the committed seals of the signers (sorted by NodeID)
+	// over the proposal hash. The nodes syncing or catching up with a proposal verify its seal with it
+	VerifyAggregatedSeal(signers []NodeID, seal, hash []byte) error
+}
+
+// sealAggregator returns the backend as a SealAggregator if the seal aggregation is enabled
+func (p *Pbft) sealAggregator() (SealAggregator, bool) {
+	if !p.config.AggregateSeals {
+		return nil, false
+	}
+	aggregator, ok := p.backend.(SealAggregator)
+	if !ok {
+		p.logger.Warn("seal aggregation is enabled but the backend does not support it")
+	}
+	return aggregator, ok
+}
+
+// aggregateSeals aggregates the verified committed seals if the seal aggregation is enabled.
+// It returns false if the seals are not aggregated, in which case they are kept individually
+func (p *Pbft) aggregateSeals(seals []CommittedSeal) ([]byte, bool, error) {
+	aggregator, ok := p.sealAggregator()
+	if !ok {
+		return nil, false, nil
+	}
+
+	sorted := append([]CommittedSeal{}, seals...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].NodeID < sorted[j].NodeID })
+
+	signatures := make([][]byte, 0, len(sorted))
+	for _, seal := range sorted {
+		signatures = append(signatures, seal.Signature)
+	}
+	aggregated, err := aggregator.AggregateSeals(signatures)
+	if err != nil {
+		return nil, false, err
+	}
+	return aggregated, true, nil
+}
+
+// verifyAggregatedSeal checks the aggregated seal of the proposal. Its committers have to be
+// distinct validators forming a quorum, and the backend checks the seal was aggregated from theirs
+func (p *Pbft) verifyAggregatedSeal(pp *SealedProposal, validators ValidatorSet) error {
+	aggregator, ok := p.backend.(SealAggregator)
+	if !ok {
+		return errAggregatedSealUnverifiable
+	}
+
+	committers := map[NodeID]CommittedSeal{}
+	for i, id := range pp.Committers {
+		if i > 0 && pp.Committers[i-1] >= id {
+			return errors.New("committers are not sorted nor distinct")
+		}
+		if !validators.Includes(id) {
+			return fmt.Errorf("committer %s is not a validator", id)
+		}
+		committers[id] = CommittedSeal{NodeID: id}
+	}
+	if !hasValidatorsQuorum(validators, committers) {
+		return errInsufficientCommittedSeals
+	}
+	return aggregator.VerifyAggregatedSeal(pp.Committers, pp.AggregatedSeal, pp.Proposal.Hash)
+}
@@ -0,0 +1,234 @@
+package pbft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockAggregatorBackend is a mockBackend which aggregates the seals by concatenating them
+type mockAggregatorBackend struct {
+	*mockBackend
+
+	aggregateErr error
+	inserted     []*SealedProposal
+}
+
+func (m *mockAggregatorBackend) AggregateSeals(seals [][]byte) ([]byte, error) {
+	if m.aggregateErr != nil {
+		return nil, m.aggregateErr
+	}
+	return bytes.Join(seals, nil), nil
+}
+
+// VerifyAggregatedSeal expects the seals of the signers (their ids) concatenated
+func (m *mockAggregatorBackend) VerifyAggregatedSeal(signers []NodeID, seal, hash []byte) error {
+	expected := []byte{}
+	for _, id := range signers {
+		expected = append(expected, id...)
+	}
+	if !bytes.Equal(seal, expected) {
+		return errors.New("invalid aggregated seal")
+	}
+	return nil
+}
+
+func (m *mockAggregatorBackend) Insert(pp *SealedProposal) error {
+	m.inserted = append(m.inserted, pp)
+	return nil
+}
+
+func TestTransition_CommitState_AggregatedSeals(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.config.AggregateSeals = true
+	backend := &mockAggregatorBackend{mockBackend: newMockBackend([]string{"A", "B", "C", "D"}, m)}
+	require.NoError(t, m.SetBackend(backend))
+
+	m.state.view = ViewMsg(1, 0)
+	m.state.proposer = "A"
+	m.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+	for _, from := range []NodeID{"C", "A", "B"} {
+		m.addMessage(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte(from)})
+	}
+	m.setState(CommitState)
+
+	m.runCycle(context.Background())
+	assert.True(t, m.IsState(DoneState))
+
+	// the aggregated seal is inserted instead of the individual ones
+	require.Len(t, backend.inserted, 1)
+	inserted := backend.inserted[0]
+	assert.Equal(t, []byte("ABC"), inserted.AggregatedSeal)
+	assert.Empty(t, inserted.CommittedSeals)
+	assert.Equal(t, []NodeID{"A", "B", "C"}, inserted.Committers)
+
+	// the nodes syncing it verify the aggregated seal against its committers
+	assert.NoError(t, m.verifySealedProposal(inserted, 1, m.state.validators))
+}
+
+// The aggregated seal of a synced proposal has to be aggregated from the seals of a quorum of the validators.
+func TestPbft_VerifyAggregatedSeal(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "D")
+	defer m.Close()
+	backend := &mockAggregatorBackend{mockBackend: newMockBackend([]string{"A", "B", "C", "D"}, m)}
+	require.NoError(t, m.SetBackend(backend))
+
+	cases := []struct {
+		name       string
+		committers []NodeID
+		seal       []byte
+		err        bool
+	}{
+		{"quorum", []NodeID{"A", "B", "C"}, []byte("ABC"), false},
+		{"forged seal", []NodeID{"A", "B", "C"}, []byte("ABD"), true},
+		{"no quorum", []NodeID{"A", "B"}, []byte("AB"), true},
+		{"duplicated committer", []NodeID{"A", "B", "B"}, []byte("ABB"), true},
+		{"unsorted committers", []NodeID{"B", "A", "C"}, []byte("BAC"), true},
+		{"not a validator", []NodeID{"A", "B", "E"}, []byte("ABE"), true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pp := &SealedProposal{
+				Proposal:       &Proposal{Data: mockProposal, Hash: digest},
+				AggregatedSeal: c.seal,
+				Committers:     c.committers,
+				Number:         1,
+			}
+			err := m.verifySealedProposal(pp, 1, m.state.validators)
+			if c.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// a backend which does not aggregate the seals cannot verify them
+	m = newMockPbft(t, []string{"A", "B", "C", "D"}, "D")
+	defer m.Close()
+	pp := &SealedProposal{
+		Proposal:       &Proposal{Data: mockProposal, Hash: digest},
+		AggregatedSeal: []byte("ABC"),
+		Committers:     []NodeID{"A", "B", "C"},
+		Number:         1,
+	}
+	assert.ErrorIs(t, m.verifySealedProposal(pp, 1, m.state.validators), errAggregatedSealUnverifiable)
+}
+
+func TestTransition_CommitState_AggregationDisabled(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	backend := &mockAggregatorBackend{mockBackend: newMockBackend([]string{"A", "B", "C", "D"}, m)}
+	require.NoError(t, m.SetBackend(backend))
+
+	m.state.view = ViewMsg(1, 0)
+	m.state.proposer = "A"
+	m.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+	for _, from := range []NodeID{"C", "A", "B"} {
+		m.addMessage(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte(from)})
+	}
+	m.setState(CommitState)
+
+	m.runCycle(context.Background())
+	assert.True(t, m.IsState(DoneState))
+
+	require.Len(t, backend.inserted, 1)
+	assert.Nil(t, backend.inserted[0].AggregatedSeal)
+	assert.Len(t, backend.inserted[0].CommittedSeals, 3)
+}
+
+func TestTransition_CommitState_AggregationFailed(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.config.AggregateSeals = true
+	backend := &mockAggregatorBackend{mockBackend: newMockBackend([]string{"A", "B", "C", "D"}, m)}
+	require.NoError(t, m.SetBackend(backend))
+	backend.aggregateErr = errors.New("aggregation failed")
+
+	m.state.view = ViewMsg(1, 0)
+	m.state.proposer = "A"
+	m.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+	m.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+	for _, from := range []NodeID{"C", "A", "B"} {
+		m.addMessage(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte(from)})
+	}
+	m.setState(CommitState)
+
+	m.runCycle(context.Background())
+
+	// nothing is inserted and the proposal stays locked
+	assert.Empty(t, backend.inserted)
+	m.expect(expectResult{
+		sequence:   1,
+		state:      RoundChangeState,
+		locked:     true,
+		err:        errSealAggregationFailed,
+		commitMsgs: 3,
+	})
+}
+
+func TestTransition_CommitState_AggregationUnsupported(t *testing.T) {
+	// the backend does not implement SealAggregator, the seals are kept individually
+	var inserted *SealedProposal
+	backend := newMockBackend([]string{"A", "B", "C", "D"}, nil).HookInsertHandler(func(pp *SealedProposal) error {
+		inserted = pp
+		return nil
+	})
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A", backend)
+	defer m.Close()
+	m.config.AggregateSeals = true
+
+	m.state.view = ViewMsg(1, 0)
+	m.state.proposer = "A"
+	m.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+	for _, from := range []NodeID{"A", "B", "C"} {
+		m.addMessage(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+	m.setState(CommitState)
+
+	m.runCycle(context.Background())
+	assert.True(t, m.IsState(DoneState))
+	require.NotNil(t, inserted)
+	assert.Nil(t, inserted.AggregatedSeal)
+	assert.Len(t, inserted.CommittedSeals, 3)
+}
+
+// The proposal committed with an aggregated seal is attached as a commit certificate, and the nodes catch up with it.
+func TestPbft_FastCatchUp_AggregatedSeal(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.config.FastCatchUp = true
+	backend := &mockAggregatorBackend{mockBackend: newMockBackend([]string{"A", "B", "C", "D"}, m)}
+	require.NoError(t, m.SetBackend(backend))
+
+	m.state.view = ViewMsg(2, 0)
+	m.forks.commit(&SealedProposal{
+		Proposal:       &Proposal{Data: mockProposal, Hash: digest},
+		AggregatedSeal: []byte("ACD"),
+		Committers:     []NodeID{"A", "C", "D"},
+		Proposer:       "A",
+		Number:         1,
+	})
+
+	cert := m.commitCertificate()
+	require.NotNil(t, cert)
+	assert.Empty(t, cert.CommittedSeals)
+	assert.Equal(t, []byte("ACD"), cert.AggregatedSeal)
+	assert.NoError(t, m.verifySealedProposal(cert, 1, m.state.validators))
+}
+
+func TestPbft_SealAggregation_Config(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A")
+
+	p := New(pool.get("A"), &mockPbft{})
+	assert.False(t, p.config.AggregateSeals)
+
+	p = New(pool.get("A"), &mockPbft{}, WithSealAggregation(true))
+	assert.True(t, p.config.AggregateSeals)
+}
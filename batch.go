@@ -0,0 +1,138 @@
+package pbft
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+)
+
+// BatchBackend is a Backend which agrees on an ordered batch of items per sequence instead of a single opaque proposal.
+// It is used if the batch proposals are enabled in the config
+type BatchBackend interface {
+	Backend
+
+	// BuildBatchProposal builds a proposal whose data is a batch encoded with the configured BatchCodec (used if proposer)
+	BuildBatchProposal(ctx context.Context) (*Proposal, error)
+
+	// ValidateBatch validates the items of a batch proposal (used if non-proposer)
+	ValidateBatch(items [][]byte) error
+}
+
+// BatchCodec encodes a batch of items into the data of a proposal and decodes it back
+type BatchCodec interface {
+	Encode(items [][]byte) ([]byte, error)
+	Decode(data []byte) ([][]byte, error)
+}
+
+// LengthPrefixBatchCodec encodes every item of the batch prefixed by its length as a big endian uint32
+type LengthPrefixBatchCodec struct {
+}
+
+// Encode implements BatchCodec interface
+func (l *LengthPrefixBatchCodec) Encode(items [][]byte) ([]byte, error) {
+	data := []byte{}
+	for _, item := range items {
+		if uint64(len(item)) > uint64(^uint32(0)) {
+			return nil, fmt.Errorf("batch item too big: %d bytes", len(item))
+		}
+		size := make([]byte, 4)
+		binary.BigEndian.PutUint32(size, uint32(len(item)))
+		data = append(data, size...)
+		data = append(data, item...)
+	}
+	return data, nil
+}
+
+// Decode implements BatchCodec interface
+func (l *LengthPrefixBatchCodec) Decode(data []byte) ([][]byte, error) {
+	items := [][]byte{}
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("truncated batch item length")
+		}
+		size := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		if uint64(len(data)) < uint64(size) {
+			return nil, fmt.Errorf("truncated batch item: expected %d bytes, found %d", size, len(data))
+		}
+		items = append(items, data[:size])
+		data = data[size:]
+	}
+	return items, nil
+}
+
+// batchBackend returns the backend as a BatchBackend if the batch proposals are enabled
+func (p *Pbft) batchBackend() (BatchBackend, bool) {
+	if !p.config.BatchProposals {
+		return nil, false
+	}
+	backend, ok := p.backend.(BatchBackend)
+	if !ok {
+		p.logger.Warn("batch proposals are enabled but the backend does not support them")
+	}
+	return backend, ok
+}
+
+// buildProposal builds a new proposal, as a batch if the batch proposals are enabled
+func (p *Pbft) buildProposal() (*Proposal, error) {
+	var proposal *Proposal
+	var err error
+
+	ctx, parent, err := p.chainContext()
+	if err != nil {
+		return nil, err
+	}
+	backend, ok := p.batchBackend()
+	if ok {
+		proposal, err = backend.BuildBatchProposal(ctx)
+	} else {
+		proposal, err = p.backend.BuildProposal(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if proposal == nil {
+		// an empty proposal has to be built explicitly, with an empty Data
+		return nil, errNoProposal
+	}
+	if parent != nil && proposal.ParentHash == nil {
+		proposal.ParentHash = append([]byte{}, parent...)
+	}
+	if ok {
+		if _, err := p.config.BatchCodec.Decode(proposal.Data); err != nil {
+			return nil, fmt.Errorf("invalid batch proposal: %w", err)
+		}
+	}
+	return proposal, nil
+}
+
+// validateProposal validates a proposal received from the proposer. If the batch
+// proposals are enabled, the items of the batch are validated as well.
+// A proposal which passed the validation already is not validated again (see ValidationCacheSize)
+func (p *Pbft) validateProposal(proposal *Proposal) error {
+	// the validation is only reused if the backend computes the hash, otherwise
+	// the hash of a valid proposal could be sent along with another proposal
+	hash, cacheable := p.hashProposalData(proposal.Data)
+	if cacheable && p.validations.contains(hash) {
+		return nil
+	}
+
+	if err := p.backend.Validate(proposal); err != nil {
+		return err
+	}
+
+	if backend, ok := p.batchBackend(); ok {
+		items, err := p.config.BatchCodec.Decode(proposal.Data)
+		if err != nil {
+			return fmt.Errorf("failed to decode batch proposal: %w", err)
+		}
+		if err := backend.ValidateBatch(items); err != nil {
+			return err
+		}
+	}
+
+	if cacheable {
+		p.validations.add(hash)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package pbft
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var mockBatch = [][]byte{[]byte("item1"), []byte("item2"), []byte("item3")}
+
+// mockBatchBackend is a mockBackend which agrees on batch proposals
+type mockBatchBackend struct {
+	*mockBackend
+
+	validatedBatches [][][]byte
+	validateBatchFn  func([][]byte) error
+	inserted         []*SealedProposal
+}
+
+func newMockBatchBackend(m *mockPbft) *mockBatchBackend {
+	backend := newMockBackend([]string{"A", "B", "C", "D"}, m)
+	return &mockBatchBackend{mockBackend: backend}
+}
+
+func (m *mockBatchBackend) BuildBatchProposal(ctx context.Context) (*Proposal, error) {
+	data, err := (&LengthPrefixBatchCodec{}).Encode(mockBatch)
+	if err != nil {
+		return nil, err
+	}
+	return &Proposal{Data: data, Time: time.Now(), Hash: digest}, nil
+}
+
+func (m *mockBatchBackend) ValidateBatch(items [][]byte) error {
+	m.validatedBatches = append(m.validatedBatches, items)
+	if m.validateBatchFn != nil {
+		return m.validateBatchFn(items)
+	}
+	return nil
+}
+
+func (m *mockBatchBackend) Insert(pp *SealedProposal) error {
+	m.inserted = append(m.inserted, pp)
+	return nil
+}
+
+func TestLengthPrefixBatchCodec(t *testing.T) {
+	codec := &LengthPrefixBatchCodec{}
+
+	data, err := codec.Encode(mockBatch)
+	require.NoError(t, err)
+
+	items, err := codec.Decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, mockBatch, items)
+
+	// empty items are preserved
+	data, err = codec.Encode([][]byte{{}, []byte("a")})
+	require.NoError(t, err)
+	items, err = codec.Decode(data)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{{}, []byte("a")}, items)
+
+	// truncated data
+	data, _ = codec.Encode(mockBatch)
+	_, err = codec.Decode(data[:len(data)-1])
+	assert.Error(t, err)
+	_, err = codec.Decode([]byte{0, 0})
+	assert.Error(t, err)
+}
+
+func TestTransition_AcceptState_Proposer_BatchProposal(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.config.BatchProposals = true
+	m.config.BatchCodec = &LengthPrefixBatchCodec{}
+	require.NoError(t, m.SetBackend(newMockBatchBackend(m)))
+	m.setState(AcceptState)
+
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence: 1,
+		outgoing: 2, // preprepare and prepare
+		state:    ValidateState,
+	})
+
+	// the preprepare carries the whole batch
+	items, err := m.config.BatchCodec.Decode(m.respMsg[0].Proposal)
+	require.NoError(t, err)
+	assert.Equal(t, mockBatch, items)
+}
+
+func TestPbft_BatchProposal_ValidatedAndCommitted(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	defer m.Close()
+	m.config.BatchProposals = true
+	m.config.BatchCodec = &LengthPrefixBatchCodec{}
+	backend := newMockBatchBackend(m)
+	require.NoError(t, m.SetBackend(backend))
+	m.setState(AcceptState)
+
+	data, err := m.config.BatchCodec.Encode(mockBatch)
+	require.NoError(t, err)
+
+	m.emitMsg(&MessageReq{
+		From:     "A",
+		Type:     MessageReq_Preprepare,
+		Proposal: data,
+		Hash:     digest,
+		View:     ViewMsg(1, 0),
+	})
+	m.runCycle(context.Background())
+
+	// the three items were validated together
+	require.Len(t, backend.validatedBatches, 1)
+	assert.Equal(t, mockBatch, backend.validatedBatches[0])
+	assert.True(t, m.IsState(ValidateState))
+
+	for _, from := range []NodeID{"A", "C"} {
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+	m.runCycle(context.Background())
+	assert.True(t, m.IsState(CommitState))
+
+	m.runCycle(context.Background())
+	assert.True(t, m.IsState(DoneState))
+
+	// the batch is committed atomically in a single sealed proposal
+	require.Len(t, backend.inserted, 1)
+	items, err := m.config.BatchCodec.Decode(backend.inserted[0].Proposal.Data)
+	require.NoError(t, err)
+	assert.Equal(t, mockBatch, items)
+}
+
+func TestTransition_AcceptState_Validator_InvalidBatch(t *testing.T) {
+	cases := []struct {
+		name     string
+		data     func(codec BatchCodec) []byte
+		validate func([][]byte) error
+	}{
+		{
+			"invalid item",
+			func(codec BatchCodec) []byte {
+				data, _ := codec.Encode(mockBatch)
+				return data
+			},
+			func(items [][]byte) error {
+				return errors.New("invalid item")
+			},
+		},
+		{
+			"malformed batch",
+			func(codec BatchCodec) []byte {
+				data, _ := codec.Encode(mockBatch)
+				return data[:len(data)-1]
+			},
+			nil,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+			defer m.Close()
+			m.config.BatchProposals = true
+			m.config.BatchCodec = &LengthPrefixBatchCodec{}
+			backend := newMockBatchBackend(m)
+			backend.validateBatchFn = c.validate
+			require.NoError(t, m.SetBackend(backend))
+			m.setState(AcceptState)
+
+			m.emitMsg(&MessageReq{
+				From:     "A",
+				Type:     MessageReq_Preprepare,
+				Proposal: c.data(m.config.BatchCodec),
+				Hash:     digest,
+				View:     ViewMsg(1, 0),
+			})
+			m.runCycle(context.Background())
+
+			m.expect(expectResult{
+				sequence: 1,
+				state:    RoundChangeState,
+			})
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package pbft
+
+import (
+	"sync"
+	"time"
+)
+
+// peerBlacklist ignores for a while the messages of the peers which sent repeated invalid messages
+// (i.e. invalid relayed votes or conflicting proposals), so that the node does not waste cycles on them.
+// Only the authenticated senders are reported, otherwise anyone could get a validator blacklisted.
+// It only filters what the node ingests, a blacklisted validator still counts toward the quorum of the other nodes
+type peerBlacklist struct {
+	lock sync.Mutex
+
+	// threshold is the number of invalid messages which blacklist a peer
+	threshold int
+
+	// duration is how long a peer is blacklisted for (0 means disabled), as well as how long its strikes last
+	duration time.Duration
+
+	strikes map[NodeID]*strikes
+	until   map[NodeID]time.Time
+}
+
+// strikes are the invalid messages of a peer since the first one, they expire once the blacklist duration elapses
+type strikes struct {
+	count int
+	since time.Time
+}
+
+func newPeerBlacklist(threshold int, duration time.Duration) *peerBlacklist {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &peerBlacklist{
+		threshold: threshold,
+		duration:  duration,
+		strikes:   map[NodeID]*strikes{},
+		until:     map[NodeID]time.Time{},
+	}
+}
+
+// offend records an invalid message from the peer. It returns true if the peer is blacklisted because of it
+func (b *peerBlacklist) offend(id NodeID, now time.Time) bool {
+	if b.duration <= 0 {
+		return false
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	peer, ok := b.strikes[id]
+	if !ok || !now.Before(peer.since.Add(b.duration)) {
+		// the old strikes expired, the peer starts over
+		peer = &strikes{since: now}
+		b.strikes[id] = peer
+	}
+	peer.count++
+	if peer.count < b.threshold {
+		return false
+	}
+	delete(b.strikes, id)
+	b.until[id] = now.Add(b.duration)
+	return true
+}
+
+// isBlacklisted checks if the messages of the peer have to be ignored, the expired entries are removed
+func (b *peerBlacklist) isBlacklisted(id NodeID, now time.Time) bool {
+	if b.duration <= 0 {
+		return false
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	until, ok := b.until[id]
+	if !ok {
+		return false
+	}
+	if !now.Before(until) {
+		delete(b.until, id)
+		return false
+	}
+	return true
+}
+
+// reportInvalid records an invalid message from the sender, blacklisting it once it sent too many of them
+func (p *Pbft) reportInvalid(from NodeID) {
+	if from == p.validator.NodeID() {
+		// our own messages are never ignored
+		return
+	}
+	if p.blacklist.offend(from, p.clock.Now()) {
+		p.logger.Warn("too many invalid messages, ignoring the peer", "from", from, "duration", p.config.BlacklistDuration)
+	}
+}
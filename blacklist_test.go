@@ -0,0 +1,100 @@
+package pbft
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerBlacklist(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newPeerBlacklist(2, time.Minute)
+
+	assert.False(t, b.offend("B", now))
+	assert.False(t, b.isBlacklisted("B", now))
+	assert.True(t, b.offend("B", now))
+	assert.True(t, b.isBlacklisted("B", now))
+	assert.False(t, b.isBlacklisted("C", now))
+
+	// the entry expires after the duration
+	assert.True(t, b.isBlacklisted("B", now.Add(time.Minute-time.Nanosecond)))
+	assert.False(t, b.isBlacklisted("B", now.Add(time.Minute)))
+
+	// and the strikes start over
+	assert.False(t, b.offend("B", now.Add(time.Minute)))
+
+	// the strikes expire after the duration too
+	assert.False(t, b.offend("C", now))
+	assert.False(t, b.offend("C", now.Add(time.Minute)))
+	assert.False(t, b.isBlacklisted("C", now.Add(time.Minute)))
+	assert.True(t, b.offend("C", now.Add(2*time.Minute-time.Nanosecond)))
+
+	// a zero duration disables it
+	disabled := newPeerBlacklist(1, 0)
+	assert.False(t, disabled.offend("B", now))
+	assert.False(t, disabled.isBlacklisted("B", now))
+}
+
+// A peer sending repeated invalid messages is ignored until its blacklisting expires. The messages
+// which fail the verification are not charged to their claimed sender, anyone could send them.
+func TestPbft_Blacklist(t *testing.T) {
+	clock := newManualClock()
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.clock = clock
+	m.config.BlacklistDuration = time.Minute
+	m.blacklist = newPeerBlacklist(3, time.Minute)
+	m.msgVerifier = func(msg *MessageReq) error {
+		if msg.Seal != nil {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+
+	prepare := func(from NodeID, votes ...*MessageReq) {
+		m.emitMsg(&MessageReq{
+			From:  from,
+			Type:  MessageReq_Prepare,
+			View:  ViewMsg(1, 0),
+			Votes: votes,
+		})
+	}
+
+	// the forged messages on behalf of C do not get it blacklisted
+	for i := 0; i < 3; i++ {
+		m.emitMsg(&MessageReq{From: "C", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+	require.False(t, m.blacklist.isBlacklisted("C", clock.Now()))
+
+	// whereas the authenticated messages of C with invalid votes do
+	for i := 0; i < 3; i++ {
+		prepare("C", &MessageReq{From: "D", Type: MessageReq_Prepare, View: ViewMsg(1, 1)})
+	}
+	require.True(t, m.blacklist.isBlacklisted("C", clock.Now()))
+	require.False(t, m.blacklist.isBlacklisted("B", clock.Now()))
+	require.Empty(t, m.QueueDepths())
+
+	// the valid messages of the blacklisted peer are ignored
+	prepare("C")
+	assert.Empty(t, m.QueueDepths())
+
+	prepare("B")
+	assert.Equal(t, 1, m.QueueDepths()[MessageReq_Prepare])
+
+	// until the blacklisting expires
+	clock.Advance(time.Minute)
+	prepare("C")
+	assert.Equal(t, 2, m.QueueDepths()[MessageReq_Prepare])
+}
+
+// The node never blacklists itself.
+func TestPbft_Blacklist_Self(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.config.BlacklistDuration = time.Minute
+	m.blacklist = newPeerBlacklist(1, time.Minute)
+
+	m.reportInvalid("A")
+	assert.False(t, m.blacklist.isBlacklisted("A", m.clock.Now()))
+}
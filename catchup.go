@@ -0,0 +1,77 @@
+package pbft
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// commitCertificate returns the proposal committed in the previous sequence, to attach to the preprepare
+// message if the fast catch-up is enabled
+func (p *Pbft) commitCertificate() *SealedProposal {
+	sequence := p.state.view.Sequence
+	if !p.config.FastCatchUp || sequence == 0 {
+		return nil
+	}
+	pp := p.forks.lookup(sequence - 1)
+	if pp == nil || pp.Proposal == nil || (len(pp.CommittedSeals) == 0 && pp.AggregatedSeal == nil) {
+		return nil
+	}
+	cert := pp.Copy()
+	// the timestamps are local to the node
+	cert.SeenAt, cert.CommittedAt = time.Time{}, time.Time{}
+	return cert
+}
+
+// offerCatchUp keeps the commit certificate received along a preprepare if it is for the
+// sequence being decided, for the state machine to commit it (see tryCatchUp)
+func (p *Pbft) offerCatchUp(pp *SealedProposal) {
+	if !p.config.FastCatchUp {
+		return
+	}
+	if sequence, ok := p.state.getSequence(); !ok || pp.Number != sequence {
+		return
+	}
+
+	p.catchUpLock.Lock()
+	p.catchUp = pp
+	p.catchUpLock.Unlock()
+}
+
+// tryCatchUp inserts the commit certificate of the current sequence if the node received one, and
+// finishes the sequence. It returns false if there is no certificate or it cannot be inserted
+func (p *Pbft) tryCatchUp(span trace.Span) bool {
+	p.catchUpLock.Lock()
+	pp := p.catchUp
+	p.catchUp = nil
+	p.catchUpLock.Unlock()
+
+	sequence := p.state.view.Sequence
+	if pp == nil || pp.Number != sequence {
+		return false
+	}
+	if err := p.verifySealedProposal(pp, sequence, p.state.validators); err != nil {
+		p.logger.Warn("invalid commit certificate", "sequence", sequence, "err", err)
+		return false
+	}
+
+	pp = pp.Copy()
+	pp.SeenAt, pp.CommittedAt = time.Time{}, time.Time{}
+	if err := p.insertWithRetry(pp); err != nil {
+		p.logger.Error("failed to insert the proposal of the commit certificate", "sequence", sequence, "err", err)
+		return false
+	}
+	if err := p.confirmInserted(pp.Number); err != nil {
+		p.logger.Error("failed to confirm the insert of the proposal of the commit certificate", "sequence", sequence, "err", err)
+		return false
+	}
+
+	p.logger.Info("caught up with a commit certificate", "sequence", sequence, "proposer", pp.Proposer)
+	span.AddEvent("CatchUp")
+	if p.state.IsLocked() {
+		p.state.unlock()
+		p.appendWAL(&WALEntry{Type: WALUnlock})
+	}
+	p.completeSequence(pp)
+	return true
+}
@@ -0,0 +1,130 @@
+package pbft
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCommitCertificate(sequence uint64, signers ...NodeID) *SealedProposal {
+	pp := &SealedProposal{
+		Proposal: &Proposal{Data: mockProposal, Hash: digest},
+		Proposer: "A",
+		Number:   sequence,
+	}
+	for _, id := range signers {
+		pp.CommittedSeals = append(pp.CommittedSeals, CommittedSeal{NodeID: id, Signature: []byte(id)})
+	}
+	return pp
+}
+
+// A node one sequence behind commits it with the certificate attached to the preprepare of the next sequence.
+func TestPbft_FastCatchUp(t *testing.T) {
+	inserted := []*SealedProposal{}
+	backend := newMockBackend([]string{"A", "B", "C", "D"}, nil).
+		HookInsertHandler(func(pp *SealedProposal) error {
+			inserted = append(inserted, pp)
+			return nil
+		})
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B", backend)
+	m.config.FastCatchUp = true
+
+	states := []PbftState{}
+	m.config.StateChangeObserver = func(_, to PbftState) {
+		states = append(states, to)
+	}
+	m.setState(AcceptState)
+
+	cert := newCommitCertificate(1, "A", "C", "D")
+	m.emitMsg(&MessageReq{
+		From:              "A",
+		Type:              MessageReq_Preprepare,
+		Proposal:          mockProposal,
+		View:              ViewMsg(2, 0),
+		CommitCertificate: cert,
+	})
+	m.runCycle(context.Background())
+
+	require.True(t, m.IsState(DoneState))
+	require.Len(t, inserted, 1)
+	assert.Equal(t, cert, inserted[0])
+	assert.Equal(t, cert, m.committed)
+
+	// neither a round change nor a sync
+	assert.Equal(t, []PbftState{AcceptState, DoneState}, states)
+
+	// the preprepare is processed in the next sequence
+	assert.Equal(t, 1, m.QueueDepths()[MessageReq_Preprepare])
+}
+
+func TestPbft_FastCatchUp_Ignored(t *testing.T) {
+	testCases := map[string]struct {
+		fastCatchUp bool
+		cert        *SealedProposal
+	}{
+		"disabled":         {false, newCommitCertificate(1, "A", "C", "D")},
+		"no quorum":        {true, newCommitCertificate(1, "A", "C")},
+		"another sequence": {true, newCommitCertificate(2, "A", "C", "D")},
+	}
+	for name, c := range testCases {
+		t.Run(name, func(t *testing.T) {
+			inserted := 0
+			backend := newMockBackend([]string{"A", "B", "C", "D"}, nil).
+				HookInsertHandler(func(*SealedProposal) error {
+					inserted++
+					return nil
+				})
+			m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B", backend)
+			m.config.FastCatchUp = c.fastCatchUp
+			m.setState(AcceptState)
+
+			m.emitMsg(&MessageReq{
+				From:              "A",
+				Type:              MessageReq_Preprepare,
+				Proposal:          mockProposal,
+				View:              ViewMsg(2, 0),
+				CommitCertificate: c.cert,
+			})
+			m.runCycle(context.Background())
+
+			assert.False(t, m.IsState(DoneState))
+			assert.Zero(t, inserted)
+		})
+	}
+}
+
+// The proposer attaches the individual committed seals of the previous sequence to its preprepare.
+func TestPbft_FastCatchUp_ProposerAttachesCertificate(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+		m.config.FastCatchUp = enabled
+		m.state.view = ViewMsg(2, 0)
+
+		committed := newCommitCertificate(1, "A", "C", "D")
+		committed.SeenAt, committed.CommittedAt = time.Now(), time.Now()
+		m.forks.commit(committed)
+
+		m.setState(AcceptState)
+		m.setProposal(&Proposal{
+			Data: mockProposal,
+			Time: time.Now(),
+		})
+		m.runCycle(context.Background())
+
+		require.NotEmpty(t, m.respMsg)
+		preprepare := m.respMsg[0]
+		require.Equal(t, MessageReq_Preprepare, preprepare.Type)
+		if !enabled {
+			assert.Nil(t, preprepare.CommitCertificate)
+			continue
+		}
+		require.NotNil(t, preprepare.CommitCertificate)
+		assert.Equal(t, uint64(1), preprepare.CommitCertificate.Number)
+		assert.Equal(t, committed.CommittedSeals, preprepare.CommitCertificate.CommittedSeals)
+		assert.True(t, preprepare.CommitCertificate.SeenAt.IsZero())
+		assert.True(t, preprepare.CommitCertificate.CommittedAt.IsZero())
+	}
+}
@@ -0,0 +1,67 @@
+package pbft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+)
+
+// errWrongParentHash is returned if a chained proposal does not extend the proposal committed in the previous sequence
+var errWrongParentHash = errors.New("proposal does not extend the committed proposal")
+
+// errUnknownParent is returned if the proposals are chained, but the node does not know the proposal committed
+// in the previous sequence. The node has to sync, since it can neither build on it nor check the proposals against it
+var errUnknownParent = errors.New("proposal committed in the previous sequence is unknown")
+
+type parentHashKey struct{}
+
+// ParentHashFromContext returns the hash of the proposal committed in the previous sequence, which
+// the context of BuildProposal carries if the proposals are chained (see Config.ChainProposals)
+func ParentHashFromContext(ctx context.Context) ([]byte, bool) {
+	parent, ok := ctx.Value(parentHashKey{}).([]byte)
+	return parent, ok
+}
+
+// chainContext returns the context to build the proposal with, and the parent hash it carries (if any)
+func (p *Pbft) chainContext() (context.Context, []byte, error) {
+	if !p.config.ChainProposals {
+		return p.ctx, nil, nil
+	}
+	parent, err := p.parentHash(p.state.view.Sequence)
+	if err != nil {
+		return nil, nil, err
+	}
+	if parent == nil {
+		return p.ctx, nil, nil
+	}
+	return context.WithValue(p.ctx, parentHashKey{}, parent), parent, nil
+}
+
+// parentHash returns the hash of the proposal committed before the height. The first height has no parent (nil),
+// for the others the proposal has to be known, committed by the node itself or in the history of the backend
+func (p *Pbft) parentHash(height uint64) ([]byte, error) {
+	if height <= 1 {
+		return nil, nil
+	}
+	pp := p.forks.lookup(height - 1)
+	if pp == nil || pp.Proposal == nil {
+		return nil, errUnknownParent
+	}
+	return append([]byte{}, pp.Proposal.Hash...), nil
+}
+
+// verifyParentHash checks that the proposal for the height extends the proposal committed before it,
+// if the proposals are chained. It fails with errUnknownParent if the node does not know that proposal
+func (p *Pbft) verifyParentHash(proposal *Proposal, height uint64) error {
+	if !p.config.ChainProposals {
+		return nil
+	}
+	parent, err := p.parentHash(height)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(parent, proposal.ParentHash) {
+		return errWrongParentHash
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package pbft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var parentDigest = []byte{0x9}
+
+// The proposer builds the proposal on top of the committed one, and sends its parent hash along.
+func TestPbft_ChainProposals_Proposer(t *testing.T) {
+	var parent []byte
+	backend := newMockBackend([]string{"A", "B", "C", "D"}, nil).
+		HookBuildProposalHandler(func(ctx context.Context) (*Proposal, error) {
+			parent, _ = ParentHashFromContext(ctx)
+			return &Proposal{Data: mockProposal, Hash: digest, Time: time.Now()}, nil
+		})
+
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A", backend)
+	m.config.ChainProposals = true
+	m.forks.commit(&SealedProposal{
+		Proposal: &Proposal{Data: []byte("first"), Hash: parentDigest},
+		Proposer: "A",
+		Number:   1,
+	})
+	m.state.view = ViewMsg(2, 0)
+	m.setState(AcceptState)
+	m.runCycle(context.Background())
+
+	require.True(t, m.IsState(ValidateState))
+	assert.Equal(t, parentDigest, parent)
+	assert.Equal(t, parentDigest, m.state.proposal.ParentHash)
+
+	require.NotEmpty(t, m.respMsg)
+	assert.Equal(t, MessageReq_Preprepare, m.respMsg[0].Type)
+	assert.Equal(t, parentDigest, m.respMsg[0].ParentHash)
+}
+
+// The proposals which do not extend the committed proposal are rejected.
+func TestPbft_ChainProposals_Validator(t *testing.T) {
+	testCases := map[string]struct {
+		parentHash []byte
+		valid      bool
+	}{
+		"parent":       {parentDigest, true},
+		"wrong parent": {[]byte{0x8}, false},
+		"no parent":    {nil, false},
+	}
+	for name, c := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var validated *Proposal
+			backend := newMockBackend([]string{"A", "B", "C", "D"}, nil).
+				HookValidateHandler(func(proposal *Proposal) error {
+					validated = proposal
+					return nil
+				})
+
+			m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B", backend)
+			m.config.ChainProposals = true
+			m.forks.commit(&SealedProposal{
+				Proposal: &Proposal{Data: []byte("first"), Hash: parentDigest},
+				Proposer: "A",
+				Number:   1,
+			})
+			m.state.view = ViewMsg(2, 0)
+			m.setState(AcceptState)
+			m.emitMsg(&MessageReq{
+				From:       "A",
+				Type:       MessageReq_Preprepare,
+				Proposal:   mockProposal,
+				View:       ViewMsg(2, 0),
+				ParentHash: c.parentHash,
+			})
+			m.runCycle(context.Background())
+
+			if !c.valid {
+				assert.True(t, m.IsState(RoundChangeState))
+				assert.ErrorIs(t, m.LastError(), errWrongParentHash)
+				assert.Nil(t, validated)
+				return
+			}
+			require.True(t, m.IsState(ValidateState))
+			require.NotNil(t, validated)
+			assert.Equal(t, parentDigest, validated.ParentHash)
+		})
+	}
+}
+
+// A node which does not know the proposal committed in the previous sequence can neither build on it
+// nor check the proposals against it, it syncs instead.
+func TestPbft_ChainProposals_UnknownParent(t *testing.T) {
+	t.Run("proposer", func(t *testing.T) {
+		built := false
+		backend := newMockBackend([]string{"A", "B", "C", "D"}, nil).
+			HookBuildProposalHandler(func(ctx context.Context) (*Proposal, error) {
+				built = true
+				return &Proposal{Data: mockProposal, Hash: digest, Time: time.Now()}, nil
+			})
+
+		m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A", backend)
+		m.config.ChainProposals = true
+		m.state.view = ViewMsg(2, 0)
+		m.setState(AcceptState)
+		m.runCycle(context.Background())
+
+		assert.True(t, m.IsState(SyncState))
+		assert.False(t, built)
+		assert.Empty(t, m.respMsg)
+	})
+
+	t.Run("validator", func(t *testing.T) {
+		var validated *Proposal
+		backend := newMockBackend([]string{"A", "B", "C", "D"}, nil).
+			HookValidateHandler(func(proposal *Proposal) error {
+				validated = proposal
+				return nil
+			})
+
+		m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B", backend)
+		m.config.ChainProposals = true
+		m.state.view = ViewMsg(2, 0)
+		m.setState(AcceptState)
+		m.emitMsg(&MessageReq{
+			From:       "A",
+			Type:       MessageReq_Preprepare,
+			Proposal:   mockProposal,
+			View:       ViewMsg(2, 0),
+			ParentHash: parentDigest,
+		})
+		m.runCycle(context.Background())
+
+		assert.True(t, m.IsState(SyncState))
+		assert.Nil(t, validated)
+		assert.Empty(t, m.respMsg)
+	})
+
+	t.Run("first sequence", func(t *testing.T) {
+		m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+		m.config.ChainProposals = true
+
+		// the first proposal has no parent
+		assert.NoError(t, m.verifyParentHash(&Proposal{Hash: digest}, 1))
+		assert.ErrorIs(t, m.verifyParentHash(&Proposal{Hash: digest}, 2), errUnknownParent)
+	})
+}
+
+// The synced proposals have to extend the chain as well.
+func TestPbft_ChainProposals_Synced(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	m.config.ChainProposals = true
+
+	pp := &SealedProposal{
+		Proposal: &Proposal{Data: mockProposal, Hash: digest, ParentHash: parentDigest},
+		CommittedSeals: []CommittedSeal{
+			{NodeID: "A", Signature: []byte("A")},
+			{NodeID: "C", Signature: []byte("C")},
+			{NodeID: "D", Signature: []byte("D")},
+		},
+		Number: 2,
+	}
+	assert.ErrorIs(t, m.verifySealedProposal(pp, 2, m.state.validators), errUnknownParent)
+
+	m.forks.commit(&SealedProposal{
+		Proposal: &Proposal{Data: []byte("first"), Hash: parentDigest},
+		Proposer: "A",
+		Number:   1,
+	})
+	assert.NoError(t, m.verifySealedProposal(pp, 2, m.state.validators))
+
+	pp.Proposal.ParentHash = []byte{0x8}
+	assert.ErrorIs(t, m.verifySealedProposal(pp, 2, m.state.validators), errWrongParentHash)
+}
+
+// A node which restarted without the history of its backend fetches the parent of the
+// first proposal it syncs, and checks the synced proposals against it.
+func TestPbft_ChainProposals_SyncParent(t *testing.T) {
+	var inserted []*SealedProposal
+	accounts := []string{"A", "B", "C", "D"}
+	backend := newMockBackend(accounts, nil).
+		HookVerifyCommittedSealHandler(func(from NodeID, seal, hash []byte) error {
+			// the seal of a node is its id
+			if !bytes.Equal(seal, []byte(from)) {
+				return errors.New("invalid seal")
+			}
+			return nil
+		}).
+		HookInsertHandler(func(pp *SealedProposal) error {
+			inserted = append(inserted, pp)
+			return nil
+		})
+	m := newMockPbft(t, accounts, "D", backend)
+	m.config.ChainProposals = true
+	m.roundTimeout = func(uint64) time.Duration { return time.Hour }
+	m.sequence = 2
+
+	parent := newSyncedProposal(1, "A", "B", "C")
+	parent.Proposal.Hash = parentDigest
+	next := newSyncedProposal(2, "A", "B", "C")
+	next.Proposal.ParentHash = parentDigest
+	wrong := newSyncedProposal(3, "A", "B", "C")
+	wrong.Proposal.ParentHash = []byte{0x8}
+
+	requests := []*HeightRange{}
+	m.gossipFn = func(msg *MessageReq) error {
+		requests = append(requests, msg.Heights)
+		if msg.Heights.From == 1 {
+			m.PushMessage(&MessageReq{From: "A", Type: MessageReq_SyncResponse, View: ViewMsg(1, 0), SealedProposals: []*SealedProposal{parent}})
+			return nil
+		}
+		m.PushMessage(&MessageReq{From: "A", Type: MessageReq_SyncResponse, View: ViewMsg(2, 0), SealedProposals: []*SealedProposal{next, wrong}})
+		return nil
+	}
+
+	require.NoError(t, m.syncFromPeers(context.Background()))
+	require.Len(t, requests, 2)
+	assert.Equal(t, &HeightRange{From: 1, To: 1}, requests[0])
+	assert.Equal(t, uint64(2), requests[1].From)
+
+	// the parent is only recorded, the backend has it already
+	require.Len(t, inserted, 1)
+	assert.Equal(t, uint64(2), inserted[0].Number)
+	assert.NotNil(t, m.forks.lookup(1))
+}
+
+// The proposer seal and the equality of the proposals cover the parent hash.
+func TestPbft_ChainProposals_ParentHashBound(t *testing.T) {
+	view := ViewMsg(2, 0)
+	assert.NotEqual(t,
+		proposerSealDigest(view, digest, parentDigest, mockProposal),
+		proposerSealDigest(view, digest, []byte{0x8}, mockProposal))
+	assert.NotEqual(t,
+		proposerSealDigest(view, digest, parentDigest, mockProposal),
+		proposerSealDigest(view, digest, nil, mockProposal))
+
+	proposal := &Proposal{Data: mockProposal, Hash: digest, ParentHash: parentDigest}
+	assert.True(t, proposal.Equal(&Proposal{Data: mockProposal, Hash: digest, ParentHash: parentDigest}))
+	assert.False(t, proposal.Equal(&Proposal{Data: mockProposal, Hash: digest, ParentHash: []byte{0x8}}))
+}
+
+// Without chaining, the parent hash is neither set nor checked.
+func TestPbft_ChainProposals_Disabled(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	m.state.view = ViewMsg(2, 0)
+
+	ctx, parent, err := m.chainContext()
+	require.NoError(t, err)
+	assert.Nil(t, parent)
+	_, ok := ParentHashFromContext(ctx)
+	assert.False(t, ok)
+	assert.NoError(t, m.verifyParentHash(&Proposal{Hash: digest}, 2))
+}
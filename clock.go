@@ -0,0 +1,57 @@
+package pbft
+
+import "time"
+
+// Clock is the source of time for the state machine. It allows replacing the
+// wall clock with a deterministic one (i.e. in tests)
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// NewTimer creates a timer which sends the current time on its channel once the duration elapsed.
+	// The timer has to be stopped if it is not needed anymore before it fires
+	NewTimer(d time.Duration) Timer
+	// Until returns the duration until t
+	Until(t time.Time) time.Duration
+}
+
+// Timer is a single event timer created by a Clock
+type Timer interface {
+	// C returns the channel the timer sends the current time on once it fires
+	C() <-chan time.Time
+	// Stop prevents the timer from firing. It returns false if the timer already fired or it was stopped
+	Stop() bool
+}
+
+// RealClock is the Clock implementation backed by the system time
+type RealClock struct {
+}
+
+// Now implements Clock interface
+func (r *RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// NewTimer implements Clock interface
+func (r *RealClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{timer: time.NewTimer(d)}
+}
+
+// Until implements Clock interface
+func (r *RealClock) Until(t time.Time) time.Duration {
+	return time.Until(t)
+}
+
+// realTimer is the Timer implementation backed by a time.Timer
+type realTimer struct {
+	timer *time.Timer
+}
+
+// C implements Timer interface
+func (r *realTimer) C() <-chan time.Time {
+	return r.timer.C
+}
+
+// Stop implements Timer interface
+func (r *realTimer) Stop() bool {
+	return r.timer.Stop()
+}
@@ -0,0 +1,330 @@
+package pbft
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// manualClockWaiter is a Timer of the manualClock
+type manualClockWaiter struct {
+	clock    *manualClock
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func (w *manualClockWaiter) C() <-chan time.Time {
+	return w.ch
+}
+
+func (w *manualClockWaiter) Stop() bool {
+	w.clock.lock.Lock()
+	defer w.clock.lock.Unlock()
+
+	for i, waiter := range w.clock.waiters {
+		if waiter == w {
+			w.clock.waiters = append(w.clock.waiters[:i], w.clock.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// manualClock is a Clock which only moves forward when it is advanced by the test
+type manualClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []*manualClockWaiter
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Unix(0, 0)}
+}
+
+func (m *manualClock) Now() time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.now
+}
+
+func (m *manualClock) NewTimer(d time.Duration) Timer {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	w := &manualClockWaiter{clock: m, deadline: m.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		w.ch <- m.now
+		return w
+	}
+	m.waiters = append(m.waiters, w)
+	return w
+}
+
+func (m *manualClock) Until(t time.Time) time.Duration {
+	return t.Sub(m.Now())
+}
+
+// Advance moves the time forward and fires all the expired waiters
+func (m *manualClock) Advance(d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.now = m.now.Add(d)
+
+	pending := m.waiters[:0]
+	for _, w := range m.waiters {
+		if w.deadline.After(m.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- m.now
+	}
+	m.waiters = pending
+}
+
+// numWaiters returns the number of waiters which did not fire yet
+func (m *manualClock) numWaiters() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return len(m.waiters)
+}
+
+func runCycleAsync(m *mockPbft) <-chan struct{} {
+	doneCh := make(chan struct{})
+	go func() {
+		m.runCycle(context.Background())
+		close(doneCh)
+	}()
+	return doneCh
+}
+
+func TestClock_RoundTimeout(t *testing.T) {
+	clock := newManualClock()
+
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.clock = clock
+	m.roundTimeout = func(uint64) time.Duration { return time.Second }
+	m.setRound(0)
+	m.setState(ValidateState)
+
+	doneCh := runCycleAsync(m)
+
+	// the timeout must not fire before the round timeout elapses
+	clock.Advance(time.Second - time.Millisecond)
+	select {
+	case <-doneCh:
+		t.Fatal("timeout fired too early")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, ValidateState, m.getState())
+
+	clock.Advance(time.Millisecond)
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("timeout did not fire")
+	}
+
+	m.expect(expectResult{
+		sequence: 1,
+		state:    RoundChangeState,
+	})
+}
+
+func TestClock_ProposerDelay(t *testing.T) {
+	clock := newManualClock()
+
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.clock = clock
+	m.setState(AcceptState)
+
+	m.setProposal(&Proposal{
+		Data: mockProposal,
+		Time: clock.Now().Add(5 * time.Second),
+	})
+
+	doneCh := runCycleAsync(m)
+
+	// wait for the proposer to start waiting for the proposal time
+	require.Eventually(t, func() bool { return clock.numWaiters() == 1 }, time.Second, time.Millisecond)
+
+	clock.Advance(4 * time.Second)
+	select {
+	case <-doneCh:
+		t.Fatal("proposal gossiped before its time")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("proposal was not gossiped")
+	}
+
+	m.expect(expectResult{
+		sequence: 1,
+		outgoing: 2, // preprepare and prepare
+		state:    ValidateState,
+	})
+}
+
+func TestTransition_AcceptState_Proposer_MaxProposalDelay(t *testing.T) {
+	clock := newManualClock()
+
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.clock = clock
+	m.config.MaxProposalDelay = 2 * time.Second
+	m.setState(AcceptState)
+
+	// the backend sets the proposal time an hour in the future
+	m.setProposal(&Proposal{
+		Data: mockProposal,
+		Time: clock.Now().Add(time.Hour),
+	})
+
+	doneCh := runCycleAsync(m)
+	require.Eventually(t, func() bool { return clock.numWaiters() == 1 }, time.Second, time.Millisecond)
+
+	// the delay is clamped to the maximum
+	clock.Advance(2 * time.Second)
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("the proposal delay was not clamped")
+	}
+
+	m.expect(expectResult{
+		sequence: 1,
+		outgoing: 2, // preprepare and prepare
+		state:    ValidateState,
+	})
+}
+
+func TestTransition_AcceptState_Proposer_ForceTimeout(t *testing.T) {
+	clock := newManualClock()
+
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.clock = clock
+	m.setState(AcceptState)
+
+	m.setProposal(&Proposal{
+		Data: mockProposal,
+		Time: clock.Now().Add(time.Hour),
+	})
+
+	doneCh := runCycleAsync(m)
+	require.Eventually(t, func() bool { return clock.numWaiters() == 1 }, time.Second, time.Millisecond)
+
+	m.ForceTimeout()
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("the proposal delay was not interrupted")
+	}
+	// the timer of the delay is stopped
+	assert.Zero(t, clock.numWaiters())
+
+	// the proposal is not gossiped
+	m.expect(expectResult{
+		sequence: 1,
+		state:    RoundChangeState,
+	})
+}
+
+func TestClock_TimeoutReplaced(t *testing.T) {
+	clock := newManualClock()
+
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.clock = clock
+
+	// every new timeout stops the one it replaces
+	for i := 0; i < 10; i++ {
+		m.setTimeout(time.Second)
+	}
+	assert.Equal(t, 1, clock.numWaiters())
+
+	clock.Advance(time.Second)
+	select {
+	case <-m.state.timeout:
+	default:
+		t.Fatal("the timeout did not fire")
+	}
+	assert.Zero(t, clock.numWaiters())
+}
+
+func TestTransition_ValidateState_ForceTimeout(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.roundTimeout = func(uint64) time.Duration { return time.Hour }
+	m.setRound(0)
+	m.setState(ValidateState)
+
+	doneCh := runCycleAsync(m)
+	m.ForceTimeout()
+
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("the wait for messages was not interrupted")
+	}
+
+	m.expect(expectResult{
+		sequence: 1,
+		state:    RoundChangeState,
+	})
+}
+
+func TestPbft_ForceTimeout_Concurrent(t *testing.T) {
+	clock := newManualClock()
+
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	m.clock = clock
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		m.Run(ctx)
+	}()
+
+	// the node waits for the proposal of A, only a forced timeout can move it forward
+	require.Eventually(t, func() bool {
+		return m.getState() == AcceptState && clock.numWaiters() > 0
+	}, time.Second, time.Millisecond)
+
+	go m.ForceTimeout()
+
+	require.Eventually(t, func() bool {
+		return m.getState() == RoundChangeState && m.View().Round == 1
+	}, time.Second, time.Millisecond)
+
+	cancelFn()
+	<-doneCh
+}
+
+func TestPbft_ForceTimeout_ClearedOnNewRound(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+
+	m.ForceTimeout()
+	m.setRound(1)
+
+	select {
+	case <-m.forceTimeoutCh:
+		t.Fatal("the forced timeout must not apply to a new round")
+	default:
+	}
+}
@@ -3,9 +3,14 @@ package pbft
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.opentelemetry.io/otel/attribute"
@@ -14,26 +19,245 @@ import (
 
 type RoundTimeout func(uint64) time.Duration
 
+// GossipFailedHandler is notified when a message could not be gossiped after all the retries
+type GossipFailedHandler func(msg *MessageReq, err error)
+
+// SyncFunc syncs the node with the network when the state machine falls behind
+type SyncFunc func(ctx context.Context) error
+
+// SequenceCompleted is notified with the sealed proposal once a sequence is committed
+type SequenceCompleted func(*SealedProposal)
+
+// SequenceStarted is notified with the view and the proposer once a sequence starts, before any proposal is built
+type SequenceStarted func(view View, proposer NodeID)
+
+// ValidatorSetChanged is notified with the validators which joined and the ones which left
+// the validator set when a sequence starts with a different set than the previous one
+type ValidatorSetChanged func(added, removed []NodeID)
+
+// StateChangeObserver is notified with the state the state machine leaves and the one it enters
+type StateChangeObserver func(from, to PbftState)
+
+// ProposerFilter reports whether the proposer is allowed to propose in the round, returning false vetoes it
+// (e.g. because it is jailed or known to be offline)
+type ProposerFilter func(proposer NodeID, round uint64) bool
+
+// QuorumThreshold returns, for the total voting power of the validators, the voting power a quorum has to exceed.
+// If the validators are not weighted, the voting power is the number of validators (or of messages).
+// The standard threshold is 2F (NumValid), so that a quorum has 2F+1 messages
+type QuorumThreshold func(totalPower uint64) uint64
+
+// MessageVerifier checks the authenticity of an incoming message (e.g. the sender signature and the commit seal)
+type MessageVerifier func(*MessageReq) error
+
 type Config struct {
-	// ProposalTimeout is the time to wait for the proposal
-	// from the validator. It defaults to Timeout
+	// ProposalTimeout is the base time (scaled by round) a non-proposer waits for the proposal
+	// from the validator. If it is not set, the round timeout is used
 	ProposalTimeout time.Duration
 
+	// VoteTimeout is the base time (scaled by round) a validator waits in ValidateState for the prepare
+	// and commit messages once it has the proposal. If it is not set, the round timeout is used
+	VoteTimeout time.Duration
+
 	// Timeout is the time to wait for validation and
 	// round change messages
 	Timeout time.Duration
 
-	// Logger is the logger to output info
-	Logger *log.Logger
+	// Logger is the structured logger to output info
+	Logger Logger
 
 	// Tracer is the OpenTelemetry tracer to log traces
 	Tracer trace.Tracer
 
-	// RoundTimeout is a function that calculates timeout based on a round number
+	// RoundTimeout is a function that calculates timeout based on a round number.
+	// It defaults to an exponential timeout which uses Timeout as the base
 	RoundTimeout RoundTimeout
 
 	// Notifier is a reference to the struct which encapsulates handling messages and timeouts
 	Notifier StateNotifier
+
+	// MessageVerifier is used to verify the incoming messages before they are enqueued
+	MessageVerifier MessageVerifier
+
+	// Metrics collects the consensus metrics
+	Metrics Metrics
+
+	// MaxRounds is the round limit after which the node moves to SyncState
+	// instead of changing the round again (0 means unlimited)
+	MaxRounds uint64
+
+	// WAL is the write-ahead log used to recover the consensus state after a crash
+	WAL WAL
+
+	// Clock is the source of time for the timeouts
+	Clock Clock
+
+	// GossipRetries is the number of times a failed gossip is retried (0 means no retries)
+	GossipRetries uint64
+
+	// GossipRetryInterval is the time to wait between the gossip retries
+	GossipRetryInterval time.Duration
+
+	// GossipFailedHandler is called when a message could not be gossiped after all the retries
+	GossipFailedHandler GossipFailedHandler
+
+	// InsertRetries is the number of times an insert failing with a retryable error is retried.
+	// The backend classifies the errors by implementing RetryableInsertBackend
+	InsertRetries uint64
+
+	// InsertRetryBackoff is the time to wait before the first insert retry, it doubles after every retry
+	InsertRetryBackoff time.Duration
+
+	// ValidationRetries is the number of times a proposal the backend reports as ErrValidationPending
+	// is validated again, once per new message, before the node gives up and changes the round
+	ValidationRetries uint64
+
+	// SequenceCompleted is called once the proposal of a sequence is committed and inserted
+	SequenceCompleted SequenceCompleted
+
+	// SequenceStarted is called once per sequence, when the proposer of its first round is calculated
+	// (a proposer vetoed by the ProposerFilter is skipped). It is called on every node, whether it is the proposer or not
+	SequenceStarted SequenceStarted
+
+	// ValidatorSetChanged is called when the validator set of a sequence differs from the one of the previous
+	// sequence, or with every validator for the first sequence. The validators are only known if the
+	// validator set implements SelectableValidatorSet, otherwise it is not called
+	ValidatorSetChanged ValidatorSetChanged
+
+	// StateChangeObserver is called every time the state machine sets its state, including when it enters
+	// the same state again (i.e. on every round change). It is called synchronously by the state machine,
+	// which waits for it, hence it must not block
+	StateChangeObserver StateChangeObserver
+
+	// ProposerSelector selects the proposer of each round. If it is not set,
+	// the validator set calculates the proposer
+	ProposerSelector ProposerSelector
+
+	// RandSource is the source of the timeout jitter of the node, a seeded source makes it reproducible.
+	// The nodes need different sources to stagger their timeouts. It is not used to select the proposers,
+	// see RandomProposerSelector
+	RandSource rand.Source
+
+	// ByzantineReport is called with the evidence when a node sends conflicting proposals
+	ByzantineReport ByzantineReport
+
+	// SafetyViolation is called with the evidence when a quorum commits a proposal other than the one
+	// the node committed at the same height. The state machine is halted (closed) before it is called
+	SafetyViolation SafetyViolationHandler
+
+	// ProposerFilter is consulted once the proposer of a round is calculated. If it vetoes
+	// the proposer, the node moves to the next round instead of waiting for its proposal
+	ProposerFilter ProposerFilter
+
+	// PrepareThreshold overrides the voting power of the prepare messages a prepare quorum has to exceed.
+	// If it is not set, the standard quorum is used. It is meant for experimenting with the protocol,
+	// a threshold below the standard one is not safe and it is the responsibility of the caller
+	PrepareThreshold QuorumThreshold
+
+	// CommitThreshold overrides the voting power of the commit messages a commit quorum has to exceed.
+	// If it is not set, the standard quorum is used. As with PrepareThreshold, a threshold
+	// below the standard one is not safe and it is the responsibility of the caller
+	CommitThreshold QuorumThreshold
+
+	// BatchProposals enables the batch proposals. The backend has to implement BatchBackend
+	BatchProposals bool
+
+	// BatchCodec encodes and decodes the batch proposals
+	BatchCodec BatchCodec
+
+	// AggregateSeals aggregates the committed seals of the sealed proposals into a single seal, which replaces them.
+	// The backend has to implement SealAggregator, the nodes syncing or catching up with the proposals verify it too
+	AggregateSeals bool
+
+	// MaxQueuedMessages is the maximum number of queued messages of each type (0 means unbounded)
+	MaxQueuedMessages int
+
+	// MaxQueuedMessagesPerView is the maximum number of queued messages of each type and view (0 means unbounded)
+	MaxQueuedMessagesPerView int
+
+	// MessageRateLimit is the number of messages per second each sender can sustain, the messages
+	// exceeding it are dropped (0 means unlimited). Only the messages which pass the MessageVerifier are counted,
+	// and the own messages of the node are not limited. It has to leave room for the sync requests and responses
+	MessageRateLimit float64
+
+	// MessageRateBurst is the number of messages a sender can send at once, above the MessageRateLimit
+	MessageRateBurst int
+
+	// BlacklistThreshold is the number of invalid messages (authenticated by the MessageVerifier, but with invalid
+	// relayed votes or with a conflicting proposal) within BlacklistDuration after which the messages of the sender
+	// are ignored for BlacklistDuration
+	BlacklistThreshold int
+
+	// BlacklistDuration is how long the messages of a blacklisted sender are ignored (0 means disabled),
+	// as well as how long the invalid messages of a sender count toward the BlacklistThreshold
+	BlacklistDuration time.Duration
+
+	// MaxProposalDelay is the maximum time the proposer waits for the proposal time before gossiping it (0 means unbounded)
+	MaxProposalDelay time.Duration
+
+	// MinBlockInterval is the minimum time between two consecutive committed sequences. The node waits
+	// in AcceptState until the interval has passed since its last commit (0 means no minimum)
+	MinBlockInterval time.Duration
+
+	// MaxProposalSize is the maximum size in bytes of a received proposal, the larger ones
+	// are rejected before they are validated (0 means unbounded)
+	MaxProposalSize int
+
+	// ValidationCacheSize is the number of validated proposals the node remembers, so that a proposal
+	// received again in a later round is not validated again (0 disables the cache). The proposals are
+	// identified by their hash, so the cache is only used if the backend implements ProposalHasher
+	ValidationCacheSize int
+
+	// SyncFunc is run when the state machine moves to SyncState. If it is not set, Run returns in SyncState
+	SyncFunc SyncFunc
+
+	// PeerSync syncs the node by requesting the sealed proposals it is missing to the other nodes over
+	// the transport, if no SyncFunc is set. The nodes answer the requests if their transport implements
+	// DirectTransport, with the proposals they committed or the ones of their CommittedHistoryBackend
+	PeerSync bool
+
+	// SelfMessageBypass applies the prepare and commit messages of the local node directly
+	// to the state instead of pushing them through the message queue
+	SelfMessageBypass bool
+
+	// LeaderAggregation sends the prepare and commit messages to the proposer of the round only,
+	// instead of gossiping them to every validator. The proposer gossips the votes it receives,
+	// aggregated into its own vote, once they reach a quorum. It requires a DirectTransport
+	LeaderAggregation bool
+
+	// StrictPreparePhase disables the fast-track of the locked nodes. A locked node which receives the
+	// locked proposal sends a prepare message again and waits for a prepare quorum before committing,
+	// instead of sending the commit message right away
+	StrictPreparePhase bool
+
+	// EarlyCommit stops processing the prepare messages once the node reached the prepare quorum and sent
+	// its commit message. The prepare messages read afterwards are dropped without being recorded nor
+	// persisted, so that the node only processes the commit messages it still needs to reach the commit quorum
+	EarlyCommit bool
+
+	// FastCatchUp attaches to the preprepare messages the sealed proposal of the previous sequence. A node
+	// still deciding that sequence inserts the proposal once its committed seals reach a quorum of the validators,
+	// and moves on without changing rounds nor syncing. The aggregated seals cannot be verified, so they are not attached
+	FastCatchUp bool
+
+	// ChainProposals links each proposal to the proposal committed in the previous sequence with its ParentHash.
+	// The proposer builds the proposal with the parent hash in the context (see ParentHashFromContext), and the
+	// proposals which do not extend the proposal the node committed are rejected. The node has to know that proposal,
+	// committed by itself or in the history of a CommittedHistoryBackend, otherwise it moves to SyncState
+	ChainProposals bool
+
+	// Observer runs the node as a non-voting observer. It follows the consensus and inserts
+	// the committed proposals with the seals of the validators, but it never proposes nor sends messages
+	Observer bool
+
+	// StuckTimeout moves the node to SyncState on a round change if it has not received a valid message
+	// from a quorum of validators within the timeout, even if the backend does not report it is stuck (0 means disabled)
+	StuckTimeout time.Duration
+
+	// TimeoutJitter is the fraction of the round, proposal and vote timeouts added at random to them,
+	// drawn from the RandSource, so that the timeouts of the nodes are staggered (0 means disabled).
+	// The jittered timeout of a round never exceeds the timeout of the next round
+	TimeoutJitter float64
 }
 
 type ConfigOption func(*Config)
@@ -50,9 +274,26 @@ func WithProposalTimeout(p time.Duration) ConfigOption {
 	}
 }
 
+func WithVoteTimeout(p time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.VoteTimeout = p
+	}
+}
+
+// WithLogger sets a standard library logger, the fields of the entries are written as key=value pairs
 func WithLogger(l *log.Logger) ConfigOption {
 	return func(c *Config) {
-		c.Logger = l
+		if l != nil {
+			c.Logger = NewStdLogger(l)
+		}
+	}
+}
+
+func WithStructuredLogger(l Logger) ConfigOption {
+	return func(c *Config) {
+		if l != nil {
+			c.Logger = l
+		}
 	}
 }
 
@@ -78,20 +319,339 @@ func WithNotifier(notifier StateNotifier) ConfigOption {
 	}
 }
 
+func WithMessageVerifier(verifier MessageVerifier) ConfigOption {
+	return func(c *Config) {
+		if verifier != nil {
+			c.MessageVerifier = verifier
+		}
+	}
+}
+
+func WithMetrics(metrics Metrics) ConfigOption {
+	return func(c *Config) {
+		if metrics != nil {
+			c.Metrics = metrics
+		}
+	}
+}
+
+func WithMaxRounds(maxRounds uint64) ConfigOption {
+	return func(c *Config) {
+		c.MaxRounds = maxRounds
+	}
+}
+
+func WithWAL(wal WAL) ConfigOption {
+	return func(c *Config) {
+		if wal != nil {
+			c.WAL = wal
+		}
+	}
+}
+
+func WithClock(clock Clock) ConfigOption {
+	return func(c *Config) {
+		if clock != nil {
+			c.Clock = clock
+		}
+	}
+}
+
+// WithInsertRetry sets the number of retries of an insert failing with a retryable error and the initial backoff
+func WithInsertRetry(retries uint64, backoff time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.InsertRetries = retries
+		c.InsertRetryBackoff = backoff
+	}
+}
+
+// WithValidationRetries sets the number of times a pending proposal validation is retried
+func WithValidationRetries(retries uint64) ConfigOption {
+	return func(c *Config) {
+		c.ValidationRetries = retries
+	}
+}
+
+func WithGossipRetry(retries uint64, interval time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.GossipRetries = retries
+		c.GossipRetryInterval = interval
+	}
+}
+
+func WithGossipFailedHandler(handler GossipFailedHandler) ConfigOption {
+	return func(c *Config) {
+		if handler != nil {
+			c.GossipFailedHandler = handler
+		}
+	}
+}
+
+func WithSequenceCompleted(handler SequenceCompleted) ConfigOption {
+	return func(c *Config) {
+		if handler != nil {
+			c.SequenceCompleted = handler
+		}
+	}
+}
+
+func WithSequenceStarted(handler SequenceStarted) ConfigOption {
+	return func(c *Config) {
+		if handler != nil {
+			c.SequenceStarted = handler
+		}
+	}
+}
+
+func WithStateChangeObserver(observer StateChangeObserver) ConfigOption {
+	return func(c *Config) {
+		if observer != nil {
+			c.StateChangeObserver = observer
+		}
+	}
+}
+
+func WithValidatorSetChanged(handler ValidatorSetChanged) ConfigOption {
+	return func(c *Config) {
+		if handler != nil {
+			c.ValidatorSetChanged = handler
+		}
+	}
+}
+
+func WithProposerSelector(selector ProposerSelector) ConfigOption {
+	return func(c *Config) {
+		c.ProposerSelector = selector
+	}
+}
+
+func WithRandSource(src rand.Source) ConfigOption {
+	return func(c *Config) {
+		if src != nil {
+			c.RandSource = src
+		}
+	}
+}
+
+// WithFixedProposer makes the proposer the same in every round, see FixedProposerSelector.
+// It replaces the configured ProposerSelector. The fixed proposer can only be honored if the validator set
+// of the backend implements SelectableValidatorSet, SetBackend fails otherwise
+func WithFixedProposer(proposer NodeID) ConfigOption {
+	return func(c *Config) {
+		c.ProposerSelector = &FixedProposerSelector{Proposer: proposer}
+	}
+}
+
+func WithProposerFilter(filter ProposerFilter) ConfigOption {
+	return func(c *Config) {
+		if filter != nil {
+			c.ProposerFilter = filter
+		}
+	}
+}
+
+func WithByzantineReport(report ByzantineReport) ConfigOption {
+	return func(c *Config) {
+		if report != nil {
+			c.ByzantineReport = report
+		}
+	}
+}
+
+func WithSafetyViolation(handler SafetyViolationHandler) ConfigOption {
+	return func(c *Config) {
+		if handler != nil {
+			c.SafetyViolation = handler
+		}
+	}
+}
+
+func WithMessageQueueLimits(maxPerType, maxPerView int) ConfigOption {
+	return func(c *Config) {
+		c.MaxQueuedMessages = maxPerType
+		c.MaxQueuedMessagesPerView = maxPerView
+	}
+}
+
+func WithMessageRateLimit(rate float64, burst int) ConfigOption {
+	return func(c *Config) {
+		c.MessageRateLimit = rate
+		c.MessageRateBurst = burst
+	}
+}
+
+func WithBlacklist(threshold int, duration time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.BlacklistThreshold = threshold
+		c.BlacklistDuration = duration
+	}
+}
+
+func WithSyncFunc(syncFn SyncFunc) ConfigOption {
+	return func(c *Config) {
+		c.SyncFunc = syncFn
+	}
+}
+
+func WithPeerSync(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.PeerSync = enabled
+	}
+}
+
+func WithStuckTimeout(timeout time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.StuckTimeout = timeout
+	}
+}
+
+func WithTimeoutJitter(fraction float64) ConfigOption {
+	return func(c *Config) {
+		c.TimeoutJitter = fraction
+	}
+}
+
+func WithObserver(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.Observer = enabled
+	}
+}
+
+func WithSelfMessageBypass(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.SelfMessageBypass = enabled
+	}
+}
+
+func WithStrictPreparePhase(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.StrictPreparePhase = enabled
+	}
+}
+
+func WithEarlyCommit(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.EarlyCommit = enabled
+	}
+}
+
+func WithChainProposals(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.ChainProposals = enabled
+	}
+}
+
+func WithFastCatchUp(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.FastCatchUp = enabled
+	}
+}
+
+func WithLeaderAggregation(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.LeaderAggregation = enabled
+	}
+}
+
+func WithMaxProposalDelay(d time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.MaxProposalDelay = d
+	}
+}
+
+func WithSealAggregation(enabled bool) ConfigOption {
+	return func(c *Config) {
+		c.AggregateSeals = enabled
+	}
+}
+
+func WithMaxProposalSize(size int) ConfigOption {
+	return func(c *Config) {
+		c.MaxProposalSize = size
+	}
+}
+
+func WithValidationCache(size int) ConfigOption {
+	return func(c *Config) {
+		c.ValidationCacheSize = size
+	}
+}
+
+func WithMinBlockInterval(interval time.Duration) ConfigOption {
+	return func(c *Config) {
+		c.MinBlockInterval = interval
+	}
+}
+
+// WithQuorumThresholds overrides the prepare and the commit quorum thresholds, a nil threshold keeps the standard one.
+// The thresholds below the standard ones are not safe, using them is the responsibility of the caller
+func WithQuorumThresholds(prepare, commit QuorumThreshold) ConfigOption {
+	return func(c *Config) {
+		if prepare != nil {
+			c.PrepareThreshold = prepare
+		}
+		if commit != nil {
+			c.CommitThreshold = commit
+		}
+	}
+}
+
+func WithBatchProposals(codec BatchCodec) ConfigOption {
+	return func(c *Config) {
+		c.BatchProposals = true
+		if codec != nil {
+			c.BatchCodec = codec
+		}
+	}
+}
+
 const (
 	defaultTimeout     = 2 * time.Second
 	maxTimeout         = 300 * time.Second
 	maxTimeoutExponent = 8
+
+	defaultGossipRetryInterval = 100 * time.Millisecond
+
+	defaultInsertRetries      = 3
+	defaultInsertRetryBackoff = 100 * time.Millisecond
+
+	defaultValidationRetries = 3
+
+	defaultMaxQueuedMessages        = 10000
+	defaultMaxQueuedMessagesPerView = 1000
+
+	defaultBlacklistThreshold = 3
 )
 
 func DefaultConfig() *Config {
 	return &Config{
 		Timeout:         defaultTimeout,
-		ProposalTimeout: defaultTimeout,
-		Logger:          log.New(os.Stderr, "", log.LstdFlags),
+		Logger:          NewStdLogger(log.New(os.Stderr, "", log.LstdFlags)),
 		Tracer:          trace.NewNoopTracerProvider().Tracer(""),
-		RoundTimeout:    exponentialTimeout,
 		Notifier:        &DefaultStateNotifier{},
+		MessageVerifier: func(*MessageReq) error { return nil },
+		Metrics:         &NoopMetrics{},
+		WAL:             &NoopWAL{},
+		Clock:           &RealClock{},
+
+		GossipRetryInterval: defaultGossipRetryInterval,
+		GossipFailedHandler: func(*MessageReq, error) {},
+		InsertRetries:       defaultInsertRetries,
+		InsertRetryBackoff:  defaultInsertRetryBackoff,
+		ValidationRetries:   defaultValidationRetries,
+		SequenceCompleted:   func(*SealedProposal) {},
+		SequenceStarted:     func(View, NodeID) {},
+		ValidatorSetChanged: func(added, removed []NodeID) {},
+		StateChangeObserver: func(from, to PbftState) {},
+		ByzantineReport:     func(*Equivocation) {},
+		SafetyViolation:     func(*SafetyViolation) {},
+		ProposerFilter:      func(NodeID, uint64) bool { return true },
+		BatchCodec:          &LengthPrefixBatchCodec{},
+		RandSource:          rand.NewSource(time.Now().UnixNano()),
+
+		MaxQueuedMessages:        defaultMaxQueuedMessages,
+		MaxQueuedMessagesPerView: defaultMaxQueuedMessagesPerView,
+		BlacklistThreshold:       defaultBlacklistThreshold,
 	}
 }
 
@@ -102,17 +662,53 @@ func (c *Config) ApplyOps(opts ...ConfigOption) {
 }
 
 type SealedProposal struct {
-	Proposal       *Proposal
+	Proposal *Proposal
+	// CommittedSeals are the individual committed seals, they are omitted if the seals are aggregated
 	CommittedSeals []CommittedSeal
+	// AggregatedSeal is the single seal the committed seals are aggregated into, if the aggregation is enabled
+	AggregatedSeal []byte
 	Proposer       NodeID
 	Number         uint64
+	// SeenAt is when the node first saw the proposal, either by building it or by receiving it from the proposer
+	SeenAt time.Time
+	// CommittedAt is when the node reached the commit quorum for the proposal.
+	// Both are local to the node, they are not encoded and are zero if the proposal was not committed
+	// by the state machine (i.e. in a sync)
+	CommittedAt time.Time
+	// Committers are the distinct validators whose committed seals formed the quorum, sorted by NodeID.
+	// They are set whether the seals are aggregated or not, the aggregated seal is verified against them
+	Committers []NodeID
+}
+
+// Copy makes a deep copy of the SealedProposal
+func (pp *SealedProposal) Copy() *SealedProposal {
+	cc := new(SealedProposal)
+	*cc = *pp
+	if pp.Proposal != nil {
+		cc.Proposal = pp.Proposal.Copy()
+	}
+	if pp.CommittedSeals != nil {
+		cc.CommittedSeals = make([]CommittedSeal, len(pp.CommittedSeals))
+		for i, seal := range pp.CommittedSeals {
+			cc.CommittedSeals[i] = CommittedSeal{NodeID: seal.NodeID, Signature: append([]byte{}, seal.Signature...)}
+		}
+	}
+	if pp.AggregatedSeal != nil {
+		cc.AggregatedSeal = append([]byte{}, pp.AggregatedSeal...)
+	}
+	if pp.Committers != nil {
+		cc.Committers = append([]NodeID{}, pp.Committers...)
+	}
+	return cc
 }
 
 type Backend interface {
-	// BuildProposal builds a proposal for the current round (used if proposer)
-	BuildProposal() (*Proposal, error)
+	// BuildProposal builds a proposal for the current round (used if proposer).
+	// The context is cancelled when the state machine stops.
+	BuildProposal(ctx context.Context) (*Proposal, error)
 
-	// Validate validates a raw proposal (used if non-proposer)
+	// Validate validates a raw proposal (used if non-proposer). It returns ErrValidationPending
+	// if the proposal cannot be validated yet, to validate it again on the next message
 	Validate(*Proposal) error
 
 	// Insert inserts the sealed proposal
@@ -132,6 +728,10 @@ type Backend interface {
 
 	// ValidateCommit is used to validate that a given commit is valid
 	ValidateCommit(from NodeID, seal []byte) error
+
+	// VerifyCommittedSeal verifies that the seal was produced by the given node over the hash. It verifies
+	// the committed seals over the proposal hash, and the proposer seals of the preprepare messages
+	VerifyCommittedSeal(from NodeID, seal, hash []byte) error
 }
 
 // RoundInfo is the information about the round
@@ -144,7 +744,7 @@ type RoundInfo struct {
 // Pbft represents the PBFT consensus mechanism object
 type Pbft struct {
 	// Output logger
-	logger *log.Logger
+	logger Logger
 
 	// Config is the configuration of the consensus
 	config *Config
@@ -178,6 +778,90 @@ type Pbft struct {
 
 	// notifier is a reference to the struct which encapsulates handling messages and timeouts
 	notifier StateNotifier
+
+	// proposalTimeout calculates the timeout to wait for the proposal in a specific round (nil if not configured)
+	proposalTimeout RoundTimeout
+
+	// voteTimeout calculates the timeout to wait for the votes in a specific round (nil if not configured)
+	voteTimeout RoundTimeout
+
+	// msgVerifier verifies the authenticity of the incoming messages
+	msgVerifier MessageVerifier
+
+	// metrics collects the consensus metrics
+	metrics Metrics
+
+	// invalidMsgs is the number of messages dropped because they failed the verification
+	invalidMsgs uint64
+
+	// staleMsgs is the number of messages dropped because they belong to an already decided sequence
+	staleMsgs uint64
+
+	// rateLimitedMsgs is the number of messages dropped because their sender exceeded the MessageRateLimit
+	rateLimitedMsgs uint64
+
+	// rateLimiter drops the messages of the senders flooding the node
+	rateLimiter *rateLimiter
+
+	// blacklist ignores the messages of the senders of repeated invalid messages
+	blacklist *peerBlacklist
+
+	// wal is the write-ahead log which persists the state of the current sequence
+	wal WAL
+
+	// clock is the source of time for the timeouts
+	clock Clock
+
+	// timeoutTimer fires the timeout of the current round, it is stopped once it is replaced
+	timeoutTimer Timer
+
+	// equivocations tracks the preprepare messages to detect conflicting proposals
+	equivocations *equivocationTracker
+
+	// validations caches the hashes of the proposals which passed the validation
+	validations *validationCache
+
+	// forks checks the commit messages for the committed heights to detect safety violations
+	forks *forkDetector
+
+	// committed is the proposal inserted by the last committed sequence
+	committed *SealedProposal
+
+	// catchUp is the commit certificate of the current sequence received along a preprepare (see Config.FastCatchUp)
+	catchUp     *SealedProposal
+	catchUpLock sync.Mutex
+
+	// lastCommit is the time the last committed sequence was inserted
+	lastCommit time.Time
+
+	// progressTime is the time.Time of the last commit, or of the first run
+	// if nothing was committed yet. It is read concurrently by Health
+	progressTime atomic.Value
+
+	// startedSequence is the last sequence announced to the SequenceStarted handler (valid if sequenceStarted is set)
+	startedSequence uint64
+	sequenceStarted bool
+
+	// liveness tracks the validators the node hears from to detect it is stuck
+	liveness *livenessTracker
+
+	// closeCh is closed to signal the state machine to stop after the current cycle
+	closeCh chan struct{}
+
+	// forceTimeoutCh signals the state machine to time out the current wait
+	forceTimeoutCh chan struct{}
+
+	// resumeCh is closed by Resume, it is set while the state machine is paused
+	resumeCh  chan struct{}
+	pauseLock sync.Mutex
+
+	// syncCh receives the sync responses of the other nodes while the node syncs with them
+	syncCh chan *MessageReq
+
+	// runDoneCh is closed when the last execution of Run exits (nil if Run was never called)
+	runDoneCh chan struct{}
+	running   bool
+	runLock   sync.Mutex
 }
 
 type SignKey interface {
@@ -185,57 +869,312 @@ type SignKey interface {
 	Sign(b []byte) ([]byte, error)
 }
 
-// New creates a new instance of the PBFT state machine
-func New(validator SignKey, transport Transport, opts ...ConfigOption) *Pbft {
-	config := DefaultConfig()
-	config.ApplyOps(opts...)
-
-	p := &Pbft{
-		validator:    validator,
-		state:        newState(),
-		transport:    transport,
-		msgQueue:     newMsgQueue(),
-		updateCh:     make(chan struct{}),
-		config:       config,
-		logger:       config.Logger,
-		tracer:       config.Tracer,
-		roundTimeout: config.RoundTimeout,
-		notifier:     config.Notifier,
+// New creates a new instance of the PBFT state machine
+func New(validator SignKey, transport Transport, opts ...ConfigOption) *Pbft {
+	config := DefaultConfig()
+	config.ApplyOps(opts...)
+	if config.RoundTimeout == nil {
+		config.RoundTimeout = ExponentialTimeout(config.Timeout, maxTimeout)
+	}
+
+	p := &Pbft{
+		validator:    validator,
+		state:        newState(),
+		transport:    transport,
+		msgQueue:     newMsgQueue(),
+		updateCh:     make(chan struct{}),
+		config:       config,
+		logger:       config.Logger,
+		tracer:       config.Tracer,
+		roundTimeout: config.RoundTimeout,
+		notifier:     config.Notifier,
+		msgVerifier:  config.MessageVerifier,
+		metrics:      config.Metrics,
+		wal:          config.WAL,
+		clock:        config.Clock,
+
+		equivocations:  newEquivocationTracker(),
+		forks:          newForkDetector(),
+		validations:    newValidationCache(config.ValidationCacheSize),
+		liveness:       newLivenessTracker(),
+		blacklist:      newPeerBlacklist(config.BlacklistThreshold, config.BlacklistDuration),
+		rateLimiter:    newRateLimiter(config.MessageRateLimit, config.MessageRateBurst),
+		closeCh:        make(chan struct{}),
+		forceTimeoutCh: make(chan struct{}, 1),
+		syncCh:         make(chan *MessageReq, syncResponsesSize),
+	}
+	if config.PeerSync && config.SyncFunc == nil {
+		p.config.SyncFunc = p.syncFromPeers
+	}
+
+	// the jitter has a generator of its own, the source itself is not safe for concurrent use
+	jitterRand := newLockedRand(config.RandSource)
+
+	p.msgQueue.clock = config.Clock
+	p.msgQueue.maxPerType = config.MaxQueuedMessages
+	p.msgQueue.maxPerView = config.MaxQueuedMessagesPerView
+
+	if config.ProposalTimeout > 0 {
+		p.proposalTimeout = ExponentialTimeout(config.ProposalTimeout, maxTimeout)
+	}
+	if config.VoteTimeout > 0 {
+		p.voteTimeout = ExponentialTimeout(config.VoteTimeout, maxTimeout)
+	}
+	if config.TimeoutJitter > 0 {
+		p.roundTimeout = JitteredTimeout(p.roundTimeout, config.TimeoutJitter, jitterRand)
+		if p.proposalTimeout != nil {
+			p.proposalTimeout = JitteredTimeout(p.proposalTimeout, config.TimeoutJitter, jitterRand)
+		}
+		if p.voteTimeout != nil {
+			p.voteTimeout = JitteredTimeout(p.voteTimeout, config.TimeoutJitter, jitterRand)
+		}
+	}
+
+	p.logger.Info("validator key", "addr", p.validator.NodeID())
+	if err := p.validator.NodeID().Validate(); err != nil {
+		// the node cannot take part in the consensus, SetBackend fails with this error
+		p.logger.Error("invalid validator key", "err", err)
+	}
+	return p
+}
+
+// NewWithBackend creates a new instance of the PBFT state machine and sets its backend,
+// it returns an error instead of a state machine which would fail to run
+func NewWithBackend(validator SignKey, transport Transport, backend Backend, opts ...ConfigOption) (*Pbft, error) {
+	if validator == nil {
+		return nil, errors.New("validator key not set")
+	}
+	if transport == nil {
+		return nil, errors.New("transport not set")
+	}
+	if backend == nil {
+		return nil, errors.New("backend not set")
+	}
+	if err := validator.NodeID().Validate(); err != nil {
+		return nil, fmt.Errorf("invalid local validator id: %w", err)
+	}
+
+	p := New(validator, transport, opts...)
+	if err := p.SetBackend(backend); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Pbft) SetBackend(backend Backend) error {
+	if backend == nil {
+		return errors.New("backend not set")
+	}
+	if err := p.validator.NodeID().Validate(); err != nil {
+		return fmt.Errorf("invalid local validator id: %w", err)
+	}
+	if err := p.checkProposerSelector(backend.ValidatorSet()); err != nil {
+		return err
+	}
+	p.backend = backend
+	p.forks.setBackend(backend)
+
+	// set the next current sequence for this iteration
+	p.setSequence(p.backend.Height())
+	p.snapshotValidators()
+
+	// recover the state of the sequence if the node restarted in the middle of it
+	p.restoreWAL()
+
+	return nil
+}
+
+// SetView sets the view the state machine starts from when it runs next, i.e. to rejoin the network
+// at the round it is on after a restart. The sequence cannot be below the height of the backend,
+// since those sequences are already committed. If the sequence changes, the locked proposal
+// of the previous sequence is dropped. It must not be called while the state machine is running
+func (p *Pbft) SetView(v View) error {
+	if p.backend == nil {
+		return errors.New("backend not set")
+	}
+	if height := p.backend.Height(); v.Sequence < height {
+		return fmt.Errorf("sequence %d is below the height %d of the backend", v.Sequence, height)
+	}
+
+	if v.Sequence != p.state.view.Sequence {
+		p.setSequence(v.Sequence)
+		p.snapshotValidators()
+		if p.state.IsLocked() {
+			p.state.unlock()
+		}
+		p.restoreWAL()
+	}
+	p.setRound(v.Round)
+	return nil
+}
+
+// snapshotValidators sets the validator set of the backend as the one of the current sequence.
+// The validator set may change between sequences, but a copy of it is frozen for all the rounds of a sequence
+// since the quorum thresholds (NumValid and MaxFaultyNodes) are derived from it
+func (p *Pbft) snapshotValidators() {
+	prevValidators := p.state.validators
+	p.state.setValidators(copyValidatorSet(p.backend.ValidatorSet()))
+	if prevValidators != nil && prevValidators.Len() != p.state.validators.Len() {
+		p.logger.Info("validator set changed",
+			"sequence", p.state.view.Sequence, "validators", p.state.validators.Len(), "quorum", QuorumSize(p.state.validators.Len()))
+	}
+	if validators, ok := p.state.validators.(SelectableValidatorSet); ok {
+		if ids := validators.Validators(); len(validNodeIDs(ids)) != len(ids) {
+			p.logger.Warn("validator set has empty or duplicated ids, they are skipped", "sequence", p.state.view.Sequence)
+		}
+	}
+	if err := p.checkProposerSelector(p.state.validators); err != nil {
+		p.logger.Error("the validator set calculates the proposers of the sequence", "sequence", p.state.view.Sequence, "err", err)
+	}
+	if added, removed, ok := diffValidators(prevValidators, p.state.validators); ok && (len(added) > 0 || len(removed) > 0) {
+		p.config.ValidatorSetChanged(added, removed)
+	}
+}
+
+// checkValidatorSet reports if the validator set of the backend differs from the snapshot
+// of the current sequence. The snapshot keeps being used until the sequence advances
+func (p *Pbft) checkValidatorSet(span trace.Span) {
+	if sameValidators(p.state.validators, p.backend.ValidatorSet()) {
+		return
+	}
+	p.logger.Warn("validator set changed in the middle of the sequence, using the one of the sequence start",
+		"sequence", p.state.view.Sequence, "round", p.state.GetCurrentRound(), "validators", p.state.validators.Len())
+	span.AddEvent("ValidatorSetChanged")
+}
+
+// restoreWAL rebuilds the state of the current sequence from the write-ahead log.
+// If the log belongs to a previous sequence, it is truncated and a new one is started
+func (p *Pbft) restoreWAL() {
+	entries, err := p.wal.Entries()
+	if err != nil {
+		p.logger.Error("failed to read the wal", "err", err)
+		return
+	}
+
+	sequence := p.state.view.Sequence
+	if len(entries) == 0 || entries[0].Type != WALSequence || entries[0].Sequence != sequence {
+		if err := p.wal.Truncate(); err != nil {
+			p.logger.Error("failed to truncate the wal", "err", err)
+			return
+		}
+		p.appendWAL(&WALEntry{Type: WALSequence, Sequence: sequence})
+		return
+	}
+
+	for _, entry := range entries[1:] {
+		switch entry.Type {
+		case WALLock:
+			p.state.proposal = entry.Proposal
+			p.state.proposalSeenAt = p.clock.Now()
+			p.state.lock(entry.PreparedCertificate)
+
+		case WALUnlock:
+			p.state.unlock()
+
+		case WALMessage:
+			// the messages are processed again once the state machine runs
+			p.PushMessageInternal(entry.Message)
+		}
+	}
+	if p.state.IsLocked() {
+		p.logger.Info("recovered locked proposal from wal", "sequence", sequence)
+	}
+}
+
+// appendWAL persists the entry in the write-ahead log
+func (p *Pbft) appendWAL(entry *WALEntry) {
+	if err := p.wal.Append(entry); err != nil {
+		p.logger.Error("failed to write entry to the wal", "type", entry.Type, "err", err)
+	}
+}
+
+// Run starts the PBFT consensus state machine. It panics if the state machine is already running
+func (p *Pbft) Run(ctx context.Context) {
+	done, err := p.startRun(ctx)
+	if errors.Is(err, ErrAlreadyRunning) {
+		panic(fmt.Errorf("BUG: Run called concurrently: %w", err))
+	}
+	if err != nil {
+		return
 	}
+	defer done()
 
-	p.logger.Printf("[INFO] validator key: addr=%s\n", p.validator.NodeID())
-	return p
+	p.run(ctx)
 }
 
-func (p *Pbft) SetBackend(backend Backend) error {
-	p.backend = backend
+// startRun marks the state machine as running with the context. It fails if the state machine
+// is closed or already running, otherwise the returned function has to be called once it stops
+func (p *Pbft) startRun(ctx context.Context) (func(), error) {
+	p.runLock.Lock()
+	defer p.runLock.Unlock()
 
-	// set the next current sequence for this iteration
-	p.setSequence(p.backend.Height())
+	if p.running {
+		return nil, ErrAlreadyRunning
+	}
+	if p.isClosed() {
+		return nil, ErrClosed
+	}
 
-	// set the current set of validators
-	p.state.validators = p.backend.ValidatorSet()
+	runDoneCh := make(chan struct{})
+	p.runDoneCh = runDoneCh
+	p.running = true
+	p.ctx = ctx
 
-	return nil
+	return func() {
+		p.runLock.Lock()
+		p.running = false
+		p.runLock.Unlock()
+		close(runDoneCh)
+	}, nil
 }
 
-// start starts the PBFT consensus state machine
-func (p *Pbft) Run(ctx context.Context) {
-	p.ctx = ctx
+// IsRunning checks if Run (or RunSequence) is in progress
+func (p *Pbft) IsRunning() bool {
+	p.runLock.Lock()
+	defer p.runLock.Unlock()
+
+	return p.running
+}
+
+func (p *Pbft) run(ctx context.Context) {
+	if !p.waitResume(ctx) {
+		return
+	}
+	p.liveness.begin(p.clock.Now())
+	if p.progressTime.Load() == nil {
+		p.progressTime.Store(p.clock.Now())
+	}
 
 	// the iteration always starts with the AcceptState.
 	// AcceptState stages will reset the rest of the message queues.
 	p.setState(AcceptState)
 
+	// the round may not be the first one (see SetView), and its timeout
+	// has to start now instead of when the view was set
+	if round := p.state.GetCurrentRound(); round > 0 {
+		p.logger.Info("starting from round", "sequence", p.state.view.Sequence, "round", round)
+	}
+	p.setTimeout(p.roundTimeout(p.state.GetCurrentRound()))
+
 	// start the trace span
 	spanCtx, span := p.tracer.Start(context.Background(), fmt.Sprintf("Sequence-%d", p.state.view.Sequence))
 	defer span.End()
 
 	// loop until we reach the a finish state
-	for p.getState() != DoneState && p.getState() != SyncState {
+	for p.getState() != DoneState {
+		if p.getState() == SyncState {
+			if p.config.SyncFunc == nil || !p.runSync(ctx) {
+				return
+			}
+			continue
+		}
+
 		select {
 		case <-ctx.Done():
 			return
+		case <-p.closeCh:
+			p.logger.Info("state machine closed", "sequence", p.state.view.Sequence, "state", p.getState())
+			return
 		default:
 		}
 
@@ -244,11 +1183,129 @@ func (p *Pbft) Run(ctx context.Context) {
 	}
 }
 
+var (
+	// ErrSyncState is returned by RunSequence when the node has to sync before it can run the sequence
+	ErrSyncState = errors.New("state machine moved to sync state")
+
+	// ErrClosed is returned by RunSequence when the state machine is closed
+	ErrClosed = errors.New("state machine closed")
+
+	// ErrAlreadyRunning is returned by RunSequence when the state machine is already running
+	ErrAlreadyRunning = errors.New("state machine already running")
+)
+
+// RunSequence runs the state machine for the sequence and blocks until it is committed,
+// returning the sealed proposal. It fails with the context error if the context is cancelled,
+// with ErrSyncState if the state machine moves to SyncState, with ErrClosed if it is closed and
+// with ErrAlreadyRunning if it is already running
+func (p *Pbft) RunSequence(ctx context.Context, sequence uint64) (*SealedProposal, error) {
+	done, err := p.startRun(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	p.setSequence(sequence)
+	p.snapshotValidators()
+	p.committed = nil
+
+	p.run(ctx)
+
+	switch {
+	case p.getState() == DoneState && p.committed != nil:
+		return p.committed, nil
+	case p.getState() == SyncState:
+		return nil, ErrSyncState
+	case ctx.Err() != nil:
+		return nil, ctx.Err()
+	default:
+		return nil, ErrClosed
+	}
+}
+
+// runSync runs the sync function and resets the state machine to the sequence of the backend
+// after it. It returns false if the state machine has to stop
+func (p *Pbft) runSync(ctx context.Context) bool {
+	if p.isClosed() {
+		return false
+	}
+
+	sequence := p.state.view.Sequence
+	if err := p.config.SyncFunc(ctx); err != nil {
+		p.logger.Error("failed to sync", "err", err)
+		return false
+	}
+
+	// start again with the height (and validator set) of the backend after the sync
+	if err := p.SetBackend(p.backend); err != nil {
+		p.logger.Error("failed to reset the backend after sync", "err", err)
+		return false
+	}
+	if p.state.view.Sequence != sequence && p.state.IsLocked() {
+		// the locked proposal belongs to a sequence that has been synced
+		p.state.unlock()
+	}
+
+	p.logger.Info("synced", "sequence", p.state.view.Sequence)
+	p.setState(AcceptState)
+	return true
+}
+
+// Close signals the state machine to stop once the current cycle completes, instead of
+// aborting it in the middle (i.e. while committing). Waiting for new messages is interrupted.
+// The returned channel is closed when Run has exited. Once closed, Run returns immediately
+func (p *Pbft) Close() <-chan struct{} {
+	p.runLock.Lock()
+	defer p.runLock.Unlock()
+
+	if !p.isClosed() {
+		close(p.closeCh)
+	}
+
+	if p.runDoneCh == nil {
+		// the state machine never ran
+		doneCh := make(chan struct{})
+		close(doneCh)
+		return doneCh
+	}
+	return p.runDoneCh
+}
+
+// RemainingBudget returns the time left until the deadline of the context the state machine runs with,
+// and false if the context has no deadline. Once the deadline passes, Run returns without waiting for the round timeout
+func (p *Pbft) RemainingBudget() (time.Duration, bool) {
+	p.runLock.Lock()
+	ctx := p.ctx
+	p.runLock.Unlock()
+
+	if ctx == nil {
+		return 0, false
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return 0, false
+	}
+	if remaining := deadline.Sub(p.clock.Now()); remaining > 0 {
+		return remaining, true
+	}
+	return 0, true
+}
+
+// isClosed checks if Close has been called
+func (p *Pbft) isClosed() bool {
+	select {
+	case <-p.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
 // runCycle represents the PBFT state machine loop
 func (p *Pbft) runCycle(ctx context.Context) {
 	// Log to the console
 	if p.state.view != nil {
-		p.logger.Printf("[DEBUG] cycle: state=%s, sequence=%d, round=%d", p.getState(), p.state.view.Sequence, p.state.GetCurrentRound())
+		p.logger.Debug("cycle", "state", p.getState(), "sequence", p.state.view.Sequence, "round", p.state.GetCurrentRound())
 	}
 
 	// Based on the current state, execute the corresponding section
@@ -271,18 +1328,66 @@ func (p *Pbft) runCycle(ctx context.Context) {
 }
 
 func (p *Pbft) setSequence(sequence uint64) {
-	p.state.view = &View{
+	p.state.setView(&View{
 		Sequence: sequence,
-	}
+	})
+	p.state.sequenceStart = p.clock.Now()
+	p.state.aheadSenders = nil
+	p.state.unconfirmed = nil
+
+	// nothing of the rounds of the previous sequence carries over, the new one starts afresh
+	p.state.resetRoundMsgs()
+	p.state.err = nil
+	p.state.clearLastErr()
+
+	p.equivocations.prune(sequence)
 	p.setRound(0)
 }
 
 func (p *Pbft) setRound(round uint64) {
 	p.state.SetCurrentRound(round)
 
+	// a forced timeout only applies to the round it was requested in
+	select {
+	case <-p.forceTimeoutCh:
+	default:
+	}
+
 	// reset current timeout and start a new one
-	timeout := p.roundTimeout(round)
-	p.state.timeout = time.NewTimer(timeout)
+	p.setTimeout(p.roundTimeout(round))
+}
+
+// startVoteTimeout starts the deadline of the wait for the votes in ValidateState. Without a vote timeout
+// the round timeout keeps running, unless the proposal timeout replaced it and a new round timeout is started
+func (p *Pbft) startVoteTimeout(proposalWait bool) {
+	round := p.state.GetCurrentRound()
+	if p.voteTimeout != nil {
+		p.setTimeout(p.voteTimeout(round))
+	} else if proposalWait {
+		p.setTimeout(p.roundTimeout(round))
+	}
+}
+
+// isNetworkAhead records a validator which sent a preprepare for a future sequence. The proposer of that
+// sequence is not known yet, so the network is only considered ahead once a weak quorum of validators sent one
+func (p *Pbft) isNetworkAhead(from NodeID) bool {
+	if !p.state.validators.Includes(from) {
+		return false
+	}
+	if p.state.aheadSenders == nil {
+		p.state.aheadSenders = map[NodeID]struct{}{}
+	}
+	p.state.aheadSenders[from] = struct{}{}
+	return p.state.hasWeakQuorum(p.state.sendersPower(p.state.aheadSenders))
+}
+
+// setTimeout replaces the timeout of the current round with a new one
+func (p *Pbft) setTimeout(timeout time.Duration) {
+	if p.timeoutTimer != nil {
+		p.timeoutTimer.Stop()
+	}
+	p.timeoutTimer = p.clock.NewTimer(timeout)
+	p.state.timeout = p.timeoutTimer.C()
 }
 
 // runAcceptState runs the Accept state loop
@@ -294,20 +1399,40 @@ func (p *Pbft) runAcceptState(ctx context.Context) { // start new round
 	_, span := p.tracer.Start(ctx, "AcceptState")
 	defer span.End()
 
-	p.logger.Printf("[INFO] accept state: sequence %d", p.state.view.Sequence)
+	p.logger.Info("accept state", "sequence", p.state.view.Sequence)
 
-	if !p.state.validators.Includes(p.validator.NodeID()) {
+	if !p.config.Observer && !p.state.validators.Includes(p.validator.NodeID()) {
 		// we are not a validator anymore, move back to sync state
-		p.logger.Print("[INFO] we are not a validator anymore")
+		p.logger.Info("we are not a validator anymore", "sequence", p.state.view.Sequence)
 		p.setState(SyncState)
 		return
 	}
 
+	if !p.waitMinBlockInterval(span) {
+		return
+	}
+	p.checkValidatorSet(span)
+
 	// reset round messages
 	p.state.resetRoundMsgs()
-	p.state.CalcProposer()
+	p.state.clearLastErr()
+	p.calcProposer()
+
+	if round := p.state.GetCurrentRound(); !p.config.ProposerFilter(p.state.proposer, round) {
+		// the proposer is not going to propose, there is no point in waiting for the timeout
+		p.logger.Info("proposer vetoed, moving to the next round", "proposer", p.state.proposer, "sequence", p.state.view.Sequence, "round", round)
+		span.AddEvent("ProposerVetoed", trace.WithAttributes(
+			attribute.String("proposer", string(p.state.proposer)),
+			attribute.Int64("round", int64(round)),
+		))
+		p.handleStateErr(errProposerVetoed)
+		return
+	}
 
-	isProposer := p.state.proposer == p.validator.NodeID()
+	p.announceSequence()
+
+	// an observer never proposes, even if it is part of the validator set
+	isProposer := !p.config.Observer && p.state.proposer == p.validator.NodeID()
 
 	p.backend.Init(&RoundInfo{
 		Proposer:   p.state.proposer,
@@ -322,26 +1447,65 @@ func (p *Pbft) runAcceptState(ctx context.Context) { // start new round
 		attribute.String("proposer", string(p.state.proposer)),
 	)
 
+	// the wait for the votes has its own deadline once the node moves to ValidateState
+	proposalWait := !isProposer && p.proposalTimeout != nil
+	defer func() {
+		if p.getState() == ValidateState {
+			p.startVoteTimeout(proposalWait)
+		}
+	}()
+
 	var err error
 
 	if isProposer {
-		p.logger.Printf("[INFO] we are the proposer")
+		p.logger.Info("we are the proposer", "sequence", p.state.view.Sequence, "round", p.state.GetCurrentRound())
 
 		if !p.state.locked {
 			// since the state is not locked, we need to build a new proposal
-			p.state.proposal, err = p.backend.BuildProposal()
+			buildStart := p.clock.Now()
+			p.state.proposal, err = p.buildProposal()
 			if err != nil {
-				p.logger.Printf("[ERROR] failed to build proposal: %v", err)
+				if p.ctx.Err() != nil {
+					// the state machine is stopping, do not start a new round. A context error returned
+					// by the backend on its own (e.g. an internal deadline) is a failure like any other
+					p.logger.Info("proposal building aborted", "err", err)
+					return
+				}
+				p.logger.Error("failed to build proposal", "err", err)
+				if errors.Is(err, errUnknownParent) {
+					// the proposal cannot extend a parent the node does not know, catch up through sync
+					p.setState(SyncState)
+					return
+				}
+				if errors.Is(err, errNoProposal) {
+					// a backend bug rather than a transient failure, report it through LastError
+					p.handleStateErr(err)
+					return
+				}
 				p.setState(RoundChangeState)
 				return
 			}
+			p.metrics.ProposalBuilt(p.clock.Now().Sub(buildStart))
+			p.state.proposalSeenAt = p.clock.Now()
 
 			// calculate how much time do we have to wait to gossip the proposal
-			delay := time.Until(p.state.proposal.Time)
+			delay := p.clock.Until(p.state.proposal.Time)
+			if p.config.MaxProposalDelay > 0 && delay > p.config.MaxProposalDelay {
+				p.logger.Warn("proposal time is too far in the future", "wait", p.config.MaxProposalDelay, "delay", delay)
+				delay = p.config.MaxProposalDelay
+			}
 
+			timer := p.clock.NewTimer(delay)
 			select {
-			case <-time.After(delay):
+			case <-timer.C():
+			case <-p.forceTimeoutCh:
+				timer.Stop()
+				p.logger.Info("proposal delay interrupted by a forced timeout", "round", p.state.GetCurrentRound())
+				span.AddEvent("ForceTimeout")
+				p.setState(RoundChangeState)
+				return
 			case <-p.ctx.Done():
+				timer.Stop()
 				return
 			}
 
@@ -358,11 +1522,17 @@ func (p *Pbft) runAcceptState(ctx context.Context) { // start new round
 		return
 	}
 
-	p.logger.Printf("[INFO] proposer calculated: proposer=%s, sequence=%d", p.state.proposer, p.state.view.Sequence)
+	p.logger.Info("proposer calculated", "proposer", p.state.proposer, "sequence", p.state.view.Sequence, "round", p.state.GetCurrentRound())
 
 	// we are NOT a proposer for this height/round. Then, we have to wait
 	// for a pre-prepare message from the proposer
 
+	if proposalWait {
+		// wait for the proposal using the proposal timeout, the validation
+		// gets its own timeout once the proposal arrives (see startVoteTimeout)
+		p.setTimeout(p.proposalTimeout(p.state.GetCurrentRound()))
+	}
+
 	// We only need to wait here for one type of message, the Prepare message from the proposer.
 	// However, since we can receive bad Prepare messages we have to wait (or timeout) until
 	// we get the message from the correct proposer.
@@ -376,40 +1546,143 @@ func (p *Pbft) runAcceptState(ctx context.Context) { // start new round
 			continue
 		}
 
-		// TODO: Validate that the fields required for Preprepare are set (Proposal and Hash)
+		// the message queue filters by view, but a custom notifier may hand over
+		// a preprepare from another sequence that must not be validated against this one
+		if msg.View.Sequence != p.state.view.Sequence {
+			p.logger.Error("preprepare for wrong sequence", "expected", p.state.view.Sequence, "found", msg.View.Sequence)
+			spanAddEventMessage("wrongSequence", span, msg)
+			p.metrics.MessageDropped(msg.Type)
+
+			if msg.View.Sequence > p.state.view.Sequence && p.isNetworkAhead(msg.From) {
+				// the network is ahead of us, catch up through sync
+				p.setState(SyncState)
+				return
+			}
+			continue
+		}
+
 		if msg.From != p.state.proposer {
-			p.logger.Printf("[ERROR] msg received from wrong proposer: expected=%s, found=%s", p.state.proposer, msg.From)
+			p.logger.Error("msg received from wrong proposer", "expected", p.state.proposer, "found", msg.From)
+			continue
+		}
+		if err := p.verifyProposerSeal(p.state.proposer, msg); err != nil {
+			// someone else is impersonating the proposer, keep waiting for the genuine proposal
+			p.logger.Error("invalid proposer seal", "from", msg.From, "err", err)
+			spanAddEventMessage("invalidProposerSeal", span, msg)
+			p.metrics.MessageDropped(msg.Type)
 			continue
 		}
 
-		// retrieve the proposal, the backend MUST validate that the hash belongs to the proposal
+		// reject the oversized proposals before the backend (or the locked proposal comparison) processes them
+		if maxSize := p.config.MaxProposalSize; maxSize > 0 && len(msg.Proposal) > maxSize {
+			p.logger.Error("proposal too large", "from", msg.From, "size", len(msg.Proposal), "max", maxSize)
+			spanAddEventMessage("proposalTooLarge", span, msg)
+			p.metrics.MessageDropped(msg.Type)
+			p.setState(RoundChangeState)
+			return
+		}
+
+		// a backend which hashes the proposals checks the hash itself, otherwise
+		// the backend MUST validate in Validate that the hash belongs to the proposal
+		if hash, ok := p.hashProposalData(msg.Proposal); ok && !bytes.Equal(hash, msg.Hash) {
+			p.logger.Error("proposal hash mismatch", "from", msg.From)
+			spanAddEventMessage("proposalHashMismatch", span, msg)
+			p.metrics.MessageDropped(msg.Type)
+			p.setState(RoundChangeState)
+			return
+		}
+
+		// retrieve the proposal
 		proposal := &Proposal{
-			Data: msg.Proposal,
-			Hash: msg.Hash,
+			Data:       msg.Proposal,
+			Hash:       msg.Hash,
+			ParentHash: msg.ParentHash,
+		}
+		if err := p.verifyParentHash(proposal, p.state.view.Sequence); errors.Is(err, errUnknownParent) {
+			// the proposal cannot be checked against a parent the node does not know, catch up through sync
+			p.logger.Warn("proposal extends an unknown parent", "from", msg.From, "sequence", p.state.view.Sequence)
+			spanAddEventMessage("unknownParent", span, msg)
+			p.setState(SyncState)
+			return
+		} else if err != nil {
+			p.logger.Error("proposal does not extend the committed chain", "from", msg.From, "err", err)
+			spanAddEventMessage("wrongParentHash", span, msg)
+			p.handleStateErr(err)
+			return
 		}
-		if err := p.backend.Validate(proposal); err != nil {
-			p.logger.Printf("[ERROR] failed to validate proposal. Error message: %v", err)
+		if ok, err := p.validateProposalWithRetry(span, proposal); !ok {
+			return
+		} else if err != nil {
+			p.logger.Error("failed to validate proposal", "err", err)
 			p.setState(RoundChangeState)
 			return
 		}
 
 		if p.state.locked {
 			// the state is locked, we need to receive the same proposal
-			if p.state.proposal.Equal(proposal) {
+			if !p.state.proposal.Equal(proposal) {
+				p.handleStateErr(errIncorrectLockedProposal)
+			} else if err := p.verifyLockedProposal(msg); err != nil {
+				// the proposer has to prove the proposal was prepared before we fast-track it
+				p.logger.Error("invalid prepared certificate from proposer", "from", msg.From, "err", err)
+				p.handleStateErr(errInvalidPreparedCertificate)
+			} else if p.config.StrictPreparePhase {
+				// go through the prepare phase again, the commit waits for a prepare quorum
+				p.sendPrepareMsg()
+				p.setState(ValidateState)
+			} else {
 				// fast-track and send a commit message and wait for validations
 				p.sendCommitMsg()
 				p.setState(ValidateState)
-			} else {
-				p.handleStateErr(errIncorrectLockedProposal)
 			}
 		} else {
 			p.state.proposal = proposal
+			p.state.proposalSeenAt = p.clock.Now()
 			p.sendPrepareMsg()
 			p.setState(ValidateState)
 		}
 	}
 }
 
+// announceSequence notifies the SequenceStarted handler with the view and the proposer,
+// if it was not notified for the current sequence yet
+func (p *Pbft) announceSequence() {
+	sequence := p.state.view.Sequence
+	if p.sequenceStarted && p.startedSequence == sequence {
+		return
+	}
+	p.startedSequence, p.sequenceStarted = sequence, true
+	p.config.SequenceStarted(*p.state.getView(), p.state.proposer)
+}
+
+// waitMinBlockInterval waits until the minimum block interval has passed since the last commit.
+// It returns false if the wait is interrupted by a forced timeout or the state machine is closed
+func (p *Pbft) waitMinBlockInterval(span trace.Span) bool {
+	if p.config.MinBlockInterval <= 0 || p.lastCommit.IsZero() {
+		return true
+	}
+	delay := p.config.MinBlockInterval - p.clock.Now().Sub(p.lastCommit)
+	if delay <= 0 {
+		return true
+	}
+
+	p.logger.Debug("waiting for the minimum block interval", "sequence", p.state.view.Sequence, "wait", delay)
+	timer := p.clock.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return true
+	case <-p.forceTimeoutCh:
+		p.logger.Info("minimum block interval wait interrupted by a forced timeout", "round", p.state.GetCurrentRound())
+		span.AddEvent("ForceTimeout")
+		p.setState(RoundChangeState)
+		return false
+	case <-p.ctx.Done():
+		return false
+	}
+}
+
 // runValidateState implements the Validate state loop.
 //
 // The Validate state is rather simple - all nodes do in this state is read messages and add them to their local snapshot state
@@ -417,11 +1690,42 @@ func (p *Pbft) runValidateState(ctx context.Context) { // start new round
 	ctx, span := p.tracer.Start(ctx, "ValidateState")
 	defer span.End()
 
+	if p.state.proposal == nil {
+		// there is nothing to validate, it should never happen but it must not take down the node
+		p.logger.Error("validate state without a proposal", "sequence", p.state.view.Sequence, "round", p.state.GetCurrentRound())
+		p.handleStateErr(errNoProposal)
+		return
+	}
+
 	hasCommitted := false
 	sendCommit := func(span trace.Span) {
+		if p.state.proposal == nil {
+			p.logger.Error("cannot lock and commit without a proposal", "sequence", p.state.view.Sequence, "round", p.state.GetCurrentRound())
+			return
+		}
+
 		// at this point either we have enough prepare messages
 		// or commit messages so we can lock the proposal
-		p.state.lock()
+		cert := p.state.lockCertificate()
+		if cert == nil {
+			// keep the proof that the locked proposal was prepared
+			if p.hasPrepareQuorum(p.state.messagesPower(p.state.prepared)) {
+				cert = p.state.buildPreparedCertificate()
+			} else {
+				cert = p.state.buildCommitCertificate()
+			}
+		}
+
+		if !p.state.IsLocked() || cert != p.state.lockCertificate() {
+			p.state.lock(cert)
+
+			// persist the lock before committing to the proposal
+			entry := &WALEntry{Type: WALLock, Proposal: p.state.proposal.Copy()}
+			if cert != nil {
+				entry.PreparedCertificate = cert.Copy()
+			}
+			p.appendWAL(entry)
+		}
 
 		if !hasCommitted {
 			// send the commit message
@@ -432,9 +1736,54 @@ func (p *Pbft) runValidateState(ctx context.Context) { // start new round
 		}
 	}
 
+	preparesAggregated, commitsAggregated := false, false
+	checkQuorum := func(span trace.Span) {
+		if p.hasPrepareQuorum(p.state.messagesPower(p.state.prepared)) {
+			if p.isAggregator() && !preparesAggregated {
+				// the validators wait for the prepare messages the proposer aggregated
+				p.broadcastVotes(MessageReq_Prepare, p.state.prepared)
+				preparesAggregated = true
+			}
+
+			// we have received enough prepare messages
+			sendCommit(span)
+		}
+
+		if p.hasCommitQuorum(p.state.messagesPower(p.state.committed)) {
+			if !hasCommitted {
+				// the node did not see a prepare quorum (i.e. it was slightly behind), but the honest nodes
+				// among the commit quorum did, so the proposal was prepared and it is safe to lock and commit it.
+				// The commit messages are the proof it was prepared
+				p.logger.Debug("commit quorum without a prepare quorum", "sequence", p.state.view.Sequence, "round", p.state.GetCurrentRound())
+				span.AddEvent("CommitWithoutPrepareQuorum")
+			}
+
+			// we have received enough commit messages
+			sendCommit(span)
+
+			if p.isAggregator() && !commitsAggregated {
+				p.broadcastVotes(MessageReq_Commit, p.state.committed)
+				commitsAggregated = true
+			}
+
+			// change to commit state just to get out of the loop
+			p.setState(CommitState)
+		}
+	}
+
 	for p.getState() == ValidateState {
 		_, span := p.tracer.Start(ctx, "ValidateState")
 
+		if p.config.SelfMessageBypass {
+			// our own messages do not go through the queue, they might have completed a quorum already
+			checkQuorum(span)
+			if p.getState() != ValidateState {
+				p.setStateSpanAttributes(span)
+				span.End()
+				return
+			}
+		}
+
 		msg, ok := p.getNextMessage(span)
 		if !ok {
 			// closing
@@ -449,38 +1798,41 @@ func (p *Pbft) runValidateState(ctx context.Context) { // start new round
 		}
 
 		// the message must have our local hash
-		if !bytes.Equal(msg.Hash, p.state.proposal.Hash) {
-			p.logger.Printf(fmt.Sprintf("[WARN]: incorrect hash in %s message", msg.Type.String()))
+		if !bytes.Equal(msg.Hash, p.proposalHash()) {
+			p.logger.Warn("incorrect hash in message", "type", msg.Type, "from", msg.From)
 			continue
 		}
 
 		switch msg.Type {
 		case MessageReq_Prepare:
+			if p.config.EarlyCommit && hasCommitted {
+				// the prepare quorum was reached already, only the commit messages are needed
+				spanAddEventMessage("skipPrepare", span, msg)
+				continue
+			}
 			p.state.addPrepared(msg)
+			p.appendWAL(&WALEntry{Type: WALMessage, Message: msg.Copy()})
 
 		case MessageReq_Commit:
+			if err := p.verifyCommitSeal(msg); err != nil {
+				p.logger.Error("invalid committed seal", "from", msg.From, "err", err)
+				spanAddEventMessage("invalidSeal", span, msg)
+				// the sender is not reported, an honest validator seals another proposal if the proposer equivocated
+				p.metrics.MessageDropped(msg.Type)
+				continue
+			}
 			if err := p.backend.ValidateCommit(msg.From, msg.Seal); err != nil {
-				p.logger.Printf("[ERROR]: failed to validate commit: %v", err)
+				p.logger.Error("failed to validate commit", "from", msg.From, "err", err)
 				continue
 			}
 			p.state.addCommitted(msg)
+			p.appendWAL(&WALEntry{Type: WALMessage, Message: msg.Copy()})
 
 		default:
 			panic(fmt.Errorf("BUG: Unexpected message type: %s in %s", msg.Type, p.getState()))
 		}
 
-		if p.state.numPrepared() > p.state.NumValid() {
-			// we have received enough prepare messages
-			sendCommit(span)
-		}
-
-		if p.state.numCommitted() > p.state.NumValid() {
-			// we have received enough commit messages
-			sendCommit(span)
-
-			// change to commit state just to get out of the loop
-			p.setState(CommitState)
-		}
+		checkQuorum(span)
 
 		// set the attributes of this span once it is done
 		p.setStateSpanAttributes(span)
@@ -508,58 +1860,206 @@ func spanAddEventMessage(typ string, span trace.Span, msg *MessageReq) {
 	))
 }
 
-func (p *Pbft) setStateSpanAttributes(span trace.Span) {
-	attr := []attribute.KeyValue{}
+func (p *Pbft) setStateSpanAttributes(span trace.Span) {
+	attr := []attribute.KeyValue{}
+
+	// number of committed messages
+	attr = append(attr, attribute.Int64("committed", int64(p.state.numCommitted())))
+
+	// number of prepared messages
+	attr = append(attr, attribute.Int64("prepared", int64(p.state.numPrepared())))
+
+	// number of change state messages per round
+	for round, msgs := range p.state.roundMessages {
+		attr = append(attr, attribute.Int64(fmt.Sprintf("roundchange_%d", round), int64(len(msgs))))
+	}
+	span.SetAttributes(attr...)
+}
+
+func (p *Pbft) runCommitState(ctx context.Context) {
+	ctx, span := p.tracer.Start(ctx, "CommitState")
+	defer span.End()
+
+	committedSeals, err := p.verifyCommittedSeals()
+	if err != nil {
+		// keep the state locked since the proposal has not been committed
+		p.logger.Error("failed to verify committed seals", "err", err)
+		span.AddEvent("InvalidCommittedSeals")
+		p.handleStateErr(err)
+		return
+	}
+	committers := sealSigners(committedSeals)
+	aggregatedSeal, aggregated, err := p.aggregateSeals(committedSeals)
+	if err != nil {
+		// keep the state locked, the proposal has not been committed either
+		p.logger.Error("failed to aggregate committed seals", "err", err)
+		span.AddEvent("SealAggregationFailed")
+		p.handleStateErr(errSealAggregationFailed)
+		return
+	}
+	if aggregated {
+		committedSeals = nil
+	}
+
+	pp := &SealedProposal{
+		Proposal:       p.state.proposal.Copy(),
+		CommittedSeals: committedSeals,
+		AggregatedSeal: aggregatedSeal,
+		Committers:     committers,
+		Proposer:       p.state.proposer,
+		Number:         p.state.view.Sequence,
+		SeenAt:         p.state.proposalSeenAt,
+		CommittedAt:    p.clock.Now(),
+	}
+	if p.state.unconfirmed != nil {
+		// the proposal is inserted already, it is not inserted twice
+		pp = p.state.unconfirmed
+	} else {
+		err = p.insertWithRetry(pp)
+	}
+	if err != nil && p.isRetryableInsertError(err) {
+		// keep the state locked since the proposal is committed, it is inserted again in the next round
+		p.logger.Error("failed to insert proposal after retries", "sequence", p.state.view.Sequence, "err", err)
+		span.AddEvent("InsertRetriesExhausted")
+		p.handleStateErr(errFailedToInsertProposal)
+		return
+	}
+	if err == nil {
+		if err := p.confirmInserted(pp.Number); err != nil {
+			if p.ctx.Err() != nil {
+				// the state machine is stopping
+				return
+			}
+			// keep the state locked and remember the insert, only the confirmation is waited for again
+			// once the proposal is committed in the next round
+			p.logger.Error("failed to confirm the insert of the proposal", "sequence", p.state.view.Sequence, "err", err)
+			span.AddEvent("InsertNotConfirmed")
+			p.state.unconfirmed = pp
+			p.handleStateErr(errInsertNotConfirmed)
+			return
+		}
+		p.state.unconfirmed = nil
+	}
+
+	// at this point either if it works or not we need to unlock the state
+	// to allow for other proposals to be produced if it insertion fails
+	p.state.unlock()
+	p.appendWAL(&WALEntry{Type: WALUnlock})
+
+	if err != nil {
+		// start a new round with the state unlocked since we need to
+		// be able to propose/validate a different proposal
+		p.logger.Error("failed to insert proposal", "sequence", p.state.view.Sequence, "err", err)
+		p.handleStateErr(errFailedToInsertProposal)
+	} else {
+		p.completeSequence(pp)
+	}
+}
+
+// completeSequence records the proposal inserted for the current sequence and finishes it
+func (p *Pbft) completeSequence(pp *SealedProposal) {
+	p.metrics.SequenceCommitted(pp.Number, p.clock.Now().Sub(p.state.sequenceStart))
+	p.forks.commit(pp)
+	p.config.SequenceCompleted(pp)
+	p.committed = pp
+	p.lastCommit = p.clock.Now()
+	p.progressTime.Store(p.lastCommit)
+
+	// move to done state to finish the current iteration of the state machine
+	p.setState(DoneState)
+}
+
+// verifyCommittedSeals checks every committed seal against the current validator set. The seals were verified
+// against the proposal hash as the commit messages were read (see verifyCommitSeal), they are not verified again.
+// It returns only the seals of the validators (one per signer), and fails if they are not enough to form a quorum.
+func (p *Pbft) verifyCommittedSeals() ([]CommittedSeal, error) {
+	seals := p.state.getCommittedSeals()
+	verified := make([]CommittedSeal, 0, len(seals))
+	signers := make(map[NodeID]struct{}, len(seals))
 
-	// number of committed messages
-	attr = append(attr, attribute.Int64("committed", int64(p.state.numCommitted())))
+	for _, seal := range seals {
+		if _, ok := signers[seal.NodeID]; ok {
+			continue
+		}
+		if !p.state.validators.Includes(seal.NodeID) {
+			p.logger.Warn("committed seal from non validator", "from", seal.NodeID)
+			continue
+		}
+		signers[seal.NodeID] = struct{}{}
+		verified = append(verified, seal)
+	}
 
-	// number of prepared messages
-	attr = append(attr, attribute.Int64("prepared", int64(p.state.numPrepared())))
+	if !p.hasCommitQuorum(p.state.sendersPower(signers)) {
+		return nil, errInsufficientCommittedSeals
+	}
+	return verified, nil
+}
 
-	// number of change state messages per round
-	for round, msgs := range p.state.roundMessages {
-		attr = append(attr, attribute.Int64(fmt.Sprintf("roundchange_%d", round), int64(len(msgs))))
+// verifyCommitSeal checks that the committed seal of the commit message is signed by its sender, and that
+// the sender is a validator. Otherwise a node could relay the seal of another validator under its own name
+// and have it counted twice towards the commit quorum
+func (p *Pbft) verifyCommitSeal(msg *MessageReq) error {
+	if !p.state.validators.Includes(msg.From) {
+		return errCommitFromNonValidator
 	}
-	span.SetAttributes(attr...)
+	return p.backend.VerifyCommittedSeal(msg.From, msg.Seal, p.proposalHash())
 }
 
-func (p *Pbft) runCommitState(ctx context.Context) {
-	_, span := p.tracer.Start(ctx, "CommitState")
-	defer span.End()
+// sealSigners returns the distinct signers of the committed seals, sorted by NodeID
+func sealSigners(seals []CommittedSeal) []NodeID {
+	signers := make([]NodeID, 0, len(seals))
+	seen := make(map[NodeID]struct{}, len(seals))
+	for _, seal := range seals {
+		if _, ok := seen[seal.NodeID]; ok {
+			continue
+		}
+		seen[seal.NodeID] = struct{}{}
+		signers = append(signers, seal.NodeID)
+	}
+	sort.Slice(signers, func(i, j int) bool { return signers[i] < signers[j] })
+	return signers
+}
 
-	committedSeals := p.state.getCommittedSeals()
-	proposal := p.state.proposal.Copy()
+// hasPrepareQuorum checks if the voting power of the prepare messages is enough to lock the proposal
+func (p *Pbft) hasPrepareQuorum(power uint64) bool {
+	return p.hasThresholdQuorum(p.config.PrepareThreshold, power)
+}
 
-	// at this point either if it works or not we need to unlock the state
-	// to allow for other proposals to be produced if it insertion fails
-	p.state.unlock()
+// hasCommitQuorum checks if the voting power of the commit messages is enough to commit the proposal
+func (p *Pbft) hasCommitQuorum(power uint64) bool {
+	return p.hasThresholdQuorum(p.config.CommitThreshold, power)
+}
 
-	pp := &SealedProposal{
-		Proposal:       proposal,
-		CommittedSeals: committedSeals,
-		Proposer:       p.state.proposer,
-		Number:         p.state.view.Sequence,
+// hasThresholdQuorum checks if the voting power exceeds the threshold, or if it is a standard quorum
+// when there is no threshold configured
+func (p *Pbft) hasThresholdQuorum(threshold QuorumThreshold, power uint64) bool {
+	if threshold == nil {
+		return p.state.hasQuorum(power)
 	}
-	if err := p.backend.Insert(pp); err != nil {
-		// start a new round with the state unlocked since we need to
-		// be able to propose/validate a different proposal
-		p.logger.Printf("[ERROR] failed to insert proposal. Error message: %v", err)
-		p.handleStateErr(errFailedToInsertProposal)
-	} else {
-		// move to done state to finish the current iteration of the state machine
-		p.setState(DoneState)
+	// the power is compared with a threshold of the same unit
+	total := uint64(p.state.validators.Len())
+	if weighted, ok := p.state.validators.(WeightedValidatorSet); ok {
+		total = weighted.TotalVotingPower()
 	}
+	return power > threshold(total)
 }
 
 var (
-	errIncorrectLockedProposal = fmt.Errorf("locked proposal is incorrect")
-	errVerificationFailed      = fmt.Errorf("proposal verification failed")
-	errFailedToInsertProposal  = fmt.Errorf("failed to insert proposal")
+	errIncorrectLockedProposal    = fmt.Errorf("locked proposal is incorrect")
+	errVerificationFailed         = fmt.Errorf("proposal verification failed")
+	errFailedToInsertProposal     = fmt.Errorf("failed to insert proposal")
+	errInsertNotConfirmed         = fmt.Errorf("insert of proposal not confirmed")
+	errInsufficientCommittedSeals = fmt.Errorf("not enough valid committed seals")
+	errInvalidPreparedCertificate = fmt.Errorf("invalid prepared certificate")
+	errProposerEquivocation       = fmt.Errorf("proposer sent conflicting proposals")
+	errNoProposal                 = fmt.Errorf("no proposal")
+	errSealAggregationFailed      = fmt.Errorf("failed to aggregate committed seals")
+	errProposerVetoed             = fmt.Errorf("proposer vetoed")
+	errCommitFromNonValidator     = fmt.Errorf("commit message from a non validator")
 )
 
 func (p *Pbft) handleStateErr(err error) {
-	p.state.err = err
+	p.state.setErr(err)
 	p.setState(RoundChangeState)
 }
 
@@ -568,9 +2068,20 @@ func (p *Pbft) runRoundChangeState(ctx context.Context) {
 	defer span.End()
 
 	sendRoundChange := func(round uint64) {
-		p.logger.Printf("[DEBUG] local round change: round=%d", round)
+		if maxRounds := p.config.MaxRounds; maxRounds > 0 && round >= maxRounds {
+			// we reached the round limit, give the runtime a chance to sync
+			p.logger.Info("max rounds reached, moving to sync state", "round", round, "max", maxRounds)
+			span.AddEvent("MaxRoundsReached", trace.WithAttributes(
+				attribute.Int64("round", int64(round)),
+				attribute.Int64("max", int64(maxRounds)),
+			))
+			p.setState(SyncState)
+			return
+		}
+		p.logger.Debug("local round change", "sequence", p.state.view.Sequence, "round", round)
 		// set the new round
 		p.setRound(round)
+		p.metrics.RoundChange(round)
 		// clean the round
 		p.state.cleanRound(round)
 		// send the round change message
@@ -595,6 +2106,16 @@ func (p *Pbft) runRoundChangeState(ctx context.Context) {
 			return
 		}
 
+		// the heights we can see might not tell it, but if a quorum of validators
+		// has not been heard from for a while we are most likely partitioned from it
+		if p.isStarved() {
+			span.AddEvent("Starved")
+			p.logger.Info("no messages from a quorum of validators, moving to sync state",
+				"sequence", p.state.view.Sequence, "round", p.state.GetCurrentRound(), "timeout", p.config.StuckTimeout)
+			p.setState(SyncState)
+			return
+		}
+
 		// otherwise, it seems that we are in sync
 		// and we should start a new round
 		sendNextRoundChange()
@@ -603,13 +2124,13 @@ func (p *Pbft) runRoundChangeState(ctx context.Context) {
 	// if the round was triggered due to an error, we send our own
 	// next round change
 	if err := p.state.getErr(); err != nil {
-		p.logger.Printf("[DEBUG] round change handle error. Error message: %v", err)
+		p.logger.Debug("round change handle error", "err", err)
 		sendNextRoundChange()
 	} else {
 		// otherwise, it is due to a timeout in any stage
 		// First, we try to sync up with any max round already available
 		if maxRound, ok := p.state.maxRound(); ok {
-			p.logger.Printf("[DEBUG] round change, max round=%d", maxRound)
+			p.logger.Debug("round change to max round", "round", maxRound)
 			sendRoundChange(maxRound)
 		} else {
 			// otherwise, do your best to sync up
@@ -628,7 +2149,7 @@ func (p *Pbft) runRoundChangeState(ctx context.Context) {
 			return
 		}
 		if msg == nil {
-			p.logger.Print("[DEBUG] round change timeout")
+			p.logger.Debug("round change timeout", "round", p.state.GetCurrentRound())
 
 			// checkTimeout will either produce a sync event and exit
 			// or restart the timeout
@@ -637,18 +2158,39 @@ func (p *Pbft) runRoundChangeState(ctx context.Context) {
 			continue
 		}
 
+		if err := p.verifyRoundChangeCertificate(msg); err != nil {
+			p.logger.Error("invalid round change certificate", "from", msg.From, "err", err)
+			spanAddEventMessage("invalidRoundChangeCertificate", span, msg)
+			p.setStateSpanAttributes(span)
+			span.End()
+			continue
+		}
+
 		// we only expect RoundChange messages right now
-		num := p.state.AddRoundMessage(msg)
+		p.state.AddRoundMessage(msg)
+		round := msg.View.Round
+		if p.state.hasWeakQuorum(p.state.roundMessagesPower(round)) {
+			// the queue is sorted by round, so the messages for the higher rounds are read last.
+			// Collect the queued ones and act on the highest round with a weak certificate,
+			// instead of on whichever round the message which reached it belongs to
+			p.collectRoundMessages(span)
+			if maxRound, ok := p.state.maxRound(); ok && maxRound > round {
+				round = maxRound
+			}
+		}
+		power := p.state.roundMessagesPower(round)
 
-		if num == p.state.NumValid() {
+		// the thresholds are latched, so neither duplicates nor messages arriving
+		// after the round messages were cleaned take the same action twice
+		if p.state.hasRoundChangeQuorum(power) && p.state.fireRoundAction(round, roundChangeQuorumAction) {
 			// start a new round inmediatly
-			p.state.SetCurrentRound(msg.View.Round)
+			p.state.SetCurrentRound(round)
 			p.setState(AcceptState)
-		} else if num == p.state.MaxFaultyNodes()+1 {
+		} else if p.state.hasWeakQuorum(power) && p.state.fireRoundAction(round, roundChangeWeakAction) {
 			// weak certificate, try to catch up if our round number is smaller
-			if p.state.GetCurrentRound() < msg.View.Round {
+			if p.state.GetCurrentRound() < round {
 				// update timer
-				sendRoundChange(msg.View.Round)
+				sendRoundChange(round)
 			}
 		}
 
@@ -657,6 +2199,103 @@ func (p *Pbft) runRoundChangeState(ctx context.Context) {
 	}
 }
 
+// collectRoundMessages adds the round change messages already in the queue to the state, without waiting for more
+func (p *Pbft) collectRoundMessages(span trace.Span) {
+	for {
+		msg, discards := p.notifier.ReadNextMessage(p)
+		p.handleDiscards(span, discards)
+		if msg == nil {
+			return
+		}
+		spanAddEventMessage("message", span, msg)
+
+		if err := p.verifyRoundChangeCertificate(msg); err != nil {
+			p.logger.Error("invalid round change certificate", "from", msg.From, "err", err)
+			spanAddEventMessage("invalidRoundChangeCertificate", span, msg)
+			continue
+		}
+		p.state.AddRoundMessage(msg)
+	}
+}
+
+// verifyRoundChangeCertificate verifies the round change certificate carried by a round change message, if any
+func (p *Pbft) verifyRoundChangeCertificate(msg *MessageReq) error {
+	if msg.RoundChangeCertificate == nil || msg.RoundChangeCertificate.PreparedCertificate == nil {
+		// the sender was not locked on any proposal
+		return nil
+	}
+	cert := msg.RoundChangeCertificate.PreparedCertificate
+	if cert.View == nil || cert.View.Cmp(msg.View) >= 0 {
+		return fmt.Errorf("prepared certificate round is not lower than the round change")
+	}
+	return p.verifyPreparedCertificate(cert, msg.View.Sequence)
+}
+
+// verifyLockedProposal verifies the prepared certificate attached to the preprepare message of a locked proposal
+func (p *Pbft) verifyLockedProposal(msg *MessageReq) error {
+	cert := msg.PreparedCertificate
+	if cert == nil {
+		return fmt.Errorf("prepared certificate is missing")
+	}
+	if !bytes.Equal(cert.Hash, msg.Hash) {
+		return fmt.Errorf("prepared certificate is for a different proposal")
+	}
+	if cert.View != nil && cert.View.Cmp(msg.View) > 0 {
+		return fmt.Errorf("prepared certificate is from a future round")
+	}
+	return p.verifyPreparedCertificate(cert, p.state.view.Sequence)
+}
+
+// verifyPreparedCertificate checks that the certificate holds a quorum of valid prepare messages (or a commit quorum
+// of valid commit messages) from distinct validators for the same proposal in the given sequence
+func (p *Pbft) verifyPreparedCertificate(cert *PreparedCertificate, sequence uint64) error {
+	if cert.View == nil || cert.View.Sequence != sequence {
+		return fmt.Errorf("prepared certificate is not for sequence %d", sequence)
+	}
+	if len(cert.Hash) == 0 {
+		return fmt.Errorf("prepared certificate has no hash")
+	}
+
+	typ := MessageReq_Prepare
+	if len(cert.PrepareMessages) > 0 && cert.PrepareMessages[0].Type == MessageReq_Commit {
+		typ = MessageReq_Commit
+	}
+	senders := map[NodeID]struct{}{}
+	for _, prepare := range cert.PrepareMessages {
+		if prepare.Type != typ {
+			return fmt.Errorf("unexpected %s message in prepared certificate", prepare.Type)
+		}
+		if prepare.View == nil || prepare.View.Cmp(cert.View) != 0 {
+			return fmt.Errorf("prepare message from %s has a different view", prepare.From)
+		}
+		if !bytes.Equal(prepare.Hash, cert.Hash) {
+			return fmt.Errorf("prepare message from %s has a different hash", prepare.From)
+		}
+		if !p.state.validators.Includes(prepare.From) {
+			return fmt.Errorf("prepare message from non validator %s", prepare.From)
+		}
+		if err := p.msgVerifier(prepare); err != nil {
+			return fmt.Errorf("prepare message from %s failed verification: %w", prepare.From, err)
+		}
+		if typ == MessageReq_Commit {
+			if err := p.backend.VerifyCommittedSeal(prepare.From, prepare.Seal, cert.Hash); err != nil {
+				return fmt.Errorf("commit message from %s has an invalid seal: %w", prepare.From, err)
+			}
+		}
+		senders[prepare.From] = struct{}{}
+	}
+	if typ == MessageReq_Commit {
+		if !p.hasCommitQuorum(p.state.sendersPower(senders)) {
+			return fmt.Errorf("not enough commit messages in prepared certificate: %d", len(senders))
+		}
+		return nil
+	}
+	if !p.hasPrepareQuorum(p.state.sendersPower(senders)) {
+		return fmt.Errorf("not enough prepare messages in prepared certificate: %d", len(senders))
+	}
+	return nil
+}
+
 // --- communication wrappers ---
 
 func (p *Pbft) sendRoundChange() {
@@ -676,32 +2315,46 @@ func (p *Pbft) sendCommitMsg() {
 }
 
 func (p *Pbft) gossip(msgType MsgType) {
-	msg := &MessageReq{
-		Type: msgType,
-		From: p.validator.NodeID(),
+	if p.config.Observer {
+		// observers do not vote, they only collect the messages of the validators
+		return
 	}
-	if msgType != MessageReq_RoundChange {
-		// Except for round change message in which we are deciding on the proposer,
-		// the rest of the consensus message require the hash:
-		// 1. Preprepare: notify the validators of the proposal + hash
-		// 2. Prepare + Commit: safe check to only include messages from our round.
-		msg.Hash = p.state.proposal.Hash
+	if msgType != MessageReq_RoundChange && p.state.proposal == nil {
+		p.logger.Error("cannot send message without a proposal", "type", msgType)
+		return
 	}
 
-	// add View
-	msg.View = p.state.view.Copy()
+	msg := p.newMessage(msgType)
 
 	// if we are sending a preprepare message we need to include the proposal
 	if msg.Type == MessageReq_Preprepare {
 		msg.SetProposal(p.state.proposal.Data)
+
+		// a locked proposal has to be justified with its prepared certificate
+		if cert := p.state.lockCertificate(); cert != nil {
+			msg.PreparedCertificate = cert.Copy()
+		}
+
+		// the nodes lagging behind can commit the previous sequence with it
+		msg.CommitCertificate = p.commitCertificate()
+
+		if p.state.proposal.ParentHash != nil {
+			msg.ParentHash = append([]byte{}, p.state.proposal.ParentHash...)
+		}
+
+		// prove that we are the author of the proposal
+		if err := p.sealProposal(msg); err != nil {
+			p.logger.Error("failed to seal the proposal", "err", err)
+			return
+		}
 	}
 
 	// if the message is commit, we need to add the committed seal
 	if msg.Type == MessageReq_Commit {
 		// seal the hash of the proposal
-		seal, err := p.validator.Sign(p.state.proposal.Hash)
+		seal, err := p.validator.Sign(p.proposalHash())
 		if err != nil {
-			p.logger.Printf("[ERROR] failed to commit seal. Error message: %v", err)
+			p.logger.Error("failed to commit seal", "err", err)
 			return
 		}
 		msg.Seal = seal
@@ -711,10 +2364,109 @@ func (p *Pbft) gossip(msgType MsgType) {
 		// send a copy to ourselves so that we can process this message as well
 		msg2 := msg.Copy()
 		msg2.From = p.validator.NodeID()
-		p.PushMessage(msg2)
+		if p.config.SelfMessageBypass && msg2.Type != MessageReq_RoundChange {
+			p.applySelfMessage(msg2)
+		} else {
+			p.pushMessage(msg2, false)
+		}
+	}
+	if p.sendVote(msg) {
+		return
+	}
+	p.gossipWithRetry(msg)
+}
+
+// newMessage creates a message of the local node for the current view
+func (p *Pbft) newMessage(msgType MsgType) *MessageReq {
+	msg := &MessageReq{
+		Type: msgType,
+		From: p.validator.NodeID(),
+	}
+	if msgType != MessageReq_RoundChange {
+		// Except for round change message in which we are deciding on the proposer,
+		// the rest of the consensus message require the hash:
+		// 1. Preprepare: notify the validators of the proposal + hash
+		// 2. Prepare + Commit: safe check to only include messages from our round.
+		msg.Hash = p.proposalHash()
+	}
+
+	// add View
+	msg.View = p.state.view.Copy()
+
+	// if we are locked, justify the round change with the prepared certificate of the locked proposal
+	if cert := p.state.lockCertificate(); msg.Type == MessageReq_RoundChange && cert != nil {
+		msg.RoundChangeCertificate = &RoundChangeCertificate{
+			PreparedCertificate: cert.Copy(),
+		}
+	}
+	return msg
+}
+
+// catchUpRoundChange sends the round change of the current round directly to the sender of a round change
+// for a lower round of the current sequence, so that the lagging node can catch up with the round.
+// It is only done if the transport supports direct messages
+func (p *Pbft) catchUpRoundChange(msg *MessageReq) {
+	if p.config.Observer || msg.Type != MessageReq_RoundChange || msg.From == p.validator.NodeID() || p.getState() != RoundChangeState {
+		return
+	}
+	if msg.View.Sequence != p.state.view.Sequence || msg.View.Cmp(p.state.getView()) >= 0 {
+		return
+	}
+	transport, ok := p.transport.(DirectTransport)
+	if !ok {
+		return
+	}
+
+	p.logger.Debug("sending round change to lagging node", "to", msg.From, "round", p.state.GetCurrentRound(), "senderRound", msg.View.Round)
+	if err := transport.Send(msg.From, p.newMessage(MessageReq_RoundChange)); err != nil {
+		p.logger.Warn("failed to send round change", "to", msg.From, "err", err)
+	}
+}
+
+// applySelfMessage adds a prepare or commit message of the local node directly to the state.
+// It applies the same filtering as the message queue, a message which is not relevant
+// for the current view and state anymore is dropped
+func (p *Pbft) applySelfMessage(msg *MessageReq) {
+	state := p.getState()
+	if state != AcceptState && state != ValidateState {
+		p.logger.Debug("dropping self message", "type", msg.Type, "state", state)
+		return
+	}
+	if msg.View.Cmp(p.state.getView()) != 0 || p.state.proposal == nil || !bytes.Equal(msg.Hash, p.proposalHash()) {
+		p.logger.Debug("dropping stale self message", "type", msg.Type)
+		return
+	}
+
+	switch msg.Type {
+	case MessageReq_Prepare:
+		p.state.addPrepared(msg)
+	case MessageReq_Commit:
+		p.state.addCommitted(msg)
+	default:
+		return
+	}
+	p.appendWAL(&WALEntry{Type: WALMessage, Message: msg.Copy()})
+}
+
+// gossipWithRetry sends the message to the transport, retrying up to the configured number of times if it fails
+func (p *Pbft) gossipWithRetry(msg *MessageReq) {
+	err := p.transport.Gossip(msg)
+	for retry := uint64(1); err != nil && retry <= p.config.GossipRetries; retry++ {
+		p.logger.Warn("failed to gossip, retrying", "retry", retry, "retries", p.config.GossipRetries, "err", err)
+
+		timer := p.clock.NewTimer(p.config.GossipRetryInterval)
+		select {
+		case <-timer.C():
+		case <-p.ctx.Done():
+			timer.Stop()
+			return
+		}
+		err = p.transport.Gossip(msg)
 	}
-	if err := p.transport.Gossip(msg); err != nil {
-		p.logger.Printf("[ERROR] failed to gossip. Error message: %v", err)
+
+	if err != nil {
+		p.logger.Error("failed to gossip", "type", msg.Type, "err", err)
+		p.config.GossipFailedHandler(msg, err)
 	}
 }
 
@@ -728,6 +2480,62 @@ func (p *Pbft) GetState() PbftState {
 	return p.getState()
 }
 
+// LastSequenceStats returns the timing stats of the last finished sequence
+func (p *Pbft) LastSequenceStats() SequenceStats {
+	return p.state.stats.getLast()
+}
+
+// ForceTimeout makes the state machine time out the current wait (the proposer delay or the wait for
+// new messages) as if the round timeout had expired, to start a round change right away.
+// It is safe to call from any goroutine, and several calls before the wait times out only trigger one timeout
+func (p *Pbft) ForceTimeout() {
+	select {
+	case p.forceTimeoutCh <- struct{}{}:
+	default:
+	}
+}
+
+// View returns a copy of the current view (sequence and round). It is the zero view until the backend is set
+func (p *Pbft) View() View {
+	return *p.state.getView()
+}
+
+// CurrentProposer returns the proposer of the current round
+func (p *Pbft) CurrentProposer() NodeID {
+	return p.state.getProposer()
+}
+
+// IsProposer checks if the local node is the proposer of the current round. If the proposer
+// of the round is not calculated yet, it is calculated without changing the state.
+// It is safe to call it concurrently
+func (p *Pbft) IsProposer() bool {
+	if p.config.Observer {
+		return false
+	}
+	proposer, ok := p.state.getCurrentProposer()
+	if !ok {
+		validators := p.state.getValidators()
+		if validators == nil {
+			return false
+		}
+		view := p.state.getView()
+		proposer = p.selectProposer(validators, view.Sequence, view.Round)
+	}
+	return proposer == p.validator.NodeID()
+}
+
+// LastError returns the most recent error that caused a round change, if any.
+// It is cleared at the start of every AcceptState
+func (p *Pbft) LastError() error {
+	return p.state.getLastErr()
+}
+
+// ValidatorSnapshot returns the validators of the current sequence, as the backend provided them when the
+// sequence started. It is nil if the validator set does not implement SelectableValidatorSet
+func (p *Pbft) ValidatorSnapshot() []NodeID {
+	return p.state.getValidatorIDs()
+}
+
 // getState returns the current PBFT state
 func (p *Pbft) getState() PbftState {
 	return p.state.getState()
@@ -744,8 +2552,11 @@ func (p *Pbft) SetState(s PbftState) {
 
 // setState sets the PBFT state
 func (p *Pbft) setState(s PbftState) {
-	p.logger.Printf("[DEBUG] state change: '%s'", s)
+	p.logger.Debug("state change", "state", s)
+	from := p.getState()
+	p.state.stats.enterState(s, p.state.view.Sequence, p.clock.Now())
 	p.state.setState(s)
+	p.config.StateChangeObserver(from, s)
 }
 
 // IsLocked returns if the current proposal is locked
@@ -758,44 +2569,131 @@ func (p *Pbft) GetProposal() *Proposal {
 	return p.state.proposal
 }
 
+// LockedProposal returns a copy of the proposal the node is locked on, and false if it is not locked.
+// It is safe to call it concurrently, i.e. to compare the locked proposals of the nodes during a stall
+func (p *Pbft) LockedProposal() (*Proposal, bool) {
+	return p.state.getLockedProposal()
+}
+
+// RoundChangeVotes returns, for every round, the distinct validators which sent a round change message
+// for the round in the current sequence. The returned map is a copy and it is safe to call it concurrently
+func (p *Pbft) RoundChangeVotes() map[uint64][]NodeID {
+	return p.state.roundChangeVotes()
+}
+
+// QueueDepths returns the number of messages of each type waiting in the message queue
+func (p *Pbft) QueueDepths() map[MsgType]int {
+	return p.msgQueue.depths()
+}
+
+// SnapshotQueue returns copies of the messages waiting in the message queue, in the order they arrived in.
+// They can be passed to RestoreQueue, i.e. to keep the messages of the peers across a restart
+func (p *Pbft) SnapshotQueue() []*MessageReq {
+	return p.msgQueue.snapshot()
+}
+
+// RestoreQueue pushes the messages of a SnapshotQueue to the message queue. The messages are
+// filtered against the current view, the ones for an older view are dropped, and the rest is verified like
+// any received message (they are not rate limited though). It must not be called while the state machine is running
+func (p *Pbft) RestoreQueue(msgs []*MessageReq) {
+	for _, msg := range msgs {
+		if msg.View != nil && p.state.view != nil && msg.View.Cmp(p.state.view) < 0 {
+			p.metrics.MessageDropped(msg.Type)
+			p.logger.Debug("old restored msg, dropping it", "from", msg.From, "type", msg.Type, "view", msg.View)
+			continue
+		}
+		p.pushMessage(msg.Copy(), false)
+	}
+}
+
+// handleDiscards drops the messages discarded by the queue, answering the lagging senders of round changes
+func (p *Pbft) handleDiscards(span trace.Span, discards []*MessageReq) {
+	for _, msg := range discards {
+		p.logger.Debug("discarded message", "message", msg)
+		spanAddEventMessage("dropMessage", span, msg)
+		p.metrics.MessageDropped(msg.Type)
+		p.catchUpRoundChange(msg)
+	}
+}
+
 // getNextMessage reads a new message from the message queue
 func (p *Pbft) getNextMessage(span trace.Span) (*MessageReq, bool) {
 	for {
+		if p.getState() != RoundChangeState && p.equivocations.hasEquivocated(p.state.view, p.state.proposer) {
+			// the proposer of this round is byzantine, stop processing and move to a new round
+			span.AddEvent("Equivocation")
+			p.handleStateErr(errProposerEquivocation)
+			return nil, false
+		}
+
+		if p.tryCatchUp(span) {
+			// the sequence is committed, stop processing it
+			return nil, false
+		}
+
 		msg, discards := p.notifier.ReadNextMessage(p)
 		// send the discard messages
-		p.logger.Printf("[TRACE] Current state %s, number of prepared messages: %d, number of committed messages %d", PbftState(p.state.state), p.state.numPrepared(), p.state.numCommitted())
+		p.logger.Debug("current state", "state", PbftState(p.state.state), "prepared", p.state.numPrepared(), "committed", p.state.numCommitted())
 
-		for _, msg := range discards {
-			p.logger.Printf("[TRACE] Discarded %s ", msg)
-			spanAddEventMessage("dropMessage", span, msg)
-		}
+		p.handleDiscards(span, discards)
 		if msg != nil {
 			// add the event to the span
 			spanAddEventMessage("message", span, msg)
-			p.logger.Printf("[TRACE] Received %s", msg)
+			p.logger.Debug("received message", "message", msg)
 			return msg, true
 		}
 
-		// wait until there is a new message or
-		// someone closes the stopCh (i.e. timeout for round change)
-		select {
-		case <-p.state.timeout.C:
-			span.AddEvent("Timeout")
-			p.notifier.HandleTimeout(p.validator.NodeID(), stateToMsg(p.getState()), &View{
-				Round:    p.state.GetCurrentRound(),
-				Sequence: p.state.view.Sequence,
-			})
-			p.logger.Printf("[TRACE] Message read timeout occurred")
-			return nil, true
-		case <-p.ctx.Done():
+		if p.ctx.Err() != nil {
+			// the deadline of the caller passed, or it cancelled the context
+			return nil, false
+		}
+
+		updated, ok := p.waitForUpdate(span)
+		if !ok {
 			return nil, false
-		case <-p.updateCh:
 		}
+		if !updated {
+			return nil, true
+		}
+	}
+}
+
+// waitForUpdate blocks until a new message is pushed (updated) or the round times out (not updated).
+// It returns false if the state machine has to stop
+func (p *Pbft) waitForUpdate(span trace.Span) (bool, bool) {
+	// wait until there is a new message or
+	// someone closes the stopCh (i.e. timeout for round change)
+	select {
+	case <-p.forceTimeoutCh:
+		span.AddEvent("ForceTimeout")
+		p.logger.Debug("forced timeout occurred")
+		return false, true
+	case <-p.state.timeout:
+		if p.ctx.Err() != nil {
+			// the timeout fired together with the deadline, the state machine has to stop instead
+			return false, false
+		}
+		span.AddEvent("Timeout")
+		p.notifier.HandleTimeout(p.validator.NodeID(), stateToMsg(p.getState()), &View{
+			Round:    p.state.GetCurrentRound(),
+			Sequence: p.state.view.Sequence,
+		})
+		p.logger.Debug("message read timeout occurred")
+		return false, true
+	case <-p.ctx.Done():
+		return false, false
+	case <-p.closeCh:
+		return false, false
+	case <-p.updateCh:
+		return true, true
 	}
 }
 
 func (p *Pbft) PushMessageInternal(msg *MessageReq) {
-	p.msgQueue.pushMessage(msg)
+	for _, evicted := range p.msgQueue.pushMessage(msg) {
+		p.logger.Debug("evicted message, the message queue is full", "message", evicted)
+		p.metrics.MessageDropped(evicted.Type)
+	}
 
 	select {
 	case p.updateCh <- struct{}{}:
@@ -805,33 +2703,133 @@ func (p *Pbft) PushMessageInternal(msg *MessageReq) {
 
 // PushMessage pushes a new message to the message queue
 func (p *Pbft) PushMessage(msg *MessageReq) {
+	p.pushMessage(msg, true)
+}
+
+// pushMessage verifies the message and pushes it to the message queue. Only the messages received from
+// the transport are rate limited, once they are verified, whoever they claim to be from
+func (p *Pbft) pushMessage(msg *MessageReq, limited bool) {
 	if err := msg.Validate(); err != nil {
-		p.logger.Printf("[ERROR]: failed to validate msg: %v", err)
+		p.logger.Error("failed to validate msg", "err", err)
+		return
+	}
+	if p.blacklist.isBlacklisted(msg.From, p.clock.Now()) {
+		p.metrics.MessageDropped(msg.Type)
+		p.logger.Debug("blacklisted sender, dropping msg", "from", msg.From, "type", msg.Type)
+		return
+	}
+	if msg.Type == MessageReq_SyncRequest || msg.Type == MessageReq_SyncResponse {
+		p.pushSyncMessage(msg)
 		return
 	}
+	if !p.checkSafety(msg) {
+		return
+	}
+	if p.isStale(msg) {
+		// the sequence is already decided, there is no point in queueing (nor verifying) the message
+		atomic.AddUint64(&p.staleMsgs, 1)
+		p.metrics.MessageDropped(msg.Type)
+		p.logger.Debug("stale msg, dropping it", "from", msg.From, "type", msg.Type, "sequence", msg.View.Sequence)
+		return
+	}
+	if err := p.msgVerifier(msg); err != nil {
+		// the sender is not authenticated, it is not reported since anyone could send the message on its behalf
+		atomic.AddUint64(&p.invalidMsgs, 1)
+		p.metrics.MessageDropped(msg.Type)
+		p.logger.Error("failed to verify msg, dropping it", "from", msg.From, "err", err)
+		return
+	}
+	if limited && p.rateLimited(msg) {
+		return
+	}
+	if len(msg.Votes) > 0 {
+		if err := checkVotes(msg); err != nil {
+			atomic.AddUint64(&p.invalidMsgs, 1)
+			p.metrics.MessageDropped(msg.Type)
+			p.logger.Error("invalid aggregated votes, dropping msg", "from", msg.From, "err", err)
+			p.reportInvalid(msg.From)
+			return
+		}
+		votes := msg.Votes
+		msg = msg.Copy()
+		msg.Votes = nil
+		p.pushVotes(msg.From, votes)
+	}
+
+	p.liveness.heard(msg.From, p.clock.Now())
+
+	if msg.Type == MessageReq_Preprepare {
+		p.trackPreprepare(msg)
+		if msg.CommitCertificate != nil {
+			p.offerCatchUp(msg.CommitCertificate)
+		}
+	}
 
 	p.PushMessageInternal(msg)
 }
 
+// isStale checks if the message belongs to a sequence lower than the one being decided
+func (p *Pbft) isStale(msg *MessageReq) bool {
+	sequence, ok := p.state.getSequence()
+	return ok && msg.View != nil && msg.View.Sequence < sequence
+}
+
 // Reads next message with discards from message queue based on current state, sequence and round
 func (p *Pbft) ReadMessageWithDiscards() (*MessageReq, []*MessageReq) {
-	return p.msgQueue.readMessageWithDiscards(p.getState(), p.state.view)
+	msg, latency, discards := p.msgQueue.readMessageWithLatency(p.getState(), p.state.view)
+	if msg != nil {
+		p.metrics.MessageQueueLatency(msg.Type, latency)
+	}
+	return msg, discards
 }
 
 // --- package-level helper functions ---
 // exponentialTimeout calculates the timeout duration depending on the current round.
 // Round acts as an exponent when determining timeout (2^round).
 func exponentialTimeout(round uint64) time.Duration {
-	timeout := defaultTimeout
-	// limit exponent to be in range of maxTimeout (<=8) otherwise use maxTimeout
-	// this prevents calculating timeout that is greater than maxTimeout and
-	// possible overflow for calculating timeout for rounds >33 since duration is in nanoseconds stored in int64
-	if round <= maxTimeoutExponent {
-		timeout += time.Duration(1<<round) * time.Second
-	} else {
-		timeout = maxTimeout
+	return ExponentialTimeout(defaultTimeout, maxTimeout)(round)
+}
+
+// ExponentialTimeout returns a RoundTimeout which adds 2^round seconds to the base timeout.
+// The result never exceeds max. The returned function is stateless and safe for concurrent use.
+func ExponentialTimeout(base, max time.Duration) RoundTimeout {
+	return func(round uint64) time.Duration {
+		// limit exponent to be in range of maxTimeout (<=8) otherwise use max
+		// this prevents calculating timeout that is greater than maxTimeout and
+		// possible overflow for calculating timeout for rounds >33 since duration is in nanoseconds stored in int64
+		if round > maxTimeoutExponent {
+			return max
+		}
+		timeout := base + time.Duration(1<<round)*time.Second
+		if timeout > max {
+			timeout = max
+		}
+		return timeout
+	}
+}
+
+// JitteredTimeout returns a RoundTimeout which adds a random duration, up to the given fraction of the
+// timeout, to the timeout of each round. The fraction is capped at 1. The extra duration is also capped so that
+// the timeout of a round never exceeds the (unjittered) timeout of the next round, hence the timeouts keep escalating.
+// The returned function is safe for concurrent use if r is.
+func JitteredTimeout(timeout RoundTimeout, fraction float64, r *rand.Rand) RoundTimeout {
+	if fraction > 1 {
+		fraction = 1
+	}
+	return func(round uint64) time.Duration {
+		d := timeout(round)
+		if fraction <= 0 || d <= 0 {
+			return d
+		}
+		limit := time.Duration(fraction * float64(d))
+		if next := timeout(round + 1); next > d && next-d < limit {
+			limit = next - d
+		}
+		if limit <= 0 {
+			return d
+		}
+		return d + time.Duration(r.Int63n(int64(limit)))
 	}
-	return timeout
 }
 
 // MaxFaultyNodes calculate max faulty nodes in order to have Byzantine-fault tollerant system.
@@ -855,6 +2853,11 @@ func MaxFaultyNodes(nodesCount int) int {
 // QuorumSize calculates quorum size (namely the number of required messages of some type in order to proceed to the next state in PolyBFT state machine).
 // It is calculated by formula:
 // 2 * F + 1, where F denotes maximum count of faulty nodes in order to have Byzantine fault tollerant property satisfied.
+// Two nodes do not tolerate any faulty node either, but a quorum of one would let each of them
+// commit a different proposal on its own, so both of them are required
 func QuorumSize(nodesCount int) int {
+	if nodesCount == 2 {
+		return 2
+	}
 	return 2*MaxFaultyNodes(nodesCount) + 1
 }
@@ -1,6 +1,7 @@
 package pbft
 
 import (
+	"bytes"
 	"container/heap"
 	"context"
 	"crypto/sha1"
@@ -9,12 +10,15 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -39,6 +43,180 @@ func TestTransition_AcceptState_ToSyncState(t *testing.T) {
 	})
 }
 
+func TestPbft_Run_SyncFunc(t *testing.T) {
+	// we are not a validator, so the state machine keeps moving to sync state
+	i := newMockPbft(t, []string{"A", "B", "C", "D"}, "")
+	defer i.Close()
+	i.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+
+	syncCalls := 0
+	sequences := []uint64{}
+	i.config.SyncFunc = func(ctx context.Context) error {
+		syncCalls++
+		sequences = append(sequences, i.state.view.Sequence)
+		if syncCalls == 1 {
+			// the sync brings the backend to a new height
+			i.sequence = 5
+			return nil
+		}
+		// stop the state machine on the next sync
+		i.cancelFn()
+		return ctx.Err()
+	}
+
+	i.Run(i.ctx)
+
+	assert.Equal(t, 2, syncCalls)
+	// the state machine resumed at the synced sequence
+	assert.Equal(t, []uint64{1, 5}, sequences)
+	assert.False(t, i.state.IsLocked())
+	i.expect(expectResult{
+		sequence: 5,
+		state:    SyncState,
+	})
+}
+
+func TestPbft_Run_NoSyncFunc(t *testing.T) {
+	i := newMockPbft(t, []string{"A", "B", "C", "D"}, "")
+	defer i.Close()
+
+	// without a sync function Run returns in sync state
+	i.Run(i.ctx)
+
+	i.expect(expectResult{
+		sequence: 1,
+		state:    SyncState,
+	})
+}
+
+// The validator set and the quorum thresholds are refreshed when the backend is set for a new sequence.
+func TestPbft_SetBackend_ValidatorSetChange(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	assert.Equal(t, 1, m.state.MaxFaultyNodes())
+	assert.Equal(t, 2, m.state.NumValid())
+
+	// the validator set grows for the next sequence
+	m.sequence = 2
+	require.NoError(t, m.SetBackend(newMockBackend([]string{"A", "B", "C", "D", "E", "F", "G"}, m)))
+	assert.Equal(t, uint64(2), m.state.view.Sequence)
+	assert.Equal(t, 2, m.state.MaxFaultyNodes())
+	assert.Equal(t, 4, m.state.NumValid())
+
+	// the local node is removed from the validator set
+	m.sequence = 3
+	require.NoError(t, m.SetBackend(newMockBackend([]string{"B", "C", "D"}, m)))
+	assert.Equal(t, 0, m.state.MaxFaultyNodes())
+	assert.Equal(t, 0, m.state.NumValid())
+
+	m.setState(AcceptState)
+	m.runCycle(context.Background())
+	m.expect(expectResult{
+		sequence: 3,
+		state:    SyncState,
+	})
+}
+
+// selectableBackend is a mock backend whose validator set exposes its validators
+// The snapshot of the validators is frozen for the sequence, whatever the backend does with its validator set.
+func TestPbft_ValidatorSnapshot(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	validators := &selectableValString{valString: valString{"A", "B", "C", "D"}}
+	backend := &selectableBackend{mockBackend: newMockBackend(nil, m), validators: validators}
+	require.NoError(t, m.SetBackend(backend))
+
+	snapshot := m.ValidatorSnapshot()
+	assert.Equal(t, []NodeID{"A", "B", "C", "D"}, snapshot)
+
+	// the returned slice is a copy
+	snapshot[0] = "E"
+	assert.Equal(t, []NodeID{"A", "B", "C", "D"}, m.ValidatorSnapshot())
+
+	// the backend changes its validator set in the middle of the sequence, the quorum keeps
+	// being calculated with the snapshot as well
+	validators.valString[3] = "E"
+	backend.validators = &selectableValString{valString: valString{"A", "B", "F"}}
+	assert.Equal(t, []NodeID{"A", "B", "C", "D"}, m.ValidatorSnapshot())
+	assert.True(t, m.state.validators.Includes("D"))
+	assert.False(t, m.state.validators.Includes("E"))
+	assert.Equal(t, 4, m.state.validators.Len())
+
+	// the change applies from the next sequence
+	m.sequence = 2
+	require.NoError(t, m.SetBackend(backend))
+	assert.Equal(t, []NodeID{"A", "B", "F"}, m.ValidatorSnapshot())
+}
+
+func TestPbft_ValidatorSnapshot_NotSelectable(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	assert.Nil(t, m.ValidatorSnapshot())
+}
+
+type selectableBackend struct {
+	*mockBackend
+	validators *selectableValString
+}
+
+func (b *selectableBackend) ValidatorSet() ValidatorSet {
+	return b.validators
+}
+
+// The validators which join and leave the validator set are reported when the backend is set for a new sequence.
+func TestPbft_ValidatorSetChanged(t *testing.T) {
+	type change struct {
+		added, removed []NodeID
+	}
+	var changes []change
+
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	p := New(m.pool.get("A"), m,
+		WithLogger(log.New(getDefaultLoggerOutput(), "", log.LstdFlags)),
+		WithValidatorSetChanged(func(added, removed []NodeID) {
+			changes = append(changes, change{added: added, removed: removed})
+		}))
+
+	setValidators := func(sequence uint64, validators ...NodeID) {
+		m.sequence = sequence
+		backend := &selectableBackend{
+			mockBackend: newMockBackend(nil, m),
+			validators:  &selectableValString{valString: validators},
+		}
+		require.NoError(t, p.SetBackend(backend))
+	}
+
+	// every validator joins in the first sequence
+	setValidators(1, "A", "B", "C", "D")
+	require.Len(t, changes, 1)
+	assert.Equal(t, change{added: []NodeID{"A", "B", "C", "D"}}, changes[0])
+
+	// the same validators in the next sequence
+	setValidators(2, "A", "B", "C", "D")
+	assert.Len(t, changes, 1)
+
+	// E joins
+	setValidators(3, "A", "B", "C", "D", "E")
+	require.Len(t, changes, 2)
+	assert.Equal(t, change{added: []NodeID{"E"}}, changes[1])
+
+	// B and D leave, F joins
+	setValidators(4, "A", "C", "E", "F")
+	require.Len(t, changes, 3)
+	assert.Equal(t, change{added: []NodeID{"F"}, removed: []NodeID{"B", "D"}}, changes[2])
+
+	// the validators of a set which does not expose them are unknown
+	m.sequence = 5
+	require.NoError(t, p.SetBackend(newMockBackend([]string{"A", "B"}, m)))
+	assert.Len(t, changes, 3)
+}
+
+func TestPbft_ValidatorSetChanged_Config(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A")
+
+	// a nil handler is ignored
+	p := New(pool.get("A"), &mockPbft{}, WithValidatorSetChanged(nil))
+	assert.NotNil(t, p.config.ValidatorSetChanged)
+}
+
 func TestTransition_AcceptState_Proposer_Propose(t *testing.T) {
 	// we are in AcceptState and we are the proposer, it needs to:
 	// 1. create a proposal
@@ -135,7 +313,7 @@ func TestTransition_AcceptState_Validator_VerifyFails(t *testing.T) {
 
 // Test that if build proposal fails, state machine will change state from AcceptState to RoundChangeState.
 func TestTransition_AcceptState_Proposer_FailedBuildProposal(t *testing.T) {
-	buildProposalFailure := func() (*Proposal, error) {
+	buildProposalFailure := func(context.Context) (*Proposal, error) {
 		return nil, errors.New("failed to build a proposal")
 	}
 
@@ -167,6 +345,134 @@ func TestTransition_AcceptState_Proposer_FailedBuildProposal(t *testing.T) {
 	assert.True(t, m.IsState(RoundChangeState))
 }
 
+// Test that a backend building no proposal at all (instead of an empty one) changes the round.
+func TestTransition_AcceptState_Proposer_NilProposal(t *testing.T) {
+	validatorIds := []string{"A", "B", "C"}
+	backend := newMockBackend(validatorIds, nil).HookBuildProposalHandler(func(context.Context) (*Proposal, error) {
+		return nil, nil
+	})
+
+	m := newMockPbft(t, validatorIds, "A", backend)
+	m.state.view = ViewMsg(1, 0)
+	m.setState(AcceptState)
+
+	assert.NotPanics(t, func() { m.runCycle(m.ctx) })
+	assert.True(t, m.IsState(RoundChangeState))
+	assert.Empty(t, m.respMsg)
+	assert.ErrorIs(t, m.LastError(), errNoProposal)
+}
+
+// Test that the proposer commits an empty proposal, nil or not, like any other proposal.
+func TestPbft_EmptyProposal_Proposer(t *testing.T) {
+	accounts := []string{"A", "B", "C", "D"}
+	for name, data := range map[string][]byte{"nil": nil, "empty": {}} {
+		t.Run(name, func(t *testing.T) {
+			m, _ := newHashingMock(t, accounts, "A")
+			hash := sha1Hash(data)
+			m.setProposal(&Proposal{Data: data, Time: time.Now(), Hash: hash})
+
+			for _, from := range accounts[1:] {
+				m.emitMsg(&MessageReq{From: NodeID(from), Type: MessageReq_Prepare, View: ViewMsg(5, 0), Hash: hash})
+				m.emitMsg(&MessageReq{From: NodeID(from), Type: MessageReq_Commit, View: ViewMsg(5, 0), Hash: hash, Seal: hash})
+			}
+
+			pp, err := m.RunSequence(context.Background(), 5)
+			require.NoError(t, err)
+			require.NotNil(t, pp.Proposal)
+			assert.True(t, pp.Proposal.IsEmpty())
+			assert.NotNil(t, pp.Proposal.Data)
+			assert.Equal(t, hash, pp.Proposal.Hash)
+
+			// the preprepare carries the empty proposal
+			require.Equal(t, MessageReq_Preprepare, m.respMsg[0].Type)
+			assert.NotNil(t, m.respMsg[0].Proposal)
+			assert.Empty(t, m.respMsg[0].Proposal)
+		})
+	}
+}
+
+// Test that a validator accepts and commits an empty proposal received over the wire.
+func TestPbft_EmptyProposal_Validator(t *testing.T) {
+	accounts := []string{"A", "B", "C", "D"}
+	m, _ := newHashingMock(t, accounts, "B")
+	hash := sha1Hash(nil)
+	m.state.proposal = nil
+
+	data, err := (&MessageReq{From: "A", Type: MessageReq_Preprepare, View: ViewMsg(5, 0), Hash: hash, Proposal: []byte{}}).Marshal()
+	require.NoError(t, err)
+	preprepare := &MessageReq{}
+	require.NoError(t, preprepare.Unmarshal(data))
+	m.emitMsg(preprepare)
+
+	for _, from := range []string{"A", "C", "D"} {
+		m.emitMsg(&MessageReq{From: NodeID(from), Type: MessageReq_Prepare, View: ViewMsg(5, 0), Hash: hash})
+		m.emitMsg(&MessageReq{From: NodeID(from), Type: MessageReq_Commit, View: ViewMsg(5, 0), Hash: hash, Seal: hash})
+	}
+
+	pp, err := m.RunSequence(context.Background(), 5)
+	require.NoError(t, err)
+	assert.True(t, pp.Proposal.IsEmpty())
+	assert.Equal(t, hash, pp.Proposal.Hash)
+	assert.Equal(t, NodeID("A"), pp.Proposer)
+}
+
+// Cancel the state machine while the backend is building the proposal.
+// The state machine must stop promptly and must not move to RoundChangeState.
+func TestTransition_AcceptState_Proposer_BuildProposalCancelled(t *testing.T) {
+	started := make(chan struct{})
+	buildProposalBlocking := func(ctx context.Context) (*Proposal, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	validatorIds := []string{"A", "B", "C"}
+	backend := newMockBackend(validatorIds, nil).HookBuildProposalHandler(buildProposalBlocking)
+
+	m := newMockPbft(t, validatorIds, "A", backend)
+	m.state.view = ViewMsg(1, 0)
+	m.setState(AcceptState)
+
+	go func() {
+		<-started
+		m.cancelFn()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		m.runCycle(m.ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("state machine did not stop while building the proposal")
+	}
+	assert.True(t, m.IsState(AcceptState))
+	assert.Empty(t, m.respMsg)
+}
+
+// A deadline of the backend itself is a failed build rather than a shutdown, the state machine must move to RoundChangeState.
+func TestTransition_AcceptState_Proposer_BuildProposalDeadline(t *testing.T) {
+	buildProposalDeadline := func(context.Context) (*Proposal, error) {
+		return nil, fmt.Errorf("backend timed out: %w", context.DeadlineExceeded)
+	}
+
+	validatorIds := []string{"A", "B", "C"}
+	backend := newMockBackend(validatorIds, nil).HookBuildProposalHandler(buildProposalDeadline)
+
+	m := newMockPbft(t, validatorIds, "A", backend)
+	defer m.Close()
+	m.state.view = ViewMsg(1, 0)
+	m.setState(AcceptState)
+
+	m.runCycle(m.ctx)
+
+	assert.True(t, m.IsState(RoundChangeState))
+	assert.Empty(t, m.respMsg)
+}
+
 // Run state machine from AcceptState, proposer node.
 // Artificially induce state machine cancellation and check whether state machine is still in AcceptState.
 func TestTransition_AcceptState_Proposer_Cancellation(t *testing.T) {
@@ -198,6 +504,139 @@ func testAcceptState_Cancellation(t *testing.T, isProposerNode bool) {
 	assert.NotPanics(t, func() { m.runCycle(m.ctx) })
 }
 
+// Non-proposer round changes at the proposal deadline even if the round timeout is much longer.
+func TestTransition_AcceptState_Validator_ProposalTimeout(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	m.roundTimeout = func(uint64) time.Duration { return time.Minute }
+	m.proposalTimeout = func(uint64) time.Duration { return 10 * time.Millisecond }
+	m.setRound(0)
+	m.setState(AcceptState)
+
+	done := make(chan struct{})
+	go func() {
+		m.runCycle(context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("proposal timeout did not fire")
+	}
+
+	m.expect(expectResult{
+		sequence: 1,
+		state:    RoundChangeState,
+	})
+}
+
+// Once the proposal is received, the validation waits for the round timeout instead of the proposal timeout.
+func TestTransition_AcceptState_Validator_ProposalTimeoutValidate(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	m.roundTimeout = func(uint64) time.Duration { return time.Minute }
+	m.proposalTimeout = func(uint64) time.Duration { return 10 * time.Millisecond }
+	m.setRound(0)
+	m.setState(AcceptState)
+
+	m.emitMsg(&MessageReq{
+		From:     "A",
+		Type:     MessageReq_Preprepare,
+		Proposal: mockProposal,
+		View:     ViewMsg(1, 0),
+	})
+	m.runCycle(context.Background())
+	assert.True(t, m.IsState(ValidateState))
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		m.cancelFn()
+	}()
+	m.runCycle(context.Background())
+
+	// the proposal timeout has long passed but the node is still validating
+	assert.True(t, m.IsState(ValidateState))
+}
+
+func TestPbft_ProposalTimeout_Config(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A")
+	logger := WithLogger(log.New(getDefaultLoggerOutput(), "", log.LstdFlags))
+
+	p := New(pool.get("A"), &mockPbft{}, logger)
+	assert.Nil(t, p.proposalTimeout)
+
+	p = New(pool.get("A"), &mockPbft{}, logger, WithProposalTimeout(5*time.Second))
+	require.NotNil(t, p.proposalTimeout)
+	assert.Equal(t, 6*time.Second, p.proposalTimeout(0))
+	assert.Equal(t, 9*time.Second, p.proposalTimeout(2))
+}
+
+// The proposal and the vote waits time out independently: a dead proposer is detected at the short
+// proposal deadline, and slow votes at the short vote deadline, while the other deadline is much longer.
+func TestTransition_ProposalAndVoteTimeouts(t *testing.T) {
+	cases := []struct {
+		name     string
+		account  string
+		proposal time.Duration
+		vote     time.Duration
+		// preprepare is the proposal received from the proposer, if any
+		preprepare bool
+		// timedOut is the state the short deadline fires in
+		timedOut PbftState
+	}{
+		{"proposer down", "B", 10 * time.Millisecond, time.Hour, false, AcceptState},
+		{"slow votes", "B", time.Hour, 10 * time.Millisecond, true, ValidateState},
+		{"slow votes to the proposer", "A", time.Hour, 10 * time.Millisecond, false, ValidateState},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := newMockPbft(t, []string{"A", "B", "C", "D"}, c.account)
+			m.roundTimeout = func(uint64) time.Duration { return time.Hour }
+			m.proposalTimeout = func(uint64) time.Duration { return c.proposal }
+			m.voteTimeout = func(uint64) time.Duration { return c.vote }
+			m.setProposal(&Proposal{Data: mockProposal, Time: time.Now()})
+			m.setRound(0)
+			m.setState(AcceptState)
+
+			if c.preprepare {
+				m.emitMsg(&MessageReq{
+					From:     "A",
+					Type:     MessageReq_Preprepare,
+					Proposal: mockProposal,
+					View:     ViewMsg(1, 0),
+				})
+			}
+
+			for state := AcceptState; state != c.timedOut; state = m.getState() {
+				// the states before the one timing out are passed without waiting
+				m.runCycle(context.Background())
+				require.NotEqual(t, RoundChangeState, m.getState())
+			}
+
+			select {
+			case <-runCycleAsync(m):
+			case <-time.After(5 * time.Second):
+				t.Fatal("the short deadline did not fire")
+			}
+			assert.True(t, m.IsState(RoundChangeState))
+		})
+	}
+}
+
+func TestPbft_VoteTimeout_Config(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A")
+	logger := WithLogger(log.New(getDefaultLoggerOutput(), "", log.LstdFlags))
+
+	p := New(pool.get("A"), &mockPbft{}, logger)
+	assert.Nil(t, p.voteTimeout)
+
+	p = New(pool.get("A"), &mockPbft{}, logger, WithVoteTimeout(time.Second))
+	require.NotNil(t, p.voteTimeout)
+	assert.Equal(t, 2*time.Second, p.voteTimeout(0))
+}
+
 func TestTransition_AcceptState_Validator_ProposerInvalid(t *testing.T) {
 	i := newMockPbft(t, []string{"A", "B", "C"}, "B")
 	i.state.view = ViewMsg(1, 0)
@@ -220,6 +659,271 @@ func TestTransition_AcceptState_Validator_ProposerInvalid(t *testing.T) {
 	})
 }
 
+// sliceNotifier hands over its messages first, bypassing the view filters of the message queue
+type sliceNotifier struct {
+	DefaultStateNotifier
+	msgs []*MessageReq
+}
+
+func (s *sliceNotifier) ReadNextMessage(p *Pbft) (*MessageReq, []*MessageReq) {
+	if len(s.msgs) == 0 {
+		return s.DefaultStateNotifier.ReadNextMessage(p)
+	}
+	msg := s.msgs[0]
+	s.msgs = s.msgs[1:]
+	return msg, nil
+}
+
+func TestTransition_AcceptState_Validator_WrongSequence(t *testing.T) {
+	cases := []struct {
+		name     string
+		sequence uint64
+		senders  []NodeID
+		state    PbftState
+	}{
+		{"stale sequence", 0, []NodeID{"A"}, RoundChangeState},
+		{"future sequence", 2, []NodeID{"A", "C"}, SyncState},
+		// a single sender may be lying, it takes a weak quorum to move to sync
+		{"future sequence single sender", 2, []NodeID{"A"}, RoundChangeState},
+		{"future sequence non validators", 2, []NodeID{"X", "Y"}, RoundChangeState},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			validated := false
+			backend := newMockBackend([]string{"A", "B", "C", "D"}, nil).HookValidateHandler(func(p *Proposal) error {
+				validated = true
+				return nil
+			})
+
+			i := newMockPbft(t, []string{"A", "B", "C", "D"}, "B", backend)
+			i.state.view = ViewMsg(1, 0)
+			i.setState(AcceptState)
+
+			// the senders send a preprepare for another sequence
+			notifier := &sliceNotifier{}
+			for _, from := range c.senders {
+				notifier.msgs = append(notifier.msgs, &MessageReq{
+					From:     from,
+					Type:     MessageReq_Preprepare,
+					Proposal: mockProposal,
+					Hash:     digest,
+					View:     ViewMsg(c.sequence, 0),
+				})
+			}
+			i.notifier = notifier
+
+			i.runCycle(context.Background())
+
+			assert.False(t, validated)
+			i.expect(expectResult{
+				sequence: 1,
+				state:    c.state,
+			})
+		})
+	}
+}
+
+func TestTransition_AcceptState_Validator_ProposalTooLarge(t *testing.T) {
+	cases := []struct {
+		name     string
+		locked   bool
+		proposal []byte
+		state    PbftState
+		outgoing uint64
+		dropped  int
+	}{
+		{"oversized", false, make([]byte, 9), RoundChangeState, 0, 1},
+		{"oversized locked", true, make([]byte, 9), RoundChangeState, 0, 1},
+		{"within limit", false, make([]byte, 8), ValidateState, 1, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			validated := false
+			backend := newMockBackend([]string{"A", "B", "C"}, nil).HookValidateHandler(func(p *Proposal) error {
+				validated = true
+				return nil
+			})
+
+			metrics := newFakeMetrics()
+			i := newMockPbft(t, []string{"A", "B", "C"}, "B", backend)
+			i.config.MaxProposalSize = 8
+			i.metrics = metrics
+			i.state.view = ViewMsg(1, 0)
+			if c.locked {
+				i.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+				i.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+			}
+			i.setState(AcceptState)
+
+			i.emitMsg(&MessageReq{
+				From:     "A",
+				Type:     MessageReq_Preprepare,
+				Proposal: c.proposal,
+				View:     ViewMsg(1, 0),
+			})
+			i.runCycle(context.Background())
+
+			i.expect(expectResult{
+				sequence: 1,
+				state:    c.state,
+				locked:   c.locked,
+				outgoing: c.outgoing,
+			})
+
+			// the oversized proposals never reach the backend
+			assert.Equal(t, c.dropped == 0, validated)
+			assert.Equal(t, c.dropped, metrics.dropped[MessageReq_Preprepare])
+		})
+	}
+}
+
+func TestPbft_MaxProposalSize_Config(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A")
+
+	p := New(pool.get("A"), &mockPbft{})
+	assert.Equal(t, 0, p.config.MaxProposalSize)
+
+	p = New(pool.get("A"), &mockPbft{}, WithMaxProposalSize(1024))
+	assert.Equal(t, 1024, p.config.MaxProposalSize)
+}
+
+func TestTransition_AcceptState_ProposerVetoed(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	m.roundTimeout = func(uint64) time.Duration { return time.Minute }
+
+	vetoed := []NodeID{}
+	m.config.ProposerFilter = func(proposer NodeID, round uint64) bool {
+		vetoed = append(vetoed, proposer)
+		return round != 0
+	}
+	m.setState(AcceptState)
+
+	done := make(chan struct{})
+	go func() {
+		m.runCycle(context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the node waited for the proposal of a vetoed proposer")
+	}
+
+	m.expect(expectResult{
+		sequence: 1,
+		state:    RoundChangeState,
+		err:      errProposerVetoed,
+	})
+	assert.Equal(t, []NodeID{"A"}, vetoed)
+
+	// the round change is sent right away
+	m.Close()
+	m.runCycle(context.Background())
+	m.expect(expectResult{
+		sequence: 1,
+		round:    1,
+		state:    RoundChangeState,
+		outgoing: 1,
+	})
+}
+
+func TestTransition_AcceptState_ProposerAllowed(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	defer m.Close()
+	m.config.ProposerFilter = func(proposer NodeID, round uint64) bool {
+		return proposer == "A"
+	}
+	m.setState(AcceptState)
+
+	m.emitMsg(&MessageReq{
+		From:     "A",
+		Type:     MessageReq_Preprepare,
+		Proposal: mockProposal,
+		View:     ViewMsg(1, 0),
+	})
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence: 1,
+		state:    ValidateState,
+		outgoing: 1, // prepare
+	})
+}
+
+func TestPbft_ProposerFilter_Config(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A")
+
+	// every proposer is allowed by default, and a nil filter is ignored
+	p := New(pool.get("A"), &mockPbft{}, WithProposerFilter(nil))
+	assert.True(t, p.config.ProposerFilter("A", 0))
+
+	p = New(pool.get("A"), &mockPbft{}, WithProposerFilter(func(NodeID, uint64) bool { return false }))
+	assert.False(t, p.config.ProposerFilter("A", 0))
+}
+
+type sequenceStart struct {
+	view     View
+	proposer NodeID
+}
+
+func TestTransition_AcceptState_SequenceStarted(t *testing.T) {
+	accept := func(m *mockPbft, view *View) {
+		m.state.view = view
+		m.setState(AcceptState)
+		m.emitMsg(&MessageReq{
+			From:     m.state.validators.CalcProposer(view.Round),
+			Type:     MessageReq_Preprepare,
+			Proposal: mockProposal,
+			View:     view.Copy(),
+		})
+		m.runCycle(context.Background())
+		require.True(t, m.IsState(ValidateState))
+	}
+
+	for _, account := range []string{"A", "B"} {
+		t.Run(account, func(t *testing.T) {
+			m := newMockPbft(t, []string{"A", "B", "C", "D"}, account)
+			defer m.Close()
+			m.setProposal(&Proposal{Data: mockProposal, Time: time.Now()})
+
+			started := []sequenceStart{}
+			m.config.SequenceStarted = func(view View, proposer NodeID) {
+				started = append(started, sequenceStart{view: view, proposer: proposer})
+			}
+
+			accept(m, ViewMsg(1, 0))
+			assert.Equal(t, []sequenceStart{{view: View{Sequence: 1}, proposer: "A"}}, started)
+
+			// not again in another round of the same sequence
+			accept(m, ViewMsg(1, 1))
+			assert.Len(t, started, 1)
+
+			accept(m, ViewMsg(2, 0))
+			assert.Equal(t, sequenceStart{view: View{Sequence: 2}, proposer: "A"}, started[1])
+			assert.Len(t, started, 2)
+		})
+	}
+}
+
+func TestPbft_SequenceStarted_Config(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A")
+
+	// a nil handler is ignored
+	p := New(pool.get("A"), &mockPbft{}, WithSequenceStarted(nil))
+	assert.NotNil(t, p.config.SequenceStarted)
+
+	called := false
+	p = New(pool.get("A"), &mockPbft{}, WithSequenceStarted(func(View, NodeID) { called = true }))
+	p.config.SequenceStarted(View{}, "A")
+	assert.True(t, called)
+}
+
 func TestTransition_AcceptState_Validator_LockWrong(t *testing.T) {
 	// We are a validator and have a locked state in 'proposal1'.
 	// We receive an invalid proposal 'proposal2' with different data.
@@ -233,7 +937,7 @@ func TestTransition_AcceptState_Validator_LockWrong(t *testing.T) {
 		Data: mockProposal,
 		Hash: digest,
 	}
-	i.state.lock()
+	i.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
 
 	// emit the wrong locked proposal
 	i.emitMsg(&MessageReq{
@@ -254,27 +958,65 @@ func TestTransition_AcceptState_Validator_LockWrong(t *testing.T) {
 	})
 }
 
-func TestTransition_AcceptState_Validator_LockCorrect(t *testing.T) {
+func TestPbft_LastError(t *testing.T) {
 	i := newMockPbft(t, []string{"A", "B", "C"}, "B")
 	i.state.view = ViewMsg(1, 0)
 	i.setState(AcceptState)
 
-	// locked proposal
-	proposal := mockProposal
+	assert.NoError(t, i.LastError())
 
+	// locked proposal
 	i.state.proposal = &Proposal{
-		Data: proposal,
+		Data: mockProposal,
 		Hash: digest,
 	}
-	i.state.locked = true
+	i.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
 
+	// emit the wrong locked proposal
 	i.emitMsg(&MessageReq{
 		From:     "A",
 		Type:     MessageReq_Preprepare,
-		Proposal: proposal,
+		Proposal: mockProposal1,
+		Hash:     digest1,
 		View:     ViewMsg(1, 0),
 	})
 
+	i.runCycle(context.Background())
+	assert.Equal(t, errIncorrectLockedProposal, i.LastError())
+
+	// the round change consumes the state error but the last error is kept
+	assert.Equal(t, errIncorrectLockedProposal, i.state.getErr())
+	assert.Equal(t, errIncorrectLockedProposal, i.LastError())
+
+	// the last error is cleared when a new round starts
+	i.setState(AcceptState)
+	i.Close()
+	i.runCycle(context.Background())
+	assert.NoError(t, i.LastError())
+}
+
+func TestTransition_AcceptState_Validator_LockCorrect(t *testing.T) {
+	i := newMockPbft(t, []string{"A", "B", "C"}, "B")
+	i.state.view = ViewMsg(1, 0)
+	i.setState(AcceptState)
+
+	// locked proposal
+	proposal := mockProposal
+
+	i.state.proposal = &Proposal{
+		Data: proposal,
+		Hash: digest,
+	}
+	i.state.locked = true
+
+	i.emitMsg(&MessageReq{
+		From:                "A",
+		Type:                MessageReq_Preprepare,
+		Proposal:            proposal,
+		View:                ViewMsg(1, 0),
+		PreparedCertificate: newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B"),
+	})
+
 	i.runCycle(context.Background())
 
 	i.expect(expectResult{
@@ -285,6 +1027,185 @@ func TestTransition_AcceptState_Validator_LockCorrect(t *testing.T) {
 	})
 }
 
+// A locked node fast-tracks the locked proposal to the commit phase, unless the prepare phase is strict.
+// Both ways commit the same proposal, only the messages sent by the node differ.
+func TestPbft_StrictPreparePhase(t *testing.T) {
+	cases := []struct {
+		strict bool
+		sent   []MsgType
+	}{
+		// the commit of the fast-track, which is sent again once the commit quorum is reached
+		{false, []MsgType{MessageReq_Commit, MessageReq_Commit}},
+		{true, []MsgType{MessageReq_Prepare, MessageReq_Commit}},
+	}
+
+	committed := make([]*SealedProposal, 0, len(cases))
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("strict=%v", c.strict), func(t *testing.T) {
+			m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+			m.config.StrictPreparePhase = c.strict
+
+			// locked proposal
+			m.state.proposal = &Proposal{
+				Data: mockProposal,
+				Hash: digest,
+			}
+			m.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+
+			m.emitMsg(&MessageReq{
+				From:                "A",
+				Type:                MessageReq_Preprepare,
+				Proposal:            mockProposal,
+				View:                ViewMsg(1, 0),
+				PreparedCertificate: newPreparedCertificate(digest, ViewMsg(1, 0), "A", "C", "D"),
+			})
+			for _, from := range []NodeID{"C", "D"} {
+				m.emitMsg(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+				m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+			}
+
+			pp, err := m.RunSequence(context.Background(), 1)
+			require.NoError(t, err)
+
+			sent := make([]MsgType, 0, len(m.respMsg))
+			for _, msg := range m.respMsg {
+				sent = append(sent, msg.Type)
+			}
+			assert.Equal(t, c.sent, sent)
+			committed = append(committed, pp)
+		})
+	}
+
+	require.Len(t, committed, 2)
+	assert.Equal(t, committed[0].Proposal, committed[1].Proposal)
+	assert.Equal(t, committed[0].Proposer, committed[1].Proposer)
+	assert.Equal(t, committed[0].Number, committed[1].Number)
+}
+
+// Once the node sent its commit, the early commit skips the prepares still queued and the node
+// moves on as soon as it reaches the commit quorum (its own commit included), leaving the commits it does not need in the queue.
+func TestPbft_EarlyCommit(t *testing.T) {
+	cases := []struct {
+		early    bool
+		prepared int
+	}{
+		{false, 6},
+		{true, 5},
+	}
+
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("early=%v", c.early), func(t *testing.T) {
+			m := newMockPbft(t, []string{"A", "B", "C", "D", "E", "F", "G"}, "B")
+			defer m.Close()
+			m.config.EarlyCommit = c.early
+			m.setState(ValidateState)
+
+			for _, from := range []NodeID{"A", "C", "D", "E", "F", "G"} {
+				m.emitMsg(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+			}
+			for _, from := range []NodeID{"A", "C"} {
+				m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte(from)})
+			}
+
+			// the other commits arrive once the node reached the prepare quorum and sent its own
+			m.gossipFn = func(msg *MessageReq) error {
+				m.respMsg = append(m.respMsg, msg)
+				if msg.Type == MessageReq_Commit && len(m.respMsg) == 1 {
+					for _, from := range []NodeID{"D", "E", "F", "G"} {
+						m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte(from)})
+					}
+				}
+				return nil
+			}
+
+			m.runCycle(context.Background())
+
+			m.expect(expectResult{
+				sequence:    1,
+				state:       CommitState,
+				prepareMsgs: uint64(c.prepared),
+				commitMsgs:  5,
+				locked:      true,
+				outgoing:    1, // A commit message
+			})
+			// the node does not wait for the commits it does not need
+			assert.Equal(t, map[MsgType]int{MessageReq_Commit: 2}, m.QueueDepths())
+		})
+	}
+}
+
+// A locked node does not fast-track a locked proposal which is not justified by a valid prepared certificate.
+func TestTransition_AcceptState_Validator_LockInvalidCertificate(t *testing.T) {
+	validCert := newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B")
+
+	cases := []struct {
+		name string
+		cert *PreparedCertificate
+	}{
+		{"missing certificate", nil},
+		{"no prepares", newPreparedCertificate(digest, ViewMsg(1, 0))},
+		{"different proposal", newPreparedCertificate(digest1, ViewMsg(1, 0), "A", "B")},
+		{"future round", newPreparedCertificate(digest, ViewMsg(1, 1), "A", "B")},
+		{"tampered prepare", func() *PreparedCertificate {
+			cert := validCert.Copy()
+			cert.PrepareMessages[1].View = ViewMsg(1, 1)
+			return cert
+		}()},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			i := newMockPbft(t, []string{"A", "B", "C"}, "B")
+			i.state.view = ViewMsg(1, 0)
+			i.setState(AcceptState)
+
+			i.state.proposal = &Proposal{
+				Data: mockProposal,
+				Hash: digest,
+			}
+			i.state.locked = true
+
+			i.emitMsg(&MessageReq{
+				From:                "A",
+				Type:                MessageReq_Preprepare,
+				Proposal:            mockProposal,
+				View:                ViewMsg(1, 0),
+				PreparedCertificate: c.cert,
+			})
+
+			i.runCycle(context.Background())
+
+			i.expect(expectResult{
+				sequence: 1,
+				state:    RoundChangeState,
+				locked:   true,
+				err:      errInvalidPreparedCertificate,
+			})
+		})
+	}
+}
+
+// A locked proposer attaches the prepared certificate to the preprepare message.
+func TestTransition_AcceptState_Proposer_LockedSendsPreparedCertificate(t *testing.T) {
+	i := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	i.setState(AcceptState)
+
+	i.state.locked = true
+	i.state.proposal = &Proposal{
+		Data: mockProposal,
+		Hash: digest,
+	}
+	i.state.preparedCert = newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C")
+
+	i.runCycle(context.Background())
+
+	require.Len(t, i.respMsg, 2)
+	preprepare := i.respMsg[0]
+	require.Equal(t, MessageReq_Preprepare, preprepare.Type)
+	assert.Equal(t, i.state.preparedCert, preprepare.PreparedCertificate)
+	assert.Nil(t, i.respMsg[1].PreparedCertificate)
+}
+
 // Test that when validating proposal fails, state machine switches to RoundChangeState.
 func TestTransition_AcceptState_Validate_ProposalFail(t *testing.T) {
 	validateProposalFunc := func(p *Proposal) error {
@@ -436,6 +1357,143 @@ func TestTransition_RoundChangeState_WeakCertificate(t *testing.T) {
 	})
 }
 
+// With weighted validators, the weak certificate is more than 1/3 of the voting power, regardless of the
+// number of validators: a single validator with enough stake makes one, three validators with little stake do not.
+func TestTransition_RoundChangeState_WeakCertificate_Weighted(t *testing.T) {
+	cases := []struct {
+		senders []NodeID
+		round   uint64
+	}{
+		{[]NodeID{"B"}, 2},
+		{[]NodeID{"C", "D", "E"}, 1},
+	}
+
+	for _, c := range cases {
+		m := newMockPbft(t, []string{"A", "B", "C", "D", "E", "F", "G"}, "A")
+		m.state.validators = newWeightedValidatorSet(
+			map[NodeID]uint64{"A": 10, "B": 40, "C": 10, "D": 10, "E": 10, "F": 10, "G": 10},
+			"A", "B", "C", "D", "E", "F", "G")
+		m.setState(RoundChangeState)
+
+		for _, from := range c.senders {
+			m.emitMsg(&MessageReq{From: from, Type: MessageReq_RoundChange, View: ViewMsg(1, 2)})
+		}
+		m.Close()
+
+		m.runCycle(context.Background())
+
+		m.expect(expectResult{
+			sequence: 1,
+			round:    c.round,
+			outgoing: c.round, // a round change message for every round the node moved to
+			state:    RoundChangeState,
+		})
+	}
+}
+
+func TestTransition_RoundChangeState_WeakCertificateOnce(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D", "E", "F", "G"}, "A")
+
+	m.setState(RoundChangeState)
+
+	roundChange := func(from NodeID) {
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_RoundChange, View: ViewMsg(1, 2)})
+	}
+	// duplicates and more messages which cross the weak certificate again (but not the quorum),
+	// since the round messages were cleaned when moving to the round
+	m.gossipFn = func(msg *MessageReq) error {
+		m.respMsg = append(m.respMsg, msg)
+		if msg.Type == MessageReq_RoundChange && msg.View.Round == 2 {
+			roundChange("C")
+			roundChange("C")
+			roundChange("E")
+		}
+		return nil
+	}
+	// weak certificate for round 2
+	roundChange("B")
+	roundChange("C")
+	roundChange("D")
+	m.Close()
+
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence: 1,
+		round:    2,
+		outgoing: 2, // two round change messages (0->1, 1->2 after weak certificate), no more for round 2
+		state:    RoundChangeState,
+	})
+}
+
+func TestTransition_RoundChangeState_HighestWeakCertificate(t *testing.T) {
+	type roundChange struct {
+		from  NodeID
+		round uint64
+	}
+	cases := []struct {
+		name     string
+		messages []roundChange
+	}{
+		{
+			name:     "lower round first",
+			messages: []roundChange{{"B", 2}, {"C", 2}, {"D", 2}, {"E", 4}, {"F", 4}, {"G", 4}},
+		},
+		{
+			name:     "higher round first",
+			messages: []roundChange{{"E", 4}, {"F", 4}, {"G", 4}, {"B", 2}, {"C", 2}, {"D", 2}},
+		},
+		{
+			name:     "interleaved",
+			messages: []roundChange{{"B", 2}, {"E", 4}, {"C", 2}, {"F", 4}, {"D", 2}, {"G", 4}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := newMockPbft(t, []string{"A", "B", "C", "D", "E", "F", "G"}, "A")
+			m.setState(RoundChangeState)
+
+			// weak certificates for the rounds 2 and 4 from disjoint senders
+			for _, msg := range c.messages {
+				m.emitMsg(&MessageReq{From: msg.from, Type: MessageReq_RoundChange, View: ViewMsg(1, msg.round)})
+			}
+			m.Close()
+
+			m.runCycle(context.Background())
+
+			m.expect(expectResult{
+				sequence: 1,
+				round:    4,
+				outgoing: 2, // two round change messages (0->1, 1->4 after weak certificate), none for round 2
+				state:    RoundChangeState,
+			})
+			assert.Equal(t, uint64(4), m.respMsg[1].View.Round)
+		})
+	}
+}
+
+func TestTransition_RoundChangeState_DuplicatesStartNewRoundOnce(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+
+	m.setState(RoundChangeState)
+
+	// the duplicates of B do not count, C completes the quorum
+	// and the duplicates after it do not start the round again
+	for _, from := range []NodeID{"B", "B", "B", "C", "C"} {
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_RoundChange, View: ViewMsg(1, 2)})
+	}
+	m.Close()
+
+	m.runCycle(context.Background())
+	m.expect(expectResult{
+		sequence: 1,
+		round:    2,
+		outgoing: 1, // our own round change message (0->1)
+		state:    AcceptState,
+	})
+	assert.Equal(t, []NodeID{"B", "C"}, m.RoundChangeVotes()[2])
+}
+
 func TestTransition_RoundChangeState_ErrStartNewRound(t *testing.T) {
 	// if we start a round change because there was an error we start
 	// a new round right away
@@ -472,131 +1530,1271 @@ func TestTransition_RoundChangeState_StartNewRound(t *testing.T) {
 	})
 }
 
-func TestTransition_RoundChangeState_MaxRound(t *testing.T) {
-	// if we start round change due to a state timeout we try to catch up
-	// with the highest round seen.
-	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
-	m.Close()
+func TestTransition_RoundChangeState_SoloValidator(t *testing.T) {
+	// a single validator starts the new round with its own round change message
+	m := newMockPbft(t, []string{"A"}, "A")
+	defer m.Close()
 
-	m.addMessage(&MessageReq{
-		From: "B",
-		Type: MessageReq_RoundChange,
-		View: &View{
-			Round:    10,
-			Sequence: 1,
-		},
-	})
+	m.state.err = errVerificationFailed
 
 	m.setState(RoundChangeState)
 	m.runCycle(context.Background())
 
 	m.expect(expectResult{
 		sequence: 1,
-		round:    10,
-		state:    RoundChangeState,
+		round:    1,
+		state:    AcceptState,
 		outgoing: 1,
 	})
 }
 
-// Test that when state machine initial state is RoundChange and proposal
-func TestTransition_RoundChangeState_Stuck(t *testing.T) {
-	isStuckFn := func(num uint64) (uint64, bool) {
-		return 0, true
-	}
+func TestPbft_SoloValidator(t *testing.T) {
+	// a single validator commits with its own prepare and commit messages
+	m := newMockPbft(t, []string{"A"}, "A")
+	defer m.Close()
+	m.setProposal(&Proposal{
+		Data: mockProposal,
+		Time: time.Now(),
+	})
 
-	validatorIds := []string{"A", "B", "C"}
-	mockBackend := newMockBackend(validatorIds, nil).HookIsStuckHandler(isStuckFn)
+	m.Run(context.Background())
 
-	m := newMockPbft(t, validatorIds, "A", mockBackend)
-	m.SetState(RoundChangeState)
+	m.expect(expectResult{
+		sequence:    1,
+		state:       DoneState,
+		prepareMsgs: 1,
+		commitMsgs:  1,
+		outgoing:    3, // preprepare, prepare and commit
+	})
+}
 
-	m.runCycle(context.Background())
-	assert.True(t, m.IsState(SyncState))
+func TestPbft_SelfMessageBypass(t *testing.T) {
+	run := func(bypass bool) (*mockPbft, *SealedProposal) {
+		var inserted *SealedProposal
+		backend := newMockBackend([]string{"A", "B", "C", "D"}, nil).
+			HookInsertHandler(func(pp *SealedProposal) error {
+				inserted = pp
+				return nil
+			})
+
+		m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A", backend)
+		m.config.SelfMessageBypass = bypass
+		m.setProposal(&Proposal{
+			Data: mockProposal,
+			Time: time.Now(),
+			Hash: digest,
+		})
+
+		for _, from := range []NodeID{"B", "C"} {
+			m.emitMsg(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+			m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+		}
+
+		m.Run(context.Background())
+		m.Pbft.Close()
+		return m, inserted
+	}
+
+	queued, queuedInserted := run(false)
+	direct, directInserted := run(true)
+
+	// both paths reach the same committed state
+	for _, m := range []*mockPbft{queued, direct} {
+		m.expect(expectResult{
+			sequence:    1,
+			state:       DoneState,
+			prepareMsgs: 3,
+			commitMsgs:  3,
+			outgoing:    3, // preprepare, prepare and commit
+		})
+	}
+	require.NotNil(t, queuedInserted)
+	require.NotNil(t, directInserted)
+	assert.Equal(t, queuedInserted.Proposal.Hash, directInserted.Proposal.Hash)
+	assert.ElementsMatch(t, queuedInserted.CommittedSeals, directInserted.CommittedSeals)
 }
 
-// Test ValidateState to CommitState transition.
-func TestTransition_ValidateState_MoveToCommitState(t *testing.T) {
-	// we receive enough prepare messages to lock and commit the proposal
+func TestPbft_SelfMessageBypass_StaleMessage(t *testing.T) {
 	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.config.SelfMessageBypass = true
 	m.setState(ValidateState)
 
-	// Prepare messages
-	m.emitMsg(&MessageReq{
-		From: "A",
-		Type: MessageReq_Prepare,
-		View: ViewMsg(1, 0),
-	})
-	m.emitMsg(&MessageReq{
-		From: "B",
-		Type: MessageReq_Prepare,
-		View: ViewMsg(1, 0),
-	})
-	// repeated message is not included
-	m.emitMsg(&MessageReq{
-		From: "B",
-		Type: MessageReq_Prepare,
-		View: ViewMsg(1, 0),
-	})
-	m.emitMsg(&MessageReq{
-		From: "C",
-		Type: MessageReq_Prepare,
-		View: ViewMsg(1, 0),
-	})
-
-	// Commit messages
-	m.emitMsg(&MessageReq{
-		From: "C",
-		Type: MessageReq_Commit,
-		View: ViewMsg(1, 0),
-	})
-	m.emitMsg(&MessageReq{
-		From: "D",
-		Type: MessageReq_Commit,
-		View: ViewMsg(1, 0),
-	})
+	// a message from a previous round is not applied
+	m.applySelfMessage(&MessageReq{From: "A", Type: MessageReq_Prepare, View: ViewMsg(1, 1), Hash: digest})
+	assert.Equal(t, 0, m.state.numPrepared())
 
-	m.runCycle(context.Background())
+	// neither is a message in a state which does not expect it
+	m.setState(RoundChangeState)
+	m.applySelfMessage(&MessageReq{From: "A", Type: MessageReq_Prepare, View: ViewMsg(1, 0), Hash: digest})
+	assert.Equal(t, 0, m.state.numPrepared())
 
-	m.expect(expectResult{
-		sequence:    1,
-		state:       CommitState,
-		prepareMsgs: 3,
-		commitMsgs:  3, // Commit messages (A proposer sent commit via state machine loop, C and D sent commit via emit message)
-		locked:      true,
-		outgoing:    1, // A commit message
-	})
+	m.setState(ValidateState)
+	m.applySelfMessage(&MessageReq{From: "A", Type: MessageReq_Prepare, View: ViewMsg(1, 0), Hash: digest})
+	assert.Equal(t, 1, m.state.numPrepared())
 }
 
-// No messages are sent, so ensure that destination state is RoundChangeState and that state machine jumps out of the loop.
-func TestTransition_ValidateState_MoveToRoundChangeState(t *testing.T) {
-	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+func benchmarkSelfMessage(b *testing.B, bypass bool) {
+	m := newMockPbft(b, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.config.SelfMessageBypass = bypass
+	m.state.view = ViewMsg(1, 0)
 	m.setState(ValidateState)
+	span := trace.SpanFromContext(context.Background())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.gossip(MessageReq_Prepare)
+		if !bypass {
+			msg, _ := m.getNextMessage(span)
+			m.state.addPrepared(msg)
+		}
+		m.respMsg = m.respMsg[:0]
+	}
+}
 
-	m.runCycle(context.Background())
+func BenchmarkSelfMessage_Queue(b *testing.B) {
+	benchmarkSelfMessage(b, false)
+}
 
-	assert.True(t, m.IsState(RoundChangeState))
+func BenchmarkSelfMessage_Bypass(b *testing.B) {
+	benchmarkSelfMessage(b, true)
 }
 
-// Send wrong message type within ValidateState and asssure it panics
-func TestTransition_ValidateState_WrongMessageType(t *testing.T) {
-	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
-	m.setState(ValidateState)
+func TestPbft_SetBackend_InvalidLocalID(t *testing.T) {
+	for _, id := range []string{"", " "} {
+		p := New(&testerAccount{alias: id}, &mockPbft{},
+			WithLogger(log.New(getDefaultLoggerOutput(), "", log.LstdFlags)))
 
-	// Create preprepare message and push it to validate state message queue
-	msg := &MessageReq{
-		From:     "A",
-		Type:     MessageReq_Preprepare,
-		Proposal: mockProposal,
-		Hash:     digest,
-		View:     ViewMsg(1, 0),
+		err := p.SetBackend(newMockBackend([]string{"A", "B", id}, nil))
+		assert.Error(t, err, "id %q", id)
 	}
-	heap.Push(&m.msgQueue.validateStateQueue, msg)
-	assert.PanicsWithError(t, "BUG: Unexpected message type: Preprepare in ValidateState", func() { m.runCycle(context.Background()) })
 }
 
-// Test that past and future messages are discarded and state machine transfers from ValidateState to RoundChangeState.
-func TestTransition_ValidateState_DiscardMessage(t *testing.T) {
-	m := newMockPbft(t, []string{"A", "B"}, "A")
+func TestNewWithBackend(t *testing.T) {
+	logger := WithLogger(log.New(getDefaultLoggerOutput(), "", log.LstdFlags))
+	transport := &mockPbft{sequence: 3}
+	backend := newMockBackend([]string{"A", "B", "C"}, transport)
+
+	p, err := NewWithBackend(&testerAccount{alias: "A"}, transport, backend, logger, WithTimeout(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), p.state.view.Sequence)
+	assert.Equal(t, time.Second, p.config.Timeout)
+
+	testCases := []struct {
+		name      string
+		validator SignKey
+		transport Transport
+		backend   Backend
+		err       string
+	}{
+		{"nil validator", nil, transport, backend, "validator key not set"},
+		{"empty validator id", &testerAccount{alias: ""}, transport, backend, "invalid local validator id"},
+		{"nil transport", &testerAccount{alias: "A"}, nil, backend, "transport not set"},
+		{"nil backend", &testerAccount{alias: "A"}, transport, nil, "backend not set"},
+	}
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			p, err := NewWithBackend(c.validator, c.transport, c.backend, logger)
+			assert.Nil(t, p)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), c.err)
+		})
+	}
+}
+
+func TestPbft_SetBackend_Nil(t *testing.T) {
+	p := New(&testerAccount{alias: "A"}, &mockPbft{},
+		WithLogger(log.New(getDefaultLoggerOutput(), "", log.LstdFlags)))
+
+	assert.NotPanics(t, func() { assert.Error(t, p.SetBackend(nil)) })
+}
+
+// The observer sees every transition of a sequence committed in the first round.
+func TestPbft_StateChangeObserver(t *testing.T) {
+	type transition struct {
+		from, to PbftState
+	}
+	var transitions []transition
+
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	defer m.Close()
+	m.config.StateChangeObserver = func(from, to PbftState) {
+		transitions = append(transitions, transition{from: from, to: to})
+	}
+
+	m.emitMsg(&MessageReq{
+		From:     "A",
+		Type:     MessageReq_Preprepare,
+		Proposal: mockProposal,
+		View:     ViewMsg(1, 0),
+	})
+	for _, from := range []NodeID{"A", "C", "D"} {
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte(from)})
+	}
+
+	m.Run(context.Background())
+	require.True(t, m.IsState(DoneState))
+
+	require.NotEmpty(t, transitions)
+	states := []PbftState{}
+	for i, tr := range transitions {
+		if i > 0 {
+			// every transition starts from the state the previous one entered
+			assert.Equal(t, transitions[i-1].to, tr.from)
+		}
+		states = append(states, tr.to)
+	}
+	assert.Equal(t, []PbftState{AcceptState, ValidateState, CommitState, DoneState}, states)
+
+	// a nil observer is ignored
+	config := DefaultConfig()
+	WithStateChangeObserver(nil)(config)
+	assert.NotNil(t, config.StateChangeObserver)
+}
+
+func TestPbft_Observer(t *testing.T) {
+	// the observer is not part of the validator set
+	var inserted *SealedProposal
+	backend := newMockBackend([]string{"A", "B", "C", "D"}, nil).
+		HookInsertHandler(func(pp *SealedProposal) error {
+			inserted = pp
+			return nil
+		})
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "", backend)
+	defer m.Close()
+	m.config.Observer = true
+
+	m.emitMsg(&MessageReq{
+		From:     "A",
+		Type:     MessageReq_Preprepare,
+		Proposal: mockProposal,
+		Hash:     digest,
+		View:     ViewMsg(1, 0),
+	})
+	for _, from := range []NodeID{"A", "B", "C"} {
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte(from)})
+	}
+
+	m.Run(context.Background())
+
+	// the observer inserts the proposal with the seals of the validators without voting
+	assert.True(t, m.IsState(DoneState))
+	assert.Empty(t, m.respMsg)
+	assert.Equal(t, 3, m.state.numCommitted())
+	require.NotNil(t, inserted)
+	assert.Equal(t, NodeID("A"), inserted.Proposer)
+	assert.Len(t, inserted.CommittedSeals, 3)
+	for _, seal := range inserted.CommittedSeals {
+		assert.Equal(t, []byte(seal.NodeID), seal.Signature)
+	}
+}
+
+func TestTransition_AcceptState_Observer_NotProposer(t *testing.T) {
+	// A is the proposer of the round, but it runs as an observer
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.config.Observer = true
+	m.setState(AcceptState)
+	m.setProposal(&Proposal{
+		Data: mockProposal,
+		Time: time.Now(),
+	})
+
+	// it waits for a preprepare until the round times out
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence: 1,
+		state:    RoundChangeState,
+		outgoing: 0,
+	})
+}
+
+func TestPbft_RunSequence_Commit(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	defer m.Close()
+	m.setProposal(&Proposal{
+		Data: mockProposal,
+		Time: time.Now(),
+		Hash: digest,
+	})
+
+	for _, from := range []NodeID{"B", "C"} {
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(5, 0)})
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(5, 0), Seal: digest})
+	}
+
+	pp, err := m.RunSequence(context.Background(), 5)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), pp.Number)
+	assert.Equal(t, NodeID("A"), pp.Proposer)
+	assert.Equal(t, mockProposal, pp.Proposal.Data)
+	assert.True(t, m.IsState(DoneState))
+}
+
+func TestPbft_MinBlockInterval(t *testing.T) {
+	const interval = 200 * time.Millisecond
+
+	inserts := []time.Time{}
+	backend := newMockBackend([]string{"A", "B", "C"}, nil).HookInsertHandler(func(pp *SealedProposal) error {
+		inserts = append(inserts, time.Now())
+		return nil
+	})
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A", backend)
+	defer m.Close()
+	m.config.MinBlockInterval = interval
+	m.setProposal(&Proposal{
+		Data: mockProposal,
+		Time: time.Now(),
+		Hash: digest,
+	})
+
+	for sequence := uint64(1); sequence <= 4; sequence++ {
+		for _, from := range []NodeID{"B", "C"} {
+			m.emitMsg(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(sequence, 0)})
+			m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(sequence, 0), Seal: digest})
+		}
+		_, err := m.RunSequence(context.Background(), sequence)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, inserts, 4)
+	for i := 1; i < len(inserts); i++ {
+		assert.GreaterOrEqual(t, inserts[i].Sub(inserts[i-1]), interval, "sequence %d", i+1)
+	}
+}
+
+func TestPbft_MinBlockInterval_Interrupted(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	defer m.Close()
+	m.config.MinBlockInterval = time.Hour
+	m.lastCommit = time.Now()
+	m.setState(AcceptState)
+
+	// a forced timeout interrupts the wait with a round change
+	m.ForceTimeout()
+	m.runCycle(context.Background())
+	assert.True(t, m.IsState(RoundChangeState))
+
+	// and so does closing the state machine
+	m.setState(AcceptState)
+	m.Close()
+	m.runCycle(context.Background())
+	assert.True(t, m.IsState(AcceptState))
+}
+
+func TestPbft_MinBlockInterval_Config(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A")
+
+	p := New(pool.get("A"), &mockPbft{})
+	assert.Zero(t, p.config.MinBlockInterval)
+
+	p = New(pool.get("A"), &mockPbft{}, WithMinBlockInterval(time.Second))
+	assert.Equal(t, time.Second, p.config.MinBlockInterval)
+}
+
+func TestPbft_ValidatorSet_FrozenWithinSequence(t *testing.T) {
+	backend := newMockBackend([]string{"A", "B", "C", "D"}, nil)
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A", backend)
+	defer m.Close()
+	logger := &capturingLogger{}
+	m.logger = logger
+
+	// the backend reports a larger validator set once the sequence started
+	backend.HookBuildProposalHandler(func(ctx context.Context) (*Proposal, error) {
+		backend.validators = newMockValidatorSet([]string{"A", "B", "C", "D", "E", "F", "G"}).(*valString)
+		return &Proposal{Data: mockProposal, Time: time.Now(), Hash: digest}, nil
+	})
+
+	// the commits of B and C are a quorum of the snapshot (but not of the new set)
+	for _, from := range []NodeID{"B", "C"} {
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+	pp, err := m.RunSequence(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), pp.Number)
+	assert.Equal(t, 4, m.state.validators.Len())
+	assert.Equal(t, 2, m.state.NumValid())
+
+	// the change is only reported in the rounds after the snapshot
+	assert.Empty(t, logger.find("validator set changed in the middle of the sequence, using the one of the sequence start"))
+	m.state.SetCurrentRound(1)
+	m.setState(AcceptState)
+	m.runCycle(context.Background())
+	assert.Len(t, logger.find("validator set changed in the middle of the sequence, using the one of the sequence start"), 1)
+	assert.Equal(t, 4, m.state.validators.Len())
+
+	// the new set is used once the sequence advances
+	m.setSequence(2)
+	m.snapshotValidators()
+	assert.Equal(t, 7, m.state.validators.Len())
+	assert.Equal(t, 4, m.state.NumValid())
+}
+
+// roundInfoBackend is a mockBackend which hands over the round info of every round
+type roundInfoBackend struct {
+	*mockBackend
+	infoCh chan *RoundInfo
+}
+
+func (b *roundInfoBackend) Init(info *RoundInfo) {
+	b.infoCh <- info
+}
+
+func TestPbft_SetView_StartFromRound(t *testing.T) {
+	accounts := []string{"A", "B", "C", "D"}
+	m := newMockPbft(t, accounts, "A")
+	defer m.Close()
+
+	backend := &roundInfoBackend{mockBackend: newMockBackend(accounts, m), infoCh: make(chan *RoundInfo, 1)}
+	require.NoError(t, m.SetBackend(backend))
+
+	var lock sync.Mutex
+	var rounds []uint64
+	m.roundTimeout = func(round uint64) time.Duration {
+		lock.Lock()
+		defer lock.Unlock()
+		rounds = append(rounds, round)
+		return time.Hour
+	}
+
+	require.NoError(t, m.SetView(View{Sequence: 1, Round: 3}))
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		m.Run(ctx)
+	}()
+
+	select {
+	case info := <-backend.infoCh:
+		assert.Equal(t, m.state.validators.CalcProposer(3), info.Proposer)
+		assert.NotEqual(t, m.state.validators.CalcProposer(0), info.Proposer)
+	case <-time.After(5 * time.Second):
+		t.Fatal("round not started")
+	}
+	cancelFn()
+	<-doneCh
+
+	assert.Equal(t, uint64(1), m.state.view.Sequence)
+	assert.Equal(t, uint64(3), m.state.GetCurrentRound())
+
+	// the timeout is computed when the view is set and again when the node starts, always for round 3
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []uint64{3, 3}, rounds)
+}
+
+func TestPbft_SetView_CommittedSequence(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	m.sequence = 5
+	require.NoError(t, m.SetBackend(m.backend))
+
+	assert.Error(t, m.SetView(View{Sequence: 4, Round: 2}))
+	assert.Equal(t, uint64(5), m.state.view.Sequence)
+	assert.Equal(t, uint64(0), m.state.GetCurrentRound())
+
+	require.NoError(t, m.SetView(View{Sequence: 6, Round: 2}))
+	assert.Equal(t, uint64(6), m.state.view.Sequence)
+	assert.Equal(t, uint64(2), m.state.GetCurrentRound())
+}
+
+func TestPbft_SetView_Lock(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	m.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+
+	// the locked proposal survives a round change in the same sequence
+	require.NoError(t, m.SetView(View{Sequence: 1, Round: 2}))
+	assert.True(t, m.state.IsLocked())
+	assert.NotNil(t, m.state.proposal)
+
+	// but not a move to another sequence
+	require.NoError(t, m.SetView(View{Sequence: 2, Round: 1}))
+	assert.False(t, m.state.IsLocked())
+	assert.Nil(t, m.state.proposal)
+	assert.Equal(t, uint64(1), m.state.GetCurrentRound())
+}
+
+func TestPbft_ValidatorSet_ChangedInPlace(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	logger := &capturingLogger{}
+	m.logger = logger
+
+	validators := &selectableValString{valString: valString{"A", "B", "C", "D"}}
+	require.NoError(t, m.SetBackend(&selectableBackend{mockBackend: newMockBackend(nil, m), validators: validators}))
+
+	// the backend changes the set it returned in place, the snapshot is a copy of it
+	validators.valString = append(validators.valString, "E", "F", "G")
+	assert.Equal(t, 4, m.state.validators.Len())
+	assert.False(t, m.state.validators.Includes("E"))
+	assert.Equal(t, 2, m.state.NumValid())
+
+	m.checkValidatorSet(trace.SpanFromContext(context.Background()))
+	assert.Len(t, logger.find("validator set changed in the middle of the sequence, using the one of the sequence start"), 1)
+}
+
+func TestPbft_RunSequence_ContextCancelled(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	defer m.Close()
+
+	// the proposer never sends the proposal, the node keeps changing rounds until the context is cancelled
+	ctx, cancelFn := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelFn()
+	m.Pbft.ctx = ctx
+
+	pp, err := m.RunSequence(ctx, 1)
+	assert.Nil(t, pp)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestPbft_RunSequence_Sync(t *testing.T) {
+	backend := newMockBackend([]string{"A", "B", "C", "D"}, nil).
+		HookIsStuckHandler(func(num uint64) (uint64, bool) {
+			return num + 1, true
+		})
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B", backend)
+	defer m.Close()
+
+	pp, err := m.RunSequence(context.Background(), 1)
+	assert.Nil(t, pp)
+	assert.ErrorIs(t, err, ErrSyncState)
+}
+
+func TestPbft_RunSequence_Closed(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	defer m.Close()
+	m.Pbft.Close()
+
+	pp, err := m.RunSequence(context.Background(), 1)
+	assert.Nil(t, pp)
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestTransition_RoundChangeState_MaxRound(t *testing.T) {
+	// if we start round change due to a state timeout we try to catch up
+	// with the highest round seen.
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	m.Close()
+
+	m.addMessage(&MessageReq{
+		From: "B",
+		Type: MessageReq_RoundChange,
+		View: &View{
+			Round:    10,
+			Sequence: 1,
+		},
+	})
+
+	m.setState(RoundChangeState)
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence: 1,
+		round:    10,
+		state:    RoundChangeState,
+		outgoing: 1,
+	})
+}
+
+func newPreparedCertificate(hash []byte, view *View, senders ...NodeID) *PreparedCertificate {
+	cert := &PreparedCertificate{
+		Hash: hash,
+		View: view,
+	}
+	for _, from := range senders {
+		cert.PrepareMessages = append(cert.PrepareMessages, &MessageReq{
+			From: from,
+			Type: MessageReq_Prepare,
+			View: view.Copy(),
+			Hash: hash,
+		})
+	}
+	return cert
+}
+
+func TestTransition_RoundChangeState_RoundChangeCertificate(t *testing.T) {
+	validCert := newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C")
+
+	cases := []struct {
+		name     string
+		cert     *PreparedCertificate
+		accepted bool
+	}{
+		{"no certificate", nil, true},
+		{"valid certificate", validCert, true},
+		{"not enough prepares", newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B"), false},
+		{"duplicated prepares", newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "B"), false},
+		{"non validator prepares", newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "E"), false},
+		{"different sequence", newPreparedCertificate(digest, ViewMsg(2, 0), "A", "B", "C"), false},
+		{"round not lower", newPreparedCertificate(digest, ViewMsg(1, 2), "A", "B", "C"), false},
+		{"different hash", func() *PreparedCertificate {
+			cert := validCert.Copy()
+			cert.PrepareMessages[0].Hash = digest1
+			return cert
+		}(), false},
+		{"wrong message type", func() *PreparedCertificate {
+			cert := validCert.Copy()
+			cert.PrepareMessages[0].Type = MessageReq_Commit
+			return cert
+		}(), false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+			m.setState(RoundChangeState)
+
+			for _, from := range []NodeID{"B", "C", "D"} {
+				msg := &MessageReq{
+					From: from,
+					Type: MessageReq_RoundChange,
+					View: ViewMsg(1, 2),
+				}
+				if c.cert != nil {
+					msg.RoundChangeCertificate = &RoundChangeCertificate{PreparedCertificate: c.cert.Copy()}
+				}
+				m.emitMsg(msg)
+			}
+			m.Close()
+
+			m.runCycle(context.Background())
+
+			if c.accepted {
+				m.expect(expectResult{
+					sequence: 1,
+					round:    2,
+					outgoing: 1,
+					state:    AcceptState,
+				})
+			} else {
+				m.expect(expectResult{
+					sequence: 1,
+					round:    1,
+					outgoing: 1,
+					state:    RoundChangeState,
+				})
+			}
+		})
+	}
+}
+
+// Prepare messages failing the message verifier invalidate the prepared certificate.
+func TestPbft_VerifyPreparedCertificate_MessageVerifier(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	cert := newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C")
+	assert.NoError(t, m.verifyPreparedCertificate(cert, 1))
+
+	m.msgVerifier = func(msg *MessageReq) error {
+		if msg.From == "C" {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+	assert.Error(t, m.verifyPreparedCertificate(cert, 1))
+}
+
+// A certificate of the commit messages of a commit quorum proves the proposal was prepared too.
+func TestPbft_VerifyPreparedCertificate_Commits(t *testing.T) {
+	backend := newMockBackend([]string{"A", "B", "C", "D"}, nil).HookVerifyCommittedSealHandler(verifyTestSeal)
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A", backend)
+
+	commit := func(from NodeID) *MessageReq {
+		return &MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Hash: digest, Seal: testSeal(from, digest)}
+	}
+	cert := &PreparedCertificate{Hash: digest, View: ViewMsg(1, 0), PrepareMessages: []*MessageReq{commit("A"), commit("B"), commit("C")}}
+	assert.NoError(t, m.verifyPreparedCertificate(cert, 1))
+
+	// not enough commit messages
+	cert.PrepareMessages = cert.PrepareMessages[:2]
+	assert.Error(t, m.verifyPreparedCertificate(cert, 1))
+
+	// the prepare and commit messages are not mixed
+	cert.PrepareMessages = append(cert.PrepareMessages, newPreparedCertificate(digest, ViewMsg(1, 0), "C").PrepareMessages...)
+	assert.Error(t, m.verifyPreparedCertificate(cert, 1))
+
+	// the seals are checked against the proposal of the certificate
+	forged := commit("C")
+	forged.Seal = testSeal("C", digest1)
+	cert.PrepareMessages = []*MessageReq{commit("A"), commit("B"), forged}
+	assert.Error(t, m.verifyPreparedCertificate(cert, 1))
+}
+
+// A locked node justifies its round changes with the prepared certificate of the locked proposal.
+func TestTransition_ValidateState_RoundChangeCarriesPreparedCertificate(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.setState(ValidateState)
+
+	for _, from := range []NodeID{"A", "B", "C"} {
+		m.emitMsg(&MessageReq{
+			From: from,
+			Type: MessageReq_Prepare,
+			View: ViewMsg(1, 0),
+		})
+	}
+	m.runCycle(context.Background())
+
+	require.True(t, m.IsState(RoundChangeState))
+	require.True(t, m.state.locked)
+	require.NotNil(t, m.state.preparedCert)
+	assert.Len(t, m.state.preparedCert.PrepareMessages, 3)
+
+	m.sendRoundChange()
+	rc := m.respMsg[len(m.respMsg)-1]
+	require.Equal(t, MessageReq_RoundChange, rc.Type)
+	require.NotNil(t, rc.RoundChangeCertificate)
+	assert.Equal(t, m.state.preparedCert, rc.RoundChangeCertificate.PreparedCertificate)
+	assert.NotSame(t, m.state.preparedCert, rc.RoundChangeCertificate.PreparedCertificate)
+
+	// the certificate is discarded once the state is unlocked
+	m.state.unlock()
+	assert.Nil(t, m.state.preparedCert)
+}
+
+// Test that the node moves to SyncState once the round limit is reached.
+func TestTransition_RoundChangeState_MaxRounds(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.config.MaxRounds = 3
+	m.setState(RoundChangeState)
+
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence: 1,
+		round:    2,
+		outgoing: 2, // round change messages for rounds 1 and 2
+		state:    SyncState,
+	})
+}
+
+// Test that when state machine initial state is RoundChange and proposal
+func TestTransition_RoundChangeState_Stuck(t *testing.T) {
+	isStuckFn := func(num uint64) (uint64, bool) {
+		return 0, true
+	}
+
+	validatorIds := []string{"A", "B", "C"}
+	mockBackend := newMockBackend(validatorIds, nil).HookIsStuckHandler(isStuckFn)
+
+	m := newMockPbft(t, validatorIds, "A", mockBackend)
+	m.SetState(RoundChangeState)
+
+	m.runCycle(context.Background())
+	assert.True(t, m.IsState(SyncState))
+}
+
+// Test ValidateState to CommitState transition.
+func TestTransition_ValidateState_MoveToCommitState(t *testing.T) {
+	// we receive enough prepare messages to lock and commit the proposal
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.setState(ValidateState)
+
+	// Prepare messages
+	m.emitMsg(&MessageReq{
+		From: "A",
+		Type: MessageReq_Prepare,
+		View: ViewMsg(1, 0),
+	})
+	m.emitMsg(&MessageReq{
+		From: "B",
+		Type: MessageReq_Prepare,
+		View: ViewMsg(1, 0),
+	})
+	// repeated message is not included
+	m.emitMsg(&MessageReq{
+		From: "B",
+		Type: MessageReq_Prepare,
+		View: ViewMsg(1, 0),
+	})
+	m.emitMsg(&MessageReq{
+		From: "C",
+		Type: MessageReq_Prepare,
+		View: ViewMsg(1, 0),
+	})
+
+	// Commit messages
+	m.emitMsg(&MessageReq{
+		From: "C",
+		Type: MessageReq_Commit,
+		View: ViewMsg(1, 0),
+		Seal: []byte("C"),
+	})
+	m.emitMsg(&MessageReq{
+		From: "D",
+		Type: MessageReq_Commit,
+		View: ViewMsg(1, 0),
+		Seal: []byte("D"),
+	})
+
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence:    1,
+		state:       CommitState,
+		prepareMsgs: 3,
+		commitMsgs:  3, // Commit messages (A proposer sent commit via state machine loop, C and D sent commit via emit message)
+		locked:      true,
+		outgoing:    1, // A commit message
+	})
+}
+
+// A node which missed the prepare messages locks and commits the proposal once it receives a commit quorum,
+// since a commit quorum implies the proposal was prepared.
+func TestTransition_ValidateState_CommitWithoutPrepareQuorum(t *testing.T) {
+	var inserted *SealedProposal
+	backend := newMockBackend([]string{"A", "B", "C", "D"}, nil).
+		HookInsertHandler(func(pp *SealedProposal) error {
+			inserted = pp
+			return nil
+		})
+
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B", backend)
+	m.state.proposer = "A"
+	m.setState(ValidateState)
+
+	// a single prepare message, far from the quorum
+	m.emitMsg(&MessageReq{
+		From: "A",
+		Type: MessageReq_Prepare,
+		View: ViewMsg(1, 0),
+	})
+	for _, id := range []NodeID{"A", "C", "D"} {
+		m.emitMsg(&MessageReq{
+			From: id,
+			Type: MessageReq_Commit,
+			View: ViewMsg(1, 0),
+			Seal: []byte(id),
+		})
+	}
+
+	m.runCycle(context.Background())
+
+	require.True(t, m.IsState(CommitState))
+	assert.False(t, m.hasPrepareQuorum(m.state.messagesPower(m.state.prepared)))
+	assert.True(t, m.state.IsLocked())
+
+	// the commit messages prove the locked proposal was prepared
+	cert := m.state.lockCertificate()
+	require.NotNil(t, cert)
+	assert.Len(t, cert.PrepareMessages, 3)
+	for _, msg := range cert.PrepareMessages {
+		assert.Equal(t, MessageReq_Commit, msg.Type)
+	}
+	assert.NoError(t, m.verifyPreparedCertificate(cert, 1))
+
+	// the node sent its own commit message too
+	require.Len(t, m.respMsg, 1)
+	assert.Equal(t, MessageReq_Commit, m.respMsg[0].Type)
+
+	m.runCycle(context.Background())
+
+	require.True(t, m.IsState(DoneState))
+	require.NotNil(t, inserted)
+	assert.Equal(t, uint64(1), inserted.Number)
+	assert.Subset(t, inserted.Committers, []NodeID{"A", "C", "D"})
+}
+
+func TestPbft_LockedProposal(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.state.proposer = "A"
+	m.setState(ValidateState)
+
+	_, locked := m.LockedProposal()
+	assert.False(t, locked)
+
+	for _, from := range []NodeID{"B", "C", "D"} {
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+
+	// the accessor is read concurrently with the state machine
+	stopCh := make(chan struct{})
+	readDoneCh := make(chan struct{})
+	go func() {
+		defer close(readDoneCh)
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+				m.LockedProposal()
+			}
+		}
+	}()
+
+	m.runCycle(context.Background())
+	require.True(t, m.IsState(CommitState))
+
+	proposal, locked := m.LockedProposal()
+	require.True(t, locked)
+	assert.Equal(t, mockProposal, proposal.Data)
+	assert.Equal(t, digest, proposal.Hash)
+
+	// the returned proposal is a copy
+	proposal.Data[0] = 0xff
+	assert.Equal(t, mockProposal[0], m.state.proposal.Data[0])
+
+	m.runCycle(context.Background())
+	close(stopCh)
+	<-readDoneCh
+
+	require.True(t, m.IsState(DoneState))
+	_, locked = m.LockedProposal()
+	assert.False(t, locked)
+}
+
+// Running the state machine again while it is running fails instead of racing on the state.
+func TestPbft_Run_Concurrent(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	m.roundTimeout = func(uint64) time.Duration { return time.Hour }
+	assert.False(t, m.IsRunning())
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	doneCh := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(doneCh)
+	}()
+	require.Eventually(t, m.IsRunning, time.Second, time.Millisecond)
+
+	assert.PanicsWithError(t, "BUG: Run called concurrently: state machine already running", func() {
+		m.Run(ctx)
+	})
+	_, err := m.RunSequence(ctx, 1)
+	assert.ErrorIs(t, err, ErrAlreadyRunning)
+
+	// the running state machine is not affected
+	assert.True(t, m.IsRunning())
+	cancelFn()
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not stop")
+	}
+	assert.False(t, m.IsRunning())
+}
+
+func TestPbft_Run_ContextDeadline(t *testing.T) {
+	// B is not the proposer of round 0 and nobody proposes, the round never times out
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	defer m.Close()
+	m.roundTimeout = func(uint64) time.Duration { return time.Hour }
+
+	_, ok := m.RemainingBudget()
+	assert.False(t, ok)
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelFn()
+
+	doneCh := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(doneCh)
+	}()
+
+	// the budget is read concurrently with the state machine
+	assert.Eventually(t, func() bool {
+		budget, ok := m.RemainingBudget()
+		return ok && budget > 0 && budget <= 100*time.Millisecond
+	}, time.Second, time.Millisecond)
+
+	// it returns at the deadline, long before the round times out
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the state machine did not return after the deadline")
+	}
+	assert.True(t, m.IsState(AcceptState))
+
+	budget, ok := m.RemainingBudget()
+	assert.True(t, ok)
+	assert.Zero(t, budget)
+}
+
+// The budget is measured with the clock of the state machine.
+func TestPbft_RemainingBudget_Clock(t *testing.T) {
+	clock := newManualClock()
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	defer m.Close()
+	m.clock = clock
+
+	ctx, cancelFn := context.WithDeadline(context.Background(), clock.Now().Add(time.Minute))
+	defer cancelFn()
+	m.ctx = ctx
+
+	budget, ok := m.RemainingBudget()
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, budget)
+
+	clock.Advance(20 * time.Second)
+	budget, _ = m.RemainingBudget()
+	assert.Equal(t, 40*time.Second, budget)
+
+	clock.Advance(time.Minute)
+	budget, ok = m.RemainingBudget()
+	assert.True(t, ok)
+	assert.Zero(t, budget)
+}
+
+// With weighted validators, two out of four validators holding most of the stake commit the proposal.
+func TestTransition_ValidateState_WeightedQuorum(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.state.validators = newWeightedValidatorSet(map[NodeID]uint64{"A": 50, "B": 30, "C": 10, "D": 10}, "A", "B", "C", "D")
+	m.state.proposer = "A"
+	m.setState(ValidateState)
+
+	m.emitMsg(&MessageReq{
+		From: "B",
+		Type: MessageReq_Prepare,
+		View: ViewMsg(1, 0),
+	})
+	m.emitMsg(&MessageReq{
+		From: "A",
+		Type: MessageReq_Prepare,
+		View: ViewMsg(1, 0),
+	})
+	m.emitMsg(&MessageReq{
+		From: "B",
+		Type: MessageReq_Commit,
+		View: ViewMsg(1, 0),
+		Seal: digest,
+	})
+
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence:    1,
+		state:       CommitState,
+		prepareMsgs: 2,
+		commitMsgs:  2, // the commit sent by A via the state machine loop and the one from B
+		locked:      true,
+		outgoing:    1, // A commit message
+	})
+
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence:    1,
+		state:       DoneState,
+		prepareMsgs: 2,
+		commitMsgs:  2,
+		outgoing:    1,
+	})
+}
+
+// With weighted validators, three out of four validators holding a minority of the stake can not lock the proposal.
+func TestTransition_ValidateState_WeightedQuorum_Minority(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "D")
+	m.state.validators = newWeightedValidatorSet(map[NodeID]uint64{"A": 60, "B": 20, "C": 10, "D": 10}, "A", "B", "C", "D")
+	m.setState(ValidateState)
+
+	for _, from := range []NodeID{"B", "C", "D"} {
+		m.emitMsg(&MessageReq{
+			From: from,
+			Type: MessageReq_Prepare,
+			View: ViewMsg(1, 0),
+		})
+	}
+
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence:    1,
+		state:       RoundChangeState,
+		prepareMsgs: 3,
+	})
+}
+
+// A single node flooding prepare messages must not be able to lock the proposal.
+func TestTransition_ValidateState_DuplicatePrepares(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.setState(ValidateState)
+
+	for i := 0; i < 10; i++ {
+		m.emitMsg(&MessageReq{
+			From: "B",
+			Type: MessageReq_Prepare,
+			View: ViewMsg(1, 0),
+		})
+	}
+
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence:    1,
+		state:       RoundChangeState,
+		prepareMsgs: 1,
+	})
+}
+
+// Duplicates from different senders still let the state advance once a quorum of distinct senders is reached.
+func TestTransition_ValidateState_DuplicatePreparesDistinctSenders(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.setState(ValidateState)
+
+	for _, from := range []NodeID{"B", "B", "C", "C", "D", "D"} {
+		m.emitMsg(&MessageReq{
+			From: from,
+			Type: MessageReq_Prepare,
+			View: ViewMsg(1, 0),
+		})
+	}
+	m.Close()
+
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence:    1,
+		state:       ValidateState,
+		prepareMsgs: 3,
+		commitMsgs:  1, // our own commit message
+		locked:      true,
+		outgoing:    1, // A commit message
+	})
+}
+
+func TestTransition_ValidateState_QuorumThresholds(t *testing.T) {
+	validators := []string{"A", "B", "C", "D", "E", "F", "G"}
+	cases := []struct {
+		name    string
+		commits []NodeID
+		state   PbftState
+	}{
+		// the own commit and B's do not exceed the commit threshold
+		{"below commit threshold", []NodeID{"B"}, ValidateState},
+		// the own commit, B's and C's do
+		{"commit threshold", []NodeID{"B", "C"}, CommitState},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// the standard quorum of 7 validators is 5 messages
+			m := newMockPbft(t, validators, "A")
+			m.config.PrepareThreshold = func(uint64) uint64 { return 1 }
+			m.config.CommitThreshold = func(uint64) uint64 { return 2 }
+			m.state.proposer = "A"
+			m.setState(ValidateState)
+
+			for _, from := range []NodeID{"B", "C"} {
+				m.emitMsg(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+			}
+			for _, from := range c.commits {
+				m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+			}
+			m.Close()
+			m.runCycle(context.Background())
+
+			// two prepares exceed the prepare threshold, so the proposal is locked and committed
+			m.expect(expectResult{
+				sequence:    1,
+				state:       c.state,
+				prepareMsgs: 2,
+				commitMsgs:  uint64(len(c.commits)) + 1,
+				locked:      true,
+				outgoing:    1,
+			})
+			if c.state != CommitState {
+				return
+			}
+
+			// the committed seals are verified against the commit threshold too
+			m.runCycle(context.Background())
+			assert.True(t, m.IsState(DoneState))
+			require.NotNil(t, m.committed)
+			assert.Len(t, m.committed.CommittedSeals, 3)
+		})
+	}
+}
+
+func TestTransition_ValidateState_StandardQuorum(t *testing.T) {
+	// without thresholds, the same messages are not a quorum of 7 validators
+	m := newMockPbft(t, []string{"A", "B", "C", "D", "E", "F", "G"}, "A")
+	m.setState(ValidateState)
+
+	for _, from := range []NodeID{"B", "C"} {
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+	m.Close()
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence:    1,
+		state:       ValidateState,
+		prepareMsgs: 2,
+		commitMsgs:  2,
+	})
+}
+
+func TestPbft_QuorumThresholds_Config(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A")
+
+	p := New(pool.get("A"), &mockPbft{})
+	assert.Nil(t, p.config.PrepareThreshold)
+	assert.Nil(t, p.config.CommitThreshold)
+
+	p = New(pool.get("A"), &mockPbft{}, WithQuorumThresholds(nil, func(total uint64) uint64 { return total / 2 }))
+	assert.Nil(t, p.config.PrepareThreshold)
+	require.NotNil(t, p.config.CommitThreshold)
+	assert.Equal(t, uint64(3), p.config.CommitThreshold(7))
+}
+
+func TestPbft_QuorumThresholds_Weighted(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.state.validators = newWeightedValidatorSet(map[NodeID]uint64{"A": 50, "B": 30, "C": 10, "D": 10}, "A", "B", "C", "D")
+
+	// the thresholds are given the total voting power, not the number of validators
+	var totals []uint64
+	m.config.CommitThreshold = func(total uint64) uint64 {
+		totals = append(totals, total)
+		return total / 2
+	}
+
+	assert.False(t, m.hasCommitQuorum(m.state.sendersPower(map[NodeID]struct{}{"C": {}, "D": {}})))
+	assert.False(t, m.hasCommitQuorum(m.state.sendersPower(map[NodeID]struct{}{"A": {}})))
+	assert.True(t, m.hasCommitQuorum(m.state.sendersPower(map[NodeID]struct{}{"A": {}, "C": {}})))
+	assert.Equal(t, []uint64{100, 100, 100}, totals)
+}
+
+// No messages are sent, so ensure that destination state is RoundChangeState and that state machine jumps out of the loop.
+func TestTransition_ValidateState_MoveToRoundChangeState(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.setState(ValidateState)
+
+	m.runCycle(context.Background())
+
+	assert.True(t, m.IsState(RoundChangeState))
+}
+
+// Send wrong message type within ValidateState and asssure it panics
+func TestTransition_ValidateState_WrongMessageType(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.setState(ValidateState)
+
+	// Create preprepare message and push it to validate state message queue
+	msg := &MessageReq{
+		From:     "A",
+		Type:     MessageReq_Preprepare,
+		Proposal: mockProposal,
+		Hash:     digest,
+		View:     ViewMsg(1, 0),
+	}
+	heap.Push(&m.msgQueue.validateStateQueue, msg)
+	assert.PanicsWithError(t, "BUG: Unexpected message type: Preprepare in ValidateState", func() { m.runCycle(context.Background()) })
+}
+
+// Test that past and future messages are discarded and state machine transfers from ValidateState to RoundChangeState.
+func TestTransition_ValidateState_DiscardMessage(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B"}, "A")
 	m.setState(ValidateState)
 	m.state.view = ViewMsg(1, 2)
 
@@ -623,35 +2821,310 @@ func TestTransition_ValidateState_DiscardMessage(t *testing.T) {
 		outgoing:    0})
 }
 
-// Test CommitState to DoneState transition.
-func TestTransition_CommitState_DoneState(t *testing.T) {
-	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+// Test CommitState to DoneState transition.
+func TestTransition_CommitState_DoneState(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	m.state.view = ViewMsg(1, 0)
+	m.state.proposer = "A"
+	m.addMessage(&MessageReq{
+		From: "A",
+		Type: MessageReq_Commit,
+		View: ViewMsg(1, 0),
+		Seal: digest,
+	})
+	m.setState(CommitState)
+
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence:   1,
+		state:      DoneState,
+		commitMsgs: 1,
+	})
+}
+
+// A sequence committed after several round changes leaves nothing behind for the next one, which starts at round 0.
+func TestPbft_NextSequence_StartsClean(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+
+	// the sequence struggled through the round changes up to round 3
+	m.setRound(3)
+	for round := uint64(1); round <= 3; round++ {
+		for _, from := range []NodeID{"B", "C", "D"} {
+			m.addMessage(&MessageReq{From: from, Type: MessageReq_RoundChange, View: ViewMsg(1, round)})
+		}
+	}
+	m.state.fireRoundAction(3, roundChangeQuorumAction)
+	m.state.setErr(errFailedToInsertProposal)
+	for _, from := range []NodeID{"A", "B", "C"} {
+		m.addMessage(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(1, 3)})
+		m.addMessage(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 3), Seal: []byte(from)})
+	}
+	m.state.proposer = "D"
+	m.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+	m.setState(CommitState)
+
+	m.runCycle(context.Background())
+	require.True(t, m.IsState(DoneState))
+
+	// the next sequence
+	m.sequence = 2
+	require.NoError(t, m.SetBackend(m.backend))
+
+	m.expect(expectResult{
+		sequence: 2,
+		round:    0,
+		state:    DoneState,
+	})
+	assert.Empty(t, m.RoundChangeVotes())
+	assert.Empty(t, m.state.roundActions)
+	assert.NoError(t, m.LastError())
+	assert.NoError(t, m.state.getErr())
+}
+
+// Test CommitState to RoundChange transition.
+func TestTransition_CommitState_RoundChange(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	m.state.view = ViewMsg(1, 0)
+	m.addMessage(&MessageReq{
+		From: "A",
+		Type: MessageReq_Commit,
+		View: ViewMsg(1, 0),
+		Seal: digest,
+	})
+	m.setState(CommitState)
+
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence:   1,
+		state:      RoundChangeState,
+		err:        errFailedToInsertProposal,
+		commitMsgs: 1,
+	})
+	assert.True(t, m.IsState(RoundChangeState))
+}
+
+func TestTransition_CommitState_SequenceCompleted(t *testing.T) {
+	cases := []struct {
+		name     string
+		proposer NodeID
+		expected int
+	}{
+		{"insert succeeds", "A", 1},
+		{"insert fails", "", 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+			m.state.view = ViewMsg(1, 0)
+			m.state.proposer = c.proposer
+
+			completed := []*SealedProposal{}
+			m.config.SequenceCompleted = func(pp *SealedProposal) {
+				completed = append(completed, pp)
+			}
+
+			m.addMessage(&MessageReq{
+				From: "A",
+				Type: MessageReq_Commit,
+				View: ViewMsg(1, 0),
+				Seal: digest,
+			})
+			m.setState(CommitState)
+
+			m.runCycle(context.Background())
+
+			require.Len(t, completed, c.expected)
+			if c.expected == 1 {
+				assert.Equal(t, uint64(1), completed[0].Number)
+				assert.Equal(t, NodeID("A"), completed[0].Proposer)
+				assert.Equal(t, digest, completed[0].Proposal.Hash)
+				assert.Len(t, completed[0].CommittedSeals, 1)
+			}
+		})
+	}
+}
+
+// Test that the forged committed seals are dropped as the commit messages are read, so that they never
+// form the quorum the proposal is inserted with.
+func TestTransition_ValidateState_ForgedSeals(t *testing.T) {
+	validatorIds := []string{"A", "B", "C", "D"}
+	inserted := false
+	backend := newMockBackend(validatorIds, nil).
+		HookVerifyCommittedSealHandler(func(from NodeID, seal, hash []byte) error {
+			if from == "A" {
+				return nil
+			}
+			return errors.New("forged seal")
+		}).
+		HookInsertHandler(func(*SealedProposal) error {
+			inserted = true
+			return nil
+		})
+
+	m := newMockPbft(t, validatorIds, "D", backend)
 	m.state.view = ViewMsg(1, 0)
 	m.state.proposer = "A"
-	m.setState(CommitState)
+	m.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+	m.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+	m.setState(ValidateState)
+	m.blacklist = newPeerBlacklist(1, time.Minute)
+	for _, id := range validatorIds[:3] {
+		m.emitMsg(&MessageReq{
+			From: NodeID(id),
+			Type: MessageReq_Commit,
+			View: ViewMsg(1, 0),
+			Seal: []byte(id),
+		})
+	}
 
 	m.runCycle(context.Background())
 
 	m.expect(expectResult{
-		sequence: 1,
-		state:    DoneState,
+		sequence:   1,
+		state:      RoundChangeState,
+		commitMsgs: 1,
+		locked:     true,
 	})
+	assert.False(t, inserted)
+	// a seal may fail because the proposer equivocated, so the senders are not blacklisted for it
+	assert.False(t, m.blacklist.isBlacklisted("B", m.clock.Now()))
+	assert.False(t, m.blacklist.isBlacklisted("C", m.clock.Now()))
 }
 
-// Test CommitState to RoundChange transition.
-func TestTransition_CommitState_RoundChange(t *testing.T) {
-	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+// Test that a node relaying the committed seal of another validator under its own name is not counted.
+func TestTransition_ValidateState_RelayedSeal(t *testing.T) {
+	validatorIds := []string{"A", "B", "C", "D"}
+	backend := newMockBackend(validatorIds, nil).
+		HookVerifyCommittedSealHandler(func(from NodeID, seal, hash []byte) error {
+			// the seal of a node is its id
+			if !bytes.Equal(seal, []byte(from)) {
+				return errors.New("seal not signed by the sender")
+			}
+			return nil
+		})
+
+	m := newMockPbft(t, validatorIds, "D", backend)
 	m.state.view = ViewMsg(1, 0)
-	m.setState(CommitState)
+	m.setState(ValidateState)
+
+	m.emitMsg(&MessageReq{From: "A", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte("A")})
+	// B relays the seal of A
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte("A")})
+	m.emitMsg(&MessageReq{From: "C", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte("C")})
 
 	m.runCycle(context.Background())
 
-	m.expect(expectResult{
-		sequence: 1,
-		state:    RoundChangeState,
-		err:      errFailedToInsertProposal,
-	})
+	// without the seal of B there is no commit quorum and the round times out
 	assert.True(t, m.IsState(RoundChangeState))
+	assert.Equal(t, 2, m.state.numCommitted())
+	assert.NotContains(t, m.state.committed, NodeID("B"))
+}
+
+// Test that the committers of the sealed proposal are the sorted signers of the verified seals.
+func TestTransition_CommitState_Committers(t *testing.T) {
+	validatorIds := []string{"A", "B", "C", "D", "E", "F", "G"}
+	var inserted *SealedProposal
+	backend := newMockBackend(validatorIds, nil).
+		HookVerifyCommittedSealHandler(func(from NodeID, seal, hash []byte) error {
+			if from == "B" {
+				return errors.New("forged seal")
+			}
+			return nil
+		}).
+		HookInsertHandler(func(pp *SealedProposal) error {
+			inserted = pp
+			return nil
+		})
+
+	m := newMockPbft(t, validatorIds, "A", backend)
+	m.state.view = ViewMsg(1, 0)
+	m.state.proposer = "A"
+	m.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+	m.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+	m.setState(ValidateState)
+	for _, id := range []string{"G", "B", "E", "C", "A", "F", "D"} {
+		m.emitMsg(&MessageReq{
+			From: NodeID(id),
+			Type: MessageReq_Commit,
+			View: ViewMsg(1, 0),
+			Seal: []byte(id),
+		})
+	}
+
+	for !m.IsState(DoneState) && !m.IsState(RoundChangeState) {
+		m.runCycle(context.Background())
+	}
+
+	require.True(t, m.IsState(DoneState))
+	require.NotNil(t, inserted)
+	// the forged seal of B is not counted
+	assert.NotContains(t, inserted.Committers, NodeID("B"))
+	assert.GreaterOrEqual(t, len(inserted.Committers), QuorumSize(len(validatorIds)))
+	assert.IsIncreasing(t, inserted.Committers)
+	assert.Len(t, inserted.CommittedSeals, len(inserted.Committers))
+}
+
+// The sealed proposal carries when the proposal was first seen and when it was committed.
+func TestTransition_CommitState_Timestamps(t *testing.T) {
+	var inserted *SealedProposal
+	backend := newMockBackend([]string{"A", "B", "C"}, nil).
+		HookInsertHandler(func(pp *SealedProposal) error {
+			inserted = pp
+			return nil
+		})
+
+	clock := newManualClock()
+	m := newMockPbft(t, []string{"A", "B", "C"}, "B", backend)
+	m.clock = clock
+	m.state.view = ViewMsg(1, 0)
+	m.setState(AcceptState)
+
+	m.emitMsg(&MessageReq{
+		From:     "A",
+		Type:     MessageReq_Preprepare,
+		Proposal: mockProposal,
+		View:     ViewMsg(1, 0),
+	})
+	m.runCycle(context.Background())
+	require.True(t, m.IsState(ValidateState))
+	seenAt := clock.Now()
+
+	clock.Advance(time.Second)
+	for _, id := range []NodeID{"A", "C"} {
+		m.emitMsg(&MessageReq{From: id, Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+		m.emitMsg(&MessageReq{From: id, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte(id)})
+	}
+	for !m.IsState(DoneState) && !m.IsState(RoundChangeState) {
+		m.runCycle(context.Background())
+	}
+
+	require.True(t, m.IsState(DoneState))
+	require.NotNil(t, inserted)
+	assert.Equal(t, seenAt, inserted.SeenAt)
+	assert.Equal(t, seenAt.Add(time.Second), inserted.CommittedAt)
+	assert.True(t, inserted.CommittedAt.After(inserted.SeenAt))
+}
+
+// Test that seals from nodes outside of the validator set are not counted towards the quorum.
+func TestPbft_VerifyCommittedSeals_NonValidator(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.state.committed["E"] = &MessageReq{From: "E", Type: MessageReq_Commit, Seal: []byte("E")}
+	m.state.committed["F"] = &MessageReq{From: "F", Type: MessageReq_Commit, Seal: []byte("F")}
+	m.addMessage(&MessageReq{From: "A", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte("A")})
+
+	_, err := m.verifyCommittedSeals()
+	assert.ErrorIs(t, err, errInsufficientCommittedSeals)
+
+	m.addMessage(&MessageReq{From: "B", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte("B")})
+	m.addMessage(&MessageReq{From: "C", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte("C")})
+
+	seals, err := m.verifyCommittedSeals()
+	assert.NoError(t, err)
+	assert.Len(t, seals, 3)
 }
 
 // Test exponential timeout for various rounds.
@@ -680,6 +3153,87 @@ func TestExponentialTimeout(t *testing.T) {
 	}
 }
 
+// Test exponential timeout with a custom base and cap.
+func TestExponentialTimeout_CustomBaseAndCap(t *testing.T) {
+	roundTimeout := ExponentialTimeout(5*time.Second, 20*time.Second)
+
+	require.Equal(t, 6*time.Second, roundTimeout(0))
+	require.Equal(t, 7*time.Second, roundTimeout(1))
+	require.Equal(t, 20*time.Second, roundTimeout(5)) // 5s + 32s is capped
+	require.Equal(t, 20*time.Second, roundTimeout(1000))
+}
+
+// The default round timeout uses the configured Timeout as its base, while a custom one takes precedence.
+func TestPbft_RoundTimeout_Config(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A")
+	logger := WithLogger(log.New(getDefaultLoggerOutput(), "", log.LstdFlags))
+
+	p := New(pool.get("A"), &mockPbft{}, logger, WithTimeout(10*time.Second))
+	require.Equal(t, 11*time.Second, p.roundTimeout(0))
+	require.Equal(t, 12*time.Second, p.roundTimeout(1))
+	require.Equal(t, 42*time.Second, p.roundTimeout(5))
+	require.Equal(t, maxTimeout, p.roundTimeout(9))
+
+	linear := func(round uint64) time.Duration { return time.Duration(round+1) * time.Second }
+	p = New(pool.get("A"), &mockPbft{}, logger, WithTimeout(10*time.Second), WithRoundTimeout(linear))
+	require.Equal(t, 1*time.Second, p.roundTimeout(0))
+	require.Equal(t, 6*time.Second, p.roundTimeout(5))
+}
+
+// The jitter only adds to the timeout, up to the fraction, and never beyond the timeout of the next round.
+func TestJitteredTimeout(t *testing.T) {
+	base := ExponentialTimeout(10*time.Second, 40*time.Second)
+	roundTimeout := JitteredTimeout(base, 0.5, rand.New(rand.NewSource(1)))
+
+	for i := 0; i < 100; i++ {
+		for round := uint64(0); round < 6; round++ {
+			d := roundTimeout(round)
+			require.GreaterOrEqual(t, d, base(round))
+			require.LessOrEqual(t, d, base(round)+base(round)/2)
+			if next := base(round + 1); next > base(round) {
+				// the timeouts keep escalating
+				require.Less(t, d, next)
+			}
+		}
+	}
+
+	// the fraction is capped at 1
+	roundTimeout = JitteredTimeout(ExponentialTimeout(0, 40*time.Second), 5, rand.New(rand.NewSource(1)))
+	for i := 0; i < 100; i++ {
+		require.Less(t, roundTimeout(5), 64*time.Second)
+	}
+
+	// no jitter leaves the timeout unchanged
+	roundTimeout = JitteredTimeout(base, 0, rand.New(rand.NewSource(1)))
+	require.Equal(t, base(1), roundTimeout(1))
+}
+
+// The same source staggers the timeouts in the same way, different ones in different ways.
+func TestPbft_TimeoutJitter_Config(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A")
+	logger := WithLogger(log.New(getDefaultLoggerOutput(), "", log.LstdFlags))
+
+	p := New(pool.get("A"), &mockPbft{}, logger, WithTimeout(10*time.Second))
+	require.Equal(t, 11*time.Second, p.roundTimeout(0))
+
+	timeouts := func(seed int64) []time.Duration {
+		p := New(pool.get("A"), &mockPbft{}, logger, WithTimeout(10*time.Second),
+			WithProposalTimeout(time.Second), WithVoteTimeout(time.Second),
+			WithTimeoutJitter(0.1), WithRandSource(rand.NewSource(seed)))
+		return []time.Duration{p.roundTimeout(0), p.roundTimeout(1), p.proposalTimeout(0), p.voteTimeout(0)}
+	}
+	first := timeouts(1)
+	require.Equal(t, first, timeouts(1))
+	require.NotEqual(t, first, timeouts(2))
+
+	require.True(t, first[0] > 11*time.Second && first[0] < 12*time.Second)
+	require.True(t, first[1] >= 12*time.Second && first[1] < 12*time.Second+1200*time.Millisecond)
+	require.True(t, first[2] >= 2*time.Second && first[2] < 2200*time.Millisecond)
+	require.True(t, first[3] >= 2*time.Second && first[3] < 2200*time.Millisecond)
+}
+
 // Ensure that DoneState cannot be set as initial state of state machine.
 func TestDoneState_RunCycle_Panics(t *testing.T) {
 	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
@@ -753,6 +3307,243 @@ func TestPbft_Run(t *testing.T) {
 	})
 }
 
+func TestPbft_Close(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	defer m.Close()
+
+	// use a long round timeout so that only Close can stop the validation
+	m.roundTimeout = func(uint64) time.Duration { return time.Minute }
+	m.setRound(0)
+
+	runDoneCh := make(chan struct{})
+	go func() {
+		m.Run(m.ctx)
+		close(runDoneCh)
+	}()
+
+	// the proposer sends the proposal and the node waits for the prepare messages
+	m.emitMsg(&MessageReq{
+		From:     "A",
+		Type:     MessageReq_Preprepare,
+		Proposal: mockProposal,
+		View:     ViewMsg(1, 0),
+	})
+	require.Eventually(t, func() bool { return m.IsState(ValidateState) }, time.Second, time.Millisecond)
+
+	select {
+	case <-m.Pbft.Close():
+	case <-time.After(time.Second):
+		t.Fatal("state machine did not close")
+	}
+	<-runDoneCh
+
+	// the node stopped without starting a new round
+	assert.True(t, m.IsState(ValidateState))
+	assert.Equal(t, uint64(0), m.state.GetCurrentRound())
+
+	// once closed, the state machine does not run again
+	m.Run(context.Background())
+	assert.True(t, m.IsState(ValidateState))
+
+	select {
+	case <-m.Pbft.Close():
+	default:
+		t.Fatal("close channel must be closed")
+	}
+}
+
+func TestPbft_View(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	defer m.Close()
+	m.state.setView(ViewMsg(1, 2))
+	m.state.CalcProposer()
+
+	view := m.View()
+	assert.Equal(t, View{Sequence: 1, Round: 2}, view)
+	assert.Equal(t, NodeID("C"), m.CurrentProposer())
+
+	// the returned view is a copy
+	view.Round = 10
+	assert.Equal(t, uint64(2), m.state.GetCurrentRound())
+
+	// there is no view before the backend is set
+	pool := newTesterAccountPool()
+	pool.add("A")
+	assert.Equal(t, View{}, New(pool.get("A"), &mockPbft{}).View())
+}
+
+func TestPbft_View_Concurrent(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+
+	// nobody sends messages, so the state machine keeps changing rounds
+	runDoneCh := make(chan struct{})
+	go func() {
+		m.Run(m.ctx)
+		close(runDoneCh)
+	}()
+
+	lastRound := uint64(0)
+	proposers := map[NodeID]struct{}{}
+	deadline := time.After(5 * time.Second)
+	for lastRound < 3 {
+		select {
+		case <-deadline:
+			t.Fatal("the state machine did not change rounds")
+		default:
+		}
+
+		view := m.View()
+		assert.Equal(t, uint64(1), view.Sequence)
+		assert.GreaterOrEqual(t, view.Round, lastRound)
+		lastRound = view.Round
+		proposers[m.CurrentProposer()] = struct{}{}
+	}
+
+	m.Close()
+	<-runDoneCh
+	assert.NotEmpty(t, proposers)
+}
+
+func TestPbft_IsProposer(t *testing.T) {
+	accounts := []string{"A", "B", "C", "D"}
+	nodes := []*mockPbft{}
+	for _, account := range accounts {
+		m := newMockPbft(t, accounts, account)
+		defer m.Close()
+		nodes = append(nodes, m)
+	}
+
+	for round := uint64(0); round < 8; round++ {
+		// the proposer is calculated on demand for the current view
+		proposers := []NodeID{}
+		for _, m := range nodes {
+			m.state.setView(ViewMsg(1, round))
+			if m.IsProposer() {
+				proposers = append(proposers, m.validator.NodeID())
+			}
+		}
+		assert.Len(t, proposers, 1, "round %d", round)
+
+		// and it matches the one the state machine calculates
+		for _, m := range nodes {
+			m.calcProposer()
+			assert.Equal(t, m.state.proposer == m.validator.NodeID(), m.IsProposer(), "round %d", round)
+			assert.Equal(t, proposers[0], m.state.proposer, "round %d", round)
+		}
+	}
+
+	// an observer never proposes
+	nodes[0].config.Observer = true
+	nodes[0].state.setView(ViewMsg(1, 0))
+	nodes[0].calcProposer()
+	assert.Equal(t, NodeID("A"), nodes[0].state.proposer)
+	assert.False(t, nodes[0].IsProposer())
+}
+
+func TestPbft_IsProposer_Concurrent(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+
+	// nobody sends messages, so the state machine keeps changing rounds
+	runDoneCh := make(chan struct{})
+	go func() {
+		m.Run(m.ctx)
+		close(runDoneCh)
+	}()
+
+	isProposer := map[bool]struct{}{}
+	deadline := time.After(5 * time.Second)
+	for m.View().Round < 3 {
+		select {
+		case <-deadline:
+			t.Fatal("the state machine did not change rounds")
+		default:
+		}
+		isProposer[m.IsProposer()] = struct{}{}
+	}
+
+	m.Close()
+	<-runDoneCh
+	assert.NotEmpty(t, isProposer)
+}
+
+func TestPbft_RoundChangeVotes(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+
+	roundChange := func(from NodeID, round uint64) *MessageReq {
+		return &MessageReq{From: from, Type: MessageReq_RoundChange, View: ViewMsg(1, round)}
+	}
+	m.state.AddRoundMessage(roundChange("B", 1))
+	m.state.AddRoundMessage(roundChange("C", 1))
+	m.state.AddRoundMessage(roundChange("D", 1))
+	m.state.AddRoundMessage(roundChange("B", 2))
+	m.state.AddRoundMessage(roundChange("D", 2))
+
+	// duplicates and messages from non validators are not counted
+	m.state.AddRoundMessage(roundChange("B", 1))
+	m.state.AddRoundMessage(roundChange("E", 2))
+
+	votes := m.RoundChangeVotes()
+	assert.Equal(t, map[uint64][]NodeID{
+		1: {"B", "C", "D"},
+		2: {"B", "D"},
+	}, votes)
+
+	// the returned votes are a copy
+	votes[1][0] = "E"
+	delete(votes, 2)
+	assert.Equal(t, []NodeID{"B", "C", "D"}, m.RoundChangeVotes()[1])
+	assert.Len(t, m.RoundChangeVotes(), 2)
+
+	m.state.resetRoundMsgs()
+	assert.Empty(t, m.RoundChangeVotes())
+}
+
+func TestPbft_RoundChangeVotes_Concurrent(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.setProposal(&Proposal{
+		Data: mockProposal,
+		Time: time.Now(),
+	})
+
+	// the other validators keep asking for new rounds while the votes are read
+	for round := uint64(1); round <= 50; round++ {
+		m.emitMsg(&MessageReq{From: "B", Type: MessageReq_RoundChange, View: ViewMsg(1, round)})
+	}
+
+	runDoneCh := make(chan struct{})
+	go func() {
+		m.Run(m.ctx)
+		close(runDoneCh)
+	}()
+
+	deadline := time.After(time.Second)
+	for done := false; !done; {
+		select {
+		case <-deadline:
+			done = true
+		default:
+		}
+		for _, senders := range m.RoundChangeVotes() {
+			assert.NotEmpty(t, senders)
+		}
+	}
+
+	m.Close()
+	<-runDoneCh
+}
+
+func TestPbft_Close_NotRunning(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	defer m.Close()
+
+	select {
+	case <-m.Pbft.Close():
+	default:
+		t.Fatal("close channel must be closed")
+	}
+}
+
 // One of the validators fails to sign a proposal. Ensure that no messages were added to any message queue.
 func TestGossip_SignProposalFailed(t *testing.T) {
 	m := newMockPbft(t, []string{"A", "B"}, "A")
@@ -768,12 +3559,237 @@ func TestGossip_SignProposalFailed(t *testing.T) {
 	assert.Empty(t, m.msgQueue.validateStateQueue)
 }
 
+func TestGossip_NilProposal(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B"}, "A")
+	defer m.Close()
+	m.state.proposal = nil
+
+	var buf bytes.Buffer
+	m.logger = NewStdLogger(log.New(&buf, "", 0))
+
+	for _, typ := range []MsgType{MessageReq_Preprepare, MessageReq_Prepare, MessageReq_Commit} {
+		assert.NotPanics(t, func() { m.gossip(typ) })
+	}
+	assert.Empty(t, m.respMsg)
+	assert.Equal(t, 0, m.msgQueue.validateStateQueue.Len())
+	assert.Contains(t, buf.String(), "cannot send message without a proposal type=Commit")
+
+	// round change messages do not carry a proposal
+	m.gossip(MessageReq_RoundChange)
+	assert.Len(t, m.respMsg, 1)
+}
+
+func TestTransition_ValidateState_NilProposal(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.state.proposal = nil
+	m.setState(ValidateState)
+
+	var buf bytes.Buffer
+	m.logger = NewStdLogger(log.New(&buf, "", 0))
+
+	m.emitMsg(&MessageReq{
+		From: "B",
+		Type: MessageReq_Prepare,
+		View: ViewMsg(1, 0),
+	})
+
+	assert.NotPanics(t, func() { m.runCycle(context.Background()) })
+	assert.Contains(t, buf.String(), "validate state without a proposal")
+
+	m.expect(expectResult{
+		sequence: 1,
+		state:    RoundChangeState,
+		err:      errNoProposal,
+	})
+}
+
+func TestGossip_Retry(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B"}, "A")
+	defer m.Close()
+	m.config.GossipRetries = 3
+	m.config.GossipRetryInterval = time.Millisecond
+	m.config.GossipFailedHandler = func(msg *MessageReq, err error) {
+		t.Fatalf("unexpected gossip failure: %v", err)
+	}
+
+	// the transport fails the first two sends
+	attempts := 0
+	m.gossipFn = func(msg *MessageReq) error {
+		attempts++
+		if attempts <= 2 {
+			return errors.New("transport unavailable")
+		}
+		m.respMsg = append(m.respMsg, msg)
+		return nil
+	}
+
+	m.gossip(MessageReq_Commit)
+
+	assert.Equal(t, 3, attempts)
+	require.Len(t, m.respMsg, 1)
+	assert.Equal(t, MessageReq_Commit, m.respMsg[0].Type)
+}
+
+func TestGossip_RetryExhausted(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B"}, "A")
+	defer m.Close()
+	m.config.GossipRetries = 2
+	m.config.GossipRetryInterval = time.Millisecond
+
+	var failedMsg *MessageReq
+	m.config.GossipFailedHandler = func(msg *MessageReq, err error) {
+		failedMsg = msg
+	}
+
+	attempts := 0
+	m.gossipFn = func(msg *MessageReq) error {
+		attempts++
+		return errors.New("transport unavailable")
+	}
+
+	m.gossip(MessageReq_Prepare)
+
+	// the first attempt and two retries
+	assert.Equal(t, 3, attempts)
+	require.NotNil(t, failedMsg)
+	assert.Equal(t, MessageReq_Prepare, failedMsg.Type)
+}
+
+func TestGossip_NoRetryByDefault(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B"}, "A")
+	defer m.Close()
+
+	attempts := 0
+	m.gossipFn = func(msg *MessageReq) error {
+		attempts++
+		return errors.New("transport unavailable")
+	}
+
+	m.gossip(MessageReq_Commit)
+	assert.Equal(t, 1, attempts)
+}
+
+// Messages that fail the verification are dropped before reaching the message queue.
+func TestPbft_PushMessage_MessageVerifier(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A", "B", "C", "D")
+
+	verifier := func(msg *MessageReq) error {
+		if msg.Type == MessageReq_Commit && !bytes.Equal(msg.Seal, []byte(msg.From)) {
+			return errors.New("invalid seal")
+		}
+		return nil
+	}
+	p := New(pool.get("A"), &mockPbft{}, WithLogger(log.New(getDefaultLoggerOutput(), "", log.LstdFlags)), WithMessageVerifier(verifier))
+	p.state.view = ViewMsg(1, 0)
+	p.setState(ValidateState)
+
+	// bad signature
+	p.PushMessage(&MessageReq{From: "B", Type: MessageReq_Commit, View: ViewMsg(1, 0), Hash: digest, Seal: []byte("A")})
+	msg, _ := p.ReadMessageWithDiscards()
+	assert.Nil(t, msg)
+	assert.Equal(t, uint64(1), p.invalidMsgs)
+
+	// good signature
+	p.PushMessage(&MessageReq{From: "C", Type: MessageReq_Commit, View: ViewMsg(1, 0), Hash: digest, Seal: []byte("C")})
+	msg, _ = p.ReadMessageWithDiscards()
+	require.NotNil(t, msg)
+	assert.Equal(t, NodeID("C"), msg.From)
+	assert.Equal(t, uint64(1), p.invalidMsgs)
+}
+
+func TestPbft_PushMessage_StaleSequence(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.setSequence(3)
+	m.setState(ValidateState)
+
+	// the messages of the decided sequences are dropped before they are queued
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte("B")})
+	m.emitMsg(&MessageReq{From: "C", Type: MessageReq_Prepare, View: ViewMsg(2, 0)})
+	m.emitMsg(&MessageReq{From: "D", Type: MessageReq_RoundChange, View: ViewMsg(2, 1)})
+	assert.Equal(t, uint64(3), m.staleMsgs)
+	assert.Empty(t, m.QueueDepths())
+
+	// but not the ones of the sequence being decided
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_Prepare, View: ViewMsg(3, 0)})
+	assert.Equal(t, uint64(3), m.staleMsgs)
+
+	span := trace.SpanFromContext(context.Background())
+	msg, ok := m.getNextMessage(span)
+	require.True(t, ok)
+	require.NotNil(t, msg)
+	assert.Equal(t, ViewMsg(3, 0), msg.View)
+	assert.Empty(t, m.QueueDepths())
+}
+
+// The queued messages of a node are restored into a fresh instance, and processed without waiting for the peers
+// to send them again. The messages for an older view are dropped on restore.
+func TestPbft_SnapshotQueue_Restore(t *testing.T) {
+	src := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer src.Close()
+	src.setState(ValidateState)
+
+	src.emitMsg(&MessageReq{From: "B", Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+	src.emitMsg(&MessageReq{From: "B", Type: MessageReq_Prepare, View: ViewMsg(1, 1)})
+	src.emitMsg(&MessageReq{From: "C", Type: MessageReq_Prepare, View: ViewMsg(1, 1)})
+	src.emitMsg(&MessageReq{From: "D", Type: MessageReq_Prepare, View: ViewMsg(1, 2)})
+	src.emitMsg(&MessageReq{From: "C", Type: MessageReq_Commit, View: ViewMsg(1, 1), Seal: []byte("C")})
+	src.emitMsg(&MessageReq{From: "D", Type: MessageReq_Commit, View: ViewMsg(1, 1), Seal: []byte("D")})
+
+	snapshot := src.SnapshotQueue()
+	require.Len(t, snapshot, 6)
+	for i, from := range []NodeID{"B", "B", "C", "D", "C", "D"} {
+		// in the order they arrived in
+		assert.Equal(t, from, snapshot[i].From)
+	}
+	// the snapshot is a copy, the queue is untouched
+	snapshot[0].From = "E"
+	assert.Equal(t, map[MsgType]int{MessageReq_Prepare: 4, MessageReq_Commit: 2}, src.QueueDepths())
+
+	dst := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer dst.Close()
+	dst.setRound(1)
+	dst.setState(ValidateState)
+	dst.RestoreQueue(src.SnapshotQueue())
+
+	// the prepare for round 0 is dropped
+	assert.Equal(t, map[MsgType]int{MessageReq_Prepare: 3, MessageReq_Commit: 2}, dst.QueueDepths())
+
+	dst.emitMsg(&MessageReq{From: "A", Type: MessageReq_Prepare, View: ViewMsg(1, 1)})
+	dst.runCycle(context.Background())
+
+	dst.expect(expectResult{
+		sequence:    1,
+		round:       1,
+		state:       CommitState,
+		prepareMsgs: 3,
+		commitMsgs:  3,
+		locked:      true,
+		outgoing:    1, // A commit message
+	})
+	// the prepare for the future round is still queued
+	assert.Equal(t, map[MsgType]int{MessageReq_Prepare: 1}, dst.QueueDepths())
+}
+
+// The default message verifier accepts every message.
+func TestPbft_PushMessage_DefaultMessageVerifier(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.setState(ValidateState)
+
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte("B")})
+	msg, _ := m.ReadMessageWithDiscards()
+	assert.NotNil(t, msg)
+	assert.Zero(t, m.invalidMsgs)
+}
+
 type gossipDelegate func(*MessageReq) error
 
 type mockPbft struct {
 	*Pbft
 
-	t        *testing.T
+	t        testing.TB
 	pool     *testerAccountPool
 	respMsg  []*MessageReq
 	proposal *Proposal
@@ -806,7 +3822,7 @@ func (m *mockPbft) CalculateTimeout() time.Duration {
 	return time.Millisecond
 }
 
-func newMockPbft(t *testing.T, accounts []string, account string, backendArg ...*mockBackend) *mockPbft {
+func newMockPbft(t testing.TB, accounts []string, account string, backendArg ...*mockBackend) *mockPbft {
 	pool := newTesterAccountPool()
 	pool.add(accounts...)
 
@@ -933,16 +3949,20 @@ func (m *mockPbft) expect(res expectResult) {
 	}
 }
 
-type buildProposalDelegate func() (*Proposal, error)
+type buildProposalDelegate func(context.Context) (*Proposal, error)
 type validateDelegate func(*Proposal) error
 type isStuckDelegate func(uint64) (uint64, bool)
+type verifyCommittedSealDelegate func(NodeID, []byte, []byte) error
+type insertDelegate func(*SealedProposal) error
 
 type mockBackend struct {
-	mock            *mockPbft
-	validators      *valString
-	buildProposalFn buildProposalDelegate
-	validateFn      validateDelegate
-	isStuckFn       isStuckDelegate
+	mock                  *mockPbft
+	validators            *valString
+	buildProposalFn       buildProposalDelegate
+	validateFn            validateDelegate
+	isStuckFn             isStuckDelegate
+	verifyCommittedSealFn verifyCommittedSealDelegate
+	insertFn              insertDelegate
 }
 
 func (m *mockBackend) HookBuildProposalHandler(buildProposal buildProposalDelegate) *mockBackend {
@@ -960,19 +3980,30 @@ func (m *mockBackend) HookIsStuckHandler(isStuck isStuckDelegate) *mockBackend {
 	return m
 }
 
+func (m *mockBackend) HookVerifyCommittedSealHandler(verifyCommittedSeal verifyCommittedSealDelegate) *mockBackend {
+	m.verifyCommittedSealFn = verifyCommittedSeal
+	return m
+}
+
+func (m *mockBackend) HookInsertHandler(insert insertDelegate) *mockBackend {
+	m.insertFn = insert
+	return m
+}
+
 func (m *mockBackend) ValidateCommit(from NodeID, seal []byte) error {
 	return nil
 }
 
-func (m *mockBackend) Hash(p []byte) []byte {
-	h := sha1.New()
-	h.Write(p)
-	return h.Sum(nil)
+func (m *mockBackend) VerifyCommittedSeal(from NodeID, seal, hash []byte) error {
+	if m.verifyCommittedSealFn != nil {
+		return m.verifyCommittedSealFn(from, seal, hash)
+	}
+	return nil
 }
 
-func (m *mockBackend) BuildProposal() (*Proposal, error) {
+func (m *mockBackend) BuildProposal(ctx context.Context) (*Proposal, error) {
 	if m.buildProposalFn != nil {
-		return m.buildProposalFn()
+		return m.buildProposalFn(ctx)
 	}
 
 	if m.mock.proposal == nil {
@@ -1000,6 +4031,9 @@ func (m *mockBackend) IsStuck(num uint64) (uint64, bool) {
 }
 
 func (m *mockBackend) Insert(pp *SealedProposal) error {
+	if m.insertFn != nil {
+		return m.insertFn(pp)
+	}
 	// TODO:
 	if pp.Proposer == "" {
 		return errVerificationFailed
@@ -0,0 +1,112 @@
+package e2e
+
+import (
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+
+	"github.com/0xPolygon/pbft-consensus"
+)
+
+// ByzantineBehavior configures the faults of a byzantine node
+type ByzantineBehavior struct {
+	// EquivocateTo are the nodes which get a conflicting proposal (and the votes for it) from the node
+	EquivocateTo []string
+
+	// GarbageSeals makes the node send random committed seals in its commit messages
+	GarbageSeals bool
+
+	// SkipGossipTo are the nodes the node does not gossip to
+	SkipGossipTo []string
+}
+
+// byzantineNode wraps a node and alters the messages it gossips according to its behavior
+type byzantineNode struct {
+	*node
+
+	behavior ByzantineBehavior
+
+	lock sync.Mutex
+	// conflicts maps the hashes of the proposals of the node to the hashes of the conflicting ones
+	conflicts map[string][]byte
+
+	// number of conflicting proposals sent
+	equivocations uint64
+}
+
+func newByzantineNode(n *node, behavior ByzantineBehavior) *byzantineNode {
+	return &byzantineNode{
+		node:      n,
+		behavior:  behavior,
+		conflicts: map[string][]byte{},
+	}
+}
+
+// MakeByzantine makes the node misbehave according to the behavior when it gossips
+func (c *Cluster) MakeByzantine(name string, behavior ByzantineBehavior) *byzantineNode {
+	b := newByzantineNode(c.nodes[name], behavior)
+	c.transport.setByzantine(pbft.NodeID(name), b)
+	return b
+}
+
+// getEquivocations returns the number of conflicting proposals the node sent
+func (b *byzantineNode) getEquivocations() uint64 {
+	return atomic.LoadUint64(&b.equivocations)
+}
+
+// outgoing returns the message the node sends to the receiver instead of msg,
+// or false if it does not send anything to the receiver
+func (b *byzantineNode) outgoing(to pbft.NodeID, msg *pbft.MessageReq) (*pbft.MessageReq, bool) {
+	if Contains(b.behavior.SkipGossipTo, string(to)) {
+		return nil, false
+	}
+
+	if Contains(b.behavior.EquivocateTo, string(to)) {
+		msg = b.equivocate(msg)
+	}
+
+	if b.behavior.GarbageSeals && msg.Type == pbft.MessageReq_Commit {
+		msg = msg.Copy()
+		msg.Seal = GenerateProposal()
+	}
+	return msg, true
+}
+
+// equivocate replaces the proposal of the preprepare message with a conflicting one,
+// and the hash of the votes for the proposal with the hash of the conflicting proposal
+func (b *byzantineNode) equivocate(msg *pbft.MessageReq) *pbft.MessageReq {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	key := hex.EncodeToString(msg.Hash)
+
+	switch msg.Type {
+	case pbft.MessageReq_Preprepare:
+		if msg.PreparedCertificate != nil {
+			// a locked proposal cannot be replaced without invalidating the certificate
+			return msg
+		}
+		if _, ok := b.conflicts[key]; !ok {
+			atomic.AddUint64(&b.equivocations, 1)
+		}
+		conflicting := append(append([]byte{}, msg.Proposal...), 0xff)
+
+		msg = msg.Copy()
+		msg.Proposal = conflicting
+		msg.Hash = Hash(conflicting)
+		b.conflicts[key] = msg.Hash
+
+	case pbft.MessageReq_Prepare, pbft.MessageReq_Commit:
+		hash, ok := b.conflicts[key]
+		if !ok {
+			return msg
+		}
+		msg = msg.Copy()
+		msg.Hash = hash
+		if msg.Type == pbft.MessageReq_Commit {
+			// the e2e keys sign by echoing the hash
+			msg.Seal = hash
+		}
+	}
+	return msg
+}
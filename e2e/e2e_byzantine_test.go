@@ -0,0 +1,136 @@
+package e2e
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0xPolygon/pbft-consensus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// insertRecorder records the proposals each node inserts at every height
+type insertRecorder struct {
+	lock      sync.Mutex
+	proposals map[uint64]map[string][]byte
+}
+
+func (r *insertRecorder) add(name string, pp *pbft.SealedProposal) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.proposals[pp.Number] == nil {
+		r.proposals[pp.Number] = map[string][]byte{}
+	}
+	r.proposals[pp.Number][name] = pp.Proposal.Hash
+}
+
+func (r *insertRecorder) get(height uint64) map[string][]byte {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	proposals := map[string][]byte{}
+	for name, hash := range r.proposals[height] {
+		proposals[name] = hash
+	}
+	return proposals
+}
+
+// recordingFsm is a backend which records the proposals it inserts
+type recordingFsm struct {
+	Fsm
+	recorder *insertRecorder
+}
+
+func (f *recordingFsm) Insert(pp *pbft.SealedProposal) error {
+	f.recorder.add(f.n.name, pp)
+	return f.Fsm.Insert(pp)
+}
+
+func TestE2E_Byzantine_Equivocation(t *testing.T) {
+	t.Parallel()
+	recorder := &insertRecorder{proposals: map[uint64]map[string][]byte{}}
+	config := &ClusterConfig{
+		Count:         4,
+		Name:          "byzantine_equivocation",
+		Prefix:        "byz",
+		RoundTimeout:  GetPredefinedTimeout(2 * time.Second),
+		CreateBackend: func() IntegrationBackend { return &recordingFsm{recorder: recorder} },
+	}
+
+	c := NewPBFTCluster(t, config)
+	// byz_0 sends a conflicting proposal (and votes for it) to byz_1 whenever it is the proposer
+	b := c.MakeByzantine("byz_0", ByzantineBehavior{EquivocateTo: []string{"byz_1"}})
+	c.Start()
+	defer c.Stop()
+
+	honest := []string{"byz_1", "byz_2", "byz_3"}
+	err := c.WaitForHeight(8, 2*time.Minute, honest)
+	require.NoError(t, err)
+	assert.NotZero(t, b.getEquivocations())
+
+	// the honest nodes which inserted a proposal at a height inserted the same one
+	for height := uint64(1); height <= 8; height++ {
+		var agreed []byte
+		for _, name := range honest {
+			hash, ok := recorder.get(height)[name]
+			if !ok {
+				continue
+			}
+			if agreed == nil {
+				agreed = hash
+			}
+			assert.Equal(t, agreed, hash, "conflicting proposals at height %d", height)
+		}
+	}
+	c.AssertSafety()
+}
+
+func TestByzantineNode_Outgoing(t *testing.T) {
+	b := newByzantineNode(nil, ByzantineBehavior{
+		EquivocateTo: []string{"B"},
+		GarbageSeals: true,
+		SkipGossipTo: []string{"D"},
+	})
+
+	proposal := []byte{0x1, 0x2}
+	hash := Hash(proposal)
+	preprepare := &pbft.MessageReq{From: "A", Type: pbft.MessageReq_Preprepare, View: pbft.ViewMsg(1, 0), Proposal: proposal, Hash: hash}
+	prepare := &pbft.MessageReq{From: "A", Type: pbft.MessageReq_Prepare, View: pbft.ViewMsg(1, 0), Hash: hash}
+	commit := &pbft.MessageReq{From: "A", Type: pbft.MessageReq_Commit, View: pbft.ViewMsg(1, 0), Hash: hash, Seal: hash}
+
+	// skipped node
+	_, ok := b.outgoing("D", preprepare)
+	assert.False(t, ok)
+
+	// honest receiver gets the original proposal
+	msg, ok := b.outgoing("C", preprepare)
+	require.True(t, ok)
+	assert.Equal(t, preprepare, msg)
+
+	// equivocation target gets a conflicting proposal and the votes for it
+	msg, ok = b.outgoing("B", preprepare)
+	require.True(t, ok)
+	assert.NotEqual(t, proposal, msg.Proposal)
+	conflicting := msg.Hash
+	assert.Equal(t, Hash(msg.Proposal), conflicting)
+	assert.Equal(t, uint64(1), b.getEquivocations())
+
+	msg, _ = b.outgoing("B", prepare)
+	assert.Equal(t, conflicting, msg.Hash)
+	msg, _ = b.outgoing("C", prepare)
+	assert.Equal(t, hash, msg.Hash)
+
+	// garbage seals
+	msg, _ = b.outgoing("C", commit)
+	assert.Equal(t, hash, msg.Hash)
+	assert.NotEqual(t, hash, msg.Seal)
+	msg, _ = b.outgoing("B", commit)
+	assert.Equal(t, conflicting, msg.Hash)
+	assert.NotEqual(t, conflicting, msg.Seal)
+
+	// the original messages are not modified
+	assert.Equal(t, proposal, preprepare.Proposal)
+	assert.Equal(t, hash, commit.Seal)
+}
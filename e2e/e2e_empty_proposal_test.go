@@ -0,0 +1,62 @@
+package e2e
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/0xPolygon/pbft-consensus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// instantProposalFsm is a backend which proposes right away, instead of waiting for the proposal time,
+// with the data built by the given function
+type instantProposalFsm struct {
+	Fsm
+	data func() []byte
+}
+
+func (f *instantProposalFsm) BuildProposal(ctx context.Context) (*pbft.Proposal, error) {
+	proposal := &pbft.Proposal{
+		Data: f.data(),
+		Time: time.Now(),
+	}
+	proposal.Hash = Hash(proposal.Data)
+	return proposal, nil
+}
+
+func TestE2E_EmptyProposals(t *testing.T) {
+	t.Parallel()
+	const height = 4
+	config := &ClusterConfig{
+		Count:        4,
+		Name:         "empty_proposals",
+		Prefix:       "empty",
+		RoundTimeout: GetPredefinedTimeout(2 * time.Second),
+		// the backend has nothing to order, it always proposes an empty proposal
+		CreateBackend: func() IntegrationBackend {
+			return &instantProposalFsm{data: func() []byte { return []byte{} }}
+		},
+	}
+
+	c := NewPBFTCluster(t, config)
+	c.Start()
+	err := c.WaitForHeight(height, 1*time.Minute)
+	c.Stop()
+	require.NoError(t, err)
+	c.AssertSafety()
+
+	// every node inserted the same empty sealed proposal at every height
+	for _, n := range c.Nodes() {
+		proposals := n.getProposals()
+		require.GreaterOrEqual(t, len(proposals), height, n.name)
+
+		for _, pp := range proposals[:height] {
+			expected := committedProposals(c, height)[pp.Number-1]
+			assert.True(t, pp.Proposal.IsEmpty(), n.name)
+			assert.Equal(t, Hash([]byte{}), pp.Proposal.Hash, n.name)
+			assert.Equal(t, expected.Proposer, pp.Proposer, n.name)
+		}
+	}
+}
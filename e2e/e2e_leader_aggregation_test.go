@@ -0,0 +1,50 @@
+package e2e
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0xPolygon/pbft-consensus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runMessageCount runs a cluster up to the height and returns the number of messages delivered per height
+// and the proposals sealed by the cluster
+func runMessageCount(t *testing.T, name string, leaderAggregation bool, height uint64) (float64, []*pbft.SealedProposal) {
+	config := &ClusterConfig{
+		Count:             7,
+		Name:              name,
+		Prefix:            name,
+		RoundTimeout:      GetPredefinedTimeout(2 * time.Second),
+		LeaderAggregation: leaderAggregation,
+	}
+
+	c := NewPBFTCluster(t, config)
+	c.Start()
+	err := c.WaitForHeight(height, 2*time.Minute)
+	c.Stop()
+	require.NoError(t, err)
+	c.AssertSafety()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return float64(c.getDeliveredMessages()) / float64(len(c.sealedProposals)), c.sealedProposals[:height]
+}
+
+func TestE2E_LeaderAggregation(t *testing.T) {
+	t.Parallel()
+
+	gossip, gossipProposals := runMessageCount(t, "gossip", false, 5)
+	aggregated, aggregatedProposals := runMessageCount(t, "aggregated", true, 5)
+
+	// O(n) messages per phase instead of O(n^2)
+	t.Logf("messages per height: gossip %.1f, leader aggregation %.1f", gossip, aggregated)
+	assert.Less(t, aggregated*2, gossip)
+
+	// the same proposers committed the heights
+	for i := range gossipProposals {
+		assert.Equal(t, gossipProposals[i].Number, aggregatedProposals[i].Number)
+		assert.Equal(t, gossipProposals[i].Proposer[len("gossip"):], aggregatedProposals[i].Proposer[len("aggregated"):])
+	}
+}
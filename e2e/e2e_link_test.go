@@ -0,0 +1,86 @@
+package e2e
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestE2E_Link_SlowLinkCausesRoundChanges(t *testing.T) {
+	t.Parallel()
+	hook := newPartitionTransport(50 * time.Millisecond)
+
+	config := &ClusterConfig{
+		Count:        4,
+		Name:         "slow_link",
+		Prefix:       "slow",
+		RoundTimeout: GetPredefinedTimeout(2 * time.Second),
+	}
+
+	c := NewPBFTCluster(t, config, hook)
+	c.Start()
+	defer c.Stop()
+
+	err := c.WaitForHeight(2, 1*time.Minute)
+	require.NoError(t, err)
+	before := c.getRoundChangeCount()
+
+	// asymmetric latency: slow_0 hears the others in time, but its messages
+	// (and its proposals) reach them only after their round has timed out
+	for _, to := range []string{"slow_1", "slow_2", "slow_3"} {
+		hook.SetLinkLatency("slow_0", to, 3*time.Second)
+	}
+
+	height := c.GetMaxHeight()
+	// slow_0 takes its turn as the proposer at least once
+	err = c.WaitForHeight(height+5, 2*time.Minute)
+	assert.NoError(t, err)
+	assert.Greater(t, c.getRoundChangeCount(), before)
+}
+
+func TestE2E_Link_HighDropRate(t *testing.T) {
+	t.Parallel()
+	hook := newPartitionTransport(50 * time.Millisecond)
+
+	config := &ClusterConfig{
+		Count:        4,
+		Name:         "drop_rate",
+		Prefix:       "drop",
+		RoundTimeout: GetPredefinedTimeout(2 * time.Second),
+	}
+
+	// drop_3 loses most of the messages it sends and receives
+	for _, n := range []string{"drop_0", "drop_1", "drop_2"} {
+		hook.SetDropRate("drop_3", n, 0.9)
+		hook.SetDropRate(n, "drop_3", 0.9)
+	}
+
+	c := NewPBFTCluster(t, config, hook)
+	c.Start()
+	defer c.Stop()
+
+	// the lossy node neither blocks the others, nor is left behind
+	err := c.WaitForHeight(5, 1*time.Minute)
+	assert.NoError(t, err)
+}
+
+func TestPartitionTransport_Links(t *testing.T) {
+	hook := newPartitionTransport(time.Millisecond)
+
+	hook.SetDropRate("A", "B", 1)
+	hook.SetLinkLatency("B", "A", 100*time.Millisecond)
+
+	// the links are directed
+	assert.False(t, hook.Gossip("A", "B", nil))
+	assert.True(t, hook.Gossip("A", "C", nil))
+
+	start := time.Now()
+	assert.True(t, hook.Gossip("B", "A", nil))
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	// the links are reset with the partitions
+	hook.Reset()
+	assert.True(t, hook.Gossip("A", "B", nil))
+}
@@ -0,0 +1,82 @@
+package e2e
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0xPolygon/pbft-consensus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sealCheckingFsm is a backend which counts the committed seals it rejects
+type sealCheckingFsm struct {
+	Fsm
+	rejected *uint64
+}
+
+func (f *sealCheckingFsm) VerifyCommittedSeal(node pbft.NodeID, seal, hash []byte) error {
+	err := f.Fsm.VerifyCommittedSeal(node, seal, hash)
+	if err != nil {
+		atomic.AddUint64(f.rejected, 1)
+	}
+	return err
+}
+
+func TestE2E_MessageMutator_CorruptSeals(t *testing.T) {
+	t.Parallel()
+	var rejected uint64
+	config := &ClusterConfig{
+		Count:         5,
+		Name:          "corrupt_seals",
+		Prefix:        "mut",
+		RoundTimeout:  GetPredefinedTimeout(2 * time.Second),
+		CreateBackend: func() IntegrationBackend { return &sealCheckingFsm{rejected: &rejected} },
+	}
+
+	// corrupt the committed seals of mut_0 in flight, the others still form a quorum with one node to spare
+	transport := newGenericGossipTransport().withMessageMutator(func(msg *pbft.MessageReq) *pbft.MessageReq {
+		if msg.From == "mut_0" && msg.Type == pbft.MessageReq_Commit {
+			msg.Seal = []byte("corrupted")
+		}
+		return msg
+	})
+
+	c := NewPBFTCluster(t, config, transport)
+	c.Start()
+	defer c.Stop()
+
+	err := c.WaitForHeight(5, 1*time.Minute)
+	require.NoError(t, err)
+
+	// the receivers rejected the corrupted seals, so none of them made it to a sealed proposal
+	assert.NotZero(t, atomic.LoadUint64(&rejected))
+	for _, pp := range committedProposals(c, 5) {
+		for _, seal := range pp.CommittedSeals {
+			assert.NotEqual(t, []byte("corrupted"), seal.Signature)
+		}
+	}
+}
+
+func TestGenericGossipTransport_MessageMutator(t *testing.T) {
+	transport := newGenericGossipTransport()
+
+	msg := &pbft.MessageReq{From: "A", Type: pbft.MessageReq_Commit, View: pbft.ViewMsg(1, 0), Hash: []byte{0x1}, Seal: []byte{0x1}}
+
+	// without a mutator the message is delivered as is
+	assert.Equal(t, msg, transport.Mutate("A", "B", msg))
+
+	transport.withMessageMutator(func(msg *pbft.MessageReq) *pbft.MessageReq {
+		msg.Seal = []byte{0x2}
+		msg.View.Round = 5
+		return msg
+	})
+	mutated := transport.Mutate("A", "B", msg)
+	assert.Equal(t, []byte{0x2}, mutated.Seal)
+	assert.Equal(t, uint64(5), mutated.View.Round)
+
+	// the sender's copy is untouched
+	assert.Equal(t, []byte{0x1}, msg.Seal)
+	assert.Equal(t, uint64(0), msg.View.Round)
+}
@@ -33,3 +33,48 @@ func TestE2E_NodeDrop(t *testing.T) {
 
 	c.Stop()
 }
+
+func TestE2E_NodeDrop_ForceTimeout(t *testing.T) {
+	t.Parallel()
+	config := &ClusterConfig{
+		Count:        4,
+		Name:         "node_drop_force_timeout",
+		Prefix:       "ft",
+		RoundTimeout: GetPredefinedTimeout(5 * time.Minute),
+	}
+
+	c := NewPBFTCluster(t, config)
+	c.Start()
+	defer c.Stop()
+
+	err := c.WaitForHeight(2, 5*time.Second)
+	assert.NoError(t, err)
+
+	// the rounds of the stopped node would wait for the five minutes long round timeout, instead the harness
+	// times out the running nodes whenever the height stalls (longer than the 1s proposal delay)
+	c.StopNode("ft_0")
+	running := generateNodeNames(1, 4, "ft_")
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go func() {
+		height := c.GetMaxHeight(running)
+		for {
+			select {
+			case <-doneCh:
+				return
+			case <-time.After(3 * time.Second):
+			}
+			if h := c.GetMaxHeight(running); h != height {
+				height = h
+				continue
+			}
+			for _, name := range running {
+				c.nodes[name].ForceTimeout()
+			}
+		}
+	}()
+
+	err = c.WaitForHeight(c.GetMaxHeight(running)+5, time.Minute, running)
+	assert.NoError(t, err)
+}
@@ -22,4 +22,5 @@ func TestE2E_NoIssue(t *testing.T) {
 
 	err := c.WaitForHeight(10, 1*time.Minute)
 	assert.NoError(t, err)
+	c.AssertSafety()
 }
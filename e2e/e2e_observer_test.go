@@ -0,0 +1,40 @@
+package e2e
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0xPolygon/pbft-consensus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestE2E_Observer(t *testing.T) {
+	t.Parallel()
+	names := generateNodeNames(0, 5, "observer_")
+	validators, observer := names[:4], names[4]
+
+	config := &ClusterConfig{
+		Count:        5,
+		Name:         "observer",
+		Prefix:       "observer",
+		RoundTimeout: GetPredefinedTimeout(2 * time.Second),
+		ValidatorSet: func(uint64) []string { return validators },
+		Observers:    []string{observer},
+	}
+
+	c := NewPBFTCluster(t, config)
+	c.Start()
+	defer c.Stop()
+
+	// the observer follows the validators
+	err := c.WaitForHeight(5, 1*time.Minute, names)
+	assert.NoError(t, err)
+
+	// it inserts the proposals it observes instead of syncing them
+	assert.Zero(t, c.GetNodesMap()[observer].getSyncCount())
+
+	// it never proposes
+	for i := int64(0); i < 5; i++ {
+		assert.NotEqual(t, pbft.NodeID(observer), c.getProposer(i))
+	}
+}
@@ -306,6 +306,55 @@ func TestE2E_Partition_OneMajority(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestE2E_Partition_MinorityDetectsStarvation(t *testing.T) {
+	t.Parallel()
+	const nodesCnt = 5
+	hook := newPartitionTransport(50 * time.Millisecond)
+
+	config := &ClusterConfig{
+		Count:        nodesCnt,
+		Name:         "minority_starvation",
+		Prefix:       "strv",
+		RoundTimeout: GetPredefinedTimeout(2 * time.Second),
+		StuckTimeout: 5 * time.Second,
+	}
+
+	c := NewPBFTCluster(t, config, hook)
+	c.Start()
+	defer c.Stop()
+
+	err := c.WaitForHeight(2, 1*time.Minute)
+	assert.NoError(t, err)
+
+	majorityPartition := []string{"strv_0", "strv_1", "strv_2"}
+	minorityPartition := []string{"strv_3", "strv_4"}
+	hook.Partition(majorityPartition, minorityPartition)
+
+	nodes := c.GetNodesMap()
+	syncs := map[string]uint64{}
+	for _, name := range minorityPartition {
+		syncs[name] = nodes[name].getSyncCount()
+	}
+
+	err = c.WaitForHeight(c.GetMaxHeight()+2, 1*time.Minute, majorityPartition)
+	assert.NoError(t, err)
+
+	// the minority cannot see the height of the majority, so the backend never reports it is stuck.
+	// It goes back to sync only because it does not hear from a quorum of validators
+	assert.Eventually(t, func() bool {
+		for _, name := range minorityPartition {
+			if nodes[name].getSyncCount() <= syncs[name] {
+				return false
+			}
+		}
+		return true
+	}, 30*time.Second, 500*time.Millisecond)
+
+	hook.Reset()
+	err = c.WaitForHeight(c.GetMaxHeight()+1, 1*time.Minute)
+	assert.NoError(t, err)
+}
+
 func TestE2E_Partition_MajorityCanValidate(t *testing.T) {
 	t.Parallel()
 	const nodesCnt = 7 // N = 3 * F + 1, F = 2
@@ -385,3 +434,31 @@ func TestE2E_Partition_BigMajorityCantValidate(t *testing.T) {
 	err := c.WaitForHeight(8, 1*time.Minute, nodeNames)
 	assert.Errorf(t, err, "Height reached for minority of nodes")
 }
+
+func TestE2E_Partition_MaxRounds(t *testing.T) {
+	t.Parallel()
+	const nodesCnt = 4
+	hook := newPartitionTransport(10 * time.Millisecond)
+
+	config := &ClusterConfig{
+		Count:        nodesCnt,
+		Name:         "max_rounds",
+		Prefix:       "prt",
+		RoundTimeout: GetPredefinedTimeout(100 * time.Millisecond),
+		MaxRounds:    3,
+	}
+
+	c := NewPBFTCluster(t, config, hook)
+	// none of the partitions has a quorum, so every node keeps changing rounds
+	hook.Partition([]string{"prt_0", "prt_1"}, []string{"prt_2", "prt_3"})
+	c.Start()
+	defer c.Stop()
+
+	err := c.WaitForHeight(2, 3*time.Second)
+	assert.Error(t, err)
+
+	// every node hits the round limit and goes back to sync instead of escalating forever
+	for _, n := range c.Nodes() {
+		assert.NotZero(t, n.getSyncCount(), "node %s never reached sync state", n.name)
+	}
+}
@@ -0,0 +1,50 @@
+package e2e
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestE2E_PeerSync(t *testing.T) {
+	t.Parallel()
+	config := &ClusterConfig{
+		Count:        4,
+		Name:         "peer_sync",
+		Prefix:       "psync",
+		RoundTimeout: GetPredefinedTimeout(2 * time.Second),
+		PeerSync:     true,
+	}
+
+	c := NewPBFTCluster(t, config)
+	c.Start()
+	defer c.Stop()
+
+	err := c.WaitForHeight(2, 10*time.Second)
+	require.NoError(t, err)
+
+	c.StopNode("psync_0")
+	stoppedHeight := c.nodes["psync_0"].GetNodeHeight()
+
+	running := generateNodeNames(1, 4, "psync_")
+	err = c.WaitForHeight(stoppedHeight+5, 30*time.Second, running)
+	require.NoError(t, err)
+
+	// the node requests the proposals it missed to the others when it starts again
+	c.StartNode("psync_0")
+	height := c.GetMaxHeight(running) + 2
+	err = c.WaitForHeight(height, 30*time.Second)
+	require.NoError(t, err)
+	c.AssertSafety()
+
+	// the node inserted every proposal itself, instead of skipping the ones committed while it was stopped
+	proposals := c.nodes["psync_0"].getProposals()
+	require.GreaterOrEqual(t, len(proposals), int(height))
+	expected := committedProposals(c, height)
+	for i, pp := range proposals[:height] {
+		assert.Equal(t, uint64(i+1), pp.Number)
+		assert.Equal(t, expected[i].Proposal.Hash, pp.Proposal.Hash)
+	}
+}
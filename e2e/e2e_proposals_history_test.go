@@ -0,0 +1,46 @@
+package e2e
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The nodes retain only the last proposals over thousands of heights, while their heights keep being tracked.
+func TestE2E_ProposalsHistory_Pruned(t *testing.T) {
+	t.Parallel()
+	const history = 10
+	config := &ClusterConfig{
+		Count:            4,
+		Name:             "proposals_history",
+		Prefix:           "hst",
+		RoundTimeout:     GetPredefinedTimeout(2 * time.Second),
+		CreateBackend:    func() IntegrationBackend { return &instantProposalFsm{data: GenerateProposal} },
+		ProposalsHistory: history,
+	}
+
+	c := NewPBFTCluster(t, config)
+	c.Start()
+	err := c.WaitForHeight(2000, 5*time.Minute)
+	c.Stop()
+	require.NoError(t, err)
+
+	for _, n := range c.Nodes() {
+		n.proposalsLock.Lock()
+		retained := cap(n.proposals)
+		n.proposalsLock.Unlock()
+		// the history is pruned once it doubles, it never grows beyond
+		assert.LessOrEqual(t, retained, 4*history, n.name)
+
+		// the retained proposals are the last ones the node inserted, up to its height
+		proposals := n.getProposals()
+		require.Len(t, proposals, history, n.name)
+		assert.Equal(t, n.GetNodeHeight(), proposals[history-1].Number, n.name)
+		for i := 1; i < history; i++ {
+			assert.Equal(t, proposals[i-1].Number+1, proposals[i].Number, n.name)
+		}
+	}
+	c.AssertSafety()
+}
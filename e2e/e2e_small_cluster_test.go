@@ -0,0 +1,52 @@
+package e2e
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestE2E_SoloValidator(t *testing.T) {
+	t.Parallel()
+	config := &ClusterConfig{
+		Count:        1,
+		Name:         "solo",
+		Prefix:       "solo",
+		RoundTimeout: GetPredefinedTimeout(1 * time.Minute),
+	}
+
+	c := NewPBFTCluster(t, config)
+	c.Start()
+	defer c.Stop()
+
+	// the only validator commits with its own messages, so it has to
+	// produce the heights before a single round times out
+	err := c.WaitForHeight(5, 30*time.Second)
+	assert.NoError(t, err)
+}
+
+func TestE2E_TwoValidators(t *testing.T) {
+	t.Parallel()
+	config := &ClusterConfig{
+		Count:        2,
+		Name:         "two_validators",
+		Prefix:       "two",
+		RoundTimeout: GetPredefinedTimeout(2 * time.Second),
+	}
+
+	c := NewPBFTCluster(t, config)
+	c.Start()
+	defer c.Stop()
+
+	err := c.WaitForHeight(5, 1*time.Minute)
+	assert.NoError(t, err)
+
+	// two validators do not tolerate any faulty node (F = 0), but the quorum is
+	// still both of them, so the remaining node must not produce heights alone
+	c.StopNode("two_1")
+	height := c.GetMaxHeight([]string{"two_0"})
+
+	err = c.WaitForHeight(height+1, 5*time.Second, []string{"two_0"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,76 @@
+package e2e
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The nodes of both partitions keep timing out while the network is split. Once it heals, the cluster
+// converges with and without the jitter, the jitter staggers the timeouts so that the nodes
+// do not keep changing rounds in lockstep.
+func TestE2E_TimeoutJitter_PartitionHeals(t *testing.T) {
+	t.Parallel()
+
+	withoutJitter, _ := partitionHealConvergence(t, "no_jitter", 0)
+	withJitter, intervals := partitionHealConvergence(t, "jitter", 0.5)
+	t.Logf("converged %s after the partition healed without jitter, %s with jitter", withoutJitter, withJitter)
+
+	// each node draws its own jitter, so the nodes wait for different timeouts before changing the round
+	require.GreaterOrEqual(t, len(intervals), 2)
+	min, max := intervals[0], intervals[0]
+	for _, d := range intervals {
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+	}
+	require.Greater(t, max-min, 100*time.Millisecond, "round change intervals %v", intervals)
+}
+
+// partitionHealConvergence splits the cluster in two partitions without a quorum, heals the network
+// and returns how long the cluster takes to commit two more proposals, along with the time elapsed
+// between the round changes of each node while the network was split
+func partitionHealConvergence(t *testing.T, name string, jitter float64) (time.Duration, []time.Duration) {
+	hook := newPartitionTransport(10 * time.Millisecond)
+	config := &ClusterConfig{
+		Count:         4,
+		Name:          name,
+		Prefix:        "jtr",
+		RoundTimeout:  GetPredefinedTimeout(2 * time.Second),
+		TimeoutJitter: jitter,
+	}
+
+	c := NewPBFTCluster(t, config, hook)
+	c.Start()
+	defer c.Stop()
+
+	err := c.WaitForHeight(2, 20*time.Second)
+	require.NoError(t, err)
+
+	// none of the partitions has a quorum
+	hook.Partition([]string{"jtr_0", "jtr_1"}, []string{"jtr_2", "jtr_3"})
+	partitioned := time.Now()
+	c.IsStuck(8 * time.Second)
+
+	intervals := []time.Duration{}
+	for _, n := range c.nodes {
+		times := n.getRoundChangeTimes(partitioned)
+		for i := 1; i < len(times); i++ {
+			intervals = append(intervals, times[i].Sub(times[i-1]))
+		}
+	}
+
+	// the partition heals, every node commits the next proposals
+	hook.Reset()
+	healed := time.Now()
+	err = c.WaitForHeight(c.GetMaxHeight()+2, 30*time.Second)
+	require.NoError(t, err, "the cluster did not converge after the partition healed")
+	converged := time.Since(healed)
+
+	c.AssertSafety()
+	return converged, intervals
+}
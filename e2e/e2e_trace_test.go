@@ -0,0 +1,91 @@
+package e2e
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/0xPolygon/pbft-consensus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// committedProposals returns the proposals committed in the cluster up to the given height
+func committedProposals(c *Cluster, height uint64) []*pbft.SealedProposal {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if uint64(len(c.sealedProposals)) < height {
+		return c.sealedProposals
+	}
+	return c.sealedProposals[:height]
+}
+
+func TestE2E_RecordAndReplayTrace(t *testing.T) {
+	t.Parallel()
+	const height = 5
+
+	newConfig := func(name string) *ClusterConfig {
+		return &ClusterConfig{
+			Count:        5,
+			Name:         name,
+			Prefix:       "trace",
+			RoundTimeout: GetPredefinedTimeout(2 * time.Second),
+		}
+	}
+
+	recorded := NewPBFTCluster(t, newConfig("trace_record"))
+	recorded.StartRecording()
+	recorded.Start()
+	err := recorded.WaitForHeight(height, 1*time.Minute)
+	trace := recorded.StopRecording()
+	recorded.Stop()
+	require.NoError(t, err)
+	require.NotEmpty(t, trace.Messages)
+
+	// the trace survives a round trip through its serialized form
+	var buf bytes.Buffer
+	require.NoError(t, trace.Save(&buf))
+	trace, err = LoadTraceLog(&buf)
+	require.NoError(t, err)
+
+	replayed := NewPBFTCluster(t, newConfig("trace_replay"))
+	require.NoError(t, replayed.Replay(trace))
+	defer replayed.Stop()
+
+	err = replayed.WaitForHeight(height, 1*time.Minute)
+	require.NoError(t, err)
+
+	expected := committedProposals(recorded, height)
+	actual := committedProposals(replayed, height)
+	require.Len(t, actual, len(expected))
+	for i := range expected {
+		assert.Equal(t, expected[i].Number, actual[i].Number)
+		assert.Equal(t, expected[i].Proposer, actual[i].Proposer)
+		assert.Equal(t, expected[i].Proposal.Data, actual[i].Proposal.Data)
+		assert.Equal(t, expected[i].Proposal.Hash, actual[i].Proposal.Hash)
+	}
+}
+
+func TestTraceRecorder(t *testing.T) {
+	r := newTraceRecorder()
+
+	msg := &pbft.MessageReq{From: "A", Type: pbft.MessageReq_Prepare, View: pbft.ViewMsg(1, 0), Hash: []byte{0x1}}
+
+	// nothing is recorded before the recording starts
+	r.record("B", msg)
+
+	r.start()
+	r.record("B", msg)
+	r.record("B", msg)
+	r.record("C", msg)
+	r.record("B", &pbft.MessageReq{From: "A", Type: pbft.MessageReq_Commit, View: pbft.ViewMsg(1, 0), Hash: []byte{0x1}})
+	messages := r.stop()
+	r.record("D", msg)
+
+	// the messages are keyed by (from, to, view, type)
+	require.Len(t, messages, 3)
+	assert.Equal(t, pbft.NodeID("B"), messages[0].To)
+	assert.Equal(t, pbft.NodeID("C"), messages[1].To)
+	assert.Equal(t, pbft.MessageReq_Commit, messages[2].Message.Type)
+}
@@ -0,0 +1,76 @@
+package e2e
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestE2E_ValidatorSet_Grow(t *testing.T) {
+	t.Parallel()
+	const growHeight = 5
+	names := generateNodeNames(0, 7, "grow_")
+
+	config := &ClusterConfig{
+		Count:        7,
+		Name:         "validator_set_grow",
+		Prefix:       "grow",
+		RoundTimeout: GetPredefinedTimeout(2 * time.Second),
+		ValidatorSet: func(height uint64) []string {
+			if height < growHeight {
+				return names[:4]
+			}
+			return names
+		},
+	}
+
+	c := NewPBFTCluster(t, config)
+	c.Start()
+	defer c.Stop()
+
+	// only the initial validators are making progress
+	err := c.WaitForHeight(growHeight, 1*time.Minute, names[:4])
+	assert.NoError(t, err)
+
+	// once the validator set grows, every node takes part in consensus
+	err = c.WaitForHeight(growHeight+5, 1*time.Minute, names)
+	assert.NoError(t, err)
+
+	// the added validators take their turn as proposers
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	addedProposer := false
+	for _, p := range c.sealedProposals[growHeight:] {
+		addedProposer = addedProposer || Contains(names[4:], string(p.Proposer))
+	}
+	assert.True(t, addedProposer)
+}
+
+func TestE2E_ValidatorSet_Shrink(t *testing.T) {
+	t.Parallel()
+	const shrinkHeight = 4
+	names := generateNodeNames(0, 5, "shrink_")
+
+	config := &ClusterConfig{
+		Count:        5,
+		Name:         "validator_set_shrink",
+		Prefix:       "shrink",
+		RoundTimeout: GetPredefinedTimeout(2 * time.Second),
+		ValidatorSet: func(height uint64) []string {
+			if height < shrinkHeight {
+				return names
+			}
+			return names[:4]
+		},
+	}
+
+	c := NewPBFTCluster(t, config)
+	c.Start()
+	defer c.Stop()
+
+	// the removed validator stops voting, but the rest of the validators keep on making progress
+	err := c.WaitForHeight(shrinkHeight+5, 1*time.Minute, names[:4])
+	assert.NoError(t, err)
+	assert.NotZero(t, c.nodes[names[4]].getSyncCount())
+}
@@ -1,8 +1,10 @@
 package e2e
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha1"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"log"
@@ -22,6 +24,9 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
+// tracerShutdownTimeout bounds the time to export the pending spans once the cluster stops
+const tracerShutdownTimeout = 500 * time.Millisecond
+
 func initTracer(name string) *sdktrace.TracerProvider {
 	ctx := context.Background()
 
@@ -68,6 +73,9 @@ type IntegrationBackend interface {
 // CreateBackend is a delegate that creates a new instance of IntegrationBackend interface
 type CreateBackend func() IntegrationBackend
 
+// ValidatorSetFn is a delegate that returns the names of the validators for the given height
+type ValidatorSetFn func(height uint64) []string
+
 type Cluster struct {
 	t                     *testing.T
 	lock                  sync.Mutex
@@ -77,6 +85,7 @@ type Cluster struct {
 	sealedProposals       []*pbft.SealedProposal
 	replayMessageNotifier ReplayNotifier
 	createBackend         CreateBackend
+	validatorSet          ValidatorSetFn
 }
 
 type ClusterConfig struct {
@@ -88,6 +97,22 @@ type ClusterConfig struct {
 	TransportHandler      transportHandler
 	RoundTimeout          pbft.RoundTimeout
 	CreateBackend         CreateBackend
+	MaxRounds             uint64
+	ValidatorSet          ValidatorSetFn
+	Observers             []string
+	StuckTimeout          time.Duration
+	LeaderAggregation     bool
+	// PeerSync makes the nodes request the proposals they miss to the other nodes
+	// over the transport, instead of catching up with the cluster directly
+	PeerSync bool
+	// TimeoutJitter is the fraction of the round timeout added at random to it on each node,
+	// drawn from a source seeded with the name of the node so that the nodes do not share it
+	TimeoutJitter float64
+	// ProposalsHistory is the number of the last proposals each node retains (0 means all of them),
+	// the height of the nodes is tracked regardless. It keeps the memory bounded in long running tests
+	ProposalsHistory int
+	// Clock is the source of time of the nodes and of the time of their proposals (the wall clock if nil)
+	Clock pbft.Clock
 }
 
 func NewPBFTCluster(t *testing.T, config *ClusterConfig, hook ...transportHook) *Cluster {
@@ -96,7 +121,7 @@ func NewPBFTCluster(t *testing.T, config *ClusterConfig, hook ...transportHook)
 		names[i] = fmt.Sprintf("%s_%d", config.Prefix, i)
 	}
 
-	tt := &transport{}
+	tt := &transport{recorder: newTraceRecorder()}
 	if len(hook) == 1 {
 		tt.addHook(hook[0])
 	}
@@ -114,6 +139,11 @@ func NewPBFTCluster(t *testing.T, config *ClusterConfig, hook ...transportHook)
 		config.CreateBackend = func() IntegrationBackend { return &Fsm{} }
 	}
 
+	if config.ValidatorSet == nil {
+		// every node in the cluster is a validator at any height
+		config.ValidatorSet = func(uint64) []string { return names }
+	}
+
 	logsDir, err := CreateLogsDir(directoryName)
 	if err != nil {
 		log.Printf("[WARNING] Could not create logs directory. Reason: %v. Logging will be defaulted to standard output.", err)
@@ -131,6 +161,7 @@ func NewPBFTCluster(t *testing.T, config *ClusterConfig, hook ...transportHook)
 		sealedProposals:       []*pbft.SealedProposal{},
 		replayMessageNotifier: config.ReplayMessageNotifier,
 		createBackend:         config.CreateBackend,
+		validatorSet:          config.ValidatorSet,
 	}
 
 	err = c.replayMessageNotifier.SaveMetaData(&names)
@@ -140,7 +171,7 @@ func NewPBFTCluster(t *testing.T, config *ClusterConfig, hook ...transportHook)
 
 	for _, name := range names {
 		trace := c.tracer.Tracer(name)
-		n, _ := newPBFTNode(name, config, names, trace, tt)
+		n, _ := newPBFTNode(name, config, trace, tt)
 		n.c = c
 		c.nodes[name] = n
 	}
@@ -235,6 +266,20 @@ func (c *Cluster) GetMaxHeight(nodes ...[]string) uint64 {
 	return max
 }
 
+// getDeliveredMessages returns the number of messages the transport delivered to the nodes
+func (c *Cluster) getDeliveredMessages() uint64 {
+	return atomic.LoadUint64(&c.transport.delivered)
+}
+
+// getRoundChangeCount returns the total number of times the nodes moved to a new round
+func (c *Cluster) getRoundChangeCount(nodes ...[]string) uint64 {
+	var total uint64
+	for _, node := range c.resolveNodes(nodes...) {
+		total += c.nodes[node].getRoundChangeCount()
+	}
+	return total
+}
+
 func (c *Cluster) WaitForHeight(num uint64, timeout time.Duration, nodes ...[]string) error {
 	// we need to check every node in the ensemble?
 	// yes, this should test if everyone can agree on the final set.
@@ -286,6 +331,8 @@ func (n *node) GetNodeHeight() uint64 {
 	return uint64(n.getSyncIndex()) + 1
 }
 
+// syncWithNetwork returns the highest height (and the sync index) of the nodes connected to the node.
+// The heights are tracked by the sync index of the nodes, which does not depend on the proposals they retain
 func (c *Cluster) syncWithNetwork(nodeID string) (uint64, int64) {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -320,6 +367,17 @@ func (n *node) GetProposal() *pbft.Proposal {
 	return n.pbft.GetProposal()
 }
 
+// getSealedProposal returns the sealed proposal inserted at the height
+func (c *Cluster) getSealedProposal(height uint64) (*pbft.SealedProposal, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if height == 0 || height > uint64(len(c.sealedProposals)) {
+		return nil, false
+	}
+	return c.sealedProposals[height-1], true
+}
+
 func (c *Cluster) getProposer(index int64) pbft.NodeID {
 	c.lock.Lock()
 	defer c.lock.Unlock()
@@ -389,7 +447,11 @@ func (c *Cluster) Stop() {
 			n.Stop()
 		}
 	}
-	if err := c.tracer.Shutdown(context.Background()); err != nil {
+	// the exporter keeps retrying the pending spans until the deadline when no collector is listening,
+	// which would add several seconds to every test
+	ctx, cancel := context.WithTimeout(context.Background(), tracerShutdownTimeout)
+	defer cancel()
+	if err := c.tracer.Shutdown(ctx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
 		panic("failed to shutdown TracerProvider")
 	}
 }
@@ -409,16 +471,70 @@ type node struct {
 	cancelFn context.CancelFunc
 	running  uint64
 
-	// validator nodes
-	nodes []string
-
 	// indicate if the node is faulty
 	faulty uint64
+
+	// number of times the node went back to sync
+	syncs uint64
+
+	// indicate if the node syncs over the transport (see ClusterConfig.PeerSync)
+	peerSync bool
+
+	// number of times the node moved to a new round
+	roundChanges uint64
+
+	// times at which the node moved to a new round
+	roundChangedAt   []time.Time
+	roundChangesLock sync.Mutex
+
+	// proposals inserted by the node, only the last proposalsHistory ones if it is set
+	proposals        []*pbft.SealedProposal
+	proposalsHistory int
+	proposalsLock    sync.Mutex
+
+	// clock is the source of time of the node (see ClusterConfig.Clock)
+	clock pbft.Clock
+}
+
+// nodeMetrics collects the metrics of the node needed by the tests
+type nodeMetrics struct {
+	pbft.NoopMetrics
+	n *node
+}
+
+// RoundChange implements pbft.Metrics interface
+func (m *nodeMetrics) RoundChange(round uint64) {
+	atomic.AddUint64(&m.n.roundChanges, 1)
+
+	m.n.roundChangesLock.Lock()
+	m.n.roundChangedAt = append(m.n.roundChangedAt, m.n.clock.Now())
+	m.n.roundChangesLock.Unlock()
 }
 
-func newPBFTNode(name string, clusterConfig *ClusterConfig, nodes []string, trace trace.Tracer, tt *transport) (*node, error) {
+func newPBFTNode(name string, clusterConfig *ClusterConfig, trace trace.Tracer, tt *transport) (*node, error) {
 	loggerOutput := GetLoggerOutput(name, clusterConfig.LogsDir)
 
+	observer := false
+	for _, o := range clusterConfig.Observers {
+		if o == name {
+			observer = true
+			break
+		}
+	}
+
+	n := &node{
+		name:    name,
+		running: 0,
+		// set to init index -1 so that zero value is not the same as first index
+		localSyncIndex:   -1,
+		peerSync:         clusterConfig.PeerSync,
+		proposalsHistory: clusterConfig.ProposalsHistory,
+		clock:            clusterConfig.Clock,
+	}
+	if n.clock == nil {
+		n.clock = &pbft.RealClock{}
+	}
+
 	con := pbft.New(
 		key(name),
 		tt,
@@ -426,7 +542,17 @@ func newPBFTNode(name string, clusterConfig *ClusterConfig, nodes []string, trac
 		pbft.WithLogger(log.New(loggerOutput, "", log.LstdFlags)),
 		pbft.WithNotifier(clusterConfig.ReplayMessageNotifier),
 		pbft.WithRoundTimeout(clusterConfig.RoundTimeout),
+		pbft.WithMaxRounds(clusterConfig.MaxRounds),
+		pbft.WithObserver(observer),
+		pbft.WithStuckTimeout(clusterConfig.StuckTimeout),
+		pbft.WithLeaderAggregation(clusterConfig.LeaderAggregation),
+		pbft.WithPeerSync(clusterConfig.PeerSync),
+		pbft.WithTimeoutJitter(clusterConfig.TimeoutJitter),
+		pbft.WithRandSource(rand.NewSource(nameSeed(name))),
+		pbft.WithMetrics(&nodeMetrics{n: n}),
+		pbft.WithClock(n.clock),
 	)
+	n.pbft = con
 
 	if clusterConfig.TransportHandler != nil {
 		//for replay messages when we do not want to gossip messages
@@ -439,14 +565,6 @@ func newPBFTNode(name string, clusterConfig *ClusterConfig, nodes []string, trac
 		})
 	}
 
-	n := &node{
-		nodes:   nodes,
-		name:    name,
-		pbft:    con,
-		running: 0,
-		// set to init index -1 so that zero value is not the same as first index
-		localSyncIndex: -1,
-	}
 	return n, nil
 }
 
@@ -468,13 +586,41 @@ func (n *node) isStuck(num uint64) (uint64, bool) {
 }
 
 func (n *node) Insert(pp *pbft.SealedProposal) error {
+	n.recordProposal(pp)
 	err := n.c.insertFinalProposal(pp)
 	if err != nil {
 		panic(err)
 	}
+	if syncIndex := int64(pp.Number) - 1; syncIndex > n.getSyncIndex() {
+		n.setSyncIndex(syncIndex)
+	}
 	return nil
 }
 
+// recordProposal records a proposal inserted by the node
+func (n *node) recordProposal(pp *pbft.SealedProposal) {
+	n.proposalsLock.Lock()
+	defer n.proposalsLock.Unlock()
+
+	n.proposals = append(n.proposals, pp)
+	if n.proposalsHistory > 0 && len(n.proposals) >= 2*n.proposalsHistory {
+		// prune the history once it doubles, so that the proposals are not moved on every insert
+		n.proposals = append(n.proposals[:0], n.proposals[len(n.proposals)-n.proposalsHistory:]...)
+	}
+}
+
+// getProposals returns the proposals inserted by the node, only the retained ones if the history is pruned
+func (n *node) getProposals() []*pbft.SealedProposal {
+	n.proposalsLock.Lock()
+	defer n.proposalsLock.Unlock()
+
+	proposals := n.proposals
+	if n.proposalsHistory > 0 && len(proposals) > n.proposalsHistory {
+		proposals = proposals[len(proposals)-n.proposalsHistory:]
+	}
+	return append([]*pbft.SealedProposal{}, proposals...)
+}
+
 // setFaultyNode sets flag indicating that the node should be faulty or not
 // 0 is for not being faulty
 func (n *node) setFaultyNode(b bool) {
@@ -495,6 +641,11 @@ func (n *node) PushMessageInternal(message *pbft.MessageReq) {
 	n.pbft.PushMessageInternal(message)
 }
 
+// ForceTimeout makes the node time out its current wait, as if the round timeout had expired
+func (n *node) ForceTimeout() {
+	n.pbft.ForceTimeout()
+}
+
 func (n *node) Start() {
 	if n.IsRunning() {
 		panic(fmt.Errorf("node '%s' is already started", n))
@@ -509,8 +660,10 @@ func (n *node) Start() {
 			atomic.StoreUint64(&n.running, 0)
 		}()
 	SYNC:
-		_, syncIndex := n.c.syncWithNetwork(n.name)
-		n.setSyncIndex(syncIndex)
+		if !n.peerSync {
+			_, syncIndex := n.c.syncWithNetwork(n.name)
+			n.setSyncIndex(syncIndex)
+		}
 		for {
 			fsm := n.c.createBackend()
 			fsm.SetBackendData(n)
@@ -529,11 +682,18 @@ func (n *node) Start() {
 			switch n.pbft.GetState() {
 			case pbft.SyncState:
 				// we need to go back to sync
+				atomic.AddUint64(&n.syncs, 1)
+				if !fsm.ValidatorSet().Includes(pbft.NodeID(n.name)) {
+					// not a validator at this height, let the network make progress before syncing again
+					select {
+					case <-time.After(100 * time.Millisecond):
+					case <-ctx.Done():
+						return
+					}
+				}
 				goto SYNC
 			case pbft.DoneState:
-				// everything worked, move to the next iteration
-				currentSyncIndex := n.getSyncIndex()
-				n.setSyncIndex(currentSyncIndex + 1)
+				// everything worked, the node moved to the next iteration when it inserted the proposal
 			default:
 				// stopped
 				return
@@ -552,6 +712,30 @@ func (n *node) Stop() {
 	}
 }
 
+// getSyncCount returns the number of times the node went back to sync
+func (n *node) getSyncCount() uint64 {
+	return atomic.LoadUint64(&n.syncs)
+}
+
+// getRoundChangeCount returns the number of times the node moved to a new round
+func (n *node) getRoundChangeCount() uint64 {
+	return atomic.LoadUint64(&n.roundChanges)
+}
+
+// getRoundChangeTimes returns the times at which the node moved to a new round since the given time
+func (n *node) getRoundChangeTimes(since time.Time) []time.Time {
+	n.roundChangesLock.Lock()
+	defer n.roundChangesLock.Unlock()
+
+	times := []time.Time{}
+	for _, t := range n.roundChangedAt {
+		if !t.Before(since) {
+			times = append(times, t)
+		}
+	}
+	return times
+}
+
 func (n *node) IsRunning() bool {
 	return atomic.LoadUint64(&n.running) != 0
 }
@@ -597,10 +781,10 @@ func (f *Fsm) IsStuck(num uint64) (uint64, bool) {
 	return f.n.isStuck(num)
 }
 
-func (f *Fsm) BuildProposal() (*pbft.Proposal, error) {
+func (f *Fsm) BuildProposal(ctx context.Context) (*pbft.Proposal, error) {
 	proposal := &pbft.Proposal{
 		Data: GenerateProposal(),
-		Time: time.Now().Add(1 * time.Second),
+		Time: f.n.clock.Now().Add(1 * time.Second),
 	}
 	proposal.Hash = Hash(proposal.Data)
 	return proposal, nil
@@ -620,7 +804,20 @@ func (f *Fsm) Validate(proposal *pbft.Proposal) error {
 }
 
 func (f *Fsm) Insert(pp *pbft.SealedProposal) error {
-	return f.n.Insert(pp)
+	if err := f.n.Insert(pp); err != nil {
+		return err
+	}
+	// follow the node to the next height, the proposals synced from the other nodes are inserted one after another
+	f.SetBackendData(f.n)
+	return nil
+}
+
+// CommittedProposal implements pbft.CommittedHistoryBackend interface, the node has the proposals up to its height
+func (f *Fsm) CommittedProposal(height uint64) (*pbft.SealedProposal, bool) {
+	if height == 0 || height > f.n.GetNodeHeight() {
+		return nil, false
+	}
+	return f.n.c.getSealedProposal(height)
 }
 
 func (f *Fsm) ValidatorSet() pbft.ValidatorSet {
@@ -638,9 +835,9 @@ func (f *Fsm) ValidatorSet() pbft.ValidatorSet {
 // SetBackendData implements IntegrationBackend interface and sets the data needed for backend
 func (f *Fsm) SetBackendData(n *node) {
 	f.n = n
-	f.nodes = n.nodes
 	f.lastProposer = n.c.getProposer(n.getSyncIndex())
 	f.height = n.GetNodeHeight() + 1
+	f.nodes = n.c.validatorSet(f.height)
 	f.validationFails = n.isFaulty()
 }
 
@@ -650,6 +847,11 @@ func Hash(p []byte) []byte {
 	return h.Sum(nil)
 }
 
+// nameSeed derives a seed from the name of the node, the nodes get different but reproducible seeds
+func nameSeed(name string) int64 {
+	return int64(binary.BigEndian.Uint64(Hash([]byte(name))[:8]))
+}
+
 func newSealedProposal(proposalData []byte, proposer pbft.NodeID, number uint64) *pbft.SealedProposal {
 	proposal := &pbft.Proposal{
 		Data: proposalData,
@@ -670,26 +872,29 @@ func (f *Fsm) ValidateCommit(node pbft.NodeID, seal []byte) error {
 	return nil
 }
 
+// VerifyCommittedSeal checks the seal against the proposal hash (the e2e keys sign by echoing the input)
+func (f *Fsm) VerifyCommittedSeal(node pbft.NodeID, seal, hash []byte) error {
+	if !bytes.Equal(seal, hash) {
+		return fmt.Errorf("invalid committed seal from %s", node)
+	}
+	return nil
+}
+
 type valString struct {
 	nodes        []pbft.NodeID
 	lastProposer pbft.NodeID
 }
 
 func (v *valString) CalcProposer(round uint64) pbft.NodeID {
-	seed := uint64(0)
-	if v.lastProposer == pbft.NodeID("") {
-		seed = round
-	} else {
-		offset := 0
-		if indx := v.Index(v.lastProposer); indx != -1 {
-			offset = indx
-		}
-		seed = uint64(offset) + round + 1
-	}
+	return (&pbft.RoundRobinProposerSelector{}).SelectProposer(round, v.lastProposer, v.nodes)
+}
 
-	pick := seed % uint64(v.Len())
+func (v *valString) Validators() []pbft.NodeID {
+	return v.nodes
+}
 
-	return (v.nodes)[pick]
+func (v *valString) LastProposer() pbft.NodeID {
+	return v.lastProposer
 }
 
 func (v *valString) Index(addr pbft.NodeID) int {
@@ -17,18 +17,20 @@ type FuzzCommand struct {
 
 	numberOfNodes uint
 	duration      time.Duration
+	seed          int64
 }
 
 // Help implements the cli.Command interface
 func (fc *FuzzCommand) Help() string {
 	return `Command runs the fuzz runner in fuzz framework based on provided configuration (nodes count and duration).
 	
-	Usage: fuzz-run -nodes={numberOfNodes} -duration={duration}
+	Usage: fuzz-run -nodes={numberOfNodes} -duration={duration} -seed={seed}
 	
 	Options:
 	
 	-nodes - Count of initially started nodes
-	-duration - Duration of fuzz daemon running, must be longer than 1 minute (e.g., 2m, 5m, 1h, 2h)`
+	-duration - Duration of fuzz daemon running, must be longer than 1 minute (e.g., 2m, 5m, 1h, 2h)
+	-seed - Seed of the random actions and network jitter, the current time is used if it is not set`
 }
 
 // Synopsis implements the cli.Command interface
@@ -48,7 +50,11 @@ func (fc *FuzzCommand) Run(args []string) int {
 	fc.UI.Info("Starting PolyBFT fuzz runner...")
 	fc.UI.Info(fmt.Sprintf("Node count: %v\n", fc.numberOfNodes))
 	fc.UI.Info(fmt.Sprintf("Duration: %v\n", fc.duration))
-	rand.Seed(time.Now().Unix())
+	if fc.seed == 0 {
+		fc.seed = time.Now().UnixNano()
+	}
+	fc.UI.Info(fmt.Sprintf("Seed: %v\n", fc.seed))
+	rand.Seed(fc.seed)
 
 	replayMessageHandler := replay.NewReplayMessagesNotifierWithPersister()
 
@@ -73,6 +79,7 @@ func (fc *FuzzCommand) NewFlagSet() *flag.FlagSet {
 	flagSet := flag.NewFlagSet("fuzz-run", flag.ContinueOnError)
 	flagSet.UintVar(&fc.numberOfNodes, "nodes", 5, "Count of initially started nodes")
 	flagSet.DurationVar(&fc.duration, "duration", 25*time.Minute, "Duration of fuzz daemon running")
+	flagSet.Int64Var(&fc.seed, "seed", 0, "Seed of the random actions and network jitter")
 
 	return flagSet
 }
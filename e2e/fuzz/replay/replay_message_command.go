@@ -1,6 +1,7 @@
 package replay
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -148,7 +149,7 @@ type ReplayBackend struct {
 }
 
 // BuildProposal builds the next proposal. If it has a preprepare message for given height in .flow file it will take the proposal from file, otherwise it will generate a new one
-func (f *ReplayBackend) BuildProposal() (*pbft.Proposal, error) {
+func (f *ReplayBackend) BuildProposal(ctx context.Context) (*pbft.Proposal, error) {
 	var data []byte
 	sequence := f.Height()
 	if prePrepareMessage, exists := f.messageReader.prePrepareMessages[sequence]; exists && prePrepareMessage != nil {
@@ -0,0 +1,204 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0xPolygon/pbft-consensus"
+)
+
+// traceKey identifies a delivered message in the trace
+type traceKey struct {
+	from     pbft.NodeID
+	to       pbft.NodeID
+	sequence uint64
+	round    uint64
+	typ      pbft.MsgType
+}
+
+// TraceMessage is a single message delivery of a recorded trace
+type TraceMessage struct {
+	To      pbft.NodeID      `json:"to"`
+	Message *pbft.MessageReq `json:"message"`
+}
+
+// TraceLog is a serializable log of every message delivered in a cluster run
+type TraceLog struct {
+	Nodes    []string        `json:"nodes"`
+	Messages []*TraceMessage `json:"messages"`
+}
+
+// Save writes the trace log to w as JSON
+func (l *TraceLog) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(l)
+}
+
+// LoadTraceLog reads a trace log written by Save
+func LoadTraceLog(r io.Reader) (*TraceLog, error) {
+	var l TraceLog
+	if err := json.NewDecoder(r).Decode(&l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// proposal returns the proposal the proposer sent for the sequence, if any
+func (l *TraceLog) proposal(sequence uint64, proposer pbft.NodeID) ([]byte, bool) {
+	for _, m := range l.Messages {
+		msg := m.Message
+		if msg.Type == pbft.MessageReq_Preprepare && msg.From == proposer && msg.View.Sequence == sequence {
+			return msg.Proposal, true
+		}
+	}
+	return nil, false
+}
+
+// traceRecorder captures the messages delivered by the transport while it is recording.
+// Messages are keyed by (from, to, view, type), so a message is recorded once per receiver
+type traceRecorder struct {
+	lock      sync.Mutex
+	recording bool
+	keys      map[traceKey]struct{}
+	messages  []*TraceMessage
+}
+
+func newTraceRecorder() *traceRecorder {
+	return &traceRecorder{keys: map[traceKey]struct{}{}}
+}
+
+// start clears the recorded messages and starts recording
+func (r *traceRecorder) start() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.recording = true
+	r.keys = map[traceKey]struct{}{}
+	r.messages = nil
+}
+
+// stop stops recording and returns the recorded messages in the order they were delivered
+func (r *traceRecorder) stop() []*TraceMessage {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.recording = false
+	return r.messages
+}
+
+// record records the delivery of the message to the node
+func (r *traceRecorder) record(to pbft.NodeID, msg *pbft.MessageReq) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.recording {
+		return
+	}
+	k := traceKey{from: msg.From, to: to, sequence: msg.View.Sequence, round: msg.View.Round, typ: msg.Type}
+	if _, ok := r.keys[k]; ok {
+		return
+	}
+	r.keys[k] = struct{}{}
+	r.messages = append(r.messages, &TraceMessage{To: to, Message: msg.Copy()})
+}
+
+// StartRecording starts recording every message delivered in the cluster
+func (c *Cluster) StartRecording() {
+	c.transport.recorder.start()
+}
+
+// StopRecording stops recording and returns the trace recorded since StartRecording
+func (c *Cluster) StopRecording() *TraceLog {
+	names := make([]string, 0, len(c.nodes))
+	for name := range c.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return &TraceLog{
+		Nodes:    names,
+		Messages: c.transport.recorder.stop(),
+	}
+}
+
+// Replay starts the cluster and replays the trace against it. The nodes do not gossip to each other,
+// instead every node gets the messages it received in the recorded run and the proposers propose
+// the recorded proposals. The cluster has to be a fresh one, with the same nodes as the recorded one
+func (c *Cluster) Replay(trace *TraceLog) error {
+	if len(trace.Nodes) != len(c.nodes) {
+		return fmt.Errorf("trace has %d nodes, but the cluster has %d", len(trace.Nodes), len(c.nodes))
+	}
+	for _, name := range trace.Nodes {
+		if _, ok := c.nodes[name]; !ok {
+			return fmt.Errorf("node '%s' from the trace not found in the cluster", name)
+		}
+	}
+	for _, n := range c.nodes {
+		if n.IsRunning() {
+			return errors.New("cannot replay a trace on a running cluster")
+		}
+	}
+
+	createBackend := c.createBackend
+	c.createBackend = func() IntegrationBackend {
+		return &traceReplayBackend{IntegrationBackend: createBackend(), trace: trace}
+	}
+	c.SetHook(&replayHook{})
+
+	for _, m := range trace.Messages {
+		c.nodes[string(m.To)].PushMessageInternal(m.Message.Copy())
+	}
+	c.Start()
+	return nil
+}
+
+// traceReplayBackend is a backend which builds the proposals recorded in the trace
+type traceReplayBackend struct {
+	IntegrationBackend
+	trace    *TraceLog
+	proposer pbft.NodeID
+}
+
+// Init implements pbft.Backend interface
+func (b *traceReplayBackend) Init(info *pbft.RoundInfo) {
+	b.proposer = info.Proposer
+	b.IntegrationBackend.Init(info)
+}
+
+// BuildProposal builds the proposal the proposer sent in the recorded run, or a new one if there is none
+func (b *traceReplayBackend) BuildProposal(ctx context.Context) (*pbft.Proposal, error) {
+	data, ok := b.trace.proposal(b.Height(), b.proposer)
+	if !ok {
+		log.Printf("[WARNING] Could not find the proposal of %s for sequence %d in the trace", b.proposer, b.Height())
+		return b.IntegrationBackend.BuildProposal(ctx)
+	}
+	return &pbft.Proposal{
+		Data: data,
+		Time: time.Now(),
+		Hash: Hash(data),
+	}, nil
+}
+
+// replayHook is a transport hook which drops every gossiped message, since the replay delivers them
+type replayHook struct{}
+
+func (r *replayHook) Connects(from, to pbft.NodeID) bool {
+	return false
+}
+
+func (r *replayHook) Gossip(from, to pbft.NodeID, msg *pbft.MessageReq) bool {
+	return false
+}
+
+func (r *replayHook) Reset() {
+}
+
+func (r *replayHook) GetPartitions() map[string][]string {
+	return nil
+}
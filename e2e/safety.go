@@ -0,0 +1,54 @@
+package e2e
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/0xPolygon/pbft-consensus"
+)
+
+// AssertSafety fails the test if two nodes of the cluster inserted different proposals,
+// or the same proposal from different proposers, at the same height
+func (c *Cluster) AssertSafety() {
+	if err := c.checkSafety(); err != nil {
+		if c.t == nil {
+			panic(err)
+		}
+		c.t.Helper()
+		c.t.Fatal(err)
+	}
+}
+
+// checkSafety compares the proposals inserted by every node at every height they have in common
+func (c *Cluster) checkSafety() error {
+	names := make([]string, 0, len(c.nodes))
+	for name := range c.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	type insert struct {
+		node     string
+		proposal *pbft.SealedProposal
+	}
+	inserted := map[uint64]insert{}
+
+	for _, name := range names {
+		for _, pp := range c.nodes[name].getProposals() {
+			first, ok := inserted[pp.Number]
+			if !ok {
+				inserted[pp.Number] = insert{node: name, proposal: pp}
+				continue
+			}
+			if !bytes.Equal(first.proposal.Proposal.Data, pp.Proposal.Data) {
+				return fmt.Errorf("safety violation at height %d: nodes %s and %s inserted different proposals", pp.Number, first.node, name)
+			}
+			if first.proposal.Proposer != pp.Proposer {
+				return fmt.Errorf("safety violation at height %d: node %s inserted the proposal of %s, node %s the one of %s",
+					pp.Number, first.node, first.proposal.Proposer, name, pp.Proposer)
+			}
+		}
+	}
+	return nil
+}
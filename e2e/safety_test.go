@@ -0,0 +1,45 @@
+package e2e
+
+import (
+	"testing"
+
+	"github.com/0xPolygon/pbft-consensus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCluster_CheckSafety(t *testing.T) {
+	c := NewPBFTCluster(t, &ClusterConfig{Count: 3, Name: "safety", Prefix: "N"})
+
+	c.nodes["N_0"].recordProposal(newSealedProposal([]byte{0x1}, "N_0", 1))
+	c.nodes["N_1"].recordProposal(newSealedProposal([]byte{0x1}, "N_0", 1))
+	c.nodes["N_1"].recordProposal(newSealedProposal([]byte{0x2}, "N_1", 2))
+	c.nodes["N_2"].recordProposal(newSealedProposal([]byte{0x2}, "N_1", 2))
+	assert.NoError(t, c.checkSafety())
+	c.AssertSafety()
+
+	// the same proposal attributed to another proposer
+	c.nodes["N_2"].recordProposal(newSealedProposal([]byte{0x3}, "N_2", 3))
+	c.nodes["N_0"].recordProposal(newSealedProposal([]byte{0x3}, "N_0", 3))
+	assert.Error(t, c.checkSafety())
+}
+
+func TestCluster_CheckSafety_Fork(t *testing.T) {
+	c := NewPBFTCluster(t, &ClusterConfig{Count: 4, Name: "safety_fork", Prefix: "byz"})
+	b := c.MakeByzantine("byz_0", ByzantineBehavior{EquivocateTo: []string{"byz_1"}})
+
+	proposal := []byte{0x1, 0x2}
+	preprepare := &pbft.MessageReq{From: "byz_0", Type: pbft.MessageReq_Preprepare, View: pbft.ViewMsg(1, 0), Proposal: proposal, Hash: Hash(proposal)}
+	conflicting, ok := b.outgoing("byz_1", preprepare)
+	require.True(t, ok)
+	require.NotEqual(t, proposal, conflicting.Proposal)
+
+	// byz_1 is forked by the equivocating proposer
+	c.nodes["byz_0"].recordProposal(newSealedProposal(proposal, "byz_0", 1))
+	c.nodes["byz_1"].recordProposal(newSealedProposal(conflicting.Proposal, "byz_0", 1))
+	c.nodes["byz_2"].recordProposal(newSealedProposal(proposal, "byz_0", 1))
+
+	err := c.checkSafety()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "height 1")
+}
@@ -1,19 +1,33 @@
 package e2e
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"math/rand"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/0xPolygon/pbft-consensus"
 )
 
 type transport struct {
-	lock   sync.Mutex
-	logger *log.Logger
-	nodes  map[pbft.NodeID]transportHandler
-	hook   transportHook
+	lock     sync.Mutex
+	logger   *log.Logger
+	nodes    map[pbft.NodeID]transportHandler
+	hook     transportHook
+	recorder *traceRecorder
+
+	// byzantine are the nodes which alter the messages they gossip
+	byzantine map[pbft.NodeID]*byzantineNode
+
+	// delivered is the number of messages delivered to the nodes
+	delivered uint64
 }
 
 func (t *transport) addHook(hook transportHook) {
@@ -28,6 +42,36 @@ func (t *transport) getHook() transportHook {
 	return t.hook
 }
 
+func (t *transport) setByzantine(name pbft.NodeID, b *byzantineNode) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.byzantine == nil {
+		t.byzantine = map[pbft.NodeID]*byzantineNode{}
+	}
+	t.byzantine[name] = b
+}
+
+// outgoing returns the message the receiver actually gets, which differs from msg only if the sender
+// is byzantine or the hook mutates it. It returns false if the sender does not send anything to the receiver
+func (t *transport) outgoing(to pbft.NodeID, msg *pbft.MessageReq) (*pbft.MessageReq, bool) {
+	t.lock.Lock()
+	b, isByzantine := t.byzantine[msg.From]
+	hook := t.hook
+	t.lock.Unlock()
+
+	out := msg
+	if isByzantine {
+		var send bool
+		if out, send = b.outgoing(to, msg); !send {
+			return nil, false
+		}
+	}
+	if mutator, ok := hook.(mutatingHook); ok {
+		out = mutator.Mutate(msg.From, to, out)
+	}
+	return out, out != nil
+}
+
 type transportHandler func(pbft.NodeID, *pbft.MessageReq)
 
 func (t *transport) Register(name pbft.NodeID, handler transportHandler) {
@@ -38,26 +82,89 @@ func (t *transport) Register(name pbft.NodeID, handler transportHandler) {
 }
 
 func (t *transport) Gossip(msg *pbft.MessageReq) error {
+	if scheduler, ok := t.getHook().(deliveryScheduler); ok {
+		t.schedule(scheduler, msg)
+		return nil
+	}
+
 	for to, handler := range t.nodes {
 		if msg.From == to {
 			continue
 		}
-		go func(to pbft.NodeID, handler transportHandler) {
-			send := true
-			if hook := t.getHook(); hook != nil {
-				send = hook.Gossip(msg.From, to, msg)
-			}
-			if send {
-				handler(to, msg)
-				t.logger.Printf("[TRACE] Message sent to %s - %s", to, msg)
-			} else {
-				t.logger.Printf("[TRACE] Message not sent to %s - %s", to, msg)
-			}
-		}(to, handler)
+		go t.deliver(to, handler, msg)
 	}
 	return nil
 }
 
+// Send implements pbft.DirectTransport interface, it delivers the message only to the receiver
+func (t *transport) Send(to pbft.NodeID, msg *pbft.MessageReq) error {
+	handler, ok := t.nodes[to]
+	if !ok {
+		return fmt.Errorf("node '%s' not found", to)
+	}
+	if scheduler, ok := t.getHook().(deliveryScheduler); ok {
+		t.scheduleTo(scheduler, to, msg)
+		return nil
+	}
+	go t.deliver(to, handler, msg)
+	return nil
+}
+
+// deliver passes the message to the handler of the receiver, unless the hook or the sender drop it
+func (t *transport) deliver(to pbft.NodeID, handler transportHandler, msg *pbft.MessageReq) {
+	send := true
+	if hook := t.getHook(); hook != nil {
+		send = hook.Gossip(msg.From, to, msg)
+	}
+	out := msg
+	if send {
+		out, send = t.outgoing(to, msg)
+	}
+	if send {
+		atomic.AddUint64(&t.delivered, 1)
+		t.recorder.record(to, out)
+		handler(to, out)
+		t.logger.Printf("[TRACE] Message sent to %s - %s", to, out)
+	} else {
+		t.logger.Printf("[TRACE] Message not sent to %s - %s", to, msg)
+	}
+}
+
+// schedule hands over the deliveries of the message to the scheduler, in the order of the receivers
+func (t *transport) schedule(scheduler deliveryScheduler, msg *pbft.MessageReq) {
+	receivers := make([]pbft.NodeID, 0, len(t.nodes))
+	for to := range t.nodes {
+		if to != msg.From {
+			receivers = append(receivers, to)
+		}
+	}
+	sort.Slice(receivers, func(i, j int) bool { return receivers[i] < receivers[j] })
+
+	for _, to := range receivers {
+		t.scheduleTo(scheduler, to, msg)
+	}
+}
+
+// scheduleTo hands over the delivery of the message to the receiver to the scheduler
+func (t *transport) scheduleTo(scheduler deliveryScheduler, to pbft.NodeID, msg *pbft.MessageReq) {
+	if !t.getHook().Gossip(msg.From, to, msg) {
+		t.logger.Printf("[TRACE] Message not sent to %s - %s", to, msg)
+		return
+	}
+	out, send := t.outgoing(to, msg)
+	if !send {
+		t.logger.Printf("[TRACE] Message not sent to %s - %s", to, msg)
+		return
+	}
+	handler := t.nodes[to]
+	scheduler.Schedule(msg.From, to, out, func() {
+		atomic.AddUint64(&t.delivered, 1)
+		t.recorder.record(to, out)
+		handler(to, out)
+		t.logger.Printf("[TRACE] Message sent to %s - %s", to, out)
+	})
+}
+
 type transportHook interface {
 	Connects(from, to pbft.NodeID) bool
 	Gossip(from, to pbft.NodeID, msg *pbft.MessageReq) bool
@@ -65,6 +172,21 @@ type transportHook interface {
 	GetPartitions() map[string][]string
 }
 
+// deliveryScheduler is a transportHook which delivers the messages itself,
+// instead of the transport delivering every message in its own goroutine
+type deliveryScheduler interface {
+	transportHook
+
+	Schedule(from, to pbft.NodeID, msg *pbft.MessageReq, deliver func())
+}
+
+// mutatingHook is a transportHook which alters the messages before they are delivered
+type mutatingHook interface {
+	transportHook
+
+	Mutate(from, to pbft.NodeID, msg *pbft.MessageReq) *pbft.MessageReq
+}
+
 type sender pbft.NodeID
 type receivers []pbft.NodeID
 
@@ -78,9 +200,13 @@ type roundMetadata struct {
 type gossipHandler func(sender, receiver pbft.NodeID, msg *pbft.MessageReq) bool
 
 // Transport implementation which enables specifying custom gossiping logic
+// Callback which alters a message before it is delivered, returning nil drops the message
+type messageMutator func(msg *pbft.MessageReq) *pbft.MessageReq
+
 type genericGossipTransport struct {
-	flowMap       map[uint64]roundMetadata
-	gossipHandler gossipHandler
+	flowMap        map[uint64]roundMetadata
+	gossipHandler  gossipHandler
+	messageMutator messageMutator
 }
 
 // Initialize new generic gossip transport
@@ -100,6 +226,12 @@ func (t *genericGossipTransport) withGossipHandler(gossipHandler gossipHandler)
 	return t
 }
 
+// Function which attaches message mutator, which alters the messages before they are delivered
+func (t *genericGossipTransport) withMessageMutator(messageMutator messageMutator) *genericGossipTransport {
+	t.messageMutator = messageMutator
+	return t
+}
+
 // Function which sets message routing per round mapping
 func (t *genericGossipTransport) withFlowMap(flowMap map[uint64]roundMetadata) *genericGossipTransport {
 	t.flowMap = flowMap
@@ -138,6 +270,14 @@ func (t *genericGossipTransport) Gossip(from, to pbft.NodeID, msg *pbft.MessageR
 	return true
 }
 
+// Mutate implements mutatingHook interface. The mutator gets a copy of the message, so the sender's one is untouched
+func (t *genericGossipTransport) Mutate(from, to pbft.NodeID, msg *pbft.MessageReq) *pbft.MessageReq {
+	if t.messageMutator == nil {
+		return msg
+	}
+	return t.messageMutator(msg.Copy())
+}
+
 func (t *genericGossipTransport) Connects(from, to pbft.NodeID) bool {
 	return true
 }
@@ -153,10 +293,16 @@ func (t *genericGossipTransport) GetPartitions() map[string][]string {
 // latency transport
 type randomTransport struct {
 	jitterMax time.Duration
+	rand      *jitterSource
 }
 
 func newRandomTransport(jitterMax time.Duration) transportHook {
-	return &randomTransport{jitterMax: jitterMax}
+	return newSeededRandomTransport(jitterMax, rand.Int63())
+}
+
+// newSeededRandomTransport creates a latency transport whose jitter is drawn from a source seeded with the seed
+func newSeededRandomTransport(jitterMax time.Duration, seed int64) *randomTransport {
+	return &randomTransport{jitterMax: jitterMax, rand: newJitterSource(seed)}
 }
 
 func (r *randomTransport) Connects(from, to pbft.NodeID) bool {
@@ -166,7 +312,7 @@ func (r *randomTransport) Connects(from, to pbft.NodeID) bool {
 func (r *randomTransport) Gossip(from, to pbft.NodeID, msg *pbft.MessageReq) bool {
 	// adds random latency between the queries
 	if r.jitterMax != 0 {
-		tt := timeJitter(r.jitterMax)
+		tt := r.rand.timeJitter(r.jitterMax)
 		time.Sleep(tt)
 	}
 	return true
@@ -182,12 +328,33 @@ func (r *randomTransport) GetPartitions() map[string][]string {
 
 type partitionTransport struct {
 	jitterMax time.Duration
+	rand      *jitterSource
 	lock      sync.Mutex
 	subsets   map[string][]string
+	links     map[link]*linkConfig
+}
+
+// link is a directed connection between two nodes
+type link struct {
+	from, to pbft.NodeID
+}
+
+// linkConfig models the quality of a link
+type linkConfig struct {
+	// latency added to every message sent over the link, on top of the jitter
+	latency time.Duration
+
+	// probability in [0, 1] of a message sent over the link being dropped
+	dropRate float64
 }
 
 func newPartitionTransport(jitterMax time.Duration) *partitionTransport {
-	return &partitionTransport{jitterMax: jitterMax}
+	return newSeededPartitionTransport(jitterMax, rand.Int63())
+}
+
+// newSeededPartitionTransport creates a partition transport whose jitter and drops are drawn from a source seeded with the seed
+func newSeededPartitionTransport(jitterMax time.Duration, seed int64) *partitionTransport {
+	return &partitionTransport{jitterMax: jitterMax, rand: newJitterSource(seed)}
 }
 
 func (p *partitionTransport) isConnected(from, to pbft.NodeID) bool {
@@ -223,6 +390,7 @@ func (p *partitionTransport) Reset() {
 	defer p.lock.Unlock()
 
 	p.subsets = nil
+	p.links = nil
 }
 
 func (p *partitionTransport) GetPartitions() map[string][]string {
@@ -249,19 +417,309 @@ func (p *partitionTransport) Partition(subsets ...[]string) {
 	p.lock.Unlock()
 }
 
+// getLink returns the configuration of the link, creating it if it does not exist yet
+func (p *partitionTransport) getLink(from, to pbft.NodeID) *linkConfig {
+	if p.links == nil {
+		p.links = map[link]*linkConfig{}
+	}
+	l, ok := p.links[link{from, to}]
+	if !ok {
+		l = &linkConfig{}
+		p.links[link{from, to}] = l
+	}
+	return l
+}
+
+// SetLinkLatency sets the latency of the messages sent from one node to the other.
+// The link is directed, so the latency of the opposite direction is not changed
+func (p *partitionTransport) SetLinkLatency(from, to string, d time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.getLink(pbft.NodeID(from), pbft.NodeID(to)).latency = d
+}
+
+// SetDropRate sets the probability in [0, 1] of a message sent from one node to the other being dropped.
+// The link is directed, so the drop rate of the opposite direction is not changed
+func (p *partitionTransport) SetDropRate(from, to string, rate float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.getLink(pbft.NodeID(from), pbft.NodeID(to)).dropRate = rate
+}
+
 func (p *partitionTransport) Gossip(from, to pbft.NodeID, msg *pbft.MessageReq) bool {
 	p.lock.Lock()
 	isConnected := p.isConnected(from, to)
+	var l linkConfig
+	if cfg, ok := p.links[link{from, to}]; ok {
+		l = *cfg
+	}
 	p.lock.Unlock()
 
 	if !isConnected {
 		return false
 	}
+	if l.dropRate > 0 && p.rand.float64() < l.dropRate {
+		return false
+	}
 
-	time.Sleep(timeJitter(p.jitterMax))
+	time.Sleep(l.latency + p.rand.timeJitter(p.jitterMax))
 	return true
 }
 
-func timeJitter(jitterMax time.Duration) time.Duration {
-	return time.Duration(uint64(rand.Int63()) % uint64(jitterMax))
+// jitterSource is a seeded random source of a transport, safe for concurrent use.
+// The default transports are seeded from the global source, so seeding it makes their runs reproducible
+type jitterSource struct {
+	lock sync.Mutex
+	rand *rand.Rand
+}
+
+func newJitterSource(seed int64) *jitterSource {
+	return &jitterSource{rand: rand.New(rand.NewSource(seed))}
+}
+
+// timeJitter returns a random duration in [0, jitterMax)
+func (s *jitterSource) timeJitter(jitterMax time.Duration) time.Duration {
+	if jitterMax <= 0 {
+		return 0
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return time.Duration(uint64(s.rand.Int63()) % uint64(jitterMax))
+}
+
+// float64 returns a random number in [0, 1)
+func (s *jitterSource) float64() float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.rand.Float64()
+}
+
+// replayTimeout is the time a replay waits for the next recorded delivery before giving up on the schedule
+const replayTimeout = 5 * time.Second
+
+// scheduledDelivery is an entry of the delivery schedule
+type scheduledDelivery struct {
+	From     pbft.NodeID  `json:"from"`
+	To       pbft.NodeID  `json:"to"`
+	Type     pbft.MsgType `json:"type"`
+	Sequence uint64       `json:"sequence"`
+	Round    uint64       `json:"round"`
+	Hash     string       `json:"hash"`
+}
+
+func newScheduledDelivery(from, to pbft.NodeID, msg *pbft.MessageReq) scheduledDelivery {
+	return scheduledDelivery{
+		From:     from,
+		To:       to,
+		Type:     msg.Type,
+		Sequence: msg.View.Sequence,
+		Round:    msg.View.Round,
+		Hash:     hex.EncodeToString(msg.Hash),
+	}
+}
+
+func (s scheduledDelivery) less(o scheduledDelivery) bool {
+	if s.Sequence != o.Sequence {
+		return s.Sequence < o.Sequence
+	}
+	if s.Round != o.Round {
+		return s.Round < o.Round
+	}
+	if s.Type != o.Type {
+		return s.Type < o.Type
+	}
+	if s.From != o.From {
+		return s.From < o.From
+	}
+	if s.To != o.To {
+		return s.To < o.To
+	}
+	return s.Hash < o.Hash
+}
+
+type pendingDelivery struct {
+	entry   scheduledDelivery
+	deliver func()
+}
+
+// deterministicTransport delivers the messages one at a time from a single goroutine.
+// The next message is picked from the pending ones with a seeded random source, and every
+// delivery is recorded so that the schedule can be dumped and replayed on a later run
+type deterministicTransport struct {
+	lock      sync.Mutex
+	rand      *rand.Rand
+	pending   []*pendingDelivery
+	delivered []scheduledDelivery
+
+	// replay is the recorded schedule to follow, if any
+	replay []scheduledDelivery
+
+	notifyCh  chan struct{}
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// newDeterministicTransport creates a deterministic transport which orders the deliveries with the seed
+func newDeterministicTransport(seed int64) *deterministicTransport {
+	d := newDeterministicScheduler(seed, nil)
+	go d.run()
+	return d
+}
+
+// newReplayTransport creates a deterministic transport which follows a recorded schedule
+func newReplayTransport(schedule []scheduledDelivery) *deterministicTransport {
+	d := newDeterministicScheduler(0, schedule)
+	go d.run()
+	return d
+}
+
+func newDeterministicScheduler(seed int64, schedule []scheduledDelivery) *deterministicTransport {
+	return &deterministicTransport{
+		rand:     rand.New(rand.NewSource(seed)),
+		replay:   schedule,
+		notifyCh: make(chan struct{}, 1),
+		closeCh:  make(chan struct{}),
+	}
+}
+
+func (d *deterministicTransport) Connects(from, to pbft.NodeID) bool {
+	return true
+}
+
+func (d *deterministicTransport) Gossip(from, to pbft.NodeID, msg *pbft.MessageReq) bool {
+	return true
+}
+
+func (d *deterministicTransport) Reset() {
+	// no impl
+}
+
+func (d *deterministicTransport) GetPartitions() map[string][]string {
+	return nil
+}
+
+// Schedule implements the deliveryScheduler interface
+func (d *deterministicTransport) Schedule(from, to pbft.NodeID, msg *pbft.MessageReq, deliver func()) {
+	d.lock.Lock()
+	d.pending = append(d.pending, &pendingDelivery{entry: newScheduledDelivery(from, to, msg), deliver: deliver})
+	d.lock.Unlock()
+
+	select {
+	case d.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the deliveries
+func (d *deterministicTransport) Close() {
+	d.closeOnce.Do(func() { close(d.closeCh) })
+}
+
+func (d *deterministicTransport) run() {
+	for {
+		if d.step() {
+			continue
+		}
+		select {
+		case <-d.notifyCh:
+		case <-time.After(replayTimeout):
+			d.abandonReplay()
+		case <-d.closeCh:
+			return
+		}
+	}
+}
+
+// step delivers the next message. It returns false if there is none to deliver
+func (d *deterministicTransport) step() bool {
+	d.lock.Lock()
+	next := d.next()
+	d.lock.Unlock()
+
+	if next == nil {
+		return false
+	}
+	next.deliver()
+	return true
+}
+
+// next removes the next delivery from the pending ones and records it
+func (d *deterministicTransport) next() *pendingDelivery {
+	if len(d.pending) == 0 {
+		return nil
+	}
+
+	indx := -1
+	if pos := len(d.delivered); pos < len(d.replay) {
+		// follow the schedule, waiting for the recorded delivery if it is not pending yet
+		for i, p := range d.pending {
+			if p.entry == d.replay[pos] {
+				indx = i
+				break
+			}
+		}
+		if indx == -1 {
+			return nil
+		}
+	} else {
+		// the pending deliveries are sorted so that the pick does not depend on the order they were scheduled in
+		sort.SliceStable(d.pending, func(i, j int) bool { return d.pending[i].entry.less(d.pending[j].entry) })
+		indx = d.rand.Intn(len(d.pending))
+	}
+
+	next := d.pending[indx]
+	d.pending = append(d.pending[:indx], d.pending[indx+1:]...)
+	d.delivered = append(d.delivered, next.entry)
+	return next
+}
+
+// abandonReplay stops following the schedule if the recorded delivery does not show up,
+// since the run diverged from the recorded one
+func (d *deterministicTransport) abandonReplay() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if len(d.pending) != 0 && len(d.delivered) < len(d.replay) {
+		log.Printf("[WARNING] replay diverged from the schedule at delivery %d", len(d.delivered))
+		d.replay = nil
+	}
+}
+
+// Delivered returns the deliveries made so far
+func (d *deterministicTransport) Delivered() []scheduledDelivery {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return append([]scheduledDelivery{}, d.delivered...)
+}
+
+// DumpSchedule writes the deliveries made so far as JSON lines, they can be loaded with loadSchedule
+func (d *deterministicTransport) DumpSchedule(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, entry := range d.Delivered() {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSchedule reads a schedule written by DumpSchedule
+func loadSchedule(r io.Reader) ([]scheduledDelivery, error) {
+	schedule := []scheduledDelivery{}
+	dec := json.NewDecoder(r)
+	for {
+		var entry scheduledDelivery
+		if err := dec.Decode(&entry); err != nil {
+			if errors.Is(err, io.EOF) {
+				return schedule, nil
+			}
+			return nil, err
+		}
+		schedule = append(schedule, entry)
+	}
 }
@@ -0,0 +1,151 @@
+package e2e
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/0xPolygon/pbft-consensus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scheduleMessages schedules the deliveries of a few rounds of messages between four nodes,
+// shuffling the order they are scheduled in, and returns the order they are delivered in
+func scheduleMessages(d *deterministicTransport, shuffleSeed int64) []string {
+	type delivery struct {
+		from, to pbft.NodeID
+		msg      *pbft.MessageReq
+	}
+	nodes := []pbft.NodeID{"A", "B", "C", "D"}
+	deliveries := []delivery{}
+	for round := uint64(0); round < 2; round++ {
+		for _, typ := range []pbft.MsgType{pbft.MessageReq_Prepare, pbft.MessageReq_Commit} {
+			for _, from := range nodes {
+				msg := &pbft.MessageReq{From: from, Type: typ, View: pbft.ViewMsg(1, round), Hash: []byte{0x1}}
+				for _, to := range nodes {
+					if to != from {
+						deliveries = append(deliveries, delivery{from, to, msg})
+					}
+				}
+			}
+		}
+	}
+	rand.New(rand.NewSource(shuffleSeed)).Shuffle(len(deliveries), func(i, j int) {
+		deliveries[i], deliveries[j] = deliveries[j], deliveries[i]
+	})
+
+	order := []string{}
+	for _, dd := range deliveries {
+		dd := dd
+		d.Schedule(dd.from, dd.to, dd.msg, func() {
+			order = append(order, fmt.Sprintf("%s->%s %s %d", dd.from, dd.to, dd.msg.Type, dd.msg.View.Round))
+		})
+	}
+	for d.step() {
+	}
+	return order
+}
+
+func TestDeterministicTransport_SameSeed(t *testing.T) {
+	first := scheduleMessages(newDeterministicScheduler(42, nil), 1)
+	second := scheduleMessages(newDeterministicScheduler(42, nil), 2)
+
+	// the order does not depend on the order the messages are scheduled in
+	require.Len(t, first, 48)
+	assert.Equal(t, first, second)
+
+	// another seed makes another schedule
+	other := scheduleMessages(newDeterministicScheduler(7, nil), 1)
+	assert.NotEqual(t, first, other)
+}
+
+func TestDeterministicTransport_Replay(t *testing.T) {
+	recorder := newDeterministicScheduler(42, nil)
+	recorded := scheduleMessages(recorder, 1)
+
+	var buf bytes.Buffer
+	require.NoError(t, recorder.DumpSchedule(&buf))
+
+	schedule, err := loadSchedule(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, recorder.Delivered(), schedule)
+
+	// the replay follows the schedule, even with another seed
+	replayed := scheduleMessages(newDeterministicScheduler(7, schedule), 3)
+	assert.Equal(t, recorded, replayed)
+}
+
+func TestE2E_DeterministicTransport(t *testing.T) {
+	t.Parallel()
+	hook := newDeterministicTransport(42)
+	defer hook.Close()
+
+	config := &ClusterConfig{
+		Count:        4,
+		Name:         "deterministic",
+		Prefix:       "det",
+		RoundTimeout: GetPredefinedTimeout(2 * time.Second),
+	}
+
+	c := NewPBFTCluster(t, config, hook)
+	c.Start()
+	defer c.Stop()
+
+	err := c.WaitForHeight(3, 1*time.Minute)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, hook.Delivered())
+}
+
+func TestTransport_Send(t *testing.T) {
+	tr := &transport{logger: log.New(io.Discard, "", 0), recorder: newTraceRecorder()}
+
+	received := make(chan pbft.NodeID, 4)
+	for _, name := range []pbft.NodeID{"A", "B", "C", "D"} {
+		tr.Register(name, func(to pbft.NodeID, msg *pbft.MessageReq) {
+			received <- to
+		})
+	}
+
+	// the message is delivered only to the receiver
+	msg := &pbft.MessageReq{From: "A", Type: pbft.MessageReq_RoundChange, View: pbft.ViewMsg(1, 2)}
+	require.NoError(t, tr.Send("C", msg))
+	select {
+	case to := <-received:
+		assert.Equal(t, pbft.NodeID("C"), to)
+	case <-time.After(time.Second):
+		t.Fatal("the message was not delivered")
+	}
+	select {
+	case to := <-received:
+		t.Fatalf("the message was delivered to %s too", to)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	assert.Error(t, tr.Send("E", msg))
+}
+
+func TestTransport_SeededJitter(t *testing.T) {
+	jitters := func(seed int64) []time.Duration {
+		r := newSeededRandomTransport(50*time.Millisecond, seed)
+		p := newSeededPartitionTransport(50*time.Millisecond, seed)
+
+		res := []time.Duration{}
+		for i := 0; i < 100; i++ {
+			res = append(res, r.rand.timeJitter(r.jitterMax), p.rand.timeJitter(p.jitterMax))
+		}
+		return res
+	}
+
+	// two runs with the same seed give the same jitter
+	first := jitters(42)
+	assert.Equal(t, first, jitters(42))
+	assert.NotEqual(t, first, jitters(43))
+	for _, d := range first {
+		assert.Less(t, d, 50*time.Millisecond)
+	}
+}
@@ -0,0 +1,123 @@
+package pbft
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Equivocation is the evidence that a node sent two conflicting preprepare messages for the same view
+type Equivocation struct {
+	// First is the first preprepare message received
+	First *MessageReq
+	// Second is the preprepare message which conflicts with the first one
+	Second *MessageReq
+}
+
+// ByzantineReport is notified with the evidence of a byzantine behaviour
+type ByzantineReport func(*Equivocation)
+
+type preprepareKey struct {
+	sequence uint64
+	round    uint64
+	from     NodeID
+}
+
+func newPreprepareKey(view *View, from NodeID) preprepareKey {
+	return preprepareKey{sequence: view.Sequence, round: view.Round, from: from}
+}
+
+// maxTrackedPreprepares bounds the preprepare messages kept by the equivocationTracker
+const maxTrackedPreprepares = 256
+
+// equivocationTracker keeps the preprepare messages received per view and sender to detect equivocations
+type equivocationTracker struct {
+	lock        sync.Mutex
+	preprepares map[preprepareKey]*MessageReq
+	equivocated map[preprepareKey]struct{}
+}
+
+func newEquivocationTracker() *equivocationTracker {
+	return &equivocationTracker{
+		preprepares: map[preprepareKey]*MessageReq{},
+		equivocated: map[preprepareKey]struct{}{},
+	}
+}
+
+// track records the preprepare message. It returns the evidence if the sender already sent
+// a different proposal for the same view (only the first time it is detected).
+// Once maxTrackedPreprepares are kept, the messages for the other views are not recorded
+func (e *equivocationTracker) track(msg *MessageReq) *Equivocation {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	key := newPreprepareKey(msg.View, msg.From)
+	first, ok := e.preprepares[key]
+	if !ok {
+		if len(e.preprepares) < maxTrackedPreprepares {
+			e.preprepares[key] = msg.Copy()
+		}
+		return nil
+	}
+	if bytes.Equal(first.Hash, msg.Hash) && bytes.Equal(first.ParentHash, msg.ParentHash) && bytes.Equal(first.Proposal, msg.Proposal) {
+		return nil
+	}
+	if _, ok := e.equivocated[key]; ok {
+		return nil
+	}
+	e.equivocated[key] = struct{}{}
+
+	return &Equivocation{
+		First:  first.Copy(),
+		Second: msg.Copy(),
+	}
+}
+
+// hasEquivocated checks if the node sent conflicting preprepare messages for the view
+func (e *equivocationTracker) hasEquivocated(view *View, from NodeID) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	_, ok := e.equivocated[newPreprepareKey(view, from)]
+	return ok
+}
+
+// prune removes the messages of the sequences before the given one
+func (e *equivocationTracker) prune(sequence uint64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for key := range e.preprepares {
+		if key.sequence < sequence {
+			delete(e.preprepares, key)
+		}
+	}
+	for key := range e.equivocated {
+		if key.sequence < sequence {
+			delete(e.equivocated, key)
+		}
+	}
+}
+
+// trackPreprepare looks for an equivocation of the sender of the preprepare message. Only the preprepares of the
+// current sequence sealed by the proposer of their round are tracked, the sender of the others cannot be checked
+// against the validator set and anyone could fill the tracker with them (or frame the proposer)
+func (p *Pbft) trackPreprepare(msg *MessageReq) {
+	sequence, ok := p.state.getSequence()
+	validators := p.state.getValidators()
+	if !ok || validators == nil || msg.View.Sequence != sequence {
+		return
+	}
+	proposer := p.selectProposer(validators, msg.View.Sequence, msg.View.Round)
+	if proposer != msg.From {
+		return
+	}
+	if err := p.verifyProposerSeal(proposer, msg); err != nil {
+		return
+	}
+
+	if evidence := p.equivocations.track(msg); evidence != nil {
+		p.logger.Warn("equivocation, conflicting proposals", "from", msg.From, "sequence", msg.View.Sequence, "round", msg.View.Round)
+		p.config.ByzantineReport(evidence)
+		p.reportInvalid(msg.From)
+	}
+}
@@ -0,0 +1,171 @@
+package pbft
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEquivocationTracker(t *testing.T) {
+	e := newEquivocationTracker()
+
+	msg := &MessageReq{From: "A", Type: MessageReq_Preprepare, View: ViewMsg(1, 0), Proposal: mockProposal, Hash: digest}
+	assert.Nil(t, e.track(msg))
+
+	// the same proposal is not an equivocation
+	assert.Nil(t, e.track(msg.Copy()))
+	assert.False(t, e.hasEquivocated(ViewMsg(1, 0), "A"))
+
+	// a different proposal for another round is not an equivocation
+	other := &MessageReq{From: "A", Type: MessageReq_Preprepare, View: ViewMsg(1, 1), Proposal: mockProposal1, Hash: digest1}
+	assert.Nil(t, e.track(other))
+
+	conflict := &MessageReq{From: "A", Type: MessageReq_Preprepare, View: ViewMsg(1, 0), Proposal: mockProposal1, Hash: digest1}
+	evidence := e.track(conflict)
+	require.NotNil(t, evidence)
+	assert.Equal(t, digest, evidence.First.Hash)
+	assert.Equal(t, digest1, evidence.Second.Hash)
+	assert.True(t, e.hasEquivocated(ViewMsg(1, 0), "A"))
+	assert.False(t, e.hasEquivocated(ViewMsg(1, 1), "A"))
+
+	// the equivocation is only reported once
+	assert.Nil(t, e.track(conflict.Copy()))
+
+	e.prune(2)
+	assert.False(t, e.hasEquivocated(ViewMsg(1, 0), "A"))
+	assert.Empty(t, e.preprepares)
+
+	// the tracker is bounded
+	for round := uint64(0); round < 2*maxTrackedPreprepares; round++ {
+		e.track(&MessageReq{From: "A", Type: MessageReq_Preprepare, View: ViewMsg(2, round), Proposal: mockProposal, Hash: digest})
+	}
+	assert.Len(t, e.preprepares, maxTrackedPreprepares)
+}
+
+// Only the preprepares of the proposer of the round in the current sequence are tracked.
+func TestPbft_TrackPreprepare(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	defer m.Close()
+
+	reports := 0
+	m.config.ByzantineReport = func(*Equivocation) {
+		reports++
+	}
+
+	conflicting := func(from NodeID, view *View) {
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Preprepare, Proposal: mockProposal, Hash: digest, View: view})
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Preprepare, Proposal: mockProposal1, Hash: digest1, View: view})
+	}
+
+	// C is not the proposer of the round, so it cannot frame it either
+	conflicting("C", ViewMsg(1, 0))
+	// the sequence is not the current one
+	conflicting("A", ViewMsg(5, 0))
+	assert.Zero(t, reports)
+	assert.Empty(t, m.equivocations.preprepares)
+
+	conflicting("A", ViewMsg(1, 0))
+	assert.Equal(t, 1, reports)
+}
+
+// The conflicting preprepares are only evidence if both of them are sealed by the proposer
+func TestPbft_TrackPreprepare_ProposerSeal(t *testing.T) {
+	backend := newMockBackend([]string{"A", "B", "C", "D"}, nil).HookVerifyCommittedSealHandler(verifyTestSeal)
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B", backend)
+	defer m.Close()
+
+	reports := 0
+	m.config.ByzantineReport = func(*Equivocation) {
+		reports++
+	}
+
+	preprepare := func(proposal, hash, seal []byte) *MessageReq {
+		return &MessageReq{From: "A", Type: MessageReq_Preprepare, Proposal: proposal, Hash: hash, View: ViewMsg(1, 0), ProposerSeal: seal}
+	}
+	sealed := func(proposal, hash []byte) *MessageReq {
+		return preprepare(proposal, hash, testSeal("A", proposerSealDigest(ViewMsg(1, 0), hash, nil, proposal)))
+	}
+
+	// anyone can send a preprepare on behalf of A, without its seal it is not tracked
+	m.trackPreprepare(preprepare(mockProposal, digest, nil))
+	m.trackPreprepare(preprepare(mockProposal1, digest1, testSeal("C", proposerSealDigest(ViewMsg(1, 0), digest1, nil, mockProposal1))))
+	assert.Zero(t, reports)
+	assert.Empty(t, m.equivocations.preprepares)
+
+	m.trackPreprepare(sealed(mockProposal, digest))
+	m.trackPreprepare(preprepare(mockProposal1, digest1, nil))
+	assert.Zero(t, reports)
+
+	m.trackPreprepare(sealed(mockProposal1, digest1))
+	assert.Equal(t, 1, reports)
+}
+
+func TestTransition_AcceptState_Validator_Equivocation(t *testing.T) {
+	i := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	defer i.Close()
+	i.state.view = ViewMsg(1, 0)
+	i.setState(AcceptState)
+
+	reports := []*Equivocation{}
+	i.config.ByzantineReport = func(evidence *Equivocation) {
+		reports = append(reports, evidence)
+	}
+
+	// the proposer sends two different proposals for the same view
+	i.emitMsg(&MessageReq{
+		From:     "A",
+		Type:     MessageReq_Preprepare,
+		Proposal: mockProposal,
+		Hash:     digest,
+		View:     ViewMsg(1, 0),
+	})
+	i.emitMsg(&MessageReq{
+		From:     "A",
+		Type:     MessageReq_Preprepare,
+		Proposal: mockProposal1,
+		Hash:     digest1,
+		View:     ViewMsg(1, 0),
+	})
+
+	require.Len(t, reports, 1)
+	assert.Equal(t, mockProposal, reports[0].First.Proposal)
+	assert.Equal(t, digest, reports[0].First.Hash)
+	assert.Equal(t, mockProposal1, reports[0].Second.Proposal)
+	assert.Equal(t, digest1, reports[0].Second.Hash)
+
+	i.runCycle(context.Background())
+
+	i.expect(expectResult{
+		sequence: 1,
+		state:    RoundChangeState,
+		err:      errProposerEquivocation,
+	})
+}
+
+func TestTransition_ValidateState_Equivocation(t *testing.T) {
+	// the node accepted the first proposal and detects the equivocation while validating
+	i := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	defer i.Close()
+	i.state.view = ViewMsg(1, 0)
+	i.state.proposer = "A"
+	i.setState(ValidateState)
+
+	reported := false
+	i.config.ByzantineReport = func(*Equivocation) {
+		reported = true
+	}
+
+	i.emitMsg(&MessageReq{From: "A", Type: MessageReq_Preprepare, Proposal: mockProposal, Hash: digest, View: ViewMsg(1, 0)})
+	i.emitMsg(&MessageReq{From: "A", Type: MessageReq_Preprepare, Proposal: mockProposal1, Hash: digest1, View: ViewMsg(1, 0)})
+
+	i.runCycle(context.Background())
+
+	assert.True(t, reported)
+	i.expect(expectResult{
+		sequence: 1,
+		state:    RoundChangeState,
+		err:      errProposerEquivocation,
+	})
+}
@@ -0,0 +1,182 @@
+package pbft
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+)
+
+// SafetyViolation is the evidence that a quorum of validators committed a proposal
+// different from the one the node committed at the same height
+type SafetyViolation struct {
+	// Committed is the sealed proposal the node committed at the height
+	Committed *SealedProposal
+	// ConflictingHash is the hash of the other committed proposal
+	ConflictingHash []byte
+	// ConflictingSeals are the valid committed seals of the quorum for the other proposal, sorted by NodeID
+	ConflictingSeals []CommittedSeal
+}
+
+// SafetyViolationHandler is notified when the node detects a safety violation, once the state machine is halted
+type SafetyViolationHandler func(*SafetyViolation)
+
+// CommittedHistoryBackend is a Backend which exposes the proposals committed in the past, so that
+// the commit messages for any committed height are checked, not only for the last ones of the node
+type CommittedHistoryBackend interface {
+	Backend
+
+	// CommittedProposal returns the sealed proposal committed at the height, and false if it is unknown
+	CommittedProposal(height uint64) (*SealedProposal, bool)
+}
+
+// forkHistorySize is the number of the last proposals committed by the node the commit messages are checked against
+const forkHistorySize = 16
+
+type forkKey struct {
+	height uint64
+	hash   string
+}
+
+// forkDetector collects the valid commit messages for the already committed heights which do not match
+// the committed proposal. A quorum of them means that two different proposals were committed at the height
+type forkDetector struct {
+	lock      sync.Mutex
+	backend   Backend
+	committed map[uint64]*SealedProposal
+	seals     map[forkKey]map[NodeID]CommittedSeal
+	reported  map[uint64]struct{}
+}
+
+func newForkDetector() *forkDetector {
+	return &forkDetector{
+		committed: map[uint64]*SealedProposal{},
+		seals:     map[forkKey]map[NodeID]CommittedSeal{},
+		reported:  map[uint64]struct{}{},
+	}
+}
+
+// setBackend sets the backend the commit seals are verified with
+func (d *forkDetector) setBackend(backend Backend) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.backend = backend
+}
+
+// commit records a proposal committed by the node, and forgets the ones out of the history window
+func (d *forkDetector) commit(pp *SealedProposal) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.committed[pp.Number] = pp
+	for height := range d.committed {
+		if height+forkHistorySize <= pp.Number {
+			delete(d.committed, height)
+		}
+	}
+	for key := range d.seals {
+		if key.height+forkHistorySize <= pp.Number {
+			delete(d.seals, key)
+		}
+	}
+}
+
+// committedAt returns the proposal committed at the height. The history of the backend is only
+// looked up for the stale heights, the commit messages for the current one are not committed yet
+func (d *forkDetector) committedAt(height uint64, stale bool) *SealedProposal {
+	if pp, ok := d.committed[height]; ok {
+		return pp
+	}
+	if !stale {
+		return nil
+	}
+	if history, ok := d.backend.(CommittedHistoryBackend); ok {
+		if pp, ok := history.CommittedProposal(height); ok {
+			return pp
+		}
+	}
+	return nil
+}
+
+// lookup returns the proposal committed at the height, by the node itself or in the history of the backend
+func (d *forkDetector) lookup(height uint64) *SealedProposal {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.committedAt(height, true)
+}
+
+// check records the commit message if it is a valid vote for a proposal other than the committed one.
+// It returns the evidence once the votes for the other proposal reach a quorum of the validators
+func (d *forkDetector) check(msg *MessageReq, validators ValidatorSet, stale bool) *SafetyViolation {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	height := msg.View.Sequence
+	if _, ok := d.reported[height]; ok || d.backend == nil || validators == nil {
+		return nil
+	}
+	committed := d.committedAt(height, stale)
+	if committed == nil || bytes.Equal(committed.Proposal.Hash, msg.Hash) {
+		return nil
+	}
+	if !validators.Includes(msg.From) {
+		return nil
+	}
+	if err := d.backend.VerifyCommittedSeal(msg.From, msg.Seal, msg.Hash); err != nil {
+		return nil
+	}
+
+	key := forkKey{height: height, hash: string(msg.Hash)}
+	if d.seals[key] == nil {
+		d.seals[key] = map[NodeID]CommittedSeal{}
+	}
+	d.seals[key][msg.From] = CommittedSeal{NodeID: msg.From, Signature: msg.Seal}
+
+	if !hasValidatorsQuorum(validators, d.seals[key]) {
+		return nil
+	}
+	d.reported[height] = struct{}{}
+
+	seals := make([]CommittedSeal, 0, len(d.seals[key]))
+	for _, seal := range d.seals[key] {
+		seals = append(seals, seal)
+	}
+	sort.Slice(seals, func(i, j int) bool { return seals[i].NodeID < seals[j].NodeID })
+
+	return &SafetyViolation{
+		Committed:        committed,
+		ConflictingHash:  append([]byte{}, msg.Hash...),
+		ConflictingSeals: seals,
+	}
+}
+
+// hasValidatorsQuorum checks if the signers of the seals are a quorum of the validator set
+func hasValidatorsQuorum(validators ValidatorSet, seals map[NodeID]CommittedSeal) bool {
+	if weighted, ok := validators.(WeightedValidatorSet); ok {
+		power := uint64(0)
+		for id := range seals {
+			power += weighted.VotingPower(id)
+		}
+		return 3*power > 2*weighted.TotalVotingPower()
+	}
+	return len(seals) >= QuorumSize(validators.Len())
+}
+
+// checkSafety checks the commit message against the proposals already committed at its height.
+// If a quorum committed another proposal, the state machine is halted and the violation reported
+func (p *Pbft) checkSafety(msg *MessageReq) bool {
+	if msg.Type != MessageReq_Commit {
+		return true
+	}
+	violation := p.forks.check(msg, p.state.getValidators(), p.isStale(msg))
+	if violation == nil {
+		return true
+	}
+
+	p.logger.Error("safety violation, a quorum committed a conflicting proposal, halting",
+		"sequence", msg.View.Sequence, "committed", violation.Committed.Proposal.Hash, "conflicting", violation.ConflictingHash)
+	p.Close()
+	p.config.SafetyViolation(violation)
+	return false
+}
@@ -0,0 +1,116 @@
+package pbft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// historyBackend is a mockBackend which exposes its committed history
+type historyBackend struct {
+	*mockBackend
+
+	history map[uint64]*SealedProposal
+}
+
+func (b *historyBackend) CommittedProposal(height uint64) (*SealedProposal, bool) {
+	pp, ok := b.history[height]
+	return pp, ok
+}
+
+// emitConflictingCommits emits the commit messages of the nodes for another proposal at the sequence
+func emitConflictingCommits(m *mockPbft, sequence uint64, from ...NodeID) {
+	for _, id := range from {
+		m.emitMsg(&MessageReq{From: id, Type: MessageReq_Commit, View: ViewMsg(sequence, 0), Hash: []byte("other"), Seal: []byte(id)})
+	}
+}
+
+func TestPbft_SafetyViolation(t *testing.T) {
+	validatorIds := []string{"A", "B", "C", "D"}
+	backend := newMockBackend(validatorIds, nil).
+		HookVerifyCommittedSealHandler(func(from NodeID, seal, hash []byte) error {
+			if from == "D" && bytes.Equal(hash, []byte("other")) {
+				return errors.New("forged seal")
+			}
+			return nil
+		})
+	m := newMockPbft(t, validatorIds, "A", backend)
+	defer m.Close()
+	m.roundTimeout = func(uint64) time.Duration { return time.Hour }
+	m.setProposal(&Proposal{Data: mockProposal, Time: time.Now(), Hash: digest})
+
+	var violations []*SafetyViolation
+	m.config.SafetyViolation = func(v *SafetyViolation) {
+		violations = append(violations, v)
+	}
+
+	emitVotes(m, 1, "B", "C", "D")
+	committed, err := m.RunSequence(context.Background(), 1)
+	require.NoError(t, err)
+
+	// the commits for the committed proposal, the forged ones and the ones below the quorum are not a violation
+	emitVotes(m, 1, "B", "C")
+	emitConflictingCommits(m, 1, "B", "C", "D", "D")
+	assert.Empty(t, violations)
+
+	// a quorum commits another proposal at the committed height
+	emitConflictingCommits(m, 1, "A")
+	require.Len(t, violations, 1)
+	assert.Equal(t, committed, violations[0].Committed)
+	assert.Equal(t, []byte("other"), violations[0].ConflictingHash)
+	assert.Equal(t, []CommittedSeal{
+		{NodeID: "A", Signature: []byte("A")},
+		{NodeID: "B", Signature: []byte("B")},
+		{NodeID: "C", Signature: []byte("C")},
+	}, violations[0].ConflictingSeals)
+
+	// the violation is reported once, and the state machine is halted
+	emitConflictingCommits(m, 1, "D")
+	assert.Len(t, violations, 1)
+	_, err = m.RunSequence(context.Background(), 2)
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestPbft_SafetyViolation_CommittedHistory(t *testing.T) {
+	validatorIds := []string{"A", "B", "C", "D"}
+	m := newMockPbft(t, validatorIds, "A")
+	defer m.Close()
+	committed := &SealedProposal{Proposal: &Proposal{Data: mockProposal, Hash: digest}, Number: 5}
+	require.NoError(t, m.SetBackend(&historyBackend{
+		mockBackend: newMockBackend(validatorIds, m),
+		history:     map[uint64]*SealedProposal{5: committed},
+	}))
+	require.NoError(t, m.SetView(View{Sequence: 10}))
+
+	var violation *SafetyViolation
+	m.config.SafetyViolation = func(v *SafetyViolation) {
+		violation = v
+	}
+
+	// the height is not known to the backend
+	emitConflictingCommits(m, 4, "A", "B", "C")
+	assert.Nil(t, violation)
+
+	emitConflictingCommits(m, 5, "A", "B", "C")
+	require.NotNil(t, violation)
+	assert.Equal(t, committed, violation.Committed)
+	assert.Len(t, violation.ConflictingSeals, 3)
+}
+
+func TestForkDetector_History(t *testing.T) {
+	d := newForkDetector()
+	d.setBackend(newMockBackend([]string{"A", "B", "C", "D"}, nil))
+	for height := uint64(1); height <= forkHistorySize+1; height++ {
+		d.commit(&SealedProposal{Proposal: &Proposal{Data: mockProposal, Hash: digest}, Number: height})
+	}
+
+	// the first height is out of the history window
+	assert.Nil(t, d.committedAt(1, true))
+	assert.NotNil(t, d.committedAt(2, true))
+	assert.Len(t, d.committed, forkHistorySize)
+}
@@ -0,0 +1,56 @@
+package pbft
+
+// ProposalHasher is a Backend which hashes the proposal data. When the backend implements it,
+// the hash it computes is authoritative: the received proposals must carry it, and the votes
+// are compared against it instead of the hash announced with the proposal
+type ProposalHasher interface {
+	Backend
+
+	// Hash returns the hash of the proposal data
+	Hash(data []byte) []byte
+}
+
+// proposalHashCache keeps the hash of the last hashed proposal data, so that the hash of
+// a proposal is computed once even if it is compared against every vote of the round.
+// The data is identified by its backing array and length, a different proposal misses the cache
+type proposalHashCache struct {
+	data *byte
+	size int
+	hash []byte
+}
+
+// get returns the cached hash of the data, or hashes the data and caches it
+func (c *proposalHashCache) get(data []byte, hash func(data []byte) []byte) []byte {
+	var ptr *byte
+	if len(data) > 0 {
+		ptr = &data[0]
+	}
+	if c.hash == nil || c.data != ptr || c.size != len(data) {
+		c.data, c.size, c.hash = ptr, len(data), hash(data)
+	}
+	return c.hash
+}
+
+// reset drops the cached hash
+func (c *proposalHashCache) reset() {
+	*c = proposalHashCache{}
+}
+
+// hashProposalData returns the hash of the proposal data computed by the backend, and false if
+// the backend does not hash the proposals
+func (p *Pbft) hashProposalData(data []byte) ([]byte, bool) {
+	hasher, ok := p.backend.(ProposalHasher)
+	if !ok {
+		return nil, false
+	}
+	return p.state.proposalHashes.get(data, hasher.Hash), true
+}
+
+// proposalHash returns the hash of the current proposal. It is the hash computed by the backend
+// if it hashes the proposals, or the hash the proposal came with otherwise
+func (p *Pbft) proposalHash() []byte {
+	if hash, ok := p.hashProposalData(p.state.proposal.Data); ok {
+		return hash
+	}
+	return p.state.proposal.Hash
+}
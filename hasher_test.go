@@ -0,0 +1,178 @@
+package pbft
+
+import (
+	"context"
+	"crypto/sha1"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockHashingBackend is a mockBackend which hashes the proposals with sha1 and counts the hashes
+type mockHashingBackend struct {
+	*mockBackend
+
+	hashes int
+}
+
+func (m *mockHashingBackend) Hash(data []byte) []byte {
+	m.hashes++
+	return sha1Hash(data)
+}
+
+func sha1Hash(data []byte) []byte {
+	h := sha1.Sum(data)
+	return h[:]
+}
+
+func newHashingMock(t testing.TB, accounts []string, account string) (*mockPbft, *mockHashingBackend) {
+	m := newMockPbft(t, accounts, account)
+	backend := &mockHashingBackend{mockBackend: newMockBackend(accounts, m)}
+	require.NoError(t, m.SetBackend(backend))
+	return m, backend
+}
+
+// runHashedSequence runs a sequence where the node is the proposer and every other validator votes
+func runHashedSequence(t testing.TB, m *mockPbft, accounts []string, data []byte) {
+	hash := sha1Hash(data)
+	m.setProposal(&Proposal{Data: data, Time: time.Now(), Hash: hash})
+
+	for _, from := range accounts[1:] {
+		m.emitMsg(&MessageReq{From: NodeID(from), Type: MessageReq_Prepare, View: ViewMsg(5, 0), Hash: hash})
+		m.emitMsg(&MessageReq{From: NodeID(from), Type: MessageReq_Commit, View: ViewMsg(5, 0), Hash: hash, Seal: hash})
+	}
+
+	_, err := m.RunSequence(context.Background(), 5)
+	require.NoError(t, err)
+}
+
+func TestProposalHashCache(t *testing.T) {
+	calls := 0
+	hash := func(data []byte) []byte {
+		calls++
+		return sha1Hash(data)
+	}
+
+	var c proposalHashCache
+	data := []byte{0x1, 0x2, 0x3}
+
+	assert.Equal(t, sha1Hash(data), c.get(data, hash))
+	assert.Equal(t, sha1Hash(data), c.get(data, hash))
+	assert.Equal(t, 1, calls)
+
+	// the same content in another slice is another proposal
+	other := append([]byte{}, data...)
+	assert.Equal(t, sha1Hash(other), c.get(other, hash))
+	assert.Equal(t, 2, calls)
+
+	// a shorter slice over the same data is another proposal too
+	assert.Equal(t, sha1Hash(other[:2]), c.get(other[:2], hash))
+	assert.Equal(t, 3, calls)
+
+	assert.Equal(t, sha1Hash(nil), c.get(nil, hash))
+	assert.Equal(t, sha1Hash(nil), c.get([]byte{}, hash))
+	assert.Equal(t, 4, calls)
+
+	c.reset()
+	assert.Equal(t, sha1Hash(nil), c.get(nil, hash))
+	assert.Equal(t, 5, calls)
+}
+
+func TestPbft_ProposalHasher_HashedOncePerSequence(t *testing.T) {
+	accounts := []string{"A", "B", "C", "D"}
+	m, backend := newHashingMock(t, accounts, "A")
+	defer m.Close()
+
+	runHashedSequence(t, m, accounts, mockProposal)
+
+	assert.True(t, m.IsState(DoneState))
+	// the votes of every validator, and the own seal, are checked against the cached hash
+	assert.Equal(t, 1, backend.hashes)
+}
+
+func TestTransition_AcceptState_ProposalHashMismatch(t *testing.T) {
+	m, backend := newHashingMock(t, []string{"A", "B", "C"}, "B")
+	m.state.view = ViewMsg(1, 0)
+	m.setState(AcceptState)
+
+	m.emitMsg(&MessageReq{
+		From:     "A",
+		Type:     MessageReq_Preprepare,
+		Proposal: mockProposal,
+		Hash:     digest,
+		View:     ViewMsg(1, 0),
+	})
+
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence: 1,
+		state:    RoundChangeState,
+	})
+	assert.Equal(t, 1, backend.hashes)
+}
+
+func TestTransition_AcceptState_ProposalHashInvalidated(t *testing.T) {
+	m, backend := newHashingMock(t, []string{"A", "B", "C"}, "C")
+
+	accept := func(round uint64, data []byte) *MessageReq {
+		m.respMsg = nil
+		m.state.view = ViewMsg(1, round)
+		m.setState(AcceptState)
+
+		m.emitMsg(&MessageReq{
+			From:     m.state.validators.CalcProposer(round),
+			Type:     MessageReq_Preprepare,
+			Proposal: data,
+			Hash:     sha1Hash(data),
+			View:     ViewMsg(1, round),
+		})
+		m.runCycle(context.Background())
+
+		require.True(t, m.IsState(ValidateState))
+		require.Len(t, m.respMsg, 1)
+		return m.respMsg[0]
+	}
+
+	prepare := accept(0, mockProposal)
+	assert.Equal(t, sha1Hash(mockProposal), prepare.Hash)
+	assert.Equal(t, 1, backend.hashes)
+
+	// the proposer of the next round proposes something else, the hash of the old proposal is not reused
+	prepare = accept(1, mockProposal1)
+	assert.Equal(t, sha1Hash(mockProposal1), prepare.Hash)
+	assert.Equal(t, sha1Hash(mockProposal1), m.proposalHash())
+	assert.Equal(t, 2, backend.hashes)
+}
+
+func TestPbft_ProposalHasher_NotImplemented(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	m.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+
+	// without a hasher the hash the proposal came with is used
+	assert.Equal(t, digest, m.proposalHash())
+}
+
+// BenchmarkProposalHasher_Sequence runs a sequence of 7 validators with a large proposal. The proposal
+// is hashed once per sequence (hashes/op), instead of once for every vote and seal it is compared with
+func BenchmarkProposalHasher_Sequence(b *testing.B) {
+	accounts := []string{"A", "B", "C", "D", "E", "F", "G"}
+	data := make([]byte, 1<<20)
+
+	hashes := 0
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		m, backend := newHashingMock(b, accounts, "A")
+		b.StartTimer()
+
+		runHashedSequence(b, m, accounts, data)
+
+		b.StopTimer()
+		hashes += backend.hashes
+		m.Close()
+		b.StartTimer()
+	}
+	b.ReportMetric(float64(hashes)/float64(b.N), "hashes/op")
+}
@@ -0,0 +1,44 @@
+package pbft
+
+import "time"
+
+// HealthStatus is a snapshot of the health of the node, i.e. for the liveness probes of an orchestrator
+type HealthStatus struct {
+	// State is the current state of the state machine
+	State PbftState
+
+	// View is the current view
+	View View
+
+	// Validator is set if the node is a validator of the current sequence (and not an observer)
+	Validator bool
+
+	// SinceLastCommit is the time since the node committed the last proposal, or since it started
+	// running if it has not committed any yet. It is zero if the node never ran
+	SinceLastCommit time.Duration
+
+	// QueuedMessages is the number of messages waiting in the message queue
+	QueuedMessages int
+
+	// Stuck is set if the node has not committed within the stuck timeout (see Config.StuckTimeout)
+	Stuck bool
+}
+
+// Health returns the health of the node. It is cheap and safe to call concurrently with the state machine
+func (p *Pbft) Health() HealthStatus {
+	status := HealthStatus{
+		State:          p.getState(),
+		QueuedMessages: p.msgQueue.len(),
+	}
+	if _, ok := p.state.getSequence(); ok {
+		status.View = *p.state.getView()
+	}
+	if validators := p.state.getValidators(); validators != nil && !p.config.Observer {
+		status.Validator = validators.Includes(p.validator.NodeID())
+	}
+	if progress, ok := p.progressTime.Load().(time.Time); ok {
+		status.SinceLastCommit = p.clock.Now().Sub(progress)
+		status.Stuck = p.config.StuckTimeout > 0 && status.SinceLastCommit > p.config.StuckTimeout
+	}
+	return status
+}
@@ -0,0 +1,80 @@
+package pbft
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPbft_Health(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	clock := newManualClock()
+	clock.Advance(time.Hour)
+	m.clock = clock
+	m.config.StuckTimeout = 10 * time.Second
+	m.setProposal(&Proposal{Data: mockProposal, Time: clock.Now(), Hash: digest})
+
+	// the node never ran
+	health := m.Health()
+	assert.True(t, health.Validator)
+	assert.Zero(t, health.SinceLastCommit)
+	assert.False(t, health.Stuck)
+
+	emitVotes(m, 1, "B", "C", "D")
+	_, err := m.RunSequence(context.Background(), 1)
+	require.NoError(t, err)
+
+	clock.Advance(time.Second)
+	health = m.Health()
+	assert.Equal(t, DoneState, health.State)
+	assert.Equal(t, View{Sequence: 1}, health.View)
+	assert.True(t, health.Validator)
+	assert.Equal(t, time.Second, health.SinceLastCommit)
+	assert.False(t, health.Stuck)
+
+	// the node stalls, the messages of the next sequence pile up
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_Prepare, View: ViewMsg(2, 0)})
+	clock.Advance(10 * time.Second)
+	health = m.Health()
+	assert.Equal(t, 11*time.Second, health.SinceLastCommit)
+	assert.True(t, health.Stuck)
+	assert.GreaterOrEqual(t, health.QueuedMessages, 1)
+}
+
+func TestPbft_Health_Concurrent(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.setProposal(&Proposal{Data: mockProposal, Time: time.Now(), Hash: digest})
+
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		for {
+			select {
+			case <-stopCh:
+				return
+			default:
+				m.Health()
+			}
+		}
+	}()
+
+	emitVotes(m, 1, "B", "C", "D")
+	_, err := m.RunSequence(context.Background(), 1)
+	close(stopCh)
+	<-doneCh
+	require.NoError(t, err)
+	assert.Less(t, m.Health().SinceLastCommit, time.Second)
+}
+
+func TestPbft_Health_Observer(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.config.Observer = true
+
+	assert.False(t, m.Health().Validator)
+}
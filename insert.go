@@ -0,0 +1,73 @@
+package pbft
+
+// RetryableInsertBackend is a Backend which classifies the errors returned by Insert.
+// A retryable error (i.e. the storage is busy) makes the state machine insert the same
+// sealed proposal again, since the proposal is already committed
+type RetryableInsertBackend interface {
+	Backend
+
+	// IsRetryableInsertError checks if inserting the same sealed proposal again can succeed
+	IsRetryableInsertError(err error) bool
+}
+
+// InsertConfirmingBackend is a Backend which commits the inserted proposals asynchronously.
+// The state machine waits for the confirmation of every inserted proposal before it moves on
+// to the next sequence, so that the consensus does not run ahead of the storage
+type InsertConfirmingBackend interface {
+	Backend
+
+	// ConfirmInserted blocks until the sealed proposal inserted at the number is durable
+	ConfirmInserted(number uint64) error
+}
+
+// isRetryableInsertError checks if the backend classifies the insert error as retryable
+func (p *Pbft) isRetryableInsertError(err error) bool {
+	backend, ok := p.backend.(RetryableInsertBackend)
+	if !ok {
+		return false
+	}
+	return backend.IsRetryableInsertError(err)
+}
+
+// insertWithRetry inserts the sealed proposal. If the insert fails with a retryable error,
+// it is retried up to the configured number of times, doubling the backoff after every retry.
+// The backoff is interrupted if the context of the state machine is cancelled
+func (p *Pbft) insertWithRetry(pp *SealedProposal) error {
+	err := p.backend.Insert(pp)
+
+	backoff := p.config.InsertRetryBackoff
+	for retry := uint64(1); err != nil && retry <= p.config.InsertRetries && p.isRetryableInsertError(err); retry++ {
+		p.logger.Warn("failed to insert proposal, retrying", "sequence", pp.Number, "retry", retry, "retries", p.config.InsertRetries, "backoff", backoff, "err", err)
+
+		timer := p.clock.NewTimer(backoff)
+		select {
+		case <-timer.C():
+		case <-p.ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff *= 2
+		err = p.backend.Insert(pp)
+	}
+	return err
+}
+
+// confirmInserted waits for the backend to confirm the insert of the sealed proposal at the number,
+// if it commits asynchronously. The wait is interrupted if the context of the state machine is cancelled
+func (p *Pbft) confirmInserted(number uint64) error {
+	backend, ok := p.backend.(InsertConfirmingBackend)
+	if !ok {
+		return nil
+	}
+
+	confirmedCh := make(chan error, 1)
+	go func() {
+		confirmedCh <- backend.ConfirmInserted(number)
+	}()
+	select {
+	case err := <-confirmedCh:
+		return err
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+}
@@ -0,0 +1,319 @@
+package pbft
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errStorageBusy = errors.New("storage busy")
+
+// mockRetryableBackend is a mockBackend which fails the inserts with the given errors (in order)
+// and classifies errStorageBusy as retryable
+type mockRetryableBackend struct {
+	*mockBackend
+
+	errs    []error
+	inserts int
+}
+
+func (m *mockRetryableBackend) Insert(pp *SealedProposal) error {
+	m.inserts++
+	if len(m.errs) == 0 {
+		return nil
+	}
+	err := m.errs[0]
+	if len(m.errs) > 1 {
+		m.errs = m.errs[1:]
+	}
+	return err
+}
+
+func (m *mockRetryableBackend) IsRetryableInsertError(err error) bool {
+	return errors.Is(err, errStorageBusy)
+}
+
+func TestTransition_CommitState_InsertRetryableThenSuccess(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.config.InsertRetries = 2
+	m.config.InsertRetryBackoff = time.Millisecond
+	backend := &mockRetryableBackend{mockBackend: newMockBackend([]string{"A", "B", "C", "D"}, m), errs: []error{errStorageBusy, errStorageBusy, nil}}
+	require.NoError(t, m.SetBackend(backend))
+
+	m.state.view = ViewMsg(1, 0)
+	m.state.proposer = "A"
+	m.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+	for _, from := range []NodeID{"A", "B", "C"} {
+		m.addMessage(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+	m.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+	m.setState(CommitState)
+	defer m.Close()
+
+	m.runCycle(context.Background())
+
+	assert.Equal(t, 3, backend.inserts)
+	assert.NotNil(t, m.committed)
+	m.expect(expectResult{
+		sequence:   1,
+		state:      DoneState,
+		commitMsgs: 3,
+	})
+}
+
+func TestTransition_CommitState_InsertRetriesExhausted(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.config.InsertRetries = 2
+	m.config.InsertRetryBackoff = time.Millisecond
+	backend := &mockRetryableBackend{mockBackend: newMockBackend([]string{"A", "B", "C", "D"}, m), errs: []error{errStorageBusy}}
+	require.NoError(t, m.SetBackend(backend))
+
+	m.state.view = ViewMsg(1, 0)
+	m.state.proposer = "A"
+	m.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+	for _, from := range []NodeID{"A", "B", "C"} {
+		m.addMessage(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+	m.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+	m.setState(CommitState)
+	defer m.Close()
+
+	m.runCycle(context.Background())
+
+	// the insert is retried and the proposal stays locked since it is committed
+	assert.Equal(t, 3, backend.inserts)
+	m.expect(expectResult{
+		sequence:   1,
+		state:      RoundChangeState,
+		locked:     true,
+		err:        errFailedToInsertProposal,
+		commitMsgs: 3,
+	})
+}
+
+func TestTransition_CommitState_InsertPermanentError(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.config.InsertRetries = 2
+	m.config.InsertRetryBackoff = time.Millisecond
+	backend := &mockRetryableBackend{mockBackend: newMockBackend([]string{"A", "B", "C", "D"}, m), errs: []error{errors.New("invalid block")}}
+	require.NoError(t, m.SetBackend(backend))
+
+	m.state.view = ViewMsg(1, 0)
+	m.state.proposer = "A"
+	m.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+	for _, from := range []NodeID{"A", "B", "C"} {
+		m.addMessage(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+	m.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+	m.setState(CommitState)
+	defer m.Close()
+
+	m.runCycle(context.Background())
+
+	// the insert is not retried and the state is unlocked
+	assert.Equal(t, 1, backend.inserts)
+	m.expect(expectResult{
+		sequence:   1,
+		state:      RoundChangeState,
+		err:        errFailedToInsertProposal,
+		commitMsgs: 3,
+	})
+}
+
+func TestTransition_CommitState_InsertRetryCancelled(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.config.InsertRetries = 2
+	m.config.InsertRetryBackoff = time.Millisecond
+	backend := &mockRetryableBackend{mockBackend: newMockBackend([]string{"A", "B", "C", "D"}, m), errs: []error{errStorageBusy}}
+	require.NoError(t, m.SetBackend(backend))
+
+	m.state.view = ViewMsg(1, 0)
+	m.state.proposer = "A"
+	m.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+	for _, from := range []NodeID{"A", "B", "C"} {
+		m.addMessage(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+	m.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+	m.setState(CommitState)
+	m.config.InsertRetryBackoff = time.Hour
+
+	// closing the state machine interrupts the backoff
+	m.Close()
+	m.runCycle(context.Background())
+
+	// the backoff is interrupted and the proposal stays locked
+	assert.Equal(t, 1, backend.inserts)
+	assert.True(t, m.state.IsLocked())
+	assert.True(t, m.IsState(RoundChangeState))
+}
+
+// mockConfirmingBackend is a mockBackend which commits the inserts asynchronously,
+// the inserts are confirmed with the results sent to the confirmations channel
+type mockConfirmingBackend struct {
+	*mockBackend
+
+	confirmations chan error
+	confirmed     []uint64
+	lock          sync.Mutex
+}
+
+func (m *mockConfirmingBackend) ConfirmInserted(number uint64) error {
+	err := <-m.confirmations
+	if err == nil {
+		m.lock.Lock()
+		m.confirmed = append(m.confirmed, number)
+		m.lock.Unlock()
+	}
+	return err
+}
+
+func TestTransition_CommitState_InsertConfirmed(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	backend := &mockConfirmingBackend{mockBackend: newMockBackend([]string{"A", "B", "C", "D"}, m), confirmations: make(chan error)}
+	require.NoError(t, m.SetBackend(backend))
+
+	m.state.view = ViewMsg(1, 0)
+	m.state.proposer = "A"
+	m.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+	for _, from := range []NodeID{"A", "B", "C"} {
+		m.addMessage(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+	m.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+	m.setState(CommitState)
+	defer m.Close()
+
+	doneCh := runCycleAsync(m)
+
+	// the sequence is not completed until the insert is confirmed
+	select {
+	case <-doneCh:
+		t.Fatal("sequence completed before the insert was confirmed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.True(t, m.IsState(CommitState))
+
+	backend.confirmations <- nil
+	<-doneCh
+
+	assert.Equal(t, []uint64{1}, backend.confirmed)
+	assert.NotNil(t, m.committed)
+	m.expect(expectResult{
+		sequence:   1,
+		state:      DoneState,
+		commitMsgs: 3,
+	})
+}
+
+func TestTransition_CommitState_InsertNotConfirmed(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	backend := &mockConfirmingBackend{mockBackend: newMockBackend([]string{"A", "B", "C", "D"}, m), confirmations: make(chan error)}
+	require.NoError(t, m.SetBackend(backend))
+
+	m.state.view = ViewMsg(1, 0)
+	m.state.proposer = "A"
+	m.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+	for _, from := range []NodeID{"A", "B", "C"} {
+		m.addMessage(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+	m.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+	m.setState(CommitState)
+	defer m.Close()
+
+	doneCh := runCycleAsync(m)
+	backend.confirmations <- errors.New("storage failure")
+	<-doneCh
+
+	// the proposal stays locked since it is committed
+	assert.Nil(t, m.committed)
+	m.expect(expectResult{
+		sequence:   1,
+		state:      RoundChangeState,
+		locked:     true,
+		err:        errInsertNotConfirmed,
+		commitMsgs: 3,
+	})
+}
+
+func TestTransition_CommitState_InsertConfirmationRetried(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	backend := &mockConfirmingBackend{mockBackend: newMockBackend([]string{"A", "B", "C", "D"}, m), confirmations: make(chan error)}
+	require.NoError(t, m.SetBackend(backend))
+
+	m.state.view = ViewMsg(1, 0)
+	m.state.proposer = "A"
+	m.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+	for _, from := range []NodeID{"A", "B", "C"} {
+		m.addMessage(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+	m.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+	m.setState(CommitState)
+	defer m.Close()
+
+	inserts := 0
+	backend.insertFn = func(*SealedProposal) error {
+		inserts++
+		return nil
+	}
+
+	doneCh := runCycleAsync(m)
+	backend.confirmations <- errors.New("storage failure")
+	<-doneCh
+
+	assert.NotNil(t, m.state.unconfirmed)
+	assert.True(t, m.IsState(RoundChangeState))
+
+	// the proposal is committed again, only the confirmation of the first insert is waited for
+	m.setState(CommitState)
+	doneCh = runCycleAsync(m)
+	backend.confirmations <- nil
+	<-doneCh
+
+	assert.Equal(t, 1, inserts)
+	assert.Equal(t, []uint64{1}, backend.confirmed)
+	assert.NotNil(t, m.committed)
+	assert.True(t, m.IsState(DoneState))
+}
+
+func TestTransition_CommitState_InsertConfirmationCancelled(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	require.NoError(t, m.SetBackend(&mockConfirmingBackend{mockBackend: newMockBackend([]string{"A", "B", "C", "D"}, m), confirmations: make(chan error)}))
+
+	m.state.view = ViewMsg(1, 0)
+	m.state.proposer = "A"
+	m.state.proposal = &Proposal{Data: mockProposal, Hash: digest}
+	for _, from := range []NodeID{"A", "B", "C"} {
+		m.addMessage(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+	m.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+	m.setState(CommitState)
+
+	doneCh := runCycleAsync(m)
+	m.Close()
+
+	select {
+	case <-doneCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the wait for the confirmation was not interrupted")
+	}
+	assert.Nil(t, m.committed)
+	assert.True(t, m.state.IsLocked())
+	assert.True(t, m.IsState(CommitState))
+}
+
+func TestPbft_InsertRetry_Config(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A")
+
+	p := New(pool.get("A"), &mockPbft{})
+	assert.Equal(t, uint64(defaultInsertRetries), p.config.InsertRetries)
+	assert.Equal(t, defaultInsertRetryBackoff, p.config.InsertRetryBackoff)
+
+	p = New(pool.get("A"), &mockPbft{}, WithInsertRetry(5, time.Second))
+	assert.Equal(t, uint64(5), p.config.InsertRetries)
+	assert.Equal(t, time.Second, p.config.InsertRetryBackoff)
+}
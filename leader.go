@@ -0,0 +1,127 @@
+package pbft
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+// errInvalidVotes is returned if the votes aggregated into a message cannot be relayed
+var errInvalidVotes = errors.New("invalid aggregated votes")
+
+// voteTransport returns the transport the votes are sent to the proposer with, and false
+// if the votes are gossiped (the leader aggregation is disabled or not supported by the transport)
+func (p *Pbft) voteTransport() (DirectTransport, bool) {
+	if !p.config.LeaderAggregation {
+		return nil, false
+	}
+	transport, ok := p.transport.(DirectTransport)
+	return transport, ok
+}
+
+// isAggregator checks if the node aggregates the votes of the round, that is if it is the proposer in the leader aggregation mode
+func (p *Pbft) isAggregator() bool {
+	_, ok := p.voteTransport()
+	return ok && !p.config.Observer && p.state.proposer == p.validator.NodeID()
+}
+
+// sendVote sends the prepare or commit message of the node to the proposer of the round in the leader aggregation mode.
+// The vote of the proposer itself is sent along with the aggregated votes. It returns false if the message has to be gossiped
+func (p *Pbft) sendVote(msg *MessageReq) bool {
+	if msg.Type != MessageReq_Prepare && msg.Type != MessageReq_Commit {
+		return false
+	}
+	transport, ok := p.voteTransport()
+	if !ok || p.state.proposer == NodeID("") {
+		return false
+	}
+	if p.state.proposer == p.validator.NodeID() {
+		return true
+	}
+
+	if err := transport.Send(p.state.proposer, msg); err != nil {
+		p.logger.Warn("failed to send the vote to the proposer, gossiping it", "type", msg.Type, "to", p.state.proposer, "err", err)
+		return false
+	}
+	return true
+}
+
+// broadcastVotes gossips the vote of the proposer with the votes of the other validators aggregated into it
+func (p *Pbft) broadcastVotes(msgType MsgType, votes map[NodeID]*MessageReq) {
+	var msg *MessageReq
+	if own, ok := votes[p.validator.NodeID()]; ok {
+		msg = own.Copy()
+	} else {
+		msg = p.newMessage(msgType)
+		if msgType == MessageReq_Commit {
+			seal, err := p.validator.Sign(p.proposalHash())
+			if err != nil {
+				p.logger.Error("failed to commit seal", "err", err)
+				return
+			}
+			msg.Seal = seal
+		}
+	}
+
+	msg.Votes = make([]*MessageReq, 0, len(votes))
+	for from, vote := range votes {
+		if from != p.validator.NodeID() {
+			msg.Votes = append(msg.Votes, vote.Copy())
+		}
+	}
+	sort.Slice(msg.Votes, func(i, j int) bool { return msg.Votes[i].From < msg.Votes[j].From })
+
+	p.logger.Debug("gossiping aggregated votes", "type", msgType, "votes", len(msg.Votes))
+	p.gossipWithRetry(msg)
+}
+
+// checkVotes checks the votes aggregated into the message. Only the votes of the same type and view as the message
+// can be aggregated, and they cannot be nested
+func checkVotes(msg *MessageReq) error {
+	for _, vote := range msg.Votes {
+		if vote == nil {
+			return errInvalidVotes
+		}
+		if err := vote.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidVotes, err)
+		}
+		if vote.Type != msg.Type || vote.View == nil || msg.View == nil || vote.View.Cmp(msg.View) != 0 {
+			return fmt.Errorf("%w: %s vote from %s", errInvalidVotes, vote.Type, vote.From)
+		}
+		if len(vote.Votes) > 0 {
+			return fmt.Errorf("%w: nested votes from %s", errInvalidVotes, vote.From)
+		}
+	}
+	return nil
+}
+
+// pushVotes verifies the votes relayed by the aggregator and pushes them to the message queue. They are not rate limited,
+// the message of the aggregator was. The message of the aggregator is verified already and it is held responsible
+// for the votes it relays, hence an invalid vote is reported as an invalid message of the aggregator and not of its sender
+func (p *Pbft) pushVotes(aggregator NodeID, votes []*MessageReq) {
+	for _, vote := range votes {
+		if p.blacklist.isBlacklisted(vote.From, p.clock.Now()) {
+			p.metrics.MessageDropped(vote.Type)
+			continue
+		}
+		if !p.checkSafety(vote) {
+			return
+		}
+		if p.isStale(vote) {
+			atomic.AddUint64(&p.staleMsgs, 1)
+			p.metrics.MessageDropped(vote.Type)
+			continue
+		}
+		if err := p.msgVerifier(vote); err != nil {
+			atomic.AddUint64(&p.invalidMsgs, 1)
+			p.metrics.MessageDropped(vote.Type)
+			p.logger.Error("failed to verify relayed vote, dropping it", "from", vote.From, "aggregator", aggregator, "err", err)
+			p.reportInvalid(aggregator)
+			continue
+		}
+
+		p.liveness.heard(vote.From, p.clock.Now())
+		p.PushMessageInternal(vote)
+	}
+}
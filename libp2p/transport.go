@@ -0,0 +1,123 @@
+package libp2p
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/0xPolygon/pbft-consensus"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+)
+
+// MessageHandler receives the consensus messages gossiped by the other nodes. It is implemented by *pbft.Pbft
+type MessageHandler interface {
+	PushMessage(msg *pbft.MessageReq)
+}
+
+// Libp2pTransport is a pbft.Transport which gossips the consensus messages on a libp2p gossipsub topic
+type Libp2pTransport struct {
+	host  host.Host
+	topic *pubsub.Topic
+	sub   *pubsub.Subscription
+
+	ctx      context.Context
+	cancelFn context.CancelFunc
+
+	lock      sync.Mutex
+	listening bool
+	doneCh    chan struct{}
+}
+
+// NewLibp2pTransport creates a gossipsub router on the host and joins the topic
+func NewLibp2pTransport(h host.Host, topic string) (*Libp2pTransport, error) {
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	ps, err := pubsub.NewGossipSub(ctx, h)
+	if err != nil {
+		cancelFn()
+		return nil, fmt.Errorf("failed to create gossipsub: %w", err)
+	}
+	t, err := ps.Join(topic)
+	if err != nil {
+		cancelFn()
+		return nil, fmt.Errorf("failed to join topic '%s': %w", topic, err)
+	}
+	sub, err := t.Subscribe()
+	if err != nil {
+		_ = t.Close()
+		cancelFn()
+		return nil, fmt.Errorf("failed to subscribe to topic '%s': %w", topic, err)
+	}
+
+	return &Libp2pTransport{
+		host:     h,
+		topic:    t,
+		sub:      sub,
+		ctx:      ctx,
+		cancelFn: cancelFn,
+		doneCh:   make(chan struct{}),
+	}, nil
+}
+
+// Gossip implements pbft.Transport interface
+func (l *Libp2pTransport) Gossip(msg *pbft.MessageReq) error {
+	data, err := msg.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	return l.topic.Publish(l.ctx, data)
+}
+
+// Listen passes the messages gossiped by the other nodes to the handler until the transport is closed.
+// It can only be called once
+func (l *Libp2pTransport) Listen(handler MessageHandler) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.listening {
+		return errors.New("transport is already listening")
+	}
+	l.listening = true
+
+	go l.listen(handler)
+	return nil
+}
+
+func (l *Libp2pTransport) listen(handler MessageHandler) {
+	defer close(l.doneCh)
+
+	for {
+		raw, err := l.sub.Next(l.ctx)
+		if err != nil {
+			// the transport is closed
+			return
+		}
+		if raw.ReceivedFrom == l.host.ID() {
+			// the state machine processes its own messages already
+			continue
+		}
+
+		msg := &pbft.MessageReq{}
+		if err := msg.Unmarshal(raw.Data); err != nil || msg.View == nil {
+			// not a consensus message, drop it
+			continue
+		}
+		handler.PushMessage(msg)
+	}
+}
+
+// Close leaves the topic and stops listening
+func (l *Libp2pTransport) Close() error {
+	l.cancelFn()
+	l.sub.Cancel()
+
+	l.lock.Lock()
+	listening := l.listening
+	l.lock.Unlock()
+	if listening {
+		<-l.doneCh
+	}
+	return l.topic.Close()
+}
@@ -0,0 +1,129 @@
+package libp2p
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/0xPolygon/pbft-consensus"
+	golibp2p "github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+type chanHandler chan *pbft.MessageReq
+
+func (c chanHandler) PushMessage(msg *pbft.MessageReq) {
+	c <- msg
+}
+
+func newTestHost(t *testing.T) host.Host {
+	h, err := golibp2p.New(golibp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = h.Close()
+	})
+	return h
+}
+
+func TestLibp2pTransport_Gossip(t *testing.T) {
+	topic := "pbft/test"
+
+	h1, h2 := newTestHost(t), newTestHost(t)
+	assert.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+
+	t1, err := NewLibp2pTransport(h1, topic)
+	assert.NoError(t, err)
+	defer t1.Close()
+
+	t2, err := NewLibp2pTransport(h2, topic)
+	assert.NoError(t, err)
+	defer t2.Close()
+
+	recv1, recv2 := make(chanHandler, 10), make(chanHandler, 10)
+	assert.NoError(t, t1.Listen(recv1))
+	assert.NoError(t, t2.Listen(recv2))
+	assert.Error(t, t2.Listen(recv2))
+
+	var _ pbft.Transport = t1
+
+	msg := &pbft.MessageReq{
+		Type:     pbft.MessageReq_Preprepare,
+		From:     "A",
+		View:     pbft.ViewMsg(1, 2),
+		Proposal: []byte{0x1, 0x2},
+		Hash:     []byte{0x3},
+	}
+
+	// gossipsub needs the hosts to exchange their subscriptions before a message reaches the peer,
+	// so keep publishing until it arrives
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+
+	for {
+		assert.NoError(t, t1.Gossip(msg))
+
+		select {
+		case got := <-recv2:
+			assert.Equal(t, msg.Type, got.Type)
+			assert.Equal(t, msg.From, got.From)
+			assert.Equal(t, 0, msg.View.Cmp(got.View))
+			assert.Equal(t, msg.Proposal, got.Proposal)
+			assert.Equal(t, msg.Hash, got.Hash)
+
+			// the sender does not get its own message back
+			select {
+			case <-recv1:
+				t.Fatal("own message delivered to the sender")
+			default:
+			}
+			return
+		case <-ticker.C:
+		case <-timeout:
+			t.Fatal("message not delivered")
+		}
+	}
+}
+
+func TestLibp2pTransport_DropsInvalidMessages(t *testing.T) {
+	topic := "pbft/test"
+
+	h1, h2 := newTestHost(t), newTestHost(t)
+	assert.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+
+	t1, err := NewLibp2pTransport(h1, topic)
+	assert.NoError(t, err)
+	defer t1.Close()
+
+	t2, err := NewLibp2pTransport(h2, topic)
+	assert.NoError(t, err)
+	defer t2.Close()
+
+	recv := make(chanHandler, 10)
+	assert.NoError(t, t2.Listen(recv))
+
+	valid := &pbft.MessageReq{Type: pbft.MessageReq_Prepare, From: "A", View: pbft.ViewMsg(1, 0)}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+
+	for {
+		// garbage is published first, so it reaches the peer before the valid message
+		assert.NoError(t, t1.topic.Publish(context.Background(), []byte("garbage")))
+		assert.NoError(t, t1.topic.Publish(context.Background(), []byte("{}")))
+		assert.NoError(t, t1.Gossip(valid))
+
+		select {
+		case got := <-recv:
+			assert.Equal(t, valid.Type, got.Type)
+			assert.Equal(t, valid.From, got.From)
+			return
+		case <-ticker.C:
+		case <-timeout:
+			t.Fatal("message not delivered")
+		}
+	}
+}
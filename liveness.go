@@ -0,0 +1,77 @@
+package pbft
+
+import (
+	"sync"
+	"time"
+)
+
+// livenessTracker records when the node last heard a valid message from each of the validators,
+// so that a node which does not hear from a quorum (i.e. on the minority side of a partition)
+// can detect it is stuck even if the heights it can see do not tell so
+type livenessTracker struct {
+	lock      sync.Mutex
+	start     time.Time
+	lastHeard map[NodeID]time.Time
+}
+
+func newLivenessTracker() *livenessTracker {
+	return &livenessTracker{lastHeard: map[NodeID]time.Time{}}
+}
+
+// begin sets the time the tracking starts at, if it was not set yet
+func (l *livenessTracker) begin(now time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.start.IsZero() {
+		l.start = now
+	}
+}
+
+// heard records a valid message from the node
+func (l *livenessTracker) heard(id NodeID, now time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if now.After(l.lastHeard[id]) {
+		l.lastHeard[id] = now
+	}
+}
+
+// heardSince returns the nodes heard from since the given time. It returns false
+// if the tracking started after that time, since the nodes might not have been heard yet
+func (l *livenessTracker) heardSince(since time.Time) (map[NodeID]struct{}, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.start.IsZero() || l.start.After(since) {
+		return nil, false
+	}
+	senders := map[NodeID]struct{}{}
+	for id, last := range l.lastHeard {
+		if !last.Before(since) {
+			senders[id] = struct{}{}
+		}
+	}
+	return senders, true
+}
+
+// isStarved checks if the node has not heard from a quorum of validators within the stuck timeout
+func (p *Pbft) isStarved() bool {
+	if p.config.StuckTimeout <= 0 {
+		return false
+	}
+
+	heard, ok := p.liveness.heardSince(p.clock.Now().Add(-p.config.StuckTimeout))
+	if !ok {
+		return false
+	}
+
+	senders := map[NodeID]struct{}{p.validator.NodeID(): {}}
+	for id := range heard {
+		if p.state.validators.Includes(id) {
+			senders[id] = struct{}{}
+		}
+	}
+	return !p.state.hasQuorum(p.state.sendersPower(senders))
+}
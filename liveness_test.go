@@ -0,0 +1,122 @@
+package pbft
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLivenessTracker(t *testing.T) {
+	l := newLivenessTracker()
+	now := time.Unix(100, 0)
+
+	// the tracking did not start yet
+	_, ok := l.heardSince(now)
+	assert.False(t, ok)
+
+	l.begin(now)
+	l.heard("A", now.Add(time.Second))
+	l.heard("B", now.Add(5*time.Second))
+	// an older message does not move the time back
+	l.heard("B", now.Add(2*time.Second))
+
+	// the tracking started after the time
+	_, ok = l.heardSince(now.Add(-time.Second))
+	assert.False(t, ok)
+
+	heard, ok := l.heardSince(now.Add(3 * time.Second))
+	assert.True(t, ok)
+	assert.Equal(t, map[NodeID]struct{}{"B": {}}, heard)
+
+	heard, _ = l.heardSince(now)
+	assert.Len(t, heard, 2)
+}
+
+func TestTransition_RoundChangeState_Starved(t *testing.T) {
+	cases := []struct {
+		name  string
+		heard []NodeID
+		state PbftState
+	}{
+		{"no messages", nil, SyncState},
+		{"messages from a minority", []NodeID{"B"}, SyncState},
+		{"messages from a non validator", []NodeID{"B", "X"}, SyncState},
+		{"messages from a quorum", []NodeID{"B", "C"}, RoundChangeState},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clock := newManualClock()
+
+			m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+			m.Close()
+			m.clock = clock
+			m.config.StuckTimeout = 10 * time.Second
+			m.liveness.begin(clock.Now())
+
+			clock.Advance(8 * time.Second)
+			for _, id := range c.heard {
+				m.liveness.heard(id, clock.Now())
+			}
+			clock.Advance(4 * time.Second)
+
+			m.setState(RoundChangeState)
+			m.runCycle(context.Background())
+
+			assert.Equal(t, c.state, m.getState())
+		})
+	}
+}
+
+func TestTransition_RoundChangeState_StarvedBeforeTimeout(t *testing.T) {
+	clock := newManualClock()
+
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.Close()
+	m.clock = clock
+	m.config.StuckTimeout = 10 * time.Second
+	m.liveness.begin(clock.Now())
+
+	// the node did not run for the stuck timeout yet
+	clock.Advance(5 * time.Second)
+
+	m.setState(RoundChangeState)
+	m.runCycle(context.Background())
+
+	m.expect(expectResult{
+		sequence: 1,
+		round:    1,
+		state:    RoundChangeState,
+		outgoing: 1,
+	})
+}
+
+func TestPbft_StuckTimeout_Disabled(t *testing.T) {
+	clock := newManualClock()
+
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.clock = clock
+	m.liveness.begin(clock.Now())
+	clock.Advance(time.Hour)
+
+	assert.False(t, m.isStarved())
+
+	m.config.StuckTimeout = time.Minute
+	assert.True(t, m.isStarved())
+}
+
+func TestPbft_PushMessage_Liveness(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.liveness.begin(time.Now().Add(-time.Hour))
+
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+	m.emitMsg(&MessageReq{From: "C", Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+
+	heard, ok := m.liveness.heardSince(time.Now().Add(-time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, map[NodeID]struct{}{"B": {}, "C": {}}, heard)
+}
@@ -0,0 +1,141 @@
+package pbft
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger is a structured logger. The key-value pairs are the fields of the entry,
+// where every key is a string followed by its value
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// StdLogger adapts a *log.Logger to the Logger interface,
+// the fields are appended to the message as key=value pairs
+type StdLogger struct {
+	logger *log.Logger
+}
+
+// NewStdLogger creates a Logger which writes to the given *log.Logger
+func NewStdLogger(logger *log.Logger) *StdLogger {
+	return &StdLogger{logger: logger}
+}
+
+// Debug implements Logger interface
+func (s *StdLogger) Debug(msg string, keyvals ...interface{}) {
+	s.print("DEBUG", msg, keyvals)
+}
+
+// Info implements Logger interface
+func (s *StdLogger) Info(msg string, keyvals ...interface{}) {
+	s.print("INFO", msg, keyvals)
+}
+
+// Warn implements Logger interface
+func (s *StdLogger) Warn(msg string, keyvals ...interface{}) {
+	s.print("WARN", msg, keyvals)
+}
+
+// Error implements Logger interface
+func (s *StdLogger) Error(msg string, keyvals ...interface{}) {
+	s.print("ERROR", msg, keyvals)
+}
+
+func (s *StdLogger) print(level, msg string, keyvals []interface{}) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s", level, msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		key, val := keyval(keyvals, i)
+		fmt.Fprintf(&b, " %s=%v", key, val)
+	}
+	s.logger.Print(b.String())
+}
+
+// JSONLogger is a Logger which writes every entry as a JSON object in its own line.
+// Besides the fields, the entries have the time, level and msg keys
+type JSONLogger struct {
+	w    io.Writer
+	lock sync.Mutex
+}
+
+// NewJSONLogger creates a JSONLogger which writes to w
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{w: w}
+}
+
+// Debug implements Logger interface
+func (j *JSONLogger) Debug(msg string, keyvals ...interface{}) {
+	j.write("debug", msg, keyvals)
+}
+
+// Info implements Logger interface
+func (j *JSONLogger) Info(msg string, keyvals ...interface{}) {
+	j.write("info", msg, keyvals)
+}
+
+// Warn implements Logger interface
+func (j *JSONLogger) Warn(msg string, keyvals ...interface{}) {
+	j.write("warn", msg, keyvals)
+}
+
+// Error implements Logger interface
+func (j *JSONLogger) Error(msg string, keyvals ...interface{}) {
+	j.write("error", msg, keyvals)
+}
+
+func (j *JSONLogger) write(level, msg string, keyvals []interface{}) {
+	entry := make(map[string]interface{}, len(keyvals)/2+3)
+	for i := 0; i < len(keyvals); i += 2 {
+		key, val := keyval(keyvals, i)
+		entry[key] = jsonValue(val)
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level
+	entry["msg"] = msg
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(map[string]interface{}{"level": "error", "msg": "failed to encode log entry", "error": err.Error()})
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	_, _ = j.w.Write(append(data, '\n'))
+}
+
+// keyval returns the key and the value of the pair starting at index i.
+// A key without value gets a nil value
+func keyval(keyvals []interface{}, i int) (string, interface{}) {
+	key := fmt.Sprint(keyvals[i])
+	if i+1 >= len(keyvals) {
+		return key, nil
+	}
+	return key, keyvals[i+1]
+}
+
+// jsonValue converts the errors and the types with a String method to strings,
+// and the values which cannot be encoded to their default format
+func jsonValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case nil:
+		return nil
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	if _, err := json.Marshal(val); err != nil {
+		return fmt.Sprintf("%v", val)
+	}
+	return val
+}
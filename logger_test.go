@@ -0,0 +1,126 @@
+package pbft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type capturedEntry struct {
+	level  string
+	msg    string
+	fields map[string]interface{}
+}
+
+// capturingLogger is a Logger which keeps the entries in memory
+type capturingLogger struct {
+	lock    sync.Mutex
+	entries []capturedEntry
+}
+
+func (c *capturingLogger) capture(level, msg string, keyvals []interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	fields := map[string]interface{}{}
+	for i := 0; i < len(keyvals); i += 2 {
+		key, val := keyval(keyvals, i)
+		fields[key] = val
+	}
+	c.entries = append(c.entries, capturedEntry{level: level, msg: msg, fields: fields})
+}
+
+func (c *capturingLogger) Debug(msg string, keyvals ...interface{}) { c.capture("debug", msg, keyvals) }
+func (c *capturingLogger) Info(msg string, keyvals ...interface{})  { c.capture("info", msg, keyvals) }
+func (c *capturingLogger) Warn(msg string, keyvals ...interface{})  { c.capture("warn", msg, keyvals) }
+func (c *capturingLogger) Error(msg string, keyvals ...interface{}) { c.capture("error", msg, keyvals) }
+
+func (c *capturingLogger) find(msg string) []capturedEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	found := []capturedEntry{}
+	for _, e := range c.entries {
+		if e.msg == msg {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func TestLogger_RoundChangeFields(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.Close()
+	logger := &capturingLogger{}
+	m.logger = logger
+
+	m.setState(RoundChangeState)
+	m.runCycle(context.Background())
+
+	entries := logger.find("local round change")
+	require.NotEmpty(t, entries)
+	assert.Equal(t, "debug", entries[0].level)
+	assert.Equal(t, uint64(1), entries[0].fields["round"])
+	assert.Equal(t, uint64(1), entries[0].fields["sequence"])
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0))
+
+	logger.Info("round change", "round", uint64(2), "state", RoundChangeState)
+	logger.Error("failed", "err", errors.New("boom"), "dangling")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal(t, []string{
+		"[INFO] round change round=2 state=RoundChangeState",
+		"[ERROR] failed err=boom dangling=<nil>",
+	}, lines)
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf)
+
+	logger.Debug("local round change", "round", uint64(3), "state", ValidateState, "err", errors.New("boom"), "from", NodeID("A"))
+	logger.Warn("no fields")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "debug", entry["level"])
+	assert.Equal(t, "local round change", entry["msg"])
+	assert.Equal(t, float64(3), entry["round"])
+	assert.Equal(t, "ValidateState", entry["state"])
+	assert.Equal(t, "boom", entry["err"])
+	assert.Equal(t, "A", entry["from"])
+	assert.NotEmpty(t, entry["time"])
+
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "warn", entry["level"])
+}
+
+func TestConfig_StructuredLogger(t *testing.T) {
+	logger := &capturingLogger{}
+
+	config := DefaultConfig()
+	config.ApplyOps(WithStructuredLogger(logger))
+	assert.Equal(t, logger, config.Logger)
+
+	// a nil logger is ignored
+	config.ApplyOps(WithStructuredLogger(nil), WithLogger(nil))
+	assert.Equal(t, logger, config.Logger)
+
+	config.ApplyOps(WithLogger(log.New(&bytes.Buffer{}, "", 0)))
+	assert.IsType(t, &StdLogger{}, config.Logger)
+}
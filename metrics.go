@@ -0,0 +1,36 @@
+package pbft
+
+import "time"
+
+// Metrics collects metrics about the progress of the PBFT state machine
+type Metrics interface {
+	// RoundChange is called when the node moves to a new round
+	RoundChange(round uint64)
+	// ProposalBuilt is called when the proposer has built a proposal, with the time it took to build it
+	ProposalBuilt(d time.Duration)
+	// SequenceCommitted is called when a sequence is committed, with the time it took to commit it
+	SequenceCommitted(seq uint64, d time.Duration)
+	// MessageDropped is called when a message is dropped without being processed
+	MessageDropped(typ MsgType)
+	// MessageQueueLatency is called when a message is read from the message queue, with the time it waited in it
+	MessageQueueLatency(typ MsgType, d time.Duration)
+}
+
+// NoopMetrics is a null object implementation of Metrics interface
+type NoopMetrics struct {
+}
+
+// RoundChange implements Metrics interface
+func (n *NoopMetrics) RoundChange(round uint64) {}
+
+// ProposalBuilt implements Metrics interface
+func (n *NoopMetrics) ProposalBuilt(d time.Duration) {}
+
+// SequenceCommitted implements Metrics interface
+func (n *NoopMetrics) SequenceCommitted(seq uint64, d time.Duration) {}
+
+// MessageDropped implements Metrics interface
+func (n *NoopMetrics) MessageDropped(typ MsgType) {}
+
+// MessageQueueLatency implements Metrics interface
+func (n *NoopMetrics) MessageQueueLatency(typ MsgType, d time.Duration) {}
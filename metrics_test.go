@@ -0,0 +1,289 @@
+package pbft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeMetrics struct {
+	lock              sync.Mutex
+	roundChanges      []uint64
+	proposalsBuilt    int
+	sequenceCommitted []uint64
+	dropped           map[MsgType]int
+	queueLatencies    map[MsgType][]time.Duration
+}
+
+func newFakeMetrics() *fakeMetrics {
+	return &fakeMetrics{dropped: map[MsgType]int{}, queueLatencies: map[MsgType][]time.Duration{}}
+}
+
+func (f *fakeMetrics) RoundChange(round uint64) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.roundChanges = append(f.roundChanges, round)
+}
+
+func (f *fakeMetrics) ProposalBuilt(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.proposalsBuilt++
+}
+
+func (f *fakeMetrics) SequenceCommitted(seq uint64, d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.sequenceCommitted = append(f.sequenceCommitted, seq)
+}
+
+func (f *fakeMetrics) MessageDropped(typ MsgType) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.dropped[typ]++
+}
+
+func (f *fakeMetrics) MessageQueueLatency(typ MsgType, d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.queueLatencies[typ] = append(f.queueLatencies[typ], d)
+}
+
+func TestMetrics_RoundChange(t *testing.T) {
+	metrics := newFakeMetrics()
+	m := newMockPbft(t, []string{"A", "B"}, "A")
+	m.metrics = metrics
+	m.Close()
+
+	m.state.err = errVerificationFailed
+	m.setState(RoundChangeState)
+	m.runCycle(context.Background())
+
+	assert.Equal(t, []uint64{1}, metrics.roundChanges)
+	assert.Empty(t, metrics.sequenceCommitted)
+}
+
+func TestMetrics_ProposalBuilt(t *testing.T) {
+	metrics := newFakeMetrics()
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.metrics = metrics
+	m.setState(AcceptState)
+	m.setProposal(&Proposal{
+		Data: mockProposal,
+		Time: time.Now(),
+	})
+
+	m.runCycle(context.Background())
+
+	assert.Equal(t, 1, metrics.proposalsBuilt)
+
+	// failing to build a proposal is not reported
+	backend := newMockBackend([]string{"A", "B", "C", "D"}, nil).HookBuildProposalHandler(func(context.Context) (*Proposal, error) {
+		return nil, errors.New("failed to build a proposal")
+	})
+	metrics = newFakeMetrics()
+	m = newMockPbft(t, []string{"A", "B", "C", "D"}, "A", backend)
+	m.metrics = metrics
+	m.setState(AcceptState)
+
+	m.runCycle(context.Background())
+
+	assert.Zero(t, metrics.proposalsBuilt)
+}
+
+func TestMetrics_SequenceCommitted(t *testing.T) {
+	commit := &MessageReq{
+		From: "A",
+		Type: MessageReq_Commit,
+		View: ViewMsg(1, 0),
+		Seal: digest,
+	}
+
+	metrics := newFakeMetrics()
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	m.metrics = metrics
+	m.state.proposer = "A"
+	m.addMessage(commit)
+	m.setState(CommitState)
+
+	m.runCycle(context.Background())
+
+	assert.True(t, m.IsState(DoneState))
+	assert.Equal(t, []uint64{1}, metrics.sequenceCommitted)
+
+	// failed inserts are not reported
+	metrics = newFakeMetrics()
+	m = newMockPbft(t, []string{"A", "B", "C"}, "A")
+	m.metrics = metrics
+	m.addMessage(commit)
+	m.setState(CommitState)
+
+	m.runCycle(context.Background())
+
+	assert.True(t, m.IsState(RoundChangeState))
+	assert.Empty(t, metrics.sequenceCommitted)
+}
+
+func TestMetrics_MessageDropped(t *testing.T) {
+	metrics := newFakeMetrics()
+	m := newMockPbft(t, []string{"A", "B"}, "A")
+	m.metrics = metrics
+	m.setState(ValidateState)
+	m.state.view = ViewMsg(1, 2)
+
+	// old messages are discarded
+	m.emitMsg(&MessageReq{
+		From: "A",
+		Type: MessageReq_Prepare,
+		View: ViewMsg(1, 1),
+	})
+	m.emitMsg(&MessageReq{
+		From: "B",
+		Type: MessageReq_Commit,
+		View: ViewMsg(1, 0),
+		Seal: digest,
+	})
+
+	// messages failing the verification are dropped
+	m.msgVerifier = func(*MessageReq) error { return errors.New("invalid message") }
+	m.emitMsg(&MessageReq{
+		From: "B",
+		Type: MessageReq_RoundChange,
+		View: ViewMsg(1, 2),
+	})
+
+	m.runCycle(context.Background())
+
+	assert.Equal(t, map[MsgType]int{
+		MessageReq_Prepare:     1,
+		MessageReq_Commit:      1,
+		MessageReq_RoundChange: 1,
+	}, metrics.dropped)
+}
+
+func TestMetrics_MessageQueueLatency(t *testing.T) {
+	clock := newManualClock()
+	metrics := newFakeMetrics()
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.metrics = metrics
+	m.msgQueue.clock = clock
+	m.setState(ValidateState)
+
+	// the prepare waits in the queue until the state machine gets to it
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+	clock.Advance(150 * time.Millisecond)
+	m.emitMsg(&MessageReq{From: "C", Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+	clock.Advance(50 * time.Millisecond)
+
+	msg, _ := m.ReadMessageWithDiscards()
+	require.NotNil(t, msg)
+	msg, _ = m.ReadMessageWithDiscards()
+	require.NotNil(t, msg)
+
+	// the messages left in the queue or discarded are not measured
+	msg, _ = m.ReadMessageWithDiscards()
+	assert.Nil(t, msg)
+
+	latencies := metrics.queueLatencies[MessageReq_Prepare]
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	assert.Equal(t, []time.Duration{50 * time.Millisecond, 200 * time.Millisecond}, latencies)
+}
+
+// promMetrics is an example of a Metrics adapter which exposes
+// the metrics in the Prometheus text exposition format
+type promMetrics struct {
+	lock     sync.Mutex
+	round    uint64
+	rounds   uint64
+	sequence uint64
+	dropped  map[MsgType]uint64
+}
+
+func (p *promMetrics) RoundChange(round uint64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.round = round
+	p.rounds++
+}
+
+func (p *promMetrics) ProposalBuilt(d time.Duration) {}
+
+func (p *promMetrics) SequenceCommitted(seq uint64, d time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.sequence = seq
+}
+
+func (p *promMetrics) MessageDropped(typ MsgType) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.dropped == nil {
+		p.dropped = map[MsgType]uint64{}
+	}
+	p.dropped[typ]++
+}
+
+func (p *promMetrics) MessageQueueLatency(typ MsgType, d time.Duration) {}
+
+func (p *promMetrics) Write() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	fmt.Fprintf(os.Stdout, "pbft_round %d\n", p.round)
+	fmt.Fprintf(os.Stdout, "pbft_round_changes_total %d\n", p.rounds)
+	fmt.Fprintf(os.Stdout, "pbft_sequence %d\n", p.sequence)
+
+	types := make([]MsgType, 0, len(p.dropped))
+	for typ := range p.dropped {
+		types = append(types, typ)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	for _, typ := range types {
+		fmt.Fprintf(os.Stdout, "pbft_messages_dropped_total{type=%q} %d\n", typ, p.dropped[typ])
+	}
+}
+
+func ExampleWithMetrics() {
+	metrics := &promMetrics{}
+	_ = WithMetrics(metrics)
+
+	// the state machine reports its progress to the adapter
+	metrics.RoundChange(1)
+	metrics.SequenceCommitted(10, time.Second)
+	metrics.MessageDropped(MessageReq_Prepare)
+	metrics.MessageDropped(MessageReq_Prepare)
+
+	metrics.Write()
+	// Output:
+	// pbft_round 1
+	// pbft_round_changes_total 1
+	// pbft_sequence 10
+	// pbft_messages_dropped_total{type="Prepare"} 2
+}
+
+func TestMetrics_MessageEvicted(t *testing.T) {
+	metrics := newFakeMetrics()
+	m := newMockPbft(t, []string{"A", "B"}, "A")
+	m.metrics = metrics
+	m.msgQueue.maxPerType = 10
+
+	// the round change messages above the limit for the furthest rounds are dropped
+	for round := uint64(1); round <= 25; round++ {
+		m.emitMsg(&MessageReq{
+			From: "B",
+			Type: MessageReq_RoundChange,
+			View: ViewMsg(1, round),
+		})
+	}
+
+	assert.Equal(t, 10, m.msgQueue.roundChangeStateQueue.Len())
+	assert.Equal(t, map[MsgType]int{MessageReq_RoundChange: 15}, metrics.dropped)
+}
@@ -2,7 +2,9 @@ package pbft
 
 import (
 	"container/heap"
+	"sort"
 	"sync"
+	"time"
 )
 
 // msgQueue defines the structure that holds message queues for different PBFT states
@@ -16,16 +18,222 @@ type msgQueue struct {
 	// Heap implementation for the validate state message queue
 	validateStateQueue msgQueueImpl
 
+	// maxPerType is the maximum number of queued messages of each type (0 means unbounded)
+	maxPerType int
+
+	// maxPerView is the maximum number of queued messages of each type and view (0 means unbounded)
+	maxPerView int
+
+	// arrivals keeps the arrival order of the queued messages to evict the oldest ones of a view first,
+	// and the time they arrived at to measure how long they wait in the queue
+	arrivals   map[*MessageReq]arrival
+	arrivalSeq uint64
+
+	// clock is the source of the arrival times
+	clock Clock
+
+	// typeCounts and viewCounts keep the number of queued messages per type and per type and view
+	typeCounts map[MsgType]int
+	viewCounts map[queueKey]int
+
+	// furthest orders the queued messages of each type by view, the furthest first, and byView keeps the
+	// queued messages of each type and view in the order they arrived in. They find the messages to evict,
+	// the messages which left the queue are skipped and dropped from them lazily
+	furthest map[MsgType]*furthestQueue
+	byView   map[queueKey][]*MessageReq
+
+	// evicted are the evicted messages which are still in the heaps, they are skipped and dropped lazily
+	evicted map[*MessageReq]struct{}
+
+	// lastRead is the type of the last message read in each state, to rotate over the valid types
+	lastRead map[PbftState]MsgType
+
 	queueLock sync.Mutex
 }
 
-// pushMessage adds a new message to a message queue
-func (m *msgQueue) pushMessage(message *MessageReq) {
+// arrival is the order and the time a message was pushed to the queue at
+type arrival struct {
+	seq uint64
+	at  time.Time
+}
+
+// minCompaction is the number of stale entries the eviction indexes of the message queue
+// tolerate before they are rebuilt, on top of the number of messages they index
+const minCompaction = 64
+
+// queueKey identifies the messages of the same type and view
+type queueKey struct {
+	typ  MsgType
+	view string
+}
+
+func newQueueKey(msg *MessageReq) queueKey {
+	return queueKey{typ: msg.Type, view: msg.View.Key()}
+}
+
+// pushMessage adds a new message to a message queue. If the queue is full, a message of the same type
+// (and view) is evicted and returned: one of the furthest view, the oldest among them, so that a flood of messages
+// for the future views cannot push out the ones for the current view. If the new message is for a view further
+// than all the queued ones, it is the one dropped and returned instead
+func (m *msgQueue) pushMessage(message *MessageReq) []*MessageReq {
 	m.queueLock.Lock()
 	defer m.queueLock.Unlock()
 
-	queue := m.getQueue(msgToState(message.Type))
-	heap.Push(queue, message)
+	key := newQueueKey(message)
+
+	evicted := []*MessageReq{}
+	if m.maxPerView > 0 && m.viewCounts[key] >= m.maxPerView {
+		if msg := m.oldestOfView(key); msg != nil {
+			m.evict(msg)
+			evicted = append(evicted, msg)
+		}
+	}
+	// the message of the same view already freed a slot, no message of another view has to go
+	if len(evicted) == 0 && m.maxPerType > 0 && m.typeCounts[message.Type] >= m.maxPerType {
+		msg := m.furthestOfType(message.Type)
+		if msg == nil || msg.View.Cmp(message.View) < 0 {
+			return append(evicted, message)
+		}
+		m.evict(msg)
+		evicted = append(evicted, msg)
+	}
+
+	heap.Push(m.getQueue(msgToState(message.Type)), message)
+	m.track(message)
+
+	return evicted
+}
+
+// oldestOfView returns the queued message of the type and view which arrived first
+func (m *msgQueue) oldestOfView(key queueKey) *MessageReq {
+	msgs := m.byView[key]
+	for len(msgs) > 0 {
+		if _, ok := m.arrivals[msgs[0]]; ok {
+			break
+		}
+		msgs = msgs[1:]
+	}
+	m.byView[key] = msgs
+	if len(msgs) == 0 {
+		return nil
+	}
+	return msgs[0]
+}
+
+// furthestOfType returns the queued message of the type for the furthest view, the oldest one if there are several
+func (m *msgQueue) furthestOfType(typ MsgType) *MessageReq {
+	queue, ok := m.furthest[typ]
+	if !ok {
+		return nil
+	}
+	for queue.Len() > 0 {
+		if _, ok := m.arrivals[(*queue)[0].msg]; ok {
+			return (*queue)[0].msg
+		}
+		heap.Pop(queue)
+	}
+	return nil
+}
+
+// evict removes a queued message. It stays in the heaps until it reaches their head, but it is not read anymore
+func (m *msgQueue) evict(msg *MessageReq) {
+	m.untrack(msg)
+	m.evicted[msg] = struct{}{}
+
+	// rebuild the heaps once most of their messages are evicted, to keep the memory bounded
+	queues := []*msgQueueImpl{&m.roundChangeStateQueue, &m.acceptStateQueue, &m.validateStateQueue}
+	size := 0
+	for _, queue := range queues {
+		size += queue.Len()
+	}
+	if 2*len(m.evicted) <= size+minCompaction {
+		return
+	}
+	for _, queue := range queues {
+		live := (*queue)[:0]
+		for _, msg := range *queue {
+			if _, ok := m.evicted[msg]; !ok {
+				live = append(live, msg)
+			}
+		}
+		for i := len(live); i < len(*queue); i++ {
+			(*queue)[i] = nil
+		}
+		*queue = live
+		heap.Init(queue)
+	}
+	m.evicted = map[*MessageReq]struct{}{}
+}
+
+// popEvicted checks if the message was evicted, it is forgotten since it is being removed from its heap
+func (m *msgQueue) popEvicted(msg *MessageReq) bool {
+	if _, ok := m.evicted[msg]; !ok {
+		return false
+	}
+	delete(m.evicted, msg)
+	return true
+}
+
+// track records a message pushed to the queue
+func (m *msgQueue) track(msg *MessageReq) {
+	m.arrivalSeq++
+	m.arrivals[msg] = arrival{seq: m.arrivalSeq, at: m.clock.Now()}
+	m.typeCounts[msg.Type]++
+
+	key := newQueueKey(msg)
+	m.viewCounts[key]++
+
+	// the messages to evict are only looked for if the queue is bounded
+	if m.maxPerView > 0 {
+		m.byView[key] = append(m.byView[key], msg)
+		if len(m.byView[key]) > 2*m.viewCounts[key]+minCompaction {
+			live := []*MessageReq{}
+			for _, msg := range m.byView[key] {
+				if _, ok := m.arrivals[msg]; ok {
+					live = append(live, msg)
+				}
+			}
+			m.byView[key] = live
+		}
+	}
+	if m.maxPerType > 0 {
+		queue, ok := m.furthest[msg.Type]
+		if !ok {
+			queue = &furthestQueue{}
+			m.furthest[msg.Type] = queue
+		}
+		heap.Push(queue, furthestItem{msg: msg, seq: m.arrivalSeq})
+		if queue.Len() > 2*m.typeCounts[msg.Type]+minCompaction {
+			live := (*queue)[:0]
+			for _, item := range *queue {
+				if _, ok := m.arrivals[item.msg]; ok {
+					live = append(live, item)
+				}
+			}
+			for i := len(live); i < len(*queue); i++ {
+				(*queue)[i] = furthestItem{}
+			}
+			*queue = live
+			heap.Init(queue)
+		}
+	}
+}
+
+// untrack removes the records of a message removed from the queue
+func (m *msgQueue) untrack(msg *MessageReq) {
+	delete(m.arrivals, msg)
+
+	m.typeCounts[msg.Type]--
+	if m.typeCounts[msg.Type] == 0 {
+		delete(m.typeCounts, msg.Type)
+	}
+
+	key := newQueueKey(msg)
+	m.viewCounts[key]--
+	if m.viewCounts[key] == 0 {
+		delete(m.viewCounts, key)
+		delete(m.byView, key)
+	}
 }
 
 // readMessage reads the message from a message queue, based on the current state and view
@@ -35,6 +243,13 @@ func (m *msgQueue) readMessage(state PbftState, current *View) *MessageReq {
 }
 
 func (m *msgQueue) readMessageWithDiscards(state PbftState, current *View) (*MessageReq, []*MessageReq) {
+	msg, _, discarded := m.readMessageWithLatency(state, current)
+	return msg, discarded
+}
+
+// readMessageWithLatency reads the message like readMessageWithDiscards, and returns as well
+// the time the message waited in the queue since it was pushed
+func (m *msgQueue) readMessageWithLatency(state PbftState, current *View) (*MessageReq, time.Duration, []*MessageReq) {
 	m.queueLock.Lock()
 	defer m.queueLock.Unlock()
 
@@ -43,9 +258,13 @@ func (m *msgQueue) readMessageWithDiscards(state PbftState, current *View) (*Mes
 
 	for {
 		if queue.Len() == 0 {
-			return nil, discarded
+			return nil, 0, discarded
 		}
 		msg := queue.head()
+		if m.popEvicted(msg) {
+			heap.Pop(queue)
+			continue
+		}
 
 		// check if the message is from the future
 		if state == RoundChangeState {
@@ -53,29 +272,100 @@ func (m *msgQueue) readMessageWithDiscards(state PbftState, current *View) (*Mes
 			// since we are interested in knowing all the possible rounds
 			if msg.View.Sequence > current.Sequence {
 				// future message
-				return nil, discarded
+				return nil, 0, discarded
 			}
 		} else {
 			// otherwise, we compare both sequence and round
-			if cmpView(msg.View, current) > 0 {
+			if msg.View.Cmp(current) > 0 {
 				// future message
-				return nil, discarded
+				return nil, 0, discarded
 			}
 		}
 
-		// at this point, 'msg' is good or old, in either case
-		// we have to remove it from the queue
-		heap.Pop(queue)
-
-		if cmpView(msg.View, current) < 0 {
-			// old value, try again
+		// at this point, 'msg' is good or old
+		if msg.View.Cmp(current) < 0 {
+			// old value, remove it and try again
+			heap.Pop(queue)
+			m.untrack(msg)
 			discarded = append(discarded, msg)
 			continue
 		}
 
-		// good value, return it
-		return msg, discarded
+		// good value, but the message of another valid type might be in turn
+		msg, latency := m.takeInTurn(state, queue, current)
+		return msg, latency, discarded
+	}
+}
+
+// takeInTurn removes and returns a message for the current view, rotating over the valid
+// message types of the state so that a backlog of one type does not starve the other ones.
+// The head of the queue has to be a message for the current view. It returns the time the message waited in the queue
+func (m *msgQueue) takeInTurn(state PbftState, queue *msgQueueImpl, current *View) (*MessageReq, time.Duration) {
+	idx := 0
+	types := stateToMsgs(state)
+	if last, ok := m.lastRead[state]; ok && len(types) > 1 {
+		next := 0
+		for i, typ := range types {
+			if typ == last {
+				next = i + 1
+				break
+			}
+		}
+		for i := 0; i < len(types); i++ {
+			typ := types[(next+i)%len(types)]
+			if typ == queue.head().Type {
+				break
+			}
+			if found := queue.find(typ, current, m.evicted); found >= 0 {
+				idx = found
+				break
+			}
+		}
+	}
+
+	msg := heap.Remove(queue, idx).(*MessageReq)
+	latency := m.clock.Now().Sub(m.arrivals[msg].at)
+	m.untrack(msg)
+	m.lastRead[state] = msg.Type
+	return msg, latency
+}
+
+// depths returns the number of queued messages of each type
+func (m *msgQueue) depths() map[MsgType]int {
+	m.queueLock.Lock()
+	defer m.queueLock.Unlock()
+
+	depths := make(map[MsgType]int, len(m.typeCounts))
+	for typ, count := range m.typeCounts {
+		depths[typ] = count
+	}
+	return depths
+}
+
+// snapshot returns copies of the queued messages in the order they arrived in
+func (m *msgQueue) snapshot() []*MessageReq {
+	m.queueLock.Lock()
+	defer m.queueLock.Unlock()
+
+	msgs := make([]*MessageReq, 0, len(m.arrivals))
+	for msg := range m.arrivals {
+		msgs = append(msgs, msg)
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return m.arrivals[msgs[i]].seq < m.arrivals[msgs[j]].seq
+	})
+	for i, msg := range msgs {
+		msgs[i] = msg.Copy()
 	}
+	return msgs
+}
+
+// len returns the number of messages in the queue
+func (m *msgQueue) len() int {
+	m.queueLock.Lock()
+	defer m.queueLock.Unlock()
+
+	return len(m.arrivals)
 }
 
 // getQueue checks the passed in state, and returns the corresponding message queue
@@ -98,6 +388,14 @@ func newMsgQueue() *msgQueue {
 		roundChangeStateQueue: msgQueueImpl{},
 		acceptStateQueue:      msgQueueImpl{},
 		validateStateQueue:    msgQueueImpl{},
+		arrivals:              map[*MessageReq]arrival{},
+		clock:                 &RealClock{},
+		typeCounts:            map[MsgType]int{},
+		viewCounts:            map[queueKey]int{},
+		furthest:              map[MsgType]*furthestQueue{},
+		byView:                map[queueKey][]*MessageReq{},
+		evicted:               map[*MessageReq]struct{}{},
+		lastRead:              map[PbftState]MsgType{},
 	}
 }
 
@@ -130,6 +428,14 @@ func stateToMsg(pbftState PbftState) MsgType {
 	}
 }
 
+// stateToMsgs returns the message types read in the state
+func stateToMsgs(pbftState PbftState) []MsgType {
+	if pbftState == ValidateState {
+		return []MsgType{MessageReq_Prepare, MessageReq_Commit}
+	}
+	return []MsgType{stateToMsg(pbftState)}
+}
+
 type msgQueueImpl []*MessageReq
 
 // head returns the head of the queue
@@ -137,6 +443,20 @@ func (m msgQueueImpl) head() *MessageReq {
 	return m[0]
 }
 
+// find returns the index of a message of the given type for the view, or -1 if there is none.
+// The skipped messages are not considered
+func (m msgQueueImpl) find(typ MsgType, view *View, skipped map[*MessageReq]struct{}) int {
+	for i, msg := range m {
+		if _, ok := skipped[msg]; ok {
+			continue
+		}
+		if msg.Type == typ && msg.View.Cmp(view) == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
 // Len returns the length of the queue
 func (m msgQueueImpl) Len() int {
 	return len(m)
@@ -145,13 +465,9 @@ func (m msgQueueImpl) Len() int {
 // Less compares the priorities of two items at the passed in indexes (A < B)
 func (m msgQueueImpl) Less(i, j int) bool {
 	ti, tj := m[i], m[j]
-	// sort by sequence
-	if ti.View.Sequence != tj.View.Sequence {
-		return ti.View.Sequence < tj.View.Sequence
-	}
-	// sort by round
-	if ti.View.Round != tj.View.Round {
-		return ti.View.Round < tj.View.Round
+	// sort by sequence and round
+	if c := ti.View.Cmp(tj.View); c != 0 {
+		return c < 0
 	}
 	// sort by message
 	return ti.Type < tj.Type
@@ -177,28 +493,45 @@ func (m *msgQueueImpl) Pop() interface{} {
 	return item
 }
 
-// cmpView compares two proto views.
-//
-// If v.Sequence == y.Sequence && v.Round == y.Round => 0
-//
-// If v.Sequence < y.Sequence => -1 ELSE => 1
-//
-// If v.Round < y.Round => -1 ELSE 1
-func cmpView(v, y *View) int {
-	if v.Sequence != y.Sequence {
-		if v.Sequence < y.Sequence {
-			return -1
-		} else {
-			return 1
-		}
-	}
-	if v.Round != y.Round {
-		if v.Round < y.Round {
-			return -1
-		} else {
-			return 1
-		}
+// furthestItem is a queued message and the order it arrived in
+type furthestItem struct {
+	msg *MessageReq
+	seq uint64
+}
+
+// furthestQueue is a heap of the queued messages, the one for the furthest view first
+// and the oldest first among the ones for the same view
+type furthestQueue []furthestItem
+
+// Len returns the length of the queue
+func (f furthestQueue) Len() int {
+	return len(f)
+}
+
+// Less compares the priorities of two items at the passed in indexes (A < B)
+func (f furthestQueue) Less(i, j int) bool {
+	if c := f[i].msg.View.Cmp(f[j].msg.View); c != 0 {
+		return c > 0
 	}
+	return f[i].seq < f[j].seq
+}
+
+// Swap swaps the places of the items at the passed-in indexes
+func (f furthestQueue) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+
+// Push adds a new item to the queue
+func (f *furthestQueue) Push(x interface{}) {
+	*f = append(*f, x.(furthestItem))
+}
 
-	return 0
+// Pop removes an item from the queue
+func (f *furthestQueue) Pop() interface{} {
+	old := *f
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = furthestItem{}
+	*f = old[0 : n-1]
+	return item
 }
@@ -1,9 +1,11 @@
 package pbft
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func mockQueueMsg(id string, msgType MsgType, view *View) *MessageReq {
@@ -74,7 +76,7 @@ func Test_msgToState(t *testing.T) {
 	}
 }
 
-func TestCmpView(t *testing.T) {
+func TestView_Cmp(t *testing.T) {
 	var cases = []struct {
 		x, y           *View
 		expectedResult int
@@ -134,9 +136,201 @@ func TestCmpView(t *testing.T) {
 			},
 			0,
 		},
+		{
+			// the sequence is compared before the round
+			&View{
+				Sequence: 1,
+				Round:    5,
+			},
+			&View{
+				Sequence: 2,
+				Round:    0,
+			},
+			-1,
+		},
+		{
+			&View{
+				Sequence: 2,
+				Round:    0,
+			},
+			&View{
+				Sequence: 1,
+				Round:    5,
+			},
+			1,
+		},
 	}
 
 	for _, c := range cases {
-		assert.Equal(t, cmpView(c.x, c.y), c.expectedResult)
+		assert.Equal(t, c.expectedResult, c.x.Cmp(c.y))
+		assert.Equal(t, -c.expectedResult, c.y.Cmp(c.x))
+	}
+}
+
+func TestView_Key(t *testing.T) {
+	assert.Equal(t, ViewMsg(1, 2).Key(), ViewMsg(1, 2).Key())
+	assert.NotEqual(t, ViewMsg(1, 2).Key(), ViewMsg(2, 1).Key())
+	assert.NotEqual(t, ViewMsg(1, 12).Key(), ViewMsg(11, 2).Key())
+	assert.NotEqual(t, ViewMsg(0, ^uint64(0)).Key(), ViewMsg(^uint64(0), 0).Key())
+}
+
+func TestMsgQueue_MaxPerType(t *testing.T) {
+	m := newMsgQueue()
+	m.maxPerType = 100
+
+	// flood the queue with round change messages for future rounds
+	evicted := []*MessageReq{}
+	for i := 0; i < 5000; i++ {
+		evicted = append(evicted, m.pushMessage(mockQueueMsg(fmt.Sprintf("%d", i), MessageReq_RoundChange, ViewMsg(2, uint64(i))))...)
+	}
+	assert.Equal(t, 100, m.len())
+	assert.Len(t, evicted, 4900)
+
+	// the messages for the furthest rounds are dropped
+	for i, msg := range evicted {
+		assert.Equal(t, NodeID(fmt.Sprintf("%d", i+100)), msg.From)
+	}
+	for _, msg := range m.roundChangeStateQueue {
+		assert.Less(t, msg.View.Round, uint64(100))
+	}
+
+	// the messages for a nearer view evict the furthest ones, the oldest first among the ones for the same view
+	assert.Equal(t, NodeID("99"), m.pushMessage(mockQueueMsg("X", MessageReq_RoundChange, ViewMsg(2, 99)))[0].From)
+	assert.Equal(t, NodeID("X"), m.pushMessage(mockQueueMsg("current", MessageReq_RoundChange, ViewMsg(1, 0)))[0].From)
+	assert.Equal(t, NodeID("98"), m.pushMessage(mockQueueMsg("Y", MessageReq_RoundChange, ViewMsg(2, 0)))[0].From)
+	assert.Equal(t, 100, m.len())
+	assert.Equal(t, NodeID("current"), m.readMessage(RoundChangeState, ViewMsg(1, 0)).From)
+
+	// other message types are not affected
+	assert.Empty(t, m.pushMessage(mockQueueMsg("A", MessageReq_Prepare, ViewMsg(2, 0))))
+	assert.Equal(t, 1, m.validateStateQueue.Len())
+}
+
+// The evicted messages are never read, and the heaps they are left in do not grow without bound.
+func TestMsgQueue_EvictedNotRead(t *testing.T) {
+	m := newMsgQueue()
+	m.maxPerType = 10
+
+	for i := 0; i < 10; i++ {
+		m.pushMessage(mockQueueMsg(fmt.Sprintf("future%d", i), MessageReq_Prepare, ViewMsg(1, 1)))
+	}
+	for i := 0; i < 1000; i++ {
+		m.pushMessage(mockQueueMsg(fmt.Sprintf("current%d", i), MessageReq_Prepare, ViewMsg(1, 0)))
+	}
+	assert.Equal(t, 10, m.len())
+	assert.Less(t, m.validateStateQueue.Len(), 2*(10+minCompaction))
+	assert.Less(t, m.furthest[MessageReq_Prepare].Len(), 2*(10+minCompaction))
+
+	// only the last messages pushed are read
+	read := 0
+	for msg := m.readMessage(ValidateState, ViewMsg(1, 0)); msg != nil; msg = m.readMessage(ValidateState, ViewMsg(1, 0)) {
+		assert.GreaterOrEqual(t, msg.From, NodeID("current"))
+		read++
+	}
+	assert.Equal(t, 10, read)
+	assert.Nil(t, m.readMessage(ValidateState, ViewMsg(1, 1)))
+	assert.Zero(t, m.len())
+}
+
+func TestMsgQueue_MaxPerView(t *testing.T) {
+	m := newMsgQueue()
+	m.maxPerView = 10
+
+	evicted := []*MessageReq{}
+	for i := 0; i < 1000; i++ {
+		evicted = append(evicted, m.pushMessage(mockQueueMsg(fmt.Sprintf("%d", i), MessageReq_Prepare, ViewMsg(1, 5)))...)
+	}
+	assert.Equal(t, 10, m.len())
+
+	// the oldest messages are evicted first
+	assert.Len(t, evicted, 990)
+	for i, msg := range evicted {
+		assert.Equal(t, NodeID(fmt.Sprintf("%d", i)), msg.From)
+	}
+
+	// the messages of other views and types are not evicted
+	m.pushMessage(mockQueueMsg("A", MessageReq_Prepare, ViewMsg(1, 6)))
+	m.pushMessage(mockQueueMsg("B", MessageReq_Commit, ViewMsg(1, 5)))
+	assert.Equal(t, 12, m.len())
+
+	// reading the queue releases the slots
+	for m.readMessage(ValidateState, ViewMsg(1, 5)) != nil {
+	}
+	assert.Equal(t, 1, m.len())
+	assert.Empty(t, m.viewCounts[queueKey{typ: MessageReq_Prepare, view: ViewMsg(1, 5).Key()}])
+
+	for i := 0; i < 10; i++ {
+		assert.Empty(t, m.pushMessage(mockQueueMsg(fmt.Sprintf("%d", i), MessageReq_Prepare, ViewMsg(1, 5))))
+	}
+}
+
+// A message over both caps evicts a single message, the oldest one of its own view.
+func TestMsgQueue_MaxPerView_Full(t *testing.T) {
+	m := newMsgQueue()
+	m.maxPerType = 3
+	m.maxPerView = 1
+
+	for i := uint64(0); i < 3; i++ {
+		assert.Empty(t, m.pushMessage(mockQueueMsg(fmt.Sprintf("%d", i), MessageReq_Prepare, ViewMsg(1, i))))
+	}
+	assert.Equal(t, 3, m.len())
+
+	evicted := m.pushMessage(mockQueueMsg("X", MessageReq_Prepare, ViewMsg(1, 0)))
+	require.Len(t, evicted, 1)
+	assert.Equal(t, NodeID("0"), evicted[0].From)
+	assert.Equal(t, 3, m.len())
+
+	// the messages of the other views are still queued
+	assert.Equal(t, NodeID("X"), m.readMessage(ValidateState, ViewMsg(1, 0)).From)
+	assert.Equal(t, NodeID("1"), m.readMessage(ValidateState, ViewMsg(1, 1)).From)
+	assert.Equal(t, NodeID("2"), m.readMessage(ValidateState, ViewMsg(1, 2)).From)
+}
+
+func TestMsgQueue_ValidateState_Fairness(t *testing.T) {
+	m := newMsgQueue()
+
+	// a backlog of commits and prepares for the current view
+	for i := 0; i < 5; i++ {
+		m.pushMessage(mockQueueMsg(fmt.Sprintf("C%d", i), MessageReq_Commit, ViewMsg(1, 0)))
+		m.pushMessage(mockQueueMsg(fmt.Sprintf("P%d", i), MessageReq_Prepare, ViewMsg(1, 0)))
+	}
+	assert.Equal(t, map[MsgType]int{MessageReq_Commit: 5, MessageReq_Prepare: 5}, m.depths())
+
+	// both types are consumed in turns
+	types := []MsgType{}
+	for i := 0; i < 4; i++ {
+		msg := m.readMessage(ValidateState, ViewMsg(1, 0))
+		assert.NotNil(t, msg)
+		types = append(types, msg.Type)
+	}
+	assert.Equal(t, []MsgType{MessageReq_Commit, MessageReq_Prepare, MessageReq_Commit, MessageReq_Prepare}, types)
+	assert.Equal(t, map[MsgType]int{MessageReq_Commit: 3, MessageReq_Prepare: 3}, m.depths())
+
+	// messages of the other type for a future view are not read in turn
+	m = newMsgQueue()
+	m.pushMessage(mockQueueMsg("A", MessageReq_Commit, ViewMsg(1, 0)))
+	m.pushMessage(mockQueueMsg("B", MessageReq_Commit, ViewMsg(1, 0)))
+	m.pushMessage(mockQueueMsg("C", MessageReq_Prepare, ViewMsg(1, 1)))
+
+	assert.Equal(t, NodeID("A"), m.readMessage(ValidateState, ViewMsg(1, 0)).From)
+	assert.Equal(t, NodeID("B"), m.readMessage(ValidateState, ViewMsg(1, 0)).From)
+	assert.Nil(t, m.readMessage(ValidateState, ViewMsg(1, 0)))
+	assert.Equal(t, map[MsgType]int{MessageReq_Prepare: 1}, m.depths())
+}
+
+func BenchmarkMsgQueue_MixedBacklog(b *testing.B) {
+	view := ViewMsg(1, 0)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		m := newMsgQueue()
+		for j := 0; j < 100; j++ {
+			m.pushMessage(mockQueueMsg("C", MessageReq_Commit, view))
+			m.pushMessage(mockQueueMsg("P", MessageReq_Prepare, view))
+			m.pushMessage(mockQueueMsg("F", MessageReq_Prepare, ViewMsg(1, 1)))
+		}
+		b.StartTimer()
+
+		for m.readMessage(ValidateState, view) != nil {
+		}
 	}
 }
@@ -0,0 +1,57 @@
+package pbft
+
+import "context"
+
+// Pause pauses the state machine at the next sequence boundary: the sequence being run is completed,
+// and the next Run waits until Resume is called. The messages received while paused are queued,
+// so that the node catches up as soon as it resumes
+func (p *Pbft) Pause() {
+	p.pauseLock.Lock()
+	defer p.pauseLock.Unlock()
+
+	if p.resumeCh == nil {
+		p.resumeCh = make(chan struct{})
+	}
+}
+
+// Resume resumes the paused state machine
+func (p *Pbft) Resume() {
+	p.pauseLock.Lock()
+	defer p.pauseLock.Unlock()
+
+	if p.resumeCh != nil {
+		close(p.resumeCh)
+		p.resumeCh = nil
+	}
+}
+
+// IsPaused checks if the state machine is paused
+func (p *Pbft) IsPaused() bool {
+	p.pauseLock.Lock()
+	defer p.pauseLock.Unlock()
+
+	return p.resumeCh != nil
+}
+
+// waitResume waits until the state machine is resumed if it is paused.
+// It returns false if the state machine has to stop instead
+func (p *Pbft) waitResume(ctx context.Context) bool {
+	p.pauseLock.Lock()
+	resumeCh := p.resumeCh
+	p.pauseLock.Unlock()
+
+	if resumeCh == nil {
+		return true
+	}
+
+	p.logger.Info("paused", "sequence", p.state.view.Sequence)
+	select {
+	case <-resumeCh:
+		p.logger.Info("resumed", "sequence", p.state.view.Sequence)
+		return true
+	case <-ctx.Done():
+		return false
+	case <-p.closeCh:
+		return false
+	}
+}
@@ -0,0 +1,94 @@
+package pbft
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// emitVotes emits the prepare and commit messages of the other validators for the sequence
+func emitVotes(m *mockPbft, sequence uint64, from ...NodeID) {
+	for _, id := range from {
+		m.emitMsg(&MessageReq{From: id, Type: MessageReq_Prepare, View: ViewMsg(sequence, 0)})
+		m.emitMsg(&MessageReq{From: id, Type: MessageReq_Commit, View: ViewMsg(sequence, 0), Seal: digest})
+	}
+}
+
+func TestPbft_PauseResume(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.roundTimeout = func(uint64) time.Duration { return time.Hour }
+	m.setProposal(&Proposal{Data: mockProposal, Time: time.Now(), Hash: digest})
+
+	emitVotes(m, 1, "B", "C", "D")
+	pp, err := m.RunSequence(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), pp.Number)
+
+	m.Pause()
+	assert.True(t, m.IsPaused())
+
+	type result struct {
+		pp  *SealedProposal
+		err error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		pp, err := m.RunSequence(context.Background(), 2)
+		resultCh <- result{pp, err}
+	}()
+
+	// the messages received while paused are queued, not processed
+	depths := m.QueueDepths()
+	emitVotes(m, 2, "B", "C", "D")
+	select {
+	case <-resultCh:
+		t.Fatal("the paused state machine ran the sequence")
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Equal(t, depths[MessageReq_Prepare]+3, m.QueueDepths()[MessageReq_Prepare])
+	assert.Equal(t, depths[MessageReq_Commit]+3, m.QueueDepths()[MessageReq_Commit])
+
+	// once resumed, the queued messages commit the sequence right away
+	start := time.Now()
+	m.Resume()
+	assert.False(t, m.IsPaused())
+
+	select {
+	case res := <-resultCh:
+		require.NoError(t, res.err)
+		assert.Equal(t, uint64(2), res.pp.Number)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the resumed state machine did not run the sequence")
+	}
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestPbft_Pause_Close(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.Pause()
+	m.Resume()
+	m.Resume()
+	assert.False(t, m.IsPaused())
+
+	m.Pause()
+	m.Pause()
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := m.RunSequence(context.Background(), 1)
+		errCh <- err
+	}()
+
+	// the paused state machine can still be closed
+	time.Sleep(10 * time.Millisecond)
+	m.Pbft.Close()
+	select {
+	case err := <-errCh:
+		assert.ErrorIs(t, err, ErrClosed)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the paused state machine was not closed")
+	}
+}
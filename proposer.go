@@ -0,0 +1,108 @@
+package pbft
+
+import "errors"
+
+// errNotSelectableValidatorSet is returned by SetBackend if a proposer selector is configured but the
+// validator set of the backend does not implement SelectableValidatorSet
+var errNotSelectableValidatorSet = errors.New("validator set does not support the proposer selector")
+
+// ProposerSelector selects the proposer of a round. The selection MUST be deterministic,
+// since all the validators have to agree on the proposer without exchanging messages
+type ProposerSelector interface {
+	// SelectProposer returns the proposer for the round given the proposer
+	// of the last sequence (empty if there is none) and the validators
+	SelectProposer(round uint64, lastProposer NodeID, validators []NodeID) NodeID
+}
+
+// SelectableValidatorSet is a ValidatorSet which exposes the data required by a ProposerSelector
+type SelectableValidatorSet interface {
+	ValidatorSet
+
+	// Validators returns the ordered list of validators
+	Validators() []NodeID
+
+	// LastProposer returns the proposer of the last sequence
+	LastProposer() NodeID
+}
+
+// RoundRobinProposerSelector selects the validators in turns, starting
+// from the one after the proposer of the last sequence
+type RoundRobinProposerSelector struct {
+}
+
+// SelectProposer implements ProposerSelector interface
+func (r *RoundRobinProposerSelector) SelectProposer(round uint64, lastProposer NodeID, validators []NodeID) NodeID {
+	if len(validators) == 0 {
+		return NodeID("")
+	}
+
+	seed := round
+	if lastProposer != NodeID("") {
+		offset := 0
+		for indx, id := range validators {
+			if id == lastProposer {
+				offset = indx
+				break
+			}
+		}
+		seed = uint64(offset) + round + 1
+	}
+
+	return validators[seed%uint64(len(validators))]
+}
+
+// FixedProposerSelector selects the same proposer in every round and sequence, i.e. for a single sequencer
+// or a primary-backup deployment. The rounds still change on timeout, but the same validator proposes again.
+// Like any ProposerSelector, it requires a SelectableValidatorSet
+type FixedProposerSelector struct {
+	// Proposer is the designated proposer. If it is empty or it is not a validator, the first validator proposes
+	Proposer NodeID
+}
+
+// SelectProposer implements ProposerSelector interface
+func (f *FixedProposerSelector) SelectProposer(round uint64, lastProposer NodeID, validators []NodeID) NodeID {
+	if len(validators) == 0 {
+		return NodeID("")
+	}
+	for _, id := range validators {
+		if id == f.Proposer {
+			return id
+		}
+	}
+	return validators[0]
+}
+
+// calcProposer calculates the proposer of the current round with the configured selector.
+// If there is no selector, or the validator set does not expose the data it needs,
+// the validator set calculates it
+func (p *Pbft) calcProposer() {
+	p.state.setProposer(p.selectProposer(p.state.validators, p.state.view.Sequence, p.state.GetCurrentRound()))
+}
+
+// selectProposer returns the proposer of the round of the sequence without changing the state
+func (p *Pbft) selectProposer(validators ValidatorSet, sequence, round uint64) NodeID {
+	if p.config.ProposerSelector == nil {
+		return validators.CalcProposer(round)
+	}
+
+	selectable, ok := validators.(SelectableValidatorSet)
+	if !ok {
+		return validators.CalcProposer(round)
+	}
+	ids := validNodeIDs(selectable.Validators())
+	if selector, ok := p.config.ProposerSelector.(RandomProposerSelector); ok {
+		return selector.SelectRandomProposer(proposerRand(sequence, round), round, selectable.LastProposer(), ids)
+	}
+	return p.config.ProposerSelector.SelectProposer(round, selectable.LastProposer(), ids)
+}
+
+// checkProposerSelector checks that the validator set supports the configured proposer selector
+func (p *Pbft) checkProposerSelector(validators ValidatorSet) error {
+	if p.config.ProposerSelector == nil {
+		return nil
+	}
+	if _, ok := validators.(SelectableValidatorSet); !ok {
+		return errNotSelectableValidatorSet
+	}
+	return nil
+}
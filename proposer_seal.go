@@ -0,0 +1,45 @@
+package pbft
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// proposerSealDomain separates the digest of the proposer seal from the other digests signed with the same key,
+// a proposer seal can never be passed off as a committed seal (or the other way around)
+var proposerSealDomain = []byte("pbft-proposer-seal")
+
+// proposerSealDigest returns the digest the proposer signs in the preprepare message, the hash of the domain tag,
+// the view, the proposal hash, the parent hash and the proposal. The view binds the seal to the round, so it cannot
+// be replayed in another one, and the parent hash binds it to the chain, so that no relayer can rewrite it
+func proposerSealDigest(view *View, hash, parentHash, proposal []byte) []byte {
+	var buf [32]byte
+	binary.BigEndian.PutUint64(buf[0:], view.Sequence)
+	binary.BigEndian.PutUint64(buf[8:], view.Round)
+	binary.BigEndian.PutUint64(buf[16:], uint64(len(hash)))
+	binary.BigEndian.PutUint64(buf[24:], uint64(len(parentHash)))
+
+	h := sha256.New()
+	h.Write(proposerSealDomain)
+	h.Write(buf[:])
+	h.Write(hash)
+	h.Write(parentHash)
+	h.Write(proposal)
+	return h.Sum(nil)
+}
+
+// sealProposal signs the view and the proposal of the preprepare message with the key of the node
+func (p *Pbft) sealProposal(msg *MessageReq) error {
+	seal, err := p.validator.Sign(proposerSealDigest(msg.View, msg.Hash, msg.ParentHash, msg.Proposal))
+	if err != nil {
+		return err
+	}
+	msg.ProposerSeal = seal
+	return nil
+}
+
+// verifyProposerSeal checks that the preprepare message was sealed by the proposer of its round,
+// so that no other node can impersonate it
+func (p *Pbft) verifyProposerSeal(proposer NodeID, msg *MessageReq) error {
+	return p.backend.VerifyCommittedSeal(proposer, msg.ProposerSeal, proposerSealDigest(msg.View, msg.Hash, msg.ParentHash, msg.Proposal))
+}
@@ -0,0 +1,108 @@
+package pbft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSeal is the seal of a node in the tests, the digest prefixed with the id of the node
+func testSeal(id NodeID, digest []byte) []byte {
+	return append([]byte(id), digest...)
+}
+
+func verifyTestSeal(from NodeID, seal, hash []byte) error {
+	if !bytes.Equal(seal, testSeal(from, hash)) {
+		return errors.New("invalid seal")
+	}
+	return nil
+}
+
+func TestGossip_ProposerSeal(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	defer m.Close()
+	m.pool.get("A").signFn = func(b []byte) ([]byte, error) {
+		return testSeal("A", b), nil
+	}
+
+	m.gossip(MessageReq_Preprepare)
+
+	require.Len(t, m.respMsg, 1)
+	msg := m.respMsg[0]
+	assert.Equal(t, testSeal("A", proposerSealDigest(ViewMsg(1, 0), digest, nil, mockProposal)), msg.ProposerSeal)
+
+	// the other messages are not sealed by the proposer
+	m.gossip(MessageReq_Prepare)
+	require.Len(t, m.respMsg, 2)
+	assert.Nil(t, m.respMsg[1].ProposerSeal)
+}
+
+func TestGossip_ProposerSealFailed(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	defer m.Close()
+	m.pool.get("A").signFn = func(b []byte) ([]byte, error) {
+		return nil, errors.New("failed to sign message")
+	}
+
+	m.gossip(MessageReq_Preprepare)
+	assert.Empty(t, m.respMsg)
+}
+
+// A validator only accepts the preprepare sealed by the proposer of the round
+func TestTransition_AcceptState_Validator_ProposerSeal(t *testing.T) {
+	cases := []struct {
+		name     string
+		seal     []byte
+		accepted bool
+	}{
+		{"proposer seal", testSeal("A", proposerSealDigest(ViewMsg(1, 0), digest, nil, mockProposal)), true},
+		{"forged seal", testSeal("C", proposerSealDigest(ViewMsg(1, 0), digest, nil, mockProposal)), false},
+		{"seal of another round", testSeal("A", proposerSealDigest(ViewMsg(1, 1), digest, nil, mockProposal)), false},
+		{"seal of another proposal", testSeal("A", proposerSealDigest(ViewMsg(1, 0), digest, nil, mockProposal1)), false},
+		{"seal of another parent", testSeal("A", proposerSealDigest(ViewMsg(1, 0), digest, []byte{0x8}, mockProposal)), false},
+		{"committed seal of the proposal", testSeal("A", digest), false},
+		{"missing seal", nil, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			backend := newMockBackend([]string{"A", "B", "C"}, nil).HookVerifyCommittedSealHandler(verifyTestSeal)
+			metrics := newFakeMetrics()
+			m := newMockPbft(t, []string{"A", "B", "C"}, "B", backend)
+			m.metrics = metrics
+			m.state.view = ViewMsg(1, 0)
+			m.setState(AcceptState)
+
+			m.emitMsg(&MessageReq{
+				From:         "A",
+				Type:         MessageReq_Preprepare,
+				Proposal:     mockProposal,
+				View:         ViewMsg(1, 0),
+				ProposerSeal: c.seal,
+			})
+			m.Close()
+
+			m.runCycle(context.Background())
+
+			if c.accepted {
+				m.expect(expectResult{
+					sequence: 1,
+					state:    ValidateState,
+					outgoing: 1, // prepare message
+				})
+				assert.Empty(t, metrics.dropped)
+			} else {
+				// the node keeps waiting for the preprepare of the proposer
+				m.expect(expectResult{
+					sequence: 1,
+					state:    AcceptState,
+				})
+				assert.Equal(t, map[MsgType]int{MessageReq_Preprepare: 1}, metrics.dropped)
+			}
+		})
+	}
+}
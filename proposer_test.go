@@ -0,0 +1,302 @@
+package pbft
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"log"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selectableValString is a validator set which exposes the data required by a ProposerSelector
+type selectableValString struct {
+	valString
+	lastProposer NodeID
+}
+
+func (v *selectableValString) Validators() []NodeID {
+	return v.valString
+}
+
+func (v *selectableValString) LastProposer() NodeID {
+	return v.lastProposer
+}
+
+// stakeWeightedSelector selects the proposer with a probability proportional to its stake,
+// using the last proposer and the round as the seed
+type stakeWeightedSelector struct {
+	stakes map[NodeID]uint64
+}
+
+func (s *stakeWeightedSelector) SelectProposer(round uint64, lastProposer NodeID, validators []NodeID) NodeID {
+	total := uint64(0)
+	for _, id := range validators {
+		total += s.stakes[id]
+	}
+	if total == 0 {
+		return NodeID("")
+	}
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, round)
+	seed := sha256.Sum256(append([]byte(lastProposer), buf...))
+	pick := binary.BigEndian.Uint64(seed[:8]) % total
+
+	for _, id := range validators {
+		if pick < s.stakes[id] {
+			return id
+		}
+		pick -= s.stakes[id]
+	}
+	panic("BUG: stake weighted selection out of range")
+}
+
+// randomSelector selects a random validator with the random generator of the round
+type randomSelector struct {
+}
+
+func (s *randomSelector) SelectProposer(round uint64, lastProposer NodeID, validators []NodeID) NodeID {
+	panic("BUG: the random selector needs a random generator")
+}
+
+func (s *randomSelector) SelectRandomProposer(r *rand.Rand, round uint64, lastProposer NodeID, validators []NodeID) NodeID {
+	return validators[r.Intn(len(validators))]
+}
+
+func TestRoundRobinProposerSelector(t *testing.T) {
+	validators := []NodeID{"A", "B", "C", "D"}
+	selector := &RoundRobinProposerSelector{}
+
+	cases := []struct {
+		round        uint64
+		lastProposer NodeID
+		proposer     NodeID
+	}{
+		{0, "", "A"},
+		{1, "", "B"},
+		{0, "A", "B"},
+		{2, "A", "D"},
+		{0, "D", "A"},
+		// unknown last proposer starts from the first validator
+		{0, "X", "B"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.proposer, selector.SelectProposer(c.round, c.lastProposer, validators))
+	}
+
+	assert.Equal(t, NodeID(""), selector.SelectProposer(0, "", nil))
+}
+
+func TestFixedProposerSelector(t *testing.T) {
+	validators := []NodeID{"A", "B", "C", "D"}
+
+	// the designated proposer proposes in every round, whoever proposed last
+	selector := &FixedProposerSelector{Proposer: "C"}
+	for round := uint64(0); round < 10; round++ {
+		for _, last := range []NodeID{"", "A", "C", "D"} {
+			assert.Equal(t, NodeID("C"), selector.SelectProposer(round, last, validators))
+		}
+	}
+
+	// the first validator proposes if there is no designated proposer, or it is not a validator
+	assert.Equal(t, NodeID("A"), (&FixedProposerSelector{}).SelectProposer(3, "B", validators))
+	assert.Equal(t, NodeID("A"), (&FixedProposerSelector{Proposer: "X"}).SelectProposer(3, "B", validators))
+	assert.Equal(t, NodeID(""), selector.SelectProposer(0, "", nil))
+}
+
+// With a fixed proposer the same validator proposes after every round change, and the sequence commits.
+func TestPbft_FixedProposer(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "C")
+	defer m.Close()
+	WithFixedProposer("C")(m.config)
+	// C proposed the last sequence, the round robin would select D
+	backend := &selectableBackend{
+		mockBackend: newMockBackend(nil, m),
+		validators:  &selectableValString{valString: valString{"A", "B", "C", "D"}, lastProposer: "C"},
+	}
+	require.NoError(t, m.SetBackend(backend))
+
+	for round := uint64(0); round < 5; round++ {
+		m.state.SetCurrentRound(round)
+		m.calcProposer()
+		assert.Equal(t, NodeID("C"), m.state.proposer, "round %d", round)
+	}
+
+	m.setRound(0)
+	m.setState(AcceptState)
+	m.setProposal(&Proposal{Data: mockProposal, Hash: digest, Time: time.Now()})
+	for _, from := range []NodeID{"A", "B", "D"} {
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte(from)})
+	}
+	m.Run(context.Background())
+
+	assert.True(t, m.IsState(DoneState))
+	require.NotEmpty(t, m.respMsg)
+	assert.Equal(t, MessageReq_Preprepare, m.respMsg[0].Type)
+	assert.Equal(t, NodeID("C"), m.state.proposer)
+}
+
+// The fixed proposer is not silently replaced by the rotation of a validator set which cannot select it.
+func TestPbft_FixedProposer_NotSelectable(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	defer m.Close()
+	WithFixedProposer("C")(m.config)
+
+	assert.ErrorIs(t, m.SetBackend(newMockBackend([]string{"A", "B", "C"}, m)), errNotSelectableValidatorSet)
+
+	backend := &selectableBackend{
+		mockBackend: newMockBackend(nil, m),
+		validators:  &selectableValString{valString: valString{"A", "B", "C"}, lastProposer: "C"},
+	}
+	require.NoError(t, m.SetBackend(backend))
+	m.calcProposer()
+	assert.Equal(t, NodeID("C"), m.state.proposer)
+}
+
+func TestProposerSelector_StakeWeighted(t *testing.T) {
+	validators := []NodeID{"A", "B", "C", "D"}
+	selector := &stakeWeightedSelector{
+		stakes: map[NodeID]uint64{"A": 70, "B": 10, "C": 10, "D": 10},
+	}
+
+	picks := map[NodeID]int{}
+	for round := uint64(0); round < 1000; round++ {
+		picks[selector.SelectProposer(round, "B", validators)]++
+	}
+
+	// the validator with most of the stake is selected most of the times
+	assert.Greater(t, picks["A"], 600)
+	assert.Less(t, picks["A"], 800)
+	for _, id := range []NodeID{"B", "C", "D"} {
+		assert.Greater(t, picks[id], 0)
+	}
+}
+
+func TestProposerSelector_Deterministic(t *testing.T) {
+	accounts := []string{"A", "B", "C", "D"}
+	selector := &stakeWeightedSelector{
+		stakes: map[NodeID]uint64{"A": 40, "B": 30, "C": 20, "D": 10},
+	}
+
+	// every node calculates the proposer on its own
+	nodes := []*mockPbft{}
+	for _, account := range accounts {
+		m := newMockPbft(t, accounts, account)
+		defer m.Close()
+		m.config.ProposerSelector = selector
+		m.state.validators = &selectableValString{valString: valString{"A", "B", "C", "D"}, lastProposer: "C"}
+		nodes = append(nodes, m)
+	}
+
+	for round := uint64(0); round < 20; round++ {
+		for _, m := range nodes {
+			m.state.SetCurrentRound(round)
+			m.calcProposer()
+		}
+		for _, m := range nodes[1:] {
+			assert.Equal(t, nodes[0].state.proposer, m.state.proposer, "round %d", round)
+		}
+	}
+}
+
+func TestProposerSelector_Fallback(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	defer m.Close()
+	m.config.ProposerSelector = &stakeWeightedSelector{stakes: map[NodeID]uint64{"C": 1}}
+
+	// the validator set of the mock backend does not expose the validators, it calculates the proposer
+	m.calcProposer()
+	assert.Equal(t, NodeID("A"), m.state.proposer)
+
+	m.state.validators = &selectableValString{valString: valString{"A", "B", "C"}}
+	m.calcProposer()
+	assert.Equal(t, NodeID("C"), m.state.proposer)
+}
+
+func TestProposerSelector_SetBackend(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	defer m.Close()
+	m.config.ProposerSelector = &RoundRobinProposerSelector{}
+
+	// the validator set of the mock backend does not expose the validators
+	assert.ErrorIs(t, m.SetBackend(newMockBackend([]string{"A", "B", "C"}, m)), errNotSelectableValidatorSet)
+
+	backend := &selectableBackend{
+		mockBackend: newMockBackend(nil, m),
+		validators:  &selectableValString{valString: valString{"A", "B", "C"}},
+	}
+	assert.NoError(t, m.SetBackend(backend))
+}
+
+func TestProposerSelector_SkipsInvalidIDs(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	defer m.Close()
+	m.config.ProposerSelector = &RoundRobinProposerSelector{}
+	m.state.validators = &selectableValString{valString: valString{"A", "", "B", " ", "B", "C"}}
+
+	// the empty and duplicated ids never become proposers
+	proposers := []NodeID{}
+	for round := uint64(0); round < 6; round++ {
+		m.state.SetCurrentRound(round)
+		m.calcProposer()
+		proposers = append(proposers, m.state.proposer)
+	}
+	assert.Equal(t, []NodeID{"A", "B", "C", "A", "B", "C"}, proposers)
+}
+
+func TestTransition_AcceptState_ProposerSelector(t *testing.T) {
+	// the selector picks B as the proposer, so B proposes in round 0
+	i := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	i.config.ProposerSelector = &stakeWeightedSelector{stakes: map[NodeID]uint64{"B": 1}}
+	i.state.validators = &selectableValString{valString: valString{"A", "B", "C", "D"}}
+	i.setState(AcceptState)
+
+	i.setProposal(&Proposal{
+		Data: mockProposal,
+		Time: time.Now(),
+	})
+
+	i.runCycle(context.Background())
+
+	assert.Equal(t, NodeID("B"), i.state.proposer)
+	i.expect(expectResult{
+		sequence: 1,
+		outgoing: 2, // preprepare and prepare
+		state:    ValidateState,
+	})
+}
+
+func TestProposerSelector_Random(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A", "B")
+
+	validators := &selectableValString{valString: valString{"A", "B", "C", "D", "E", "F", "G"}}
+	run := func(account string, seed int64, sequence uint64) []NodeID {
+		p := New(pool.get(account), nil,
+			WithLogger(log.New(getDefaultLoggerOutput(), "", log.LstdFlags)),
+			WithProposerSelector(&randomSelector{}),
+			WithTimeoutJitter(0.1),
+			WithRandSource(rand.NewSource(seed)))
+
+		proposers := []NodeID{}
+		for round := uint64(0); round < 20; round++ {
+			// the proposers are selected more than once, i.e. to check the sender of the preprepares
+			proposers = append(proposers, p.selectProposer(validators, sequence, round))
+			assert.Equal(t, proposers[round], p.selectProposer(validators, sequence, round))
+		}
+		return proposers
+	}
+
+	// the nodes select the same proposers whatever their random source
+	proposers := run("A", 42, 1)
+	assert.Equal(t, proposers, run("B", 43, 1))
+
+	// but they change with the height
+	assert.NotEqual(t, proposers, run("A", 42, 2))
+}
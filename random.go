@@ -0,0 +1,57 @@
+package pbft
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+)
+
+// RandomProposerSelector is a ProposerSelector which needs randomness, i.e. a selection weighted by stake.
+// All the validators have to select the same proposer, hence the random generator it is given is seeded
+// with the height and the round only. It is not drawn from the RandSource of the node
+type RandomProposerSelector interface {
+	ProposerSelector
+
+	// SelectRandomProposer returns the proposer for the round given the random generator of the height
+	// and the round, the proposer of the last sequence (empty if there is none) and the validators.
+	// It is called instead of SelectProposer
+	SelectRandomProposer(r *rand.Rand, round uint64, lastProposer NodeID, validators []NodeID) NodeID
+}
+
+// proposerRand returns the random generator of the proposer selection of the height and the round,
+// which is the same in every node
+func proposerRand(height, round uint64) *rand.Rand {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], height)
+	binary.BigEndian.PutUint64(buf[8:], round)
+	seed := sha256.Sum256(buf[:])
+	return rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(seed[:8]))))
+}
+
+// lockedSource is a rand.Source safe for concurrent use
+type lockedSource struct {
+	lock sync.Mutex
+	src  rand.Source
+}
+
+// newLockedRand creates a random generator safe for concurrent use from the source
+func newLockedRand(src rand.Source) *rand.Rand {
+	return rand.New(&lockedSource{src: src})
+}
+
+// Int63 implements rand.Source interface
+func (s *lockedSource) Int63() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.src.Int63()
+}
+
+// Seed implements rand.Source interface
+func (s *lockedSource) Seed(seed int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.src.Seed(seed)
+}
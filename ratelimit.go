@@ -0,0 +1,103 @@
+package pbft
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxRateLimitedSenders is the number of senders the rate limiter tracks. Once reached, it forgets the ones
+// whose bucket refilled, and if none did, the messages of the new senders are dropped
+const maxRateLimitedSenders = 1024
+
+// tokenBucket holds the messages a sender can still send, it refills over time up to the burst
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter limits the rate of the messages of each sender with a token bucket, so that a peer
+// flooding the node (i.e. misconfigured) cannot saturate the message queue
+type rateLimiter struct {
+	lock sync.Mutex
+
+	// rate is the number of messages per second each sender can sustain (0 means unlimited)
+	rate float64
+
+	// burst is the number of messages a sender can send at once
+	burst float64
+
+	buckets map[NodeID]*tokenBucket
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[NodeID]*tokenBucket{},
+	}
+}
+
+// allow takes a token from the bucket of the sender. It returns false if the bucket is empty
+func (r *rateLimiter) allow(id NodeID, now time.Time) bool {
+	if r.rate <= 0 {
+		return true
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	b, ok := r.buckets[id]
+	if !ok {
+		if len(r.buckets) >= maxRateLimitedSenders {
+			r.prune(now)
+		}
+		if len(r.buckets) >= maxRateLimitedSenders {
+			return false
+		}
+		b = &tokenBucket{tokens: r.burst, last: now}
+		r.buckets[id] = b
+	}
+	r.refill(b, now)
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refill adds the tokens earned since the last refill, up to the burst
+func (r *rateLimiter) refill(b *tokenBucket, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * r.rate
+		if b.tokens > r.burst {
+			b.tokens = r.burst
+		}
+		b.last = now
+	}
+}
+
+// prune forgets the senders whose bucket is full again, they start over with a full bucket anyway
+func (r *rateLimiter) prune(now time.Time) {
+	for id, b := range r.buckets {
+		r.refill(b, now)
+		if b.tokens >= r.burst {
+			delete(r.buckets, id)
+		}
+	}
+}
+
+// rateLimited checks if the sender of the verified message exceeded the MessageRateLimit, the message is dropped if so
+func (p *Pbft) rateLimited(msg *MessageReq) bool {
+	if p.rateLimiter.allow(msg.From, p.clock.Now()) {
+		return false
+	}
+	atomic.AddUint64(&p.rateLimitedMsgs, 1)
+	p.metrics.MessageDropped(msg.Type)
+	p.logger.Debug("sender exceeded the rate limit, dropping msg", "from", msg.From, "type", msg.Type)
+	return true
+}
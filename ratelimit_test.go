@@ -0,0 +1,125 @@
+package pbft
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Unix(0, 0)
+	r := newRateLimiter(2, 3)
+
+	// the burst is available at once
+	for i := 0; i < 3; i++ {
+		assert.True(t, r.allow("A", now))
+	}
+	assert.False(t, r.allow("A", now))
+
+	// the other senders have their own bucket
+	assert.True(t, r.allow("B", now))
+
+	// the bucket refills at the rate
+	assert.False(t, r.allow("A", now.Add(400*time.Millisecond)))
+	assert.True(t, r.allow("A", now.Add(500*time.Millisecond)))
+	assert.False(t, r.allow("A", now.Add(500*time.Millisecond)))
+
+	// but never above the burst
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, r.allow("A", later))
+	}
+	assert.False(t, r.allow("A", later))
+
+	// a zero rate disables it
+	unlimited := newRateLimiter(0, 1)
+	for i := 0; i < 100; i++ {
+		assert.True(t, unlimited.allow("A", now))
+	}
+}
+
+func TestRateLimiter_Prune(t *testing.T) {
+	now := time.Unix(0, 0)
+	r := newRateLimiter(1, 1)
+
+	for i := 0; i < maxRateLimitedSenders; i++ {
+		assert.True(t, r.allow(NodeID(fmt.Sprintf("node%d", i)), now))
+	}
+	assert.False(t, r.allow("node0", now))
+
+	// the new senders are dropped while the buckets of the others did not refill
+	assert.False(t, r.allow("new", now))
+	assert.Len(t, r.buckets, maxRateLimitedSenders)
+
+	// the buckets which refilled are forgotten to make room for the new senders
+	assert.True(t, r.allow("new", now.Add(time.Second)))
+	assert.Len(t, r.buckets, 1)
+}
+
+// A sender flooding the node gets its excess messages dropped, the other senders are not affected.
+func TestPbft_MessageRateLimit(t *testing.T) {
+	clock := newManualClock()
+	metrics := newFakeMetrics()
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.clock = clock
+	m.metrics = metrics
+	m.rateLimiter = newRateLimiter(10, 5)
+
+	// the flood of C, within the same instant
+	for round := uint64(0); round < 50; round++ {
+		m.emitMsg(&MessageReq{From: "C", Type: MessageReq_RoundChange, View: ViewMsg(1, round)})
+	}
+	// the well-behaved B
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte("B")})
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_RoundChange, View: ViewMsg(1, 0)})
+	// the messages of the transport are limited, even if they claim to be our own
+	for round := uint64(0); round < 10; round++ {
+		m.emitMsg(&MessageReq{From: "A", Type: MessageReq_Prepare, View: ViewMsg(1, round)})
+	}
+	// whereas the messages the node sends to itself are never limited
+	for i := 0; i < 10; i++ {
+		m.gossip(MessageReq_RoundChange)
+	}
+
+	assert.Equal(t, uint64(50), m.rateLimitedMsgs)
+	assert.Equal(t, map[MsgType]int{MessageReq_Prepare: 5, MessageReq_RoundChange: 45}, metrics.dropped)
+	assert.Equal(t, map[MsgType]int{
+		MessageReq_Prepare:     6,
+		MessageReq_Commit:      1,
+		MessageReq_RoundChange: 16,
+	}, m.QueueDepths())
+
+	// C can send again once its bucket refills
+	clock.Advance(100 * time.Millisecond)
+	m.emitMsg(&MessageReq{From: "C", Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+	m.emitMsg(&MessageReq{From: "C", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte("C")})
+	assert.Equal(t, uint64(51), m.rateLimitedMsgs)
+	assert.Equal(t, 7, m.QueueDepths()[MessageReq_Prepare])
+}
+
+// The messages failing the verification do not take the tokens of the sender they claim to be from.
+func TestPbft_MessageRateLimit_Verified(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.rateLimiter = newRateLimiter(1, 2)
+	m.msgVerifier = func(msg *MessageReq) error {
+		if msg.Seal != nil {
+			return errors.New("bad signature")
+		}
+		return nil
+	}
+
+	// the forged messages on behalf of B
+	for i := 0; i < 10; i++ {
+		m.emitMsg(&MessageReq{From: "B", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte("B")})
+	}
+	assert.Zero(t, m.rateLimitedMsgs)
+
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_RoundChange, View: ViewMsg(1, 0)})
+	assert.Zero(t, m.rateLimitedMsgs)
+	assert.Equal(t, 2, m.msgQueue.len())
+}
@@ -3,6 +3,10 @@ package pbft
 import (
 	"bytes"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -14,6 +18,11 @@ const (
 	MessageReq_Preprepare  MsgType = 1
 	MessageReq_Commit      MsgType = 2
 	MessageReq_Prepare     MsgType = 3
+
+	// MessageReq_SyncRequest and MessageReq_SyncResponse are not consensus messages, a node
+	// which fell behind requests the sealed proposals it is missing with them (see Config.PeerSync)
+	MessageReq_SyncRequest  MsgType = 4
+	MessageReq_SyncResponse MsgType = 5
 )
 
 func (m MsgType) String() string {
@@ -26,6 +35,10 @@ func (m MsgType) String() string {
 		return "Commit"
 	case MessageReq_Prepare:
 		return "Prepare"
+	case MessageReq_SyncRequest:
+		return "SyncRequest"
+	case MessageReq_SyncResponse:
+		return "SyncResponse"
 	default:
 		panic(fmt.Sprintf("BUG: Bad msgtype %d", m))
 	}
@@ -49,6 +62,38 @@ type MessageReq struct {
 
 	// proposal is the arbitrary data proposal (only for preprepare messages)
 	Proposal []byte `json:"proposal"`
+
+	// roundChangeCertificate justifies the round change (only for round change messages)
+	RoundChangeCertificate *RoundChangeCertificate `json:"roundChangeCertificate,omitempty"`
+
+	// preparedCertificate proves that a locked proposal was prepared (only for preprepare messages of a locked proposer)
+	PreparedCertificate *PreparedCertificate `json:"preparedCertificate,omitempty"`
+
+	// votes are the prepare or commit messages of the other validators aggregated by the proposer
+	// (only for prepare and commit messages of the proposer, see Config.LeaderAggregation)
+	Votes []*MessageReq `json:"votes,omitempty"`
+
+	// heights is the range of heights the sender is missing (only for sync request messages)
+	Heights *HeightRange `json:"heights,omitempty"`
+
+	// sealedProposals are the proposals committed at the requested heights (only for sync response messages)
+	SealedProposals []*SealedProposal `json:"sealedProposals,omitempty"`
+
+	// proposerSeal is the signature of the proposer over the view and the proposal (only for preprepare messages)
+	ProposerSeal []byte `json:"proposerSeal,omitempty"`
+
+	// commitCertificate is the proposal the sender committed in the previous sequence (only for preprepare messages,
+	// see Config.FastCatchUp)
+	CommitCertificate *SealedProposal `json:"commitCertificate,omitempty"`
+
+	// parentHash is the parent hash of the proposal (only for preprepare messages, see Config.ChainProposals)
+	ParentHash []byte `json:"parentHash,omitempty"`
+}
+
+// HeightRange is a range of heights, both ends included
+type HeightRange struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
 }
 
 func (m MessageReq) String() string {
@@ -56,14 +101,38 @@ func (m MessageReq) String() string {
 }
 
 func (m *MessageReq) Validate() error {
-	// Hash field has to exist for state != RoundStateChange
-	if m.Type != MessageReq_RoundChange {
+	switch m.Type {
+	case MessageReq_RoundChange, MessageReq_SyncResponse:
+	case MessageReq_SyncRequest:
+		if m.Heights == nil {
+			return fmt.Errorf("heights are empty for type %s", m.Type.String())
+		}
+	default:
+		// Hash field has to exist for the consensus messages other than the round change
 		if m.Hash == nil {
 			return fmt.Errorf("hash is empty for type %s", m.Type.String())
 		}
 	}
 
-	// TODO
+	// the fields which only some types of messages carry
+	switch m.Type {
+	case MessageReq_Preprepare:
+		// the proposal can be empty, but it cannot be missing
+		if m.Proposal == nil {
+			return fmt.Errorf("proposal is missing for type %s", m.Type.String())
+		}
+	case MessageReq_Commit:
+		if len(m.Seal) == 0 {
+			return fmt.Errorf("seal is empty for type %s", m.Type.String())
+		}
+	case MessageReq_Prepare, MessageReq_RoundChange:
+		if m.Proposal != nil {
+			return fmt.Errorf("unexpected proposal for type %s", m.Type.String())
+		}
+		if m.Seal != nil {
+			return fmt.Errorf("unexpected seal for type %s", m.Type.String())
+		}
+	}
 	return nil
 }
 
@@ -83,9 +152,85 @@ func (m *MessageReq) Copy() *MessageReq {
 	if m.Seal != nil {
 		mm.Seal = append([]byte{}, m.Seal...)
 	}
+	if m.ProposerSeal != nil {
+		mm.ProposerSeal = append([]byte{}, m.ProposerSeal...)
+	}
+	if m.RoundChangeCertificate != nil {
+		mm.RoundChangeCertificate = m.RoundChangeCertificate.Copy()
+	}
+	if m.PreparedCertificate != nil {
+		mm.PreparedCertificate = m.PreparedCertificate.Copy()
+	}
+	if m.Votes != nil {
+		mm.Votes = make([]*MessageReq, len(m.Votes))
+		for i, vote := range m.Votes {
+			mm.Votes[i] = vote.Copy()
+		}
+	}
+	if m.Heights != nil {
+		heights := *m.Heights
+		mm.Heights = &heights
+	}
+	if m.SealedProposals != nil {
+		mm.SealedProposals = make([]*SealedProposal, len(m.SealedProposals))
+		for i, pp := range m.SealedProposals {
+			mm.SealedProposals[i] = pp.Copy()
+		}
+	}
+	if m.CommitCertificate != nil {
+		mm.CommitCertificate = m.CommitCertificate.Copy()
+	}
+	if m.ParentHash != nil {
+		mm.ParentHash = append([]byte{}, m.ParentHash...)
+	}
 	return mm
 }
 
+// PreparedCertificate is the proof that a proposal was prepared in a given view,
+// namely the prepare messages received from a quorum of validators
+type PreparedCertificate struct {
+	// Hash of the prepared proposal
+	Hash []byte `json:"hash"`
+
+	// View in which the proposal was prepared
+	View *View `json:"view"`
+
+	// PrepareMessages are the prepare messages from distinct validators. If the node locked the proposal
+	// on a commit quorum without a prepare quorum, they are the commit messages of the commit quorum instead,
+	// since the honest nodes among it saw a prepare quorum. The messages are never of both types
+	PrepareMessages []*MessageReq `json:"prepareMessages"`
+}
+
+// Copy makes a deep copy of the PreparedCertificate
+func (c *PreparedCertificate) Copy() *PreparedCertificate {
+	cc := &PreparedCertificate{
+		Hash:            append([]byte{}, c.Hash...),
+		PrepareMessages: make([]*MessageReq, len(c.PrepareMessages)),
+	}
+	if c.View != nil {
+		cc.View = c.View.Copy()
+	}
+	for i, msg := range c.PrepareMessages {
+		cc.PrepareMessages[i] = msg.Copy()
+	}
+	return cc
+}
+
+// RoundChangeCertificate is carried on round change messages to justify the round change
+type RoundChangeCertificate struct {
+	// PreparedCertificate is the certificate for the highest round the sender locked on (nil if not locked)
+	PreparedCertificate *PreparedCertificate `json:"preparedCertificate,omitempty"`
+}
+
+// Copy makes a deep copy of the RoundChangeCertificate
+func (c *RoundChangeCertificate) Copy() *RoundChangeCertificate {
+	cc := new(RoundChangeCertificate)
+	if c.PreparedCertificate != nil {
+		cc.PreparedCertificate = c.PreparedCertificate.Copy()
+	}
+	return cc
+}
+
 // Equal compares if two messages are equal
 func (m *MessageReq) Equal(other *MessageReq) bool {
 	return other != nil &&
@@ -93,8 +238,7 @@ func (m *MessageReq) Equal(other *MessageReq) bool {
 		bytes.Equal(m.Proposal, other.Proposal) &&
 		bytes.Equal(m.Hash, other.Hash) &&
 		bytes.Equal(m.Seal, other.Seal) &&
-		m.View.Round == other.View.Round &&
-		m.View.Sequence == other.View.Sequence
+		m.View.Cmp(other.View) == 0
 }
 
 type View struct {
@@ -115,6 +259,29 @@ func (v *View) String() string {
 	return fmt.Sprintf("(Sequence=%d, Round=%d)", v.Sequence, v.Round)
 }
 
+// Cmp compares the view with another one, first by sequence and then by round.
+// It returns -1 if the view is lower, 0 if both views are equal and 1 if it is higher
+func (v *View) Cmp(o *View) int {
+	if v.Sequence != o.Sequence {
+		if v.Sequence < o.Sequence {
+			return -1
+		}
+		return 1
+	}
+	if v.Round != o.Round {
+		if v.Round < o.Round {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// Key returns a stable key which identifies the view (i.e. to index the messages by view)
+func (v *View) Key() string {
+	return strconv.FormatUint(v.Sequence, 10) + "/" + strconv.FormatUint(v.Round, 10)
+}
+
 func ViewMsg(sequence, round uint64) *View {
 	return &View{
 		Round:    round,
@@ -124,6 +291,203 @@ func ViewMsg(sequence, round uint64) *View {
 
 type NodeID string
 
+// Validate checks that the id can identify a validator. Empty ids, and ids with leading
+// or trailing whitespaces, are rejected since they come from misconfigured nodes
+func (id NodeID) Validate() error {
+	trimmed := strings.TrimSpace(string(id))
+	if trimmed == "" {
+		return fmt.Errorf("empty node id")
+	}
+	if trimmed != string(id) {
+		return fmt.Errorf("node id %q has leading or trailing whitespaces", string(id))
+	}
+	return nil
+}
+
+// sameValidators checks if two validator sets have the same validators, in the same order. The sets which
+// do not expose their validators are compared by size and by the proposers they calculate for each round
+func sameValidators(a, b ValidatorSet) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Len() != b.Len() {
+		return false
+	}
+
+	weightedA, okA := a.(WeightedValidatorSet)
+	weightedB, okB := b.(WeightedValidatorSet)
+	if okA != okB || (okA && weightedA.TotalVotingPower() != weightedB.TotalVotingPower()) {
+		return false
+	}
+
+	selectableA, okA := a.(SelectableValidatorSet)
+	selectableB, okB := b.(SelectableValidatorSet)
+	if okA && okB {
+		idsA, idsB := selectableA.Validators(), selectableB.Validators()
+		if len(idsA) != len(idsB) {
+			return false
+		}
+		for i := range idsA {
+			if idsA[i] != idsB[i] {
+				return false
+			}
+			if weightedA != nil && weightedA.VotingPower(idsA[i]) != weightedB.VotingPower(idsB[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for round := uint64(0); round < uint64(a.Len()); round++ {
+		if a.CalcProposer(round) != b.CalcProposer(round) {
+			return false
+		}
+	}
+	return true
+}
+
+// copyValidatorSet returns a copy of the validator set, so that the changes the backend makes to its set
+// afterwards (i.e. in place) do not reach the copy. The validators, the last proposer and the voting power
+// are copied, and the proposers are copied for a full turn of the validators. A set which does not expose
+// its validators (see SelectableValidatorSet) cannot be copied, it is returned as it is
+func copyValidatorSet(validators ValidatorSet) ValidatorSet {
+	selectable, ok := validators.(SelectableValidatorSet)
+	if !ok {
+		return validators
+	}
+
+	cc := &validatorSetCopy{
+		ids:          append([]NodeID{}, selectable.Validators()...),
+		includes:     map[NodeID]struct{}{},
+		proposers:    make([]NodeID, validators.Len()),
+		lastProposer: selectable.LastProposer(),
+	}
+	for _, id := range cc.ids {
+		cc.includes[id] = struct{}{}
+	}
+	for round := range cc.proposers {
+		cc.proposers[round] = validators.CalcProposer(uint64(round))
+	}
+
+	weighted, ok := validators.(WeightedValidatorSet)
+	if !ok {
+		return cc
+	}
+	wc := &weightedValidatorSetCopy{
+		validatorSetCopy: cc,
+		power:            make(map[NodeID]uint64, len(cc.ids)),
+		totalPower:       weighted.TotalVotingPower(),
+	}
+	for _, id := range cc.ids {
+		wc.power[id] = weighted.VotingPower(id)
+	}
+	return wc
+}
+
+// validatorSetCopy is a copy of a SelectableValidatorSet (see copyValidatorSet)
+type validatorSetCopy struct {
+	ids          []NodeID
+	includes     map[NodeID]struct{}
+	proposers    []NodeID
+	lastProposer NodeID
+}
+
+func (v *validatorSetCopy) CalcProposer(round uint64) NodeID {
+	if len(v.proposers) == 0 {
+		return NodeID("")
+	}
+	return v.proposers[round%uint64(len(v.proposers))]
+}
+
+func (v *validatorSetCopy) Includes(id NodeID) bool {
+	_, ok := v.includes[id]
+	return ok
+}
+
+func (v *validatorSetCopy) Len() int {
+	return len(v.proposers)
+}
+
+func (v *validatorSetCopy) Validators() []NodeID {
+	return append([]NodeID{}, v.ids...)
+}
+
+func (v *validatorSetCopy) LastProposer() NodeID {
+	return v.lastProposer
+}
+
+// weightedValidatorSetCopy is a copy of a SelectableValidatorSet which is weighted too (see copyValidatorSet)
+type weightedValidatorSetCopy struct {
+	*validatorSetCopy
+
+	power      map[NodeID]uint64
+	totalPower uint64
+}
+
+func (v *weightedValidatorSetCopy) VotingPower(id NodeID) uint64 {
+	return v.power[id]
+}
+
+func (v *weightedValidatorSetCopy) TotalVotingPower() uint64 {
+	return v.totalPower
+}
+
+// diffValidators returns the validators of next which are not in prev, and the ones of prev which are not
+// in next. Every validator of next is added if there is no prev. It returns false if the validators
+// of either set are unknown, that is if they do not implement SelectableValidatorSet
+func diffValidators(prev, next ValidatorSet) (added, removed []NodeID, ok bool) {
+	nextSet, ok := next.(SelectableValidatorSet)
+	if !ok {
+		return nil, nil, false
+	}
+	var prevIDs []NodeID
+	if prev != nil {
+		prevSet, ok := prev.(SelectableValidatorSet)
+		if !ok {
+			return nil, nil, false
+		}
+		prevIDs = validNodeIDs(prevSet.Validators())
+	}
+	nextIDs := validNodeIDs(nextSet.Validators())
+
+	contains := func(ids []NodeID, id NodeID) bool {
+		for _, other := range ids {
+			if other == id {
+				return true
+			}
+		}
+		return false
+	}
+	for _, id := range nextIDs {
+		if !contains(prevIDs, id) {
+			added = append(added, id)
+		}
+	}
+	for _, id := range prevIDs {
+		if !contains(nextIDs, id) {
+			removed = append(removed, id)
+		}
+	}
+	return added, removed, true
+}
+
+// validNodeIDs returns the ids in the same order, skipping the invalid and duplicated ones
+func validNodeIDs(ids []NodeID) []NodeID {
+	valid := make([]NodeID, 0, len(ids))
+	seen := make(map[NodeID]struct{}, len(ids))
+	for _, id := range ids {
+		if id.Validate() != nil {
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		valid = append(valid, id)
+	}
+	return valid
+}
+
 type PbftState uint32
 
 // Define the states in PBFT
@@ -156,7 +520,8 @@ func (i PbftState) String() string {
 }
 
 type Proposal struct {
-	// Data is an arbitrary set of data to approve in consensus
+	// Data is an arbitrary set of data to approve in consensus. A zero-length Data (nil or not)
+	// is a valid empty proposal (i.e. an empty block), it is committed like any other proposal
 	Data []byte
 
 	// Time is the time to create the proposal
@@ -164,11 +529,21 @@ type Proposal struct {
 
 	// Hash is the digest of the data to seal
 	Hash []byte
+
+	// ParentHash is the hash of the proposal committed in the previous sequence, if the proposals are
+	// chained (see Config.ChainProposals). It is not part of the Hash the committed seals sign, unless the backend
+	// includes it, but the proposer seal covers it
+	ParentHash []byte
 }
 
-// Equal compares whether two proposals have the same hash
+// Equal compares whether two proposals have the same hash and extend the same parent
 func (p *Proposal) Equal(pp *Proposal) bool {
-	return bytes.Equal(p.Hash, pp.Hash)
+	return bytes.Equal(p.Hash, pp.Hash) && bytes.Equal(p.ParentHash, pp.ParentHash)
+}
+
+// IsEmpty checks if the proposal is an empty proposal, which is distinct from a missing (nil) one
+func (p *Proposal) IsEmpty() bool {
+	return len(p.Data) == 0
 }
 
 // Copy makes a copy of the Proposal
@@ -178,6 +553,9 @@ func (p *Proposal) Copy() *Proposal {
 
 	pp.Data = append([]byte{}, p.Data...)
 	pp.Hash = append([]byte{}, p.Hash...)
+	if p.ParentHash != nil {
+		pp.ParentHash = append([]byte{}, p.ParentHash...)
+	}
 	return pp
 }
 
@@ -200,12 +578,24 @@ type currentState struct {
 	// proposal stores information about the height proposal
 	proposal *Proposal
 
+	// proposalSeenAt is when the node first saw the proposal, it is kept while the proposal is locked
+	proposalSeenAt time.Time
+
+	// proposalHashes caches the hash of the proposal computed by the backend
+	proposalHashes proposalHashCache
+
 	// The selected proposer
 	proposer NodeID
 
+	// proposerView is the view the proposer was calculated for
+	proposerView View
+
 	// Current view
 	view *View
 
+	// sequenceStart is the time when the current sequence started
+	sequenceStart time.Time
+
 	// List of prepared messages
 	prepared map[NodeID]*MessageReq
 
@@ -215,23 +605,50 @@ type currentState struct {
 	// List of round change messages
 	roundMessages map[uint64]map[NodeID]*MessageReq
 
+	// roundMessagesLock protects the round change messages from the readers outside of the state machine
+	roundMessagesLock sync.RWMutex
+
+	// roundActions latches the round change actions already taken for every round
+	roundActions map[uint64]roundChangeAction
+
+	// aheadSenders are the validators which sent a preprepare for a future sequence in the current one
+	aheadSenders map[NodeID]struct{}
+
+	// unconfirmed is the sealed proposal of the current sequence which was inserted, but whose insert the backend
+	// did not confirm. It is not inserted again, only the confirmation is waited for in the next rounds
+	unconfirmed *SealedProposal
+
 	// Locked signals whether the proposal is locked
 	locked bool
 
+	// preparedCert is the prepared certificate of the locked proposal, it is set and cleared along with locked
+	// (nil if it was locked without a prepare quorum)
+	preparedCert *PreparedCertificate
+
 	// timeout tracks the time left for this round
-	timeout *time.Timer
+	timeout <-chan time.Time
 
 	// Describes whether there has been an error during the computation
 	err error
+
+	// lastErr is the most recent error, kept until the start of the next AcceptState
+	lastErr     error
+	lastErrLock sync.RWMutex
+
+	// viewLock protects the view, the proposer and the validator set from the readers outside of the state machine
+	viewLock sync.RWMutex
+
+	// lockedProposal is a copy of the locked proposal for the readers outside of the state machine (nil if not locked)
+	lockedProposal     *Proposal
+	lockedProposalLock sync.RWMutex
+
+	// stats accumulates the timing stats of the current sequence
+	stats sequenceStatsTracker
 }
 
 // newState creates a new state with reset round messages
 func newState() *currentState {
-	c := &currentState{
-		// this is a default value, it will get reset
-		// at every iteration
-		timeout: time.NewTimer(0),
-	}
+	c := &currentState{}
 	c.resetRoundMsgs()
 
 	return c
@@ -267,8 +684,12 @@ func (c *currentState) setState(s PbftState) {
 	atomic.StoreUint64(stateAddr, uint64(s))
 }
 
-// MaxFaultyNodes returns the maximum number of allowed faulty nodes (F), based on the current validator set
+// MaxFaultyNodes returns the maximum number of allowed faulty nodes (F), based on the current validator set.
+// If the validators are weighted, it is the maximum faulty voting power instead, that is up to 1/3 of the total voting power
 func (c *currentState) MaxFaultyNodes() int {
+	if weighted, ok := c.validators.(WeightedValidatorSet); ok {
+		return int(weighted.TotalVotingPower() / 3)
+	}
 	return MaxFaultyNodes(c.validators.Len())
 }
 
@@ -280,6 +701,95 @@ func (c *currentState) NumValid() int {
 	return QuorumSize(c.validators.Len()) - 1
 }
 
+// setView replaces the current view
+func (c *currentState) setView(view *View) {
+	c.viewLock.Lock()
+	c.view = view
+	c.viewLock.Unlock()
+}
+
+// getView returns a copy of the current view
+func (c *currentState) getView() *View {
+	c.viewLock.RLock()
+	defer c.viewLock.RUnlock()
+
+	if c.view == nil {
+		// there is no view until the backend is set
+		return &View{}
+	}
+	return &View{
+		Sequence: c.view.Sequence,
+		Round:    atomic.LoadUint64(&c.view.Round),
+	}
+}
+
+// getSequence returns the current sequence, or false if it is not set yet
+func (c *currentState) getSequence() (uint64, bool) {
+	c.viewLock.RLock()
+	defer c.viewLock.RUnlock()
+
+	if c.view == nil {
+		return 0, false
+	}
+	return c.view.Sequence, true
+}
+
+// setProposer sets the proposer of the current round
+func (c *currentState) setProposer(proposer NodeID) {
+	c.viewLock.Lock()
+	c.proposer = proposer
+	if c.view != nil {
+		c.proposerView = View{Sequence: c.view.Sequence, Round: atomic.LoadUint64(&c.view.Round)}
+	}
+	c.viewLock.Unlock()
+}
+
+// getCurrentProposer returns the proposer, or false if it was not calculated for the current view
+func (c *currentState) getCurrentProposer() (NodeID, bool) {
+	c.viewLock.RLock()
+	defer c.viewLock.RUnlock()
+
+	calculated := c.proposer != "" && c.view != nil &&
+		c.proposerView.Sequence == c.view.Sequence && c.proposerView.Round == atomic.LoadUint64(&c.view.Round)
+	return c.proposer, calculated
+}
+
+// setValidators replaces the validator set
+func (c *currentState) setValidators(validators ValidatorSet) {
+	c.viewLock.Lock()
+	c.validators = validators
+	c.viewLock.Unlock()
+}
+
+// getValidatorIDs returns a copy of the ids of the validator set, nil if the set does not expose them
+// (see SelectableValidatorSet). They are read from the same set the quorum is calculated with
+func (c *currentState) getValidatorIDs() []NodeID {
+	c.viewLock.RLock()
+	defer c.viewLock.RUnlock()
+
+	selectable, ok := c.validators.(SelectableValidatorSet)
+	if !ok {
+		return nil
+	}
+	return validNodeIDs(selectable.Validators())
+}
+
+// getValidators returns the validator set
+func (c *currentState) getValidators() ValidatorSet {
+	c.viewLock.RLock()
+	defer c.viewLock.RUnlock()
+
+	return c.validators
+}
+
+// getProposer returns the proposer of the current round
+func (c *currentState) getProposer() NodeID {
+	c.viewLock.RLock()
+	defer c.viewLock.RUnlock()
+
+	return c.proposer
+}
+
 // getErr returns the current error, if any, and consumes it
 func (c *currentState) getErr() error {
 	err := c.err
@@ -288,11 +798,101 @@ func (c *currentState) getErr() error {
 	return err
 }
 
-func (c *currentState) maxRound() (maxRound uint64, found bool) {
-	num := c.MaxFaultyNodes() + 1
+// setErr sets the current error and records it as the last error
+func (c *currentState) setErr(err error) {
+	c.err = err
+
+	c.lastErrLock.Lock()
+	c.lastErr = err
+	c.lastErrLock.Unlock()
+}
+
+// getLastErr returns the most recent error without consuming it
+func (c *currentState) getLastErr() error {
+	c.lastErrLock.RLock()
+	defer c.lastErrLock.RUnlock()
+
+	return c.lastErr
+}
+
+// clearLastErr clears the most recent error
+func (c *currentState) clearLastErr() {
+	c.lastErrLock.Lock()
+	c.lastErr = nil
+	c.lastErrLock.Unlock()
+}
+
+// votingPower returns the voting power of the validator (1 if the validators are not weighted)
+func (c *currentState) votingPower(id NodeID) uint64 {
+	if weighted, ok := c.validators.(WeightedValidatorSet); ok {
+		return weighted.VotingPower(id)
+	}
+	return 1
+}
+
+// messagesPower returns the sum of the voting power of the senders of the messages
+func (c *currentState) messagesPower(msgs map[NodeID]*MessageReq) (power uint64) {
+	for id := range msgs {
+		power += c.votingPower(id)
+	}
+	return
+}
 
+// sendersPower returns the sum of the voting power of the senders
+func (c *currentState) sendersPower(senders map[NodeID]struct{}) (power uint64) {
+	for id := range senders {
+		power += c.votingPower(id)
+	}
+	return
+}
+
+// hasQuorum checks if the voting power is enough to prepare or commit a proposal.
+// That is more than 2/3 of the total voting power if the validators are weighted,
+// or QuorumSize validators otherwise
+func (c *currentState) hasQuorum(power uint64) bool {
+	if weighted, ok := c.validators.(WeightedValidatorSet); ok {
+		return 3*power > 2*weighted.TotalVotingPower()
+	}
+	return power > uint64(c.NumValid())
+}
+
+// hasRoundChangeQuorum checks if the voting power of the round change messages for a round is enough to start it.
+// If no faulty node is tolerated, the round change waits for all the validators: a single validator
+// still needs its own message to start the new round, and no validator starts it on its own otherwise
+func (c *currentState) hasRoundChangeQuorum(power uint64) bool {
+	if _, ok := c.validators.(WeightedValidatorSet); ok {
+		return c.hasQuorum(power)
+	}
+	if c.MaxFaultyNodes() == 0 {
+		return power >= uint64(c.validators.Len())
+	}
+	return power >= uint64(c.NumValid())
+}
+
+// hasWeakQuorum checks if the voting power includes at least one honest validator, that is more than
+// MaxFaultyNodes: more than 1/3 of the total voting power if the validators are weighted, or F + 1 validators otherwise
+func (c *currentState) hasWeakQuorum(power uint64) bool {
+	return power > uint64(c.MaxFaultyNodes())
+}
+
+// hasPreparedQuorum checks if there are enough prepare messages to lock the proposal
+func (c *currentState) hasPreparedQuorum() bool {
+	return c.hasQuorum(c.messagesPower(c.prepared))
+}
+
+// hasCommittedQuorum checks if there are enough commit messages to commit the proposal
+func (c *currentState) hasCommittedQuorum() bool {
+	return c.hasQuorum(c.messagesPower(c.committed))
+}
+
+// roundMessagesPower returns the voting power of the round change messages for the round
+func (c *currentState) roundMessagesPower(round uint64) uint64 {
+	return c.messagesPower(c.roundMessages[round])
+}
+
+func (c *currentState) maxRound() (maxRound uint64, found bool) {
 	for currentRound, messages := range c.roundMessages {
-		if len(messages) < num {
+		if !c.hasWeakQuorum(c.messagesPower(messages)) {
 			continue
 		}
 		if maxRound < currentRound {
@@ -307,29 +907,137 @@ func (c *currentState) maxRound() (maxRound uint64, found bool) {
 func (c *currentState) resetRoundMsgs() {
 	c.prepared = map[NodeID]*MessageReq{}
 	c.committed = map[NodeID]*MessageReq{}
+
+	c.roundMessagesLock.Lock()
 	c.roundMessages = map[uint64]map[NodeID]*MessageReq{}
+	c.roundMessagesLock.Unlock()
+
+	c.roundActions = map[uint64]roundChangeAction{}
 }
 
 // CalcProposer calculates the proposer and sets it to the state
 func (c *currentState) CalcProposer() {
-	c.proposer = c.validators.CalcProposer(c.view.Round)
+	c.setProposer(c.validators.CalcProposer(c.view.Round))
 }
 
-func (c *currentState) lock() {
+// lock locks the current proposal with the certificate which proves it was prepared
+func (c *currentState) lock(cert *PreparedCertificate) {
 	c.locked = true
+	c.preparedCert = cert
+	c.setLockedProposal(c.proposal)
+}
+
+// lockCertificate returns the prepared certificate of the locked proposal, or nil if the state is not locked
+func (c *currentState) lockCertificate() *PreparedCertificate {
+	if !c.locked {
+		return nil
+	}
+	return c.preparedCert
 }
 
 func (c *currentState) unlock() {
 	c.proposal = nil
+	c.proposalSeenAt = time.Time{}
+	c.proposalHashes.reset()
 	c.locked = false
+	c.preparedCert = nil
+	c.setLockedProposal(nil)
+}
+
+// setLockedProposal keeps a copy of the locked proposal for the readers outside of the state machine
+func (c *currentState) setLockedProposal(proposal *Proposal) {
+	if proposal != nil {
+		proposal = proposal.Copy()
+	}
+
+	c.lockedProposalLock.Lock()
+	defer c.lockedProposalLock.Unlock()
+
+	c.lockedProposal = proposal
+}
+
+// getLockedProposal returns a copy of the locked proposal, and false if the state is not locked
+func (c *currentState) getLockedProposal() (*Proposal, bool) {
+	c.lockedProposalLock.RLock()
+	defer c.lockedProposalLock.RUnlock()
+
+	if c.lockedProposal == nil {
+		return nil, false
+	}
+	return c.lockedProposal.Copy(), true
+}
+
+// buildPreparedCertificate creates a prepared certificate out of the prepare messages received so far
+func (c *currentState) buildPreparedCertificate() *PreparedCertificate {
+	return c.buildCertificate(c.prepared)
+}
+
+// buildCommitCertificate builds the certificate of the current proposal out of the commit messages,
+// when the proposal is locked on a commit quorum without a prepare quorum
+func (c *currentState) buildCommitCertificate() *PreparedCertificate {
+	return c.buildCertificate(c.committed)
+}
+
+func (c *currentState) buildCertificate(msgs map[NodeID]*MessageReq) *PreparedCertificate {
+	cert := &PreparedCertificate{
+		Hash:            append([]byte{}, c.proposal.Hash...),
+		View:            c.view.Copy(),
+		PrepareMessages: make([]*MessageReq, 0, len(msgs)),
+	}
+	for _, msg := range msgs {
+		cert.PrepareMessages = append(cert.PrepareMessages, msg.Copy())
+	}
+	return cert
 }
 
 // cleanRound deletes the specific round messages
 func (c *currentState) cleanRound(round uint64) {
+	c.roundMessagesLock.Lock()
+	defer c.roundMessagesLock.Unlock()
+
 	delete(c.roundMessages, round)
 }
 
-// AddRoundMessage adds a message to the round, and returns the round message size
+// roundChangeVotes returns the sorted senders of the round change messages for every round
+func (c *currentState) roundChangeVotes() map[uint64][]NodeID {
+	c.roundMessagesLock.RLock()
+	defer c.roundMessagesLock.RUnlock()
+
+	votes := make(map[uint64][]NodeID, len(c.roundMessages))
+	for round, messages := range c.roundMessages {
+		senders := make([]NodeID, 0, len(messages))
+		for from := range messages {
+			senders = append(senders, from)
+		}
+		sort.Slice(senders, func(i, j int) bool { return senders[i] < senders[j] })
+		votes[round] = senders
+	}
+	return votes
+}
+
+// roundChangeAction is an action taken when the round change messages for a round reach a threshold
+type roundChangeAction uint8
+
+const (
+	// roundChangeQuorumAction starts the round once there is a round change quorum
+	roundChangeQuorumAction roundChangeAction = 1 << iota
+
+	// roundChangeWeakAction catches up with the round once there is a weak certificate
+	roundChangeWeakAction
+)
+
+// fireRoundAction latches the action for the round. It returns true only the first time
+// it is called for the round and the action, so that every action is taken once per round
+func (c *currentState) fireRoundAction(round uint64, action roundChangeAction) bool {
+	if c.roundActions[round]&action != 0 {
+		return false
+	}
+	c.roundActions[round] |= action
+	return true
+}
+
+// AddRoundMessage adds a message to the round, and returns the round message size.
+// Only the first message of every sender is kept for the round, the duplicates are ignored
 func (c *currentState) AddRoundMessage(msg *MessageReq) int {
 	if msg.Type != MessageReq_RoundChange {
 		return 0
@@ -360,7 +1068,7 @@ func (c *currentState) addCommitted(msg *MessageReq) {
 // addMessage adds a new message to one of the following message lists: committed, prepared, roundMessages
 func (c *currentState) addMessage(msg *MessageReq) {
 	addr := msg.From
-	if !c.validators.Includes(addr) {
+	if addr.Validate() != nil || !c.validators.Includes(addr) {
 		// only include messages from validators
 		return
 	}
@@ -370,12 +1078,19 @@ func (c *currentState) addMessage(msg *MessageReq) {
 	} else if msg.Type == MessageReq_Prepare {
 		c.prepared[addr] = msg
 	} else if msg.Type == MessageReq_RoundChange {
+		c.roundMessagesLock.Lock()
+		defer c.roundMessagesLock.Unlock()
+
 		view := msg.View
 		roundMessages, exists := c.roundMessages[view.Round]
 		if !exists {
 			roundMessages = map[NodeID]*MessageReq{}
 			c.roundMessages[view.Round] = roundMessages
 		}
+		if _, ok := roundMessages[addr]; ok {
+			// duplicate round change from the same sender
+			return
+		}
 		roundMessages[addr] = msg
 	}
 }
@@ -402,6 +1117,18 @@ type ValidatorSet interface {
 	Len() int
 }
 
+// WeightedValidatorSet is a ValidatorSet in which the validators have different voting power.
+// If the validator set implements it, the quorums are measured by voting power instead of by number of validators
+type WeightedValidatorSet interface {
+	ValidatorSet
+
+	// VotingPower returns the voting power of the validator
+	VotingPower(id NodeID) uint64
+
+	// TotalVotingPower returns the sum of the voting power of all the validators
+	TotalVotingPower() uint64
+}
+
 // StateNotifier enables custom logic encapsulation related to internal triggers within PBFT state machine (namely receiving timeouts).
 type StateNotifier interface {
 	// HandleTimeout notifies that a timeout occurred while getting next message
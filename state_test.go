@@ -5,6 +5,7 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	crand "crypto/rand"
+	"encoding/json"
 	"fmt"
 	mrand "math/rand"
 	"strconv"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func init() {
@@ -79,7 +81,7 @@ func Test_QuorumSize(t *testing.T) {
 		TotalNodesCount, QuorumSize int
 	}{
 		{1, 1},
-		{2, 1},
+		{2, 2},
 		{3, 1},
 		{4, 3},
 		{5, 3},
@@ -101,7 +103,7 @@ func TestState_ValidNodesCount(t *testing.T) {
 		TotalNodesCount, ValidNodesCount int
 	}{
 		{1, 0},
-		{2, 0},
+		{2, 1},
 		{3, 0},
 		{4, 2},
 		{5, 2},
@@ -163,6 +165,40 @@ func TestState_AddMessages(t *testing.T) {
 	}
 }
 
+func TestState_AddRoundMessage_Duplicates(t *testing.T) {
+	s := newState()
+	s.validators = newMockValidatorSet([]string{"A", "B", "C", "D"})
+
+	first := createMessage("A", MessageReq_RoundChange, 1)
+	assert.Equal(t, 1, s.AddRoundMessage(first))
+
+	// a duplicate from the same sender neither counts nor replaces the first message
+	assert.Equal(t, 1, s.AddRoundMessage(createMessage("A", MessageReq_RoundChange, 1)))
+	assert.Same(t, first, s.roundMessages[1]["A"])
+
+	// the same sender counts in another round
+	assert.Equal(t, 1, s.AddRoundMessage(createMessage("A", MessageReq_RoundChange, 2)))
+	assert.Equal(t, 2, s.AddRoundMessage(createMessage("B", MessageReq_RoundChange, 1)))
+}
+
+func TestState_FireRoundAction(t *testing.T) {
+	s := newState()
+
+	assert.True(t, s.fireRoundAction(1, roundChangeWeakAction))
+	assert.False(t, s.fireRoundAction(1, roundChangeWeakAction))
+
+	// the actions are latched independently for every round
+	assert.True(t, s.fireRoundAction(1, roundChangeQuorumAction))
+	assert.True(t, s.fireRoundAction(2, roundChangeWeakAction))
+
+	// cleaning the round messages does not reset the latch
+	s.cleanRound(1)
+	assert.False(t, s.fireRoundAction(1, roundChangeWeakAction))
+
+	s.resetRoundMsgs()
+	assert.True(t, s.fireRoundAction(1, roundChangeWeakAction))
+}
+
 func TestState_MaxRound_Found(t *testing.T) {
 	validatorsCount := 5
 	roundsCount := 6
@@ -224,6 +260,245 @@ func TestState_MaxRound_NotFound(t *testing.T) {
 	assert.Equal(t, false, found)
 }
 
+// weightedValString is a validator set in which every validator has its own voting power
+type weightedValString struct {
+	valString
+	powers map[NodeID]uint64
+}
+
+func newWeightedValidatorSet(powers map[NodeID]uint64, validatorIds ...NodeID) *weightedValString {
+	return &weightedValString{valString: validatorIds, powers: powers}
+}
+
+func (w *weightedValString) VotingPower(id NodeID) uint64 {
+	return w.powers[id]
+}
+
+func (w *weightedValString) TotalVotingPower() (total uint64) {
+	for _, id := range w.valString {
+		total += w.powers[id]
+	}
+	return
+}
+
+func TestNodeID_Validate(t *testing.T) {
+	cases := []struct {
+		id    NodeID
+		valid bool
+	}{
+		{"A", true},
+		{"node 1", true},
+		{"", false},
+		{"  ", false},
+		{" A", false},
+		{"A\n", false},
+	}
+	for _, c := range cases {
+		err := c.id.Validate()
+		if c.valid {
+			assert.NoError(t, err, "id %q", c.id)
+		} else {
+			assert.Error(t, err, "id %q", c.id)
+		}
+	}
+}
+
+func TestMessageReq_Validate(t *testing.T) {
+	hash := []byte{0x1}
+	cases := []struct {
+		name  string
+		msg   *MessageReq
+		valid bool
+	}{
+		{"preprepare", &MessageReq{Type: MessageReq_Preprepare, Hash: hash, Proposal: []byte{0x2}}, true},
+		{"preprepare with an empty proposal", &MessageReq{Type: MessageReq_Preprepare, Hash: hash, Proposal: []byte{}}, true},
+		{"preprepare without proposal", &MessageReq{Type: MessageReq_Preprepare, Hash: hash}, false},
+		{"preprepare without hash", &MessageReq{Type: MessageReq_Preprepare, Proposal: []byte{0x2}}, false},
+
+		{"prepare", &MessageReq{Type: MessageReq_Prepare, Hash: hash}, true},
+		{"prepare with a proposal", &MessageReq{Type: MessageReq_Prepare, Hash: hash, Proposal: []byte{0x2}}, false},
+		{"prepare with a seal", &MessageReq{Type: MessageReq_Prepare, Hash: hash, Seal: []byte{0x3}}, false},
+		{"prepare without hash", &MessageReq{Type: MessageReq_Prepare}, false},
+
+		{"commit", &MessageReq{Type: MessageReq_Commit, Hash: hash, Seal: []byte{0x3}}, true},
+		{"commit without seal", &MessageReq{Type: MessageReq_Commit, Hash: hash}, false},
+		{"commit with an empty seal", &MessageReq{Type: MessageReq_Commit, Hash: hash, Seal: []byte{}}, false},
+		{"commit without hash", &MessageReq{Type: MessageReq_Commit, Seal: []byte{0x3}}, false},
+
+		{"round change", &MessageReq{Type: MessageReq_RoundChange}, true},
+		{"round change with a proposal", &MessageReq{Type: MessageReq_RoundChange, Proposal: []byte{0x2}}, false},
+		{"round change with a seal", &MessageReq{Type: MessageReq_RoundChange, Seal: []byte{0x3}}, false},
+
+		{"sync request", &MessageReq{Type: MessageReq_SyncRequest, Heights: &HeightRange{From: 1, To: 2}}, true},
+		{"sync request without heights", &MessageReq{Type: MessageReq_SyncRequest}, false},
+		{"sync response", &MessageReq{Type: MessageReq_SyncResponse}, true},
+	}
+	for _, c := range cases {
+		err := c.msg.Validate()
+		if c.valid {
+			assert.NoError(t, err, c.name)
+		} else {
+			assert.Error(t, err, c.name)
+		}
+	}
+}
+
+// A malformed message is rejected before it is queued.
+func TestPbft_PushMessage_Malformed(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.setState(ValidateState)
+
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_Prepare, View: ViewMsg(1, 0), Proposal: mockProposal})
+	m.emitMsg(&MessageReq{From: "C", Type: MessageReq_Commit, View: ViewMsg(1, 0)})
+	assert.Empty(t, m.QueueDepths())
+
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+	m.emitMsg(&MessageReq{From: "C", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: []byte("C")})
+	assert.Equal(t, map[MsgType]int{MessageReq_Prepare: 1, MessageReq_Commit: 1}, m.QueueDepths())
+}
+
+func TestValidNodeIDs(t *testing.T) {
+	// valid ids
+	assert.Equal(t, []NodeID{"A", "B", "C"}, validNodeIDs([]NodeID{"A", "B", "C"}))
+
+	// empty ids are skipped
+	assert.Equal(t, []NodeID{"A", "B"}, validNodeIDs([]NodeID{"", "A", " ", "B"}))
+
+	// duplicated ids are skipped, keeping the first one
+	assert.Equal(t, []NodeID{"B", "A"}, validNodeIDs([]NodeID{"B", "A", "B", "A"}))
+
+	assert.Empty(t, validNodeIDs(nil))
+}
+
+func TestState_AddMessage_EmptyID(t *testing.T) {
+	s := newState()
+	s.validators = newMockValidatorSet([]string{"A", "B", ""})
+
+	// the validator set includes the empty id, but its messages are not counted
+	s.addMessage(createMessage("", MessageReq_Prepare))
+	s.addMessage(createMessage("", MessageReq_Commit))
+	assert.Equal(t, 0, s.numPrepared())
+	assert.Equal(t, 0, s.numCommitted())
+
+	s.addMessage(createMessage("A", MessageReq_Prepare))
+	assert.Equal(t, 1, s.numPrepared())
+}
+
+func TestState_Quorum_HeadCount(t *testing.T) {
+	s := newState()
+	s.validators = newMockValidatorSet([]string{"A", "B", "C", "D"})
+
+	// 4 validators tolerate 1 faulty node, the quorum is 3 validators
+	assert.False(t, s.hasQuorum(2))
+	assert.True(t, s.hasQuorum(3))
+	assert.False(t, s.hasWeakQuorum(1))
+	assert.True(t, s.hasWeakQuorum(2))
+
+	s.addMessage(createMessage("A", MessageReq_Prepare))
+	s.addMessage(createMessage("B", MessageReq_Prepare))
+	assert.False(t, s.hasPreparedQuorum())
+	s.addMessage(createMessage("C", MessageReq_Prepare))
+	assert.True(t, s.hasPreparedQuorum())
+}
+
+func TestState_Quorum_SmallValidatorSet(t *testing.T) {
+	// a single validator is a quorum on its own
+	s := newState()
+	s.validators = newMockValidatorSet([]string{"A"})
+	assert.True(t, s.hasQuorum(1))
+	assert.True(t, s.hasWeakQuorum(1))
+
+	// but a round change still requires at least one message
+	assert.False(t, s.hasRoundChangeQuorum(0))
+	assert.True(t, s.hasRoundChangeQuorum(1))
+
+	// two validators do not tolerate any faulty node either, but the quorum is
+	// still both of them so that each of them cannot commit on its own
+	s = newState()
+	s.validators = newMockValidatorSet([]string{"A", "B"})
+	assert.False(t, s.hasQuorum(1))
+	assert.True(t, s.hasQuorum(2))
+	assert.True(t, s.hasWeakQuorum(1))
+
+	assert.False(t, s.hasRoundChangeQuorum(1))
+	assert.True(t, s.hasRoundChangeQuorum(2))
+
+	// and the round change waits for all the validators while none is tolerated to fail
+	s.validators = newMockValidatorSet([]string{"A", "B", "C"})
+	assert.True(t, s.hasQuorum(1))
+	assert.False(t, s.hasRoundChangeQuorum(2))
+	assert.True(t, s.hasRoundChangeQuorum(3))
+}
+
+func TestState_Quorum_Weighted(t *testing.T) {
+	s := newState()
+	s.validators = newWeightedValidatorSet(map[NodeID]uint64{"A": 50, "B": 30, "C": 10, "D": 10}, "A", "B", "C", "D")
+
+	// two validators with most of the stake reach the quorum
+	s.addMessage(createMessage("A", MessageReq_Prepare))
+	s.addMessage(createMessage("B", MessageReq_Prepare))
+	assert.Equal(t, uint64(80), s.messagesPower(s.prepared))
+	assert.True(t, s.hasPreparedQuorum())
+
+	// three validators with little stake do not
+	s.addMessage(createMessage("B", MessageReq_Commit))
+	s.addMessage(createMessage("C", MessageReq_Commit))
+	s.addMessage(createMessage("D", MessageReq_Commit))
+	assert.Equal(t, uint64(50), s.messagesPower(s.committed))
+	assert.False(t, s.hasCommittedQuorum())
+
+	// exactly 2/3 of the stake is not enough
+	s.validators = newWeightedValidatorSet(map[NodeID]uint64{"A": 20, "B": 10, "C": 10, "D": 5, "E": 15}, "A", "B", "C", "D", "E")
+	assert.False(t, s.hasQuorum(40))
+	assert.True(t, s.hasQuorum(41))
+	assert.False(t, s.hasWeakQuorum(20))
+	assert.True(t, s.hasWeakQuorum(21))
+}
+
+func TestState_MaxFaultyNodes_Weighted(t *testing.T) {
+	s := newState()
+	s.validators = newWeightedValidatorSet(map[NodeID]uint64{"A": 40, "B": 20, "C": 20, "D": 10, "E": 10}, "A", "B", "C", "D", "E")
+
+	// the fault tolerance is in voting power, not in number of validators
+	assert.Equal(t, 33, s.MaxFaultyNodes())
+	assert.Equal(t, 1, MaxFaultyNodes(s.validators.Len()))
+
+	// the weak certificate is more than 1/3 of the voting power
+	assert.False(t, s.hasWeakQuorum(33))
+	assert.True(t, s.hasWeakQuorum(34))
+
+	// a single validator with more than 1/3 of the stake is a weak certificate
+	s.addMessage(createMessage("A", MessageReq_RoundChange, 1))
+	assert.True(t, s.hasWeakQuorum(s.roundMessagesPower(1)))
+
+	// while two validators (F + 1 by count) with less than 1/3 of the stake are not
+	s.addMessage(createMessage("D", MessageReq_RoundChange, 2))
+	s.addMessage(createMessage("E", MessageReq_RoundChange, 2))
+	assert.False(t, s.hasWeakQuorum(s.roundMessagesPower(2)))
+
+	// a total voting power multiple of 3
+	s.validators = newWeightedValidatorSet(map[NodeID]uint64{"A": 30, "B": 30, "C": 30}, "A", "B", "C")
+	assert.Equal(t, 30, s.MaxFaultyNodes())
+	assert.False(t, s.hasWeakQuorum(30))
+	assert.True(t, s.hasWeakQuorum(31))
+}
+
+func TestState_MaxRound_Weighted(t *testing.T) {
+	s := newState()
+	s.validators = newWeightedValidatorSet(map[NodeID]uint64{"A": 60, "B": 20, "C": 10, "D": 10}, "A", "B", "C", "D")
+
+	// a single validator with more than 1/3 of the stake is a weak certificate
+	s.addMessage(createMessage("A", MessageReq_RoundChange, 3))
+	// two validators with less than 1/3 of the stake are not
+	s.addMessage(createMessage("C", MessageReq_RoundChange, 5))
+	s.addMessage(createMessage("D", MessageReq_RoundChange, 5))
+
+	maxRound, found := s.maxRound()
+	assert.True(t, found)
+	assert.Equal(t, uint64(3), maxRound)
+}
+
 func TestState_AddRoundMessage(t *testing.T) {
 	s := newState()
 	s.validators = newMockValidatorSet([]string{"A", "B"})
@@ -278,6 +553,21 @@ func TestState_addCommitted(t *testing.T) {
 	assert.Empty(t, s.roundMessages)
 }
 
+func TestState_addPrepared_DuplicateSender(t *testing.T) {
+	s := newState()
+	s.validators = newMockValidatorSet([]string{"A", "B", "C", "D"})
+
+	for i := 0; i < 10; i++ {
+		s.addPrepared(createMessage("A", MessageReq_Prepare))
+	}
+	assert.Equal(t, 1, s.numPrepared())
+
+	for i := 0; i < 10; i++ {
+		s.addCommitted(createMessage("A", MessageReq_Commit))
+	}
+	assert.Equal(t, 1, s.numCommitted())
+}
+
 func TestState_Copy(t *testing.T) {
 	originalMsg := createMessage("A", MessageReq_Preprepare, 0)
 	copyMsg := originalMsg.Copy()
@@ -285,6 +575,67 @@ func TestState_Copy(t *testing.T) {
 	assert.Equal(t, originalMsg, copyMsg)
 }
 
+func TestState_RoundChangeCertificate_Encoding(t *testing.T) {
+	msg := createMessage("A", MessageReq_RoundChange, 2)
+	msg.Proposal = nil
+	msg.RoundChangeCertificate = &RoundChangeCertificate{
+		PreparedCertificate: &PreparedCertificate{
+			Hash: []byte{0x1},
+			View: ViewMsg(1, 1),
+			PrepareMessages: []*MessageReq{
+				{From: "A", Type: MessageReq_Prepare, View: ViewMsg(1, 1), Hash: []byte{0x1}},
+				{From: "B", Type: MessageReq_Prepare, View: ViewMsg(1, 1), Hash: []byte{0x1}},
+			},
+		},
+	}
+
+	data, err := json.Marshal(msg)
+	assert.NoError(t, err)
+
+	decoded := new(MessageReq)
+	assert.NoError(t, json.Unmarshal(data, decoded))
+	assert.Equal(t, msg, decoded)
+
+	copyMsg := msg.Copy()
+	assert.Equal(t, msg, copyMsg)
+	assert.NotSame(t, msg.RoundChangeCertificate.PreparedCertificate, copyMsg.RoundChangeCertificate.PreparedCertificate)
+	assert.NotSame(t, msg.RoundChangeCertificate.PreparedCertificate.PrepareMessages[0], copyMsg.RoundChangeCertificate.PreparedCertificate.PrepareMessages[0])
+
+	// messages without certificate are encoded as before
+	msg.RoundChangeCertificate = nil
+	data, err = json.Marshal(msg)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "roundChangeCertificate")
+}
+
+func TestState_PreparedCertificate_Encoding(t *testing.T) {
+	msg := createMessage("A", MessageReq_Preprepare, 1)
+	msg.Hash = []byte{0x1}
+	msg.PreparedCertificate = &PreparedCertificate{
+		Hash: []byte{0x1},
+		View: ViewMsg(1, 0),
+		PrepareMessages: []*MessageReq{
+			{From: "A", Type: MessageReq_Prepare, View: ViewMsg(1, 0), Hash: []byte{0x1}},
+			{From: "B", Type: MessageReq_Prepare, View: ViewMsg(1, 0), Hash: []byte{0x1}},
+		},
+	}
+
+	data, err := json.Marshal(msg)
+	assert.NoError(t, err)
+
+	decoded := new(MessageReq)
+	assert.NoError(t, json.Unmarshal(data, decoded))
+	assert.Equal(t, msg, decoded)
+
+	// tampering the copy does not affect the original certificate
+	copyMsg := msg.Copy()
+	assert.Equal(t, msg, copyMsg)
+	copyMsg.PreparedCertificate.Hash[0] = 0x2
+	copyMsg.PreparedCertificate.PrepareMessages[0].From = "C"
+	assert.Equal(t, []byte{0x1}, msg.PreparedCertificate.Hash)
+	assert.Equal(t, NodeID("A"), msg.PreparedCertificate.PrepareMessages[0].From)
+}
+
 func TestState_Lock_Unlock(t *testing.T) {
 	s := newState()
 	proposalData := make([]byte, 2)
@@ -293,13 +644,16 @@ func TestState_Lock_Unlock(t *testing.T) {
 		Data: proposalData,
 		Time: time.Now(),
 	}
-	s.lock()
+	cert := newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C")
+	s.lock(cert)
 	assert.True(t, s.locked)
 	assert.NotNil(t, s.proposal)
+	assert.Same(t, cert, s.lockCertificate())
 
 	s.unlock()
 	assert.False(t, s.locked)
 	assert.Nil(t, s.proposal)
+	assert.Nil(t, s.lockCertificate())
 }
 
 func TestState_GetSequence(t *testing.T) {
@@ -376,7 +730,8 @@ func (t *testerAccount) Sign(b []byte) ([]byte, error) {
 	if t.signFn != nil {
 		return t.signFn(b)
 	}
-	return nil, nil
+	// a commit message needs a seal
+	return append([]byte{}, b...), nil
 }
 
 type testerAccountPool struct {
@@ -494,3 +849,87 @@ func (v *valString) Includes(id NodeID) bool {
 func (v *valString) Len() int {
 	return len(*v)
 }
+
+func TestState_SameValidators(t *testing.T) {
+	set := newMockValidatorSet([]string{"A", "B", "C", "D"})
+	assert.True(t, sameValidators(set, set))
+	assert.True(t, sameValidators(set, newMockValidatorSet([]string{"A", "B", "C", "D"})))
+	assert.False(t, sameValidators(set, newMockValidatorSet([]string{"A", "B", "C"})))
+	assert.False(t, sameValidators(set, newMockValidatorSet([]string{"A", "B", "C", "E"})))
+	assert.False(t, sameValidators(set, nil))
+
+	// the selectable sets are compared by their validators
+	selectable := &selectableValString{valString: valString{"A", "B", "C", "D"}, lastProposer: "C"}
+	assert.True(t, sameValidators(selectable, &selectableValString{valString: valString{"A", "B", "C", "D"}}))
+	assert.False(t, sameValidators(selectable, &selectableValString{valString: valString{"A", "B", "D", "C"}}))
+
+	// and the weighted sets by their voting power too
+	weighted := newWeightedValidatorSet(map[NodeID]uint64{"A": 50, "B": 30, "C": 10, "D": 10}, "A", "B", "C", "D")
+	assert.True(t, sameValidators(weighted, newWeightedValidatorSet(map[NodeID]uint64{"A": 50, "B": 30, "C": 10, "D": 10}, "A", "B", "C", "D")))
+	assert.False(t, sameValidators(weighted, newWeightedValidatorSet(map[NodeID]uint64{"A": 10, "B": 30, "C": 10, "D": 10}, "A", "B", "C", "D")))
+	assert.False(t, sameValidators(weighted, set))
+
+	// a set changed in place is not the same as its copy
+	selectable = &selectableValString{valString: valString{"A", "B", "C", "D"}}
+	cc := copyValidatorSet(selectable)
+	assert.True(t, sameValidators(selectable, cc))
+	selectable.valString[3] = "E"
+	assert.False(t, sameValidators(selectable, cc))
+}
+
+// selectableWeightedValString is a weighted validator set which exposes its validators
+type selectableWeightedValString struct {
+	*weightedValString
+}
+
+func (v *selectableWeightedValString) Validators() []NodeID {
+	return v.valString
+}
+
+func (v *selectableWeightedValString) LastProposer() NodeID {
+	return NodeID("")
+}
+
+func TestState_CopyValidatorSet(t *testing.T) {
+	// the sets which do not expose their validators are not copied
+	set := newMockValidatorSet([]string{"A", "B", "C", "D"})
+	assert.Same(t, set, copyValidatorSet(set))
+
+	selectable := &selectableValString{valString: valString{"A", "B", "C", "D"}, lastProposer: "B"}
+	cc := copyValidatorSet(selectable)
+	_, weighted := cc.(WeightedValidatorSet)
+	assert.False(t, weighted)
+
+	// the backend changes its set in place
+	selectable.valString[0] = "E"
+	selectable.lastProposer = "C"
+
+	assert.Equal(t, 4, cc.Len())
+	assert.True(t, cc.Includes("A"))
+	assert.False(t, cc.Includes("E"))
+	assert.Equal(t, []NodeID{"A", "B", "C", "D"}, cc.(SelectableValidatorSet).Validators())
+	assert.Equal(t, NodeID("B"), cc.(SelectableValidatorSet).LastProposer())
+	for round := uint64(0); round < 8; round++ {
+		assert.Equal(t, valString{"A", "B", "C", "D"}[round%4], cc.CalcProposer(round))
+	}
+
+	// the voting power of a weighted set is copied too
+	powers := map[NodeID]uint64{"A": 50, "B": 30, "C": 10, "D": 10}
+	weightedSet := &selectableWeightedValString{weightedValString: newWeightedValidatorSet(powers, "A", "B", "C", "D")}
+	cc = copyValidatorSet(weightedSet)
+	powers["A"] = 10
+
+	require.Implements(t, (*WeightedValidatorSet)(nil), cc)
+	assert.Equal(t, uint64(50), cc.(WeightedValidatorSet).VotingPower("A"))
+	assert.Equal(t, uint64(100), cc.(WeightedValidatorSet).TotalVotingPower())
+	assert.False(t, sameValidators(weightedSet, cc))
+}
+
+func TestProposal_IsEmpty(t *testing.T) {
+	assert.True(t, (&Proposal{}).IsEmpty())
+	assert.True(t, (&Proposal{Data: []byte{}}).IsEmpty())
+	assert.False(t, (&Proposal{Data: []byte{0x1}}).IsEmpty())
+
+	// the copy of an empty proposal is empty too
+	assert.True(t, (&Proposal{}).Copy().IsEmpty())
+}
@@ -0,0 +1,90 @@
+package pbft
+
+import (
+	"sync"
+	"time"
+)
+
+// SequenceStats are the timing stats of a sequence
+type SequenceStats struct {
+	// Sequence is the sequence number
+	Sequence uint64
+
+	// Rounds is the number of rounds it took to finish the sequence
+	Rounds uint64
+
+	// Duration is the time from the start of the sequence until it finished
+	Duration time.Duration
+
+	// StateDurations is the time spent in each of the states
+	StateDurations map[PbftState]time.Duration
+}
+
+// Copy makes a copy of the stats
+func (s SequenceStats) Copy() SequenceStats {
+	ss := s
+	ss.StateDurations = make(map[PbftState]time.Duration, len(s.StateDurations))
+	for state, d := range s.StateDurations {
+		ss.StateDurations[state] = d
+	}
+	return ss
+}
+
+// sequenceStatsTracker accumulates the stats of the current sequence from the state transitions
+type sequenceStatsTracker struct {
+	current   SequenceStats
+	start     time.Time
+	state     PbftState
+	enteredAt time.Time
+	running   bool
+
+	// last are the stats of the last finished sequence
+	last     SequenceStats
+	lastLock sync.RWMutex
+}
+
+// enterState records the transition to a new state at the given time
+func (t *sequenceStatsTracker) enterState(s PbftState, sequence uint64, now time.Time) {
+	if s == AcceptState && (!t.running || t.current.Sequence != sequence) {
+		// a new sequence starts
+		t.current = SequenceStats{
+			Sequence:       sequence,
+			Rounds:         1,
+			StateDurations: map[PbftState]time.Duration{},
+		}
+		t.start = now
+		t.running = true
+		t.state = s
+		t.enteredAt = now
+		return
+	}
+	if !t.running {
+		return
+	}
+
+	t.current.StateDurations[t.state] += now.Sub(t.enteredAt)
+	if s == AcceptState && t.state != AcceptState {
+		// a new round starts
+		t.current.Rounds++
+	}
+	t.state = s
+	t.enteredAt = now
+
+	if s == DoneState || s == SyncState {
+		// the sequence finished
+		t.current.Duration = now.Sub(t.start)
+		t.running = false
+
+		t.lastLock.Lock()
+		t.last = t.current.Copy()
+		t.lastLock.Unlock()
+	}
+}
+
+// getLast returns the stats of the last finished sequence
+func (t *sequenceStatsTracker) getLast() SequenceStats {
+	t.lastLock.RLock()
+	defer t.lastLock.RUnlock()
+
+	return t.last.Copy()
+}
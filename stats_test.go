@@ -0,0 +1,90 @@
+package pbft
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequenceStats_MultiRound(t *testing.T) {
+	clock := newManualClock()
+
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.clock = clock
+
+	// simulate a sequence which fails the first round and commits in the second one
+	transitions := []struct {
+		state PbftState
+		d     time.Duration
+	}{
+		{AcceptState, time.Second},
+		{ValidateState, 2 * time.Second},
+		{RoundChangeState, time.Second},
+		{AcceptState, time.Second},
+		{ValidateState, 2 * time.Second},
+		{CommitState, 500 * time.Millisecond},
+		{DoneState, 0},
+	}
+	for _, tr := range transitions {
+		m.setState(tr.state)
+		clock.Advance(tr.d)
+	}
+
+	stats := m.LastSequenceStats()
+	assert.Equal(t, uint64(1), stats.Sequence)
+	assert.Equal(t, uint64(2), stats.Rounds)
+	assert.Equal(t, 7500*time.Millisecond, stats.Duration)
+	assert.Equal(t, map[PbftState]time.Duration{
+		AcceptState:      2 * time.Second,
+		ValidateState:    4 * time.Second,
+		RoundChangeState: time.Second,
+		CommitState:      500 * time.Millisecond,
+	}, stats.StateDurations)
+
+	// the stats are kept until the next sequence finishes
+	m.setSequence(2)
+	m.setState(AcceptState)
+	clock.Advance(time.Second)
+	assert.Equal(t, uint64(1), m.LastSequenceStats().Sequence)
+
+	m.setState(SyncState)
+	stats = m.LastSequenceStats()
+	assert.Equal(t, uint64(2), stats.Sequence)
+	assert.Equal(t, uint64(1), stats.Rounds)
+	assert.Equal(t, time.Second, stats.Duration)
+}
+
+func TestSequenceStats_Run(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C"}, "A")
+	defer m.Close()
+	m.setProposal(&Proposal{
+		Data: mockProposal,
+		Time: time.Now(),
+	})
+
+	for _, from := range []NodeID{"B", "C"} {
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+
+	m.Run(context.Background())
+	assert.True(t, m.IsState(DoneState))
+
+	stats := m.LastSequenceStats()
+	assert.Equal(t, uint64(1), stats.Sequence)
+	assert.Equal(t, uint64(1), stats.Rounds)
+
+	sum := time.Duration(0)
+	for _, d := range stats.StateDurations {
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+		sum += d
+	}
+	assert.Equal(t, stats.Duration, sum)
+
+	// the returned stats are a copy
+	stats.StateDurations[AcceptState] = time.Hour
+	assert.NotEqual(t, time.Hour, m.LastSequenceStats().StateDurations[AcceptState])
+}
@@ -0,0 +1,244 @@
+package pbft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+const (
+	// syncBatchSize is the maximum number of sealed proposals requested (and sent) in a sync message
+	syncBatchSize = 32
+
+	// syncResponsesSize is the number of sync responses buffered until the syncing node reads them
+	syncResponsesSize = 16
+)
+
+// errSyncNoProgress is returned by the peer sync if no node sent a valid proposal for the next height
+var errSyncNoProgress = errors.New("no node has the proposal for the next height")
+
+// pushSyncMessage handles a sync message. The requests are answered directly, whereas
+// the responses are handed over to the node syncing with the network (if any)
+func (p *Pbft) pushSyncMessage(msg *MessageReq) {
+	if msg.From == p.validator.NodeID() {
+		return
+	}
+	if err := p.msgVerifier(msg); err != nil {
+		// the sender is not authenticated, hence it is not reported
+		atomic.AddUint64(&p.invalidMsgs, 1)
+		p.metrics.MessageDropped(msg.Type)
+		p.logger.Error("failed to verify msg, dropping it", "from", msg.From, "err", err)
+		return
+	}
+	if p.rateLimited(msg) {
+		return
+	}
+
+	if msg.Type == MessageReq_SyncRequest {
+		p.serveSync(msg)
+		return
+	}
+	select {
+	case p.syncCh <- msg:
+	default:
+		// the node is not syncing (or it has plenty of responses already)
+		p.metrics.MessageDropped(msg.Type)
+	}
+}
+
+// serveSync sends to the sender of the request the proposals committed at the heights it is missing
+func (p *Pbft) serveSync(msg *MessageReq) {
+	transport, ok := p.transport.(DirectTransport)
+	if !ok {
+		p.logger.Debug("cannot answer the sync request, the transport does not support direct messages", "from", msg.From)
+		return
+	}
+
+	resp := &MessageReq{
+		Type: MessageReq_SyncResponse,
+		From: p.validator.NodeID(),
+		View: &View{Sequence: msg.Heights.From},
+	}
+	for height := msg.Heights.From; height <= msg.Heights.To && len(resp.SealedProposals) < syncBatchSize; height++ {
+		pp := p.forks.lookup(height)
+		if pp == nil {
+			break
+		}
+		resp.SealedProposals = append(resp.SealedProposals, pp.Copy())
+	}
+	if len(resp.SealedProposals) == 0 {
+		// the node is not ahead of the sender
+		return
+	}
+
+	p.logger.Debug("sending sealed proposals", "to", msg.From, "from", msg.Heights.From, "count", len(resp.SealedProposals))
+	if err := transport.Send(msg.From, resp); err != nil {
+		p.logger.Warn("failed to send the sync response", "to", msg.From, "err", err)
+	}
+}
+
+// syncFromPeers is the SyncFunc of the peer sync. It requests the proposals from the height of the backend
+// onwards to the other nodes, and inserts the ones sealed by a quorum of the validators
+func (p *Pbft) syncFromPeers(ctx context.Context) error {
+	start := p.backend.Height()
+	if err := p.syncParent(ctx, start); err != nil {
+		return err
+	}
+	height := start
+	for {
+		inserted, err := p.syncBatch(ctx, height)
+		if err != nil {
+			return err
+		}
+		height += inserted
+		if inserted < syncBatchSize {
+			// the node which answered has no more proposals
+			break
+		}
+	}
+
+	if height == start {
+		return errSyncNoProgress
+	}
+	p.logger.Info("synced with the network", "from", start, "to", height-1)
+	return nil
+}
+
+// syncBatch requests the proposals from the height onwards, and inserts the ones of the first
+// response with a valid proposal for the height. It returns the number of inserted proposals
+func (p *Pbft) syncBatch(ctx context.Context, height uint64) (uint64, error) {
+	return p.requestSealed(ctx, height, height+syncBatchSize-1, func(resp *MessageReq) uint64 {
+		return p.insertSynced(height, resp)
+	})
+}
+
+// syncParent fetches the proposal committed before the height if the proposals are chained and the node
+// does not know it (e.g. it restarted without a CommittedHistoryBackend), so that the synced proposals
+// can be checked against it. The backend already has it, so it is only verified and not inserted
+func (p *Pbft) syncParent(ctx context.Context, height uint64) error {
+	if !p.config.ChainProposals || height <= 1 || p.forks.lookup(height-1) != nil {
+		return nil
+	}
+	found, err := p.requestSealed(ctx, height-1, height-1, func(resp *MessageReq) uint64 {
+		if len(resp.SealedProposals) == 0 {
+			return 0
+		}
+		pp := resp.SealedProposals[0]
+		if err := p.verifySeals(pp, height-1, p.backend.ValidatorSet()); err != nil {
+			p.logger.Warn("invalid synced parent proposal", "from", resp.From, "height", height-1, "err", err)
+			return 0
+		}
+		p.forks.commit(pp)
+		return 1
+	})
+	if err != nil {
+		return err
+	}
+	if found == 0 {
+		return errUnknownParent
+	}
+	return nil
+}
+
+// requestSealed gossips a request for the sealed proposals of the heights, and passes the responses to the
+// handler until it accepts one of them (non zero result), the round timeout expires or the node stops
+func (p *Pbft) requestSealed(ctx context.Context, from, to uint64, handle func(*MessageReq) uint64) (uint64, error) {
+	// the responses to the previous requests are outdated
+	for len(p.syncCh) > 0 {
+		<-p.syncCh
+	}
+
+	req := &MessageReq{
+		Type:    MessageReq_SyncRequest,
+		From:    p.validator.NodeID(),
+		View:    &View{Sequence: from},
+		Heights: &HeightRange{From: from, To: to},
+	}
+	if err := p.transport.Gossip(req); err != nil {
+		return 0, fmt.Errorf("failed to request the sealed proposals: %w", err)
+	}
+
+	timer := p.clock.NewTimer(p.roundTimeout(0))
+	defer timer.Stop()
+
+	for {
+		select {
+		case resp := <-p.syncCh:
+			if n := handle(resp); n > 0 {
+				return n, nil
+			}
+		case <-timer.C():
+			return 0, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-p.closeCh:
+			return 0, ErrClosed
+		}
+	}
+}
+
+// insertSynced inserts the proposals of the response in order from the height, until one of them is invalid.
+// The validator set of the backend is used to verify the seals, so it has to follow the inserted proposals
+func (p *Pbft) insertSynced(height uint64, resp *MessageReq) uint64 {
+	inserted := uint64(0)
+	for _, pp := range resp.SealedProposals {
+		if err := p.verifySealedProposal(pp, height+inserted, p.backend.ValidatorSet()); err != nil {
+			p.logger.Warn("invalid synced proposal", "from", resp.From, "height", height+inserted, "err", err)
+			break
+		}
+		if err := p.insertWithRetry(pp); err != nil {
+			p.logger.Error("failed to insert synced proposal", "height", pp.Number, "err", err)
+			break
+		}
+		if err := p.confirmInserted(pp.Number); err != nil {
+			p.logger.Error("failed to confirm the insert of synced proposal", "height", pp.Number, "err", err)
+			break
+		}
+		p.forks.commit(pp)
+		inserted++
+	}
+	return inserted
+}
+
+// verifySealedProposal checks that the proposal was committed at the height by a quorum of the validators,
+// and that it extends the proposal committed before it if the proposals are chained
+func (p *Pbft) verifySealedProposal(pp *SealedProposal, height uint64, validators ValidatorSet) error {
+	if err := p.verifySeals(pp, height, validators); err != nil {
+		return err
+	}
+	return p.verifyParentHash(pp.Proposal, height)
+}
+
+// verifySeals checks that the proposal for the height carries the committed seals of a quorum of the validators,
+// either individually or aggregated into a single seal (see SealAggregator)
+func (p *Pbft) verifySeals(pp *SealedProposal, height uint64, validators ValidatorSet) error {
+	if pp == nil || pp.Proposal == nil {
+		return errNoProposal
+	}
+	if pp.Number != height {
+		return fmt.Errorf("proposal for height %d, expected %d", pp.Number, height)
+	}
+	if hash, ok := p.hashProposalData(pp.Proposal.Data); ok && !bytes.Equal(hash, pp.Proposal.Hash) {
+		return errors.New("proposal hash mismatch")
+	}
+	if pp.AggregatedSeal != nil {
+		return p.verifyAggregatedSeal(pp, validators)
+	}
+
+	seals := map[NodeID]CommittedSeal{}
+	for _, seal := range pp.CommittedSeals {
+		if !validators.Includes(seal.NodeID) {
+			continue
+		}
+		if err := p.backend.VerifyCommittedSeal(seal.NodeID, seal.Signature, pp.Proposal.Hash); err != nil {
+			continue
+		}
+		seals[seal.NodeID] = seal
+	}
+	if !hasValidatorsQuorum(validators, seals) {
+		return errInsufficientCommittedSeals
+	}
+	return nil
+}
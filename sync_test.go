@@ -0,0 +1,164 @@
+package pbft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSyncedProposal creates a proposal committed at the height, sealed by the given nodes (the seal of a node is its id)
+func newSyncedProposal(height uint64, sealers ...string) *SealedProposal {
+	pp := &SealedProposal{
+		Proposal: &Proposal{Data: mockProposal, Hash: digest},
+		Proposer: "A",
+		Number:   height,
+	}
+	for _, id := range sealers {
+		pp.CommittedSeals = append(pp.CommittedSeals, CommittedSeal{NodeID: NodeID(id), Signature: []byte(id)})
+	}
+	return pp
+}
+
+func TestPeerSync_Serve(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	transport := &mockDirectTransport{mockPbft: m}
+	m.transport = transport
+
+	for height := uint64(1); height <= 3; height++ {
+		m.forks.commit(newSyncedProposal(height, "A", "B", "C"))
+	}
+
+	m.PushMessage(&MessageReq{From: "D", Type: MessageReq_SyncRequest, View: ViewMsg(2, 0), Heights: &HeightRange{From: 2, To: 10}})
+
+	// the node sends the proposals it has from the first requested height
+	require.Len(t, transport.sent, 1)
+	resp := transport.sent[0]
+	assert.Equal(t, NodeID("D"), resp.to)
+	assert.Equal(t, MessageReq_SyncResponse, resp.msg.Type)
+	assert.Equal(t, NodeID("A"), resp.msg.From)
+	require.Len(t, resp.msg.SealedProposals, 2)
+	assert.Equal(t, uint64(2), resp.msg.SealedProposals[0].Number)
+	assert.Equal(t, uint64(3), resp.msg.SealedProposals[1].Number)
+
+	// a node which is not ahead does not answer
+	m.PushMessage(&MessageReq{From: "D", Type: MessageReq_SyncRequest, View: ViewMsg(4, 0), Heights: &HeightRange{From: 4, To: 10}})
+	assert.Len(t, transport.sent, 1)
+
+	// nor does it answer its own requests
+	m.PushMessage(&MessageReq{From: "A", Type: MessageReq_SyncRequest, View: ViewMsg(1, 0), Heights: &HeightRange{From: 1, To: 10}})
+	assert.Len(t, transport.sent, 1)
+
+	// the requests are not consensus messages
+	assert.Empty(t, m.QueueDepths())
+}
+
+func TestPeerSync_Sync(t *testing.T) {
+	var inserted []*SealedProposal
+	accounts := []string{"A", "B", "C", "D"}
+	backend := newMockBackend(accounts, nil).
+		HookVerifyCommittedSealHandler(func(from NodeID, seal, hash []byte) error {
+			// the seal of a node is its id
+			if !bytes.Equal(seal, []byte(from)) {
+				return errors.New("invalid seal")
+			}
+			return nil
+		}).
+		HookInsertHandler(func(pp *SealedProposal) error {
+			inserted = append(inserted, pp)
+			return nil
+		})
+	m := newMockPbft(t, accounts, "D", backend)
+	m.roundTimeout = func(uint64) time.Duration { return time.Hour }
+
+	requests := 0
+	m.gossipFn = func(msg *MessageReq) error {
+		require.Equal(t, MessageReq_SyncRequest, msg.Type)
+		require.Equal(t, uint64(1), msg.Heights.From)
+		requests++
+
+		// a byzantine node answers first with a proposal sealed by itself only
+		m.PushMessage(&MessageReq{From: "B", Type: MessageReq_SyncResponse, View: ViewMsg(1, 0), SealedProposals: []*SealedProposal{
+			newSyncedProposal(1, "B"),
+		}})
+		m.PushMessage(&MessageReq{From: "A", Type: MessageReq_SyncResponse, View: ViewMsg(1, 0), SealedProposals: []*SealedProposal{
+			newSyncedProposal(1, "A", "B", "C"),
+			newSyncedProposal(2, "A", "B", "D"),
+			// the sealed proposals have to follow the heights
+			newSyncedProposal(4, "A", "B", "C"),
+		}})
+		return nil
+	}
+
+	require.NoError(t, m.syncFromPeers(context.Background()))
+	assert.Equal(t, 1, requests)
+	require.Len(t, inserted, 2)
+	assert.Equal(t, uint64(1), inserted[0].Number)
+	assert.Equal(t, uint64(2), inserted[1].Number)
+
+	// the synced proposals are served to the other nodes as well
+	assert.NotNil(t, m.forks.lookup(2))
+}
+
+func TestPeerSync_NoProgress(t *testing.T) {
+	var inserted []*SealedProposal
+	accounts := []string{"A", "B", "C", "D"}
+	backend := newMockBackend(accounts, nil).
+		HookVerifyCommittedSealHandler(func(from NodeID, seal, hash []byte) error {
+			// the seal of a node is its id
+			if !bytes.Equal(seal, []byte(from)) {
+				return errors.New("invalid seal")
+			}
+			return nil
+		}).
+		HookInsertHandler(func(pp *SealedProposal) error {
+			inserted = append(inserted, pp)
+			return nil
+		})
+	m := newMockPbft(t, accounts, "D", backend)
+	m.roundTimeout = func(uint64) time.Duration { return time.Hour }
+	m.roundTimeout = func(uint64) time.Duration { return 10 * time.Millisecond }
+
+	m.gossipFn = func(msg *MessageReq) error {
+		// the seals of a node outside the validator set and the forged ones are not counted
+		m.PushMessage(&MessageReq{From: "A", Type: MessageReq_SyncResponse, View: ViewMsg(1, 0), SealedProposals: []*SealedProposal{
+			{
+				Proposal: &Proposal{Data: mockProposal, Hash: digest},
+				Number:   1,
+				CommittedSeals: []CommittedSeal{
+					{NodeID: "A", Signature: []byte("A")},
+					{NodeID: "B", Signature: []byte("A")},
+					{NodeID: "E", Signature: []byte("E")},
+				},
+			},
+		}})
+		return nil
+	}
+
+	assert.ErrorIs(t, m.syncFromPeers(context.Background()), errSyncNoProgress)
+	assert.Empty(t, inserted)
+}
+
+func TestPeerSync_Config(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A")
+
+	p := New(pool.get("A"), &mockPbft{})
+	assert.Nil(t, p.config.SyncFunc)
+
+	p = New(pool.get("A"), &mockPbft{}, WithPeerSync(true))
+	assert.NotNil(t, p.config.SyncFunc)
+
+	// a custom sync function takes precedence
+	called := false
+	p = New(pool.get("A"), &mockPbft{}, WithPeerSync(true), WithSyncFunc(func(context.Context) error {
+		called = true
+		return nil
+	}))
+	require.NoError(t, p.config.SyncFunc(context.Background()))
+	assert.True(t, called)
+}
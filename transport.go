@@ -5,3 +5,12 @@ type Transport interface {
 	// Gossip broadcast the message to the network
 	Gossip(msg *MessageReq) error
 }
+
+// DirectTransport is a Transport which can also deliver a message to a single node.
+// It is used if the transport implements it (i.e. to help a lagging node catch up)
+type DirectTransport interface {
+	Transport
+
+	// Send sends the message only to the given node
+	Send(to NodeID, msg *MessageReq) error
+}
@@ -0,0 +1,191 @@
+package pbft
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type directMessage struct {
+	to  NodeID
+	msg *MessageReq
+}
+
+// mockDirectTransport is a mockPbft transport which also records the direct messages
+type mockDirectTransport struct {
+	*mockPbft
+	sent []directMessage
+}
+
+func (m *mockDirectTransport) Send(to NodeID, msg *MessageReq) error {
+	m.sent = append(m.sent, directMessage{to: to, msg: msg})
+	return nil
+}
+
+func TestTransition_RoundChangeState_CatchUpLaggingNode(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	transport := &mockDirectTransport{mockPbft: m}
+	m.transport = transport
+
+	m.state.SetCurrentRound(2)
+	m.state.setErr(errFailedToInsertProposal)
+	m.setState(RoundChangeState)
+
+	// B and C are lagging behind, D is in the same round
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_RoundChange, View: ViewMsg(1, 1)})
+	m.emitMsg(&MessageReq{From: "C", Type: MessageReq_RoundChange, View: ViewMsg(1, 0)})
+	m.emitMsg(&MessageReq{From: "D", Type: MessageReq_RoundChange, View: ViewMsg(1, 3)})
+
+	m.Close()
+	m.runCycle(context.Background())
+
+	// only the lagging nodes get the round change of the current round
+	require.Len(t, transport.sent, 2)
+	for i, to := range []NodeID{"C", "B"} {
+		assert.Equal(t, to, transport.sent[i].to)
+		assert.Equal(t, MessageReq_RoundChange, transport.sent[i].msg.Type)
+		assert.Equal(t, NodeID("A"), transport.sent[i].msg.From)
+		assert.Equal(t, ViewMsg(1, 3), transport.sent[i].msg.View)
+	}
+}
+
+func TestTransition_RoundChangeState_NoDirectTransport(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+
+	m.state.SetCurrentRound(2)
+	m.state.setErr(errFailedToInsertProposal)
+	m.setState(RoundChangeState)
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_RoundChange, View: ViewMsg(1, 1)})
+
+	m.Close()
+	m.runCycle(context.Background())
+
+	// without direct messages, only the own round change is gossiped
+	require.Len(t, m.respMsg, 1)
+	assert.Equal(t, ViewMsg(1, 3), m.respMsg[0].View)
+}
+
+func TestLeaderAggregation_Validator(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	transport := &mockDirectTransport{mockPbft: m}
+	m.transport = transport
+	m.config.LeaderAggregation = true
+	m.roundTimeout = func(uint64) time.Duration { return time.Hour }
+	defer m.Close()
+	m.setState(AcceptState)
+
+	m.emitMsg(&MessageReq{From: "A", Type: MessageReq_Preprepare, View: ViewMsg(1, 0), Proposal: mockProposal})
+	m.runCycle(context.Background())
+	require.True(t, m.IsState(ValidateState))
+
+	// the prepare is sent to the proposer only
+	require.Len(t, transport.sent, 1)
+	assert.Equal(t, NodeID("A"), transport.sent[0].to)
+	assert.Equal(t, MessageReq_Prepare, transport.sent[0].msg.Type)
+	assert.Empty(t, m.respMsg)
+
+	// the proposer aggregated the prepares into its own
+	m.emitMsg(&MessageReq{From: "A", Type: MessageReq_Prepare, View: ViewMsg(1, 0), Votes: []*MessageReq{
+		{From: "C", Type: MessageReq_Prepare, View: ViewMsg(1, 0), Hash: digest},
+		{From: "D", Type: MessageReq_Prepare, View: ViewMsg(1, 0), Hash: digest},
+	}})
+	m.emitMsg(&MessageReq{From: "A", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest, Votes: []*MessageReq{
+		{From: "C", Type: MessageReq_Commit, View: ViewMsg(1, 0), Hash: digest, Seal: digest},
+		{From: "D", Type: MessageReq_Commit, View: ViewMsg(1, 0), Hash: digest, Seal: digest},
+	}})
+	m.runCycle(context.Background())
+
+	// the aggregated commits are a quorum (even before all the prepares are read)
+	assert.True(t, m.IsState(CommitState))
+	assert.True(t, m.IsLocked())
+	for _, from := range []NodeID{"A", "C", "D"} {
+		assert.Contains(t, m.state.committed, from)
+	}
+
+	// the commit is sent to the proposer only as well
+	require.Len(t, transport.sent, 2)
+	assert.Equal(t, NodeID("A"), transport.sent[1].to)
+	assert.Equal(t, MessageReq_Commit, transport.sent[1].msg.Type)
+	assert.Empty(t, m.respMsg)
+}
+
+func TestLeaderAggregation_Proposer(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	transport := &mockDirectTransport{mockPbft: m}
+	m.transport = transport
+	m.config.LeaderAggregation = true
+	m.roundTimeout = func(uint64) time.Duration { return time.Hour }
+	defer m.Close()
+	m.state.proposer = "A"
+	m.setState(ValidateState)
+
+	for _, from := range []NodeID{"A", "B", "C"} {
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+	}
+	for _, from := range []NodeID{"B", "C"} {
+		m.emitMsg(&MessageReq{From: from, Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest})
+	}
+	m.runCycle(context.Background())
+	require.True(t, m.IsState(CommitState))
+
+	// the proposer does not send its votes on their own, it gossips the aggregated ones
+	assert.Empty(t, transport.sent)
+	require.Len(t, m.respMsg, 2)
+
+	prepare := m.respMsg[0]
+	assert.Equal(t, MessageReq_Prepare, prepare.Type)
+	assert.Equal(t, NodeID("A"), prepare.From)
+	require.Len(t, prepare.Votes, 2)
+	assert.Equal(t, NodeID("B"), prepare.Votes[0].From)
+	assert.Equal(t, NodeID("C"), prepare.Votes[1].From)
+
+	commit := m.respMsg[1]
+	assert.Equal(t, MessageReq_Commit, commit.Type)
+	assert.Equal(t, NodeID("A"), commit.From)
+	require.Len(t, commit.Votes, 2)
+	for _, vote := range commit.Votes {
+		assert.Equal(t, MessageReq_Commit, vote.Type)
+		assert.Equal(t, digest, vote.Seal)
+	}
+}
+
+func TestLeaderAggregation_InvalidVotes(t *testing.T) {
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "B")
+	defer m.Close()
+	m.config.LeaderAggregation = true
+	m.blacklist = newPeerBlacklist(1, time.Minute)
+	m.msgVerifier = func(msg *MessageReq) error {
+		if msg.From == "C" {
+			return errors.New("invalid seal")
+		}
+		return nil
+	}
+
+	// the bundles with nested votes, or votes of another type or view, are rejected as a whole
+	for _, vote := range []*MessageReq{
+		{From: "D", Type: MessageReq_Commit, View: ViewMsg(1, 0), Hash: digest, Seal: digest, Votes: []*MessageReq{
+			{From: "C", Type: MessageReq_Commit, View: ViewMsg(1, 0), Hash: digest, Seal: digest},
+		}},
+		{From: "D", Type: MessageReq_Prepare, View: ViewMsg(1, 0), Hash: digest},
+		{From: "D", Type: MessageReq_Commit, View: ViewMsg(1, 1), Hash: digest, Seal: digest},
+	} {
+		m.blacklist = newPeerBlacklist(1, time.Minute)
+		m.emitMsg(&MessageReq{From: "A", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest, Votes: []*MessageReq{vote}})
+		assert.Equal(t, 0, m.msgQueue.len())
+		assert.True(t, m.blacklist.isBlacklisted("A", m.clock.Now()))
+	}
+
+	// the invalid votes are dropped, and reported as invalid messages of the aggregator which relayed them
+	m.blacklist = newPeerBlacklist(1, time.Minute)
+	m.emitMsg(&MessageReq{From: "A", Type: MessageReq_Commit, View: ViewMsg(1, 0), Seal: digest, Votes: []*MessageReq{
+		{From: "C", Type: MessageReq_Commit, View: ViewMsg(1, 0), Hash: digest, Seal: digest},
+		{From: "D", Type: MessageReq_Commit, View: ViewMsg(1, 0), Hash: digest, Seal: digest},
+	}})
+	assert.Equal(t, 2, m.msgQueue.len())
+	assert.True(t, m.blacklist.isBlacklisted("A", m.clock.Now()))
+	assert.False(t, m.blacklist.isBlacklisted("C", m.clock.Now()))
+}
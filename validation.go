@@ -0,0 +1,84 @@
+package pbft
+
+import (
+	"container/list"
+	"errors"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrValidationPending is returned by the backend when validating a proposal which cannot be validated yet
+// (i.e. the backend misses a dependency). The node keeps waiting in AcceptState and validates the proposal
+// again on the next message, up to ValidationRetries times, instead of changing the round
+var ErrValidationPending = errors.New("proposal validation pending")
+
+// validationCache is a bounded LRU set of the hashes of the proposals which passed the validation,
+// so that a proposal proposed again in a later round (i.e. a locked one) is not validated twice.
+// Only the successful validations are cached, a failure may be transient
+type validationCache struct {
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+// newValidationCache creates a cache of the given size (0 disables it)
+func newValidationCache(size int) *validationCache {
+	return &validationCache{
+		size:  size,
+		items: map[string]*list.Element{},
+		order: list.New(),
+	}
+}
+
+// contains checks if the proposal with the hash was validated, and marks it as the most recently used
+func (c *validationCache) contains(hash []byte) bool {
+	elem, ok := c.items[string(hash)]
+	if ok {
+		c.order.MoveToFront(elem)
+	}
+	return ok
+}
+
+// add records that the proposal with the hash was validated, evicting the least recently used one if the cache is full
+func (c *validationCache) add(hash []byte) {
+	if c.size <= 0 {
+		return
+	}
+	key := string(hash)
+	if elem, ok := c.items[key]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(string))
+	}
+	c.items[key] = c.order.PushFront(key)
+}
+
+// len returns the number of cached validations
+func (c *validationCache) len() int {
+	return c.order.Len()
+}
+
+// validateProposalWithRetry validates the proposal, and validates it again every time a new message
+// is pushed while the backend reports the validation as pending. It returns false if the state machine has to stop
+func (p *Pbft) validateProposalWithRetry(span trace.Span, proposal *Proposal) (bool, error) {
+	err := p.validateProposal(proposal)
+	for retry := uint64(1); errors.Is(err, ErrValidationPending) && retry <= p.config.ValidationRetries; retry++ {
+		p.logger.Info("proposal validation pending, waiting", "retry", retry, "retries", p.config.ValidationRetries)
+		span.AddEvent("ValidationPending")
+
+		updated, ok := p.waitForUpdate(span)
+		if !ok {
+			return false, err
+		}
+		if !updated {
+			// the round timed out before the backend could validate the proposal
+			return true, err
+		}
+		err = p.validateProposal(proposal)
+	}
+	return true, err
+}
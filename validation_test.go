@@ -0,0 +1,276 @@
+package pbft
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidationCache(t *testing.T) {
+	c := newValidationCache(2)
+
+	c.add([]byte{0x1})
+	c.add([]byte{0x2})
+	assert.True(t, c.contains([]byte{0x1}))
+
+	// 0x2 is the least recently used one
+	c.add([]byte{0x3})
+	assert.Equal(t, 2, c.len())
+	assert.True(t, c.contains([]byte{0x1}))
+	assert.False(t, c.contains([]byte{0x2}))
+	assert.True(t, c.contains([]byte{0x3}))
+
+	// adding a cached hash again does not evict anything
+	c.add([]byte{0x3})
+	assert.Equal(t, 2, c.len())
+	assert.True(t, c.contains([]byte{0x1}))
+}
+
+func TestValidationCache_Disabled(t *testing.T) {
+	c := newValidationCache(0)
+	c.add([]byte{0x1})
+
+	assert.False(t, c.contains([]byte{0x1}))
+	assert.Equal(t, 0, c.len())
+}
+
+// acceptProposal runs AcceptState for the round with the preprepare of its proposer
+func acceptProposal(m *mockPbft, round uint64, data []byte, cert *PreparedCertificate) {
+	m.state.view = ViewMsg(1, round)
+	m.setState(AcceptState)
+	m.emitMsg(&MessageReq{
+		From:                m.state.validators.CalcProposer(round),
+		Type:                MessageReq_Preprepare,
+		Proposal:            data,
+		Hash:                sha1Hash(data),
+		View:                ViewMsg(1, round),
+		PreparedCertificate: cert,
+	})
+	m.runCycle(context.Background())
+}
+
+func TestTransition_AcceptState_ValidationCached(t *testing.T) {
+	accounts := []string{"A", "B", "C"}
+	m := newMockPbft(t, accounts, "C")
+	m.validations = newValidationCache(4)
+
+	validations := 0
+	backend := &mockHashingBackend{mockBackend: newMockBackend(accounts, m).HookValidateHandler(func(*Proposal) error {
+		validations++
+		return nil
+	})}
+	require.NoError(t, m.SetBackend(backend))
+
+	acceptProposal(m, 0, mockProposal, nil)
+	require.True(t, m.IsState(ValidateState))
+	assert.Equal(t, 1, validations)
+
+	// the node locks on the proposal, and the proposer of the next round proposes it again
+	m.state.lock(newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B", "C"))
+	hash := sha1Hash(mockProposal)
+	acceptProposal(m, 1, mockProposal, newPreparedCertificate(hash, ViewMsg(1, 0), "A", "B"))
+
+	require.True(t, m.IsState(ValidateState))
+	assert.True(t, m.state.IsLocked())
+	assert.Equal(t, 1, validations)
+
+	// another proposal is validated
+	m.state.unlock()
+	acceptProposal(m, 3, mockProposal1, nil)
+	require.True(t, m.IsState(ValidateState))
+	assert.Equal(t, 2, validations)
+}
+
+func TestTransition_AcceptState_ValidationFailureNotCached(t *testing.T) {
+	accounts := []string{"A", "B", "C"}
+	m := newMockPbft(t, accounts, "C")
+	m.validations = newValidationCache(4)
+
+	validations := 0
+	backend := &mockHashingBackend{mockBackend: newMockBackend(accounts, m).HookValidateHandler(func(*Proposal) error {
+		validations++
+		return errors.New("invalid")
+	})}
+	require.NoError(t, m.SetBackend(backend))
+
+	acceptProposal(m, 0, mockProposal, nil)
+	assert.True(t, m.IsState(RoundChangeState))
+
+	acceptProposal(m, 1, mockProposal, nil)
+	assert.True(t, m.IsState(RoundChangeState))
+	assert.Equal(t, 2, validations)
+	assert.Equal(t, 0, m.validations.len())
+}
+
+func TestTransition_AcceptState_ValidationNotCachedWithoutHasher(t *testing.T) {
+	validations := 0
+	backend := newMockBackend([]string{"A", "B", "C"}, nil).HookValidateHandler(func(*Proposal) error {
+		validations++
+		return nil
+	})
+	m := newMockPbft(t, []string{"A", "B", "C"}, "C", backend)
+	m.validations = newValidationCache(4)
+
+	// without a hasher the hash of the message cannot be trusted as the key of the cache
+	acceptProposal(m, 0, mockProposal, nil)
+	acceptProposal(m, 1, mockProposal, nil)
+	assert.Equal(t, 2, validations)
+	assert.Equal(t, 0, m.validations.len())
+}
+
+func TestPbft_ValidationCache_Config(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A")
+
+	// disabled by default
+	p := New(pool.get("A"), &mockPbft{})
+	assert.Equal(t, 0, p.validations.size)
+
+	p = New(pool.get("A"), &mockPbft{}, WithValidationCache(16))
+	assert.Equal(t, 16, p.validations.size)
+}
+
+// runAcceptStateWithMessages runs AcceptState while a message is pushed every millisecond,
+// every one of them lets the node validate a pending proposal again
+func runAcceptStateWithMessages(m *mockPbft) {
+	done := make(chan struct{})
+	go func() {
+		m.runCycle(m.ctx)
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(time.Millisecond):
+			m.PushMessageInternal(&MessageReq{
+				From: "B",
+				Type: MessageReq_Prepare,
+				Hash: digest,
+				View: ViewMsg(1, 0),
+			})
+		}
+	}
+}
+
+func TestTransition_AcceptState_ValidationPending(t *testing.T) {
+	accounts := []string{"A", "B", "C"}
+	validations := 0
+	backend := newMockBackend(accounts, nil).HookValidateHandler(func(*Proposal) error {
+		// the validation is pending for the first 2 validations
+		validations++
+		if validations <= 2 {
+			return ErrValidationPending
+		}
+		return nil
+	})
+	m := newMockPbft(t, accounts, "C", backend)
+	m.setTimeout(time.Hour)
+	m.state.view = ViewMsg(1, 0)
+	m.setState(AcceptState)
+	m.emitMsg(&MessageReq{
+		From:     "A",
+		Type:     MessageReq_Preprepare,
+		Proposal: mockProposal,
+		View:     ViewMsg(1, 0),
+	})
+
+	runAcceptStateWithMessages(m)
+
+	// the proposal is accepted once the backend validates it, without a round change
+	require.True(t, m.IsState(ValidateState))
+	assert.Equal(t, 3, validations)
+	assert.Equal(t, uint64(0), m.state.GetCurrentRound())
+	assert.Equal(t, mockProposal, m.state.proposal.Data)
+}
+
+func TestTransition_AcceptState_ValidationPendingRetriesExhausted(t *testing.T) {
+	accounts := []string{"A", "B", "C"}
+	validations := 0
+	backend := newMockBackend(accounts, nil).HookValidateHandler(func(*Proposal) error {
+		// the validation is pending for the first 10 validations
+		validations++
+		if validations <= 10 {
+			return ErrValidationPending
+		}
+		return nil
+	})
+	m := newMockPbft(t, accounts, "C", backend)
+	m.setTimeout(time.Hour)
+	m.state.view = ViewMsg(1, 0)
+	m.setState(AcceptState)
+	m.emitMsg(&MessageReq{
+		From:     "A",
+		Type:     MessageReq_Preprepare,
+		Proposal: mockProposal,
+		View:     ViewMsg(1, 0),
+	})
+	m.config.ValidationRetries = 2
+
+	runAcceptStateWithMessages(m)
+
+	assert.True(t, m.IsState(RoundChangeState))
+	assert.Equal(t, 3, validations)
+}
+
+func TestTransition_AcceptState_ValidationPendingTimeout(t *testing.T) {
+	accounts := []string{"A", "B", "C"}
+	validations := 0
+	backend := newMockBackend(accounts, nil).HookValidateHandler(func(*Proposal) error {
+		// the validation is pending for the first 10 validations
+		validations++
+		if validations <= 10 {
+			return ErrValidationPending
+		}
+		return nil
+	})
+	m := newMockPbft(t, accounts, "C", backend)
+	m.setTimeout(time.Hour)
+	m.state.view = ViewMsg(1, 0)
+	m.setState(AcceptState)
+	m.emitMsg(&MessageReq{
+		From:     "A",
+		Type:     MessageReq_Preprepare,
+		Proposal: mockProposal,
+		View:     ViewMsg(1, 0),
+	})
+	m.setTimeout(time.Millisecond)
+
+	// no message arrives before the round times out
+	m.runCycle(m.ctx)
+
+	assert.True(t, m.IsState(RoundChangeState))
+	assert.Equal(t, 1, validations)
+}
+
+func TestTransition_AcceptState_ValidationFailureRoundChange(t *testing.T) {
+	validations := 0
+	backend := newMockBackend([]string{"A", "B", "C"}, nil).HookValidateHandler(func(*Proposal) error {
+		validations++
+		return errors.New("invalid")
+	})
+	m := newMockPbft(t, []string{"A", "B", "C"}, "C", backend)
+	m.setTimeout(time.Hour)
+
+	// a hard failure changes the round right away, without waiting for another message
+	acceptProposal(m, 0, mockProposal, nil)
+
+	assert.True(t, m.IsState(RoundChangeState))
+	assert.Equal(t, 1, validations)
+}
+
+func TestPbft_ValidationRetries_Config(t *testing.T) {
+	pool := newTesterAccountPool()
+	pool.add("A")
+
+	p := New(pool.get("A"), &mockPbft{})
+	assert.Equal(t, uint64(defaultValidationRetries), p.config.ValidationRetries)
+
+	p = New(pool.get("A"), &mockPbft{}, WithValidationRetries(5))
+	assert.Equal(t, uint64(5), p.config.ValidationRetries)
+}
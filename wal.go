@@ -0,0 +1,187 @@
+package pbft
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// WAL is a write-ahead log used to persist the consensus state of the current sequence,
+// so that a node which crashes mid-round can recover its lock after a restart
+type WAL interface {
+	// Append persists a new entry at the end of the log
+	Append(entry *WALEntry) error
+	// Entries returns all the entries in the log in the order they were appended
+	Entries() ([]*WALEntry, error)
+	// Truncate removes all the entries from the log
+	Truncate() error
+}
+
+type WALEntryType int
+
+const (
+	// WALSequence marks the start of a new sequence. It is always the first entry in the log
+	WALSequence WALEntryType = iota
+	// WALLock records that the proposal was locked, together with the proof it was prepared
+	WALLock
+	// WALUnlock records that the locked proposal was released
+	WALUnlock
+	// WALMessage records a prepare or commit message accepted by the state machine
+	WALMessage
+)
+
+func (t WALEntryType) String() string {
+	switch t {
+	case WALSequence:
+		return "Sequence"
+	case WALLock:
+		return "Lock"
+	case WALUnlock:
+		return "Unlock"
+	case WALMessage:
+		return "Message"
+	default:
+		panic(fmt.Sprintf("BUG: Bad wal entry type %d", t))
+	}
+}
+
+// WALEntry is a single record of the write-ahead log
+type WALEntry struct {
+	Type                WALEntryType         `json:"type"`
+	Sequence            uint64               `json:"sequence,omitempty"`
+	Proposal            *Proposal            `json:"proposal,omitempty"`
+	PreparedCertificate *PreparedCertificate `json:"preparedCertificate,omitempty"`
+	Message             *MessageReq          `json:"message,omitempty"`
+}
+
+// NoopWAL is a null object implementation of WAL interface
+type NoopWAL struct {
+}
+
+// Append implements WAL interface
+func (n *NoopWAL) Append(entry *WALEntry) error { return nil }
+
+// Entries implements WAL interface
+func (n *NoopWAL) Entries() ([]*WALEntry, error) { return nil, nil }
+
+// Truncate implements WAL interface
+func (n *NoopWAL) Truncate() error { return nil }
+
+// MemoryWAL is a WAL which keeps the entries in memory. It survives the restart
+// of the state machine but not the restart of the process
+type MemoryWAL struct {
+	lock    sync.Mutex
+	entries []*WALEntry
+}
+
+// NewMemoryWAL creates an empty in-memory WAL
+func NewMemoryWAL() *MemoryWAL {
+	return &MemoryWAL{}
+}
+
+// Append implements WAL interface
+func (m *MemoryWAL) Append(entry *WALEntry) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.entries = append(m.entries, entry)
+	return nil
+}
+
+// Entries implements WAL interface
+func (m *MemoryWAL) Entries() ([]*WALEntry, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	entries := make([]*WALEntry, len(m.entries))
+	copy(entries, m.entries)
+	return entries, nil
+}
+
+// Truncate implements WAL interface
+func (m *MemoryWAL) Truncate() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.entries = nil
+	return nil
+}
+
+// FileWAL is a WAL which stores the entries as JSON lines in a file.
+// Every append is synced to disk before returning
+type FileWAL struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewFileWAL opens (or creates) the WAL stored in the file at the given path
+func NewFileWAL(path string) (*FileWAL, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileWAL{file: file}, nil
+}
+
+// Append implements WAL interface
+func (f *FileWAL) Append(entry *WALEntry) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := f.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+// Entries implements WAL interface
+func (f *FileWAL) Entries() ([]*WALEntry, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if _, err := f.file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	entries := []*WALEntry{}
+	scanner := bufio.NewScanner(f.file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		entry := &WALEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			// a partially written entry at the end of the log means
+			// the node crashed while appending it, ignore it
+			break
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Truncate implements WAL interface
+func (f *FileWAL) Truncate() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.file.Truncate(0); err != nil {
+		return err
+	}
+	return f.file.Sync()
+}
+
+// Close closes the file backing the WAL
+func (f *FileWAL) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.file.Close()
+}
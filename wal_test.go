@@ -0,0 +1,137 @@
+package pbft
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testWALEntries() []*WALEntry {
+	return []*WALEntry{
+		{Type: WALSequence, Sequence: 1},
+		{Type: WALLock, Proposal: &Proposal{Data: mockProposal, Hash: digest}, PreparedCertificate: newPreparedCertificate(digest, ViewMsg(1, 0), "A", "B")},
+		{Type: WALMessage, Message: &MessageReq{Type: MessageReq_Commit, From: "A", View: ViewMsg(1, 0), Hash: digest, Seal: digest}},
+		{Type: WALUnlock},
+	}
+}
+
+func testWAL(t *testing.T, wal WAL) {
+	entries, err := wal.Entries()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	for _, entry := range testWALEntries() {
+		require.NoError(t, wal.Append(entry))
+	}
+
+	entries, err = wal.Entries()
+	require.NoError(t, err)
+	assert.Equal(t, len(testWALEntries()), len(entries))
+	for i, expected := range testWALEntries() {
+		assert.Equal(t, expected.Type, entries[i].Type)
+		assert.Equal(t, expected.Sequence, entries[i].Sequence)
+	}
+	assert.Equal(t, digest, entries[1].Proposal.Hash)
+	assert.Len(t, entries[1].PreparedCertificate.PrepareMessages, 2)
+	assert.Equal(t, NodeID("A"), entries[2].Message.From)
+
+	require.NoError(t, wal.Truncate())
+	entries, err = wal.Entries()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestMemoryWAL(t *testing.T) {
+	testWAL(t, NewMemoryWAL())
+}
+
+func TestFileWAL(t *testing.T) {
+	wal, err := NewFileWAL(filepath.Join(t.TempDir(), "wal"))
+	require.NoError(t, err)
+	defer wal.Close()
+
+	testWAL(t, wal)
+}
+
+func TestFileWAL_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal")
+
+	wal, err := NewFileWAL(path)
+	require.NoError(t, err)
+	for _, entry := range testWALEntries() {
+		require.NoError(t, wal.Append(entry))
+	}
+	require.NoError(t, wal.Close())
+
+	// simulate a crash in the middle of an append
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"type":3,"mess`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	wal, err = NewFileWAL(path)
+	require.NoError(t, err)
+	defer wal.Close()
+
+	entries, err := wal.Entries()
+	require.NoError(t, err)
+	assert.Len(t, entries, len(testWALEntries()))
+}
+
+func TestPbft_WAL_RestartKeepsLock(t *testing.T) {
+	wal := NewMemoryWAL()
+
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	m.wal = wal
+	m.restoreWAL()
+	m.setState(ValidateState)
+
+	// the node locks the proposal in the middle of the round
+	m.emitMsg(&MessageReq{From: "A", Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+	m.emitMsg(&MessageReq{From: "B", Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+	m.emitMsg(&MessageReq{From: "C", Type: MessageReq_Prepare, View: ViewMsg(1, 0)})
+	m.runCycle(context.Background())
+	assert.True(t, m.state.IsLocked())
+
+	// kill the node and start a new instance with the same wal
+	m.Close()
+	restarted := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer restarted.Close()
+	restarted.state.proposal = nil
+	restarted.wal = wal
+	restarted.restoreWAL()
+
+	assert.True(t, restarted.state.IsLocked())
+	assert.Equal(t, digest, restarted.state.proposal.Hash)
+	require.NotNil(t, restarted.state.preparedCert)
+	assert.Len(t, restarted.state.preparedCert.PrepareMessages, 3)
+
+	// the prepare and commit messages are enqueued again
+	assert.Equal(t, 4, restarted.msgQueue.validateStateQueue.Len())
+}
+
+func TestPbft_WAL_NewSequenceTruncates(t *testing.T) {
+	wal := NewMemoryWAL()
+	for _, entry := range testWALEntries() {
+		require.NoError(t, wal.Append(entry))
+	}
+
+	m := newMockPbft(t, []string{"A", "B", "C", "D"}, "A")
+	defer m.Close()
+	m.state.view.Sequence = 2
+	m.wal = wal
+	m.restoreWAL()
+
+	assert.False(t, m.state.IsLocked())
+
+	entries, err := wal.Entries()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, WALSequence, entries[0].Type)
+	assert.Equal(t, uint64(2), entries[0].Sequence)
+}
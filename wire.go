@@ -0,0 +1,509 @@
+package pbft
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WireVersion is the version of the wire schema (wire.proto) the messages are encoded with.
+// Decoders accept the messages of any version and ignore the fields they do not know
+const WireVersion = 6
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var (
+	errWireTruncated = errors.New("truncated message")
+	errWireVersion   = errors.New("missing wire version")
+)
+
+// Marshal encodes the message in the protobuf wire format
+func (m *MessageReq) Marshal() ([]byte, error) {
+	e := &wireEncoder{}
+	if err := m.encode(e); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+// Unmarshal decodes a message encoded by Marshal
+func (m *MessageReq) Unmarshal(data []byte) error {
+	*m = MessageReq{}
+
+	var version uint64
+	err := decodeWire(data, func(field int, f wireField) error {
+		var err error
+		switch field {
+		case 1:
+			version, err = f.varint()
+		case 2:
+			var typ uint64
+			if typ, err = f.varint(); err == nil {
+				if typ > uint64(MessageReq_SyncResponse) {
+					return fmt.Errorf("unknown message type %d", typ)
+				}
+				m.Type = MsgType(typ)
+			}
+		case 3:
+			var from []byte
+			if from, err = f.bytes(); err == nil {
+				m.From = NodeID(from)
+			}
+		case 4:
+			m.Seal, err = f.bytes()
+		case 5:
+			m.View = new(View)
+			err = f.message(m.View.Unmarshal)
+		case 6:
+			m.Hash, err = f.bytes()
+		case 7:
+			m.Proposal, err = f.bytes()
+		case 8:
+			m.RoundChangeCertificate = new(RoundChangeCertificate)
+			err = f.message(m.RoundChangeCertificate.unmarshal)
+		case 9:
+			m.PreparedCertificate = new(PreparedCertificate)
+			err = f.message(m.PreparedCertificate.unmarshal)
+		case 10:
+			vote := new(MessageReq)
+			if err = f.message(vote.Unmarshal); err == nil {
+				m.Votes = append(m.Votes, vote)
+			}
+		case 11:
+			m.Heights = new(HeightRange)
+			err = f.message(m.Heights.unmarshal)
+		case 12:
+			pp := new(SealedProposal)
+			if err = f.message(pp.unmarshal); err == nil {
+				m.SealedProposals = append(m.SealedProposals, pp)
+			}
+		case 13:
+			m.ProposerSeal, err = f.bytes()
+		case 14:
+			m.CommitCertificate = new(SealedProposal)
+			err = f.message(m.CommitCertificate.unmarshal)
+		case 15:
+			m.ParentHash, err = f.bytes()
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		return errWireVersion
+	}
+	return nil
+}
+
+func (m *MessageReq) encode(e *wireEncoder) error {
+	e.varint(1, WireVersion)
+	e.varint(2, uint64(m.Type))
+	e.string(3, string(m.From))
+	e.bytes(4, m.Seal)
+	if m.View != nil {
+		e.message(5, m.View.encode)
+	}
+	e.bytes(6, m.Hash)
+	e.bytes(7, m.Proposal)
+
+	var err error
+	if m.RoundChangeCertificate != nil {
+		e.message(8, func(e *wireEncoder) {
+			err = m.RoundChangeCertificate.encode(e)
+		})
+	}
+	if err == nil && m.PreparedCertificate != nil {
+		e.message(9, func(e *wireEncoder) {
+			err = m.PreparedCertificate.encode(e)
+		})
+	}
+	for _, vote := range m.Votes {
+		if err != nil {
+			break
+		}
+		if vote == nil {
+			return errors.New("message has an empty vote")
+		}
+		e.message(10, func(e *wireEncoder) {
+			err = vote.encode(e)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if m.Heights != nil {
+		e.message(11, m.Heights.encode)
+	}
+	for _, pp := range m.SealedProposals {
+		if pp == nil {
+			return errors.New("message has an empty sealed proposal")
+		}
+		e.message(12, pp.encode)
+	}
+	e.bytes(13, m.ProposerSeal)
+	if m.CommitCertificate != nil {
+		e.message(14, m.CommitCertificate.encode)
+	}
+	e.bytes(15, m.ParentHash)
+	return nil
+}
+
+// Marshal encodes the view in the protobuf wire format
+func (v *View) Marshal() ([]byte, error) {
+	e := &wireEncoder{}
+	v.encode(e)
+	return e.buf, nil
+}
+
+// Unmarshal decodes a view encoded by Marshal
+func (v *View) Unmarshal(data []byte) error {
+	*v = View{}
+
+	return decodeWire(data, func(field int, f wireField) error {
+		var err error
+		switch field {
+		case 1:
+			v.Round, err = f.varint()
+		case 2:
+			v.Sequence, err = f.varint()
+		}
+		return err
+	})
+}
+
+func (v *View) encode(e *wireEncoder) {
+	e.varint(1, v.Round)
+	e.varint(2, v.Sequence)
+}
+
+// Marshal encodes the proposal in the protobuf wire format
+func (p *Proposal) Marshal() ([]byte, error) {
+	e := &wireEncoder{}
+	p.encode(e)
+	return e.buf, nil
+}
+
+func (p *Proposal) encode(e *wireEncoder) {
+	e.bytes(1, p.Data)
+	if !p.Time.IsZero() {
+		e.message(2, func(e *wireEncoder) {
+			e.varint(1, uint64(p.Time.Unix()))
+			e.varint(2, uint64(p.Time.Nanosecond()))
+		})
+	}
+	e.bytes(3, p.Hash)
+	e.bytes(4, p.ParentHash)
+}
+
+// Unmarshal decodes a proposal encoded by Marshal
+func (p *Proposal) Unmarshal(data []byte) error {
+	*p = Proposal{}
+
+	return decodeWire(data, func(field int, f wireField) error {
+		var err error
+		switch field {
+		case 1:
+			p.Data, err = f.bytes()
+		case 2:
+			err = f.message(func(data []byte) error {
+				var sec, nsec uint64
+				err := decodeWire(data, func(field int, f wireField) error {
+					var err error
+					switch field {
+					case 1:
+						sec, err = f.varint()
+					case 2:
+						nsec, err = f.varint()
+					}
+					return err
+				})
+				p.Time = time.Unix(int64(sec), int64(int32(nsec)))
+				return err
+			})
+		case 3:
+			p.Hash, err = f.bytes()
+		case 4:
+			p.ParentHash, err = f.bytes()
+		}
+		return err
+	})
+}
+
+func (pp *SealedProposal) encode(e *wireEncoder) {
+	if pp.Proposal != nil {
+		e.message(1, pp.Proposal.encode)
+	}
+	for _, seal := range pp.CommittedSeals {
+		e.message(2, seal.encode)
+	}
+	e.bytes(3, pp.AggregatedSeal)
+	e.string(4, string(pp.Proposer))
+	e.varint(5, pp.Number)
+	for _, id := range pp.Committers {
+		e.string(6, string(id))
+	}
+}
+
+func (pp *SealedProposal) unmarshal(data []byte) error {
+	return decodeWire(data, func(field int, f wireField) error {
+		var err error
+		switch field {
+		case 1:
+			pp.Proposal = new(Proposal)
+			err = f.message(pp.Proposal.Unmarshal)
+		case 2:
+			var seal CommittedSeal
+			if err = f.message(seal.unmarshal); err == nil {
+				pp.CommittedSeals = append(pp.CommittedSeals, seal)
+			}
+		case 3:
+			pp.AggregatedSeal, err = f.bytes()
+		case 4:
+			var proposer []byte
+			if proposer, err = f.bytes(); err == nil {
+				pp.Proposer = NodeID(proposer)
+			}
+		case 5:
+			pp.Number, err = f.varint()
+		case 6:
+			var id []byte
+			if id, err = f.bytes(); err == nil {
+				pp.Committers = append(pp.Committers, NodeID(id))
+			}
+		}
+		return err
+	})
+}
+
+func (s CommittedSeal) encode(e *wireEncoder) {
+	e.bytes(1, s.Signature)
+	e.string(2, string(s.NodeID))
+}
+
+func (s *CommittedSeal) unmarshal(data []byte) error {
+	return decodeWire(data, func(field int, f wireField) error {
+		var err error
+		switch field {
+		case 1:
+			s.Signature, err = f.bytes()
+		case 2:
+			var id []byte
+			if id, err = f.bytes(); err == nil {
+				s.NodeID = NodeID(id)
+			}
+		}
+		return err
+	})
+}
+
+func (r *HeightRange) encode(e *wireEncoder) {
+	e.varint(1, r.From)
+	e.varint(2, r.To)
+}
+
+func (r *HeightRange) unmarshal(data []byte) error {
+	return decodeWire(data, func(field int, f wireField) error {
+		var err error
+		switch field {
+		case 1:
+			r.From, err = f.varint()
+		case 2:
+			r.To, err = f.varint()
+		}
+		return err
+	})
+}
+
+func (c *PreparedCertificate) encode(e *wireEncoder) error {
+	e.bytes(1, c.Hash)
+	if c.View != nil {
+		e.message(2, c.View.encode)
+	}
+
+	var err error
+	for _, msg := range c.PrepareMessages {
+		if msg == nil {
+			return errors.New("prepared certificate has an empty prepare message")
+		}
+		e.message(3, func(e *wireEncoder) {
+			err = msg.encode(e)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *PreparedCertificate) unmarshal(data []byte) error {
+	return decodeWire(data, func(field int, f wireField) error {
+		var err error
+		switch field {
+		case 1:
+			c.Hash, err = f.bytes()
+		case 2:
+			c.View = new(View)
+			err = f.message(c.View.Unmarshal)
+		case 3:
+			msg := new(MessageReq)
+			if err = f.message(msg.Unmarshal); err == nil {
+				c.PrepareMessages = append(c.PrepareMessages, msg)
+			}
+		}
+		return err
+	})
+}
+
+func (c *RoundChangeCertificate) encode(e *wireEncoder) error {
+	var err error
+	if c.PreparedCertificate != nil {
+		e.message(1, func(e *wireEncoder) {
+			err = c.PreparedCertificate.encode(e)
+		})
+	}
+	return err
+}
+
+func (c *RoundChangeCertificate) unmarshal(data []byte) error {
+	return decodeWire(data, func(field int, f wireField) error {
+		if field != 1 {
+			return nil
+		}
+		c.PreparedCertificate = new(PreparedCertificate)
+		return f.message(c.PreparedCertificate.unmarshal)
+	})
+}
+
+// wireEncoder appends protobuf fields to a buffer. Scalars are skipped when they have
+// the default value, while bytes are written whenever they are not nil
+type wireEncoder struct {
+	buf []byte
+}
+
+func (e *wireEncoder) tag(field int, wireType int) {
+	e.buf = appendUvarint(e.buf, uint64(field)<<3|uint64(wireType))
+}
+
+func (e *wireEncoder) varint(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.buf = appendUvarint(e.buf, v)
+}
+
+func (e *wireEncoder) string(field int, s string) {
+	if s == "" {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.buf = appendUvarint(e.buf, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *wireEncoder) bytes(field int, b []byte) {
+	if b == nil {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.buf = appendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *wireEncoder) message(field int, encode func(e *wireEncoder)) {
+	inner := &wireEncoder{}
+	encode(inner)
+
+	e.tag(field, wireBytes)
+	e.buf = appendUvarint(e.buf, uint64(len(inner.buf)))
+	e.buf = append(e.buf, inner.buf...)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	return append(buf, b[:n]...)
+}
+
+// wireField is a decoded protobuf field
+type wireField struct {
+	wireType int
+	value    uint64
+	data     []byte
+}
+
+func (f wireField) varint() (uint64, error) {
+	if f.wireType != wireVarint {
+		return 0, fmt.Errorf("expected a varint but found wire type %d", f.wireType)
+	}
+	return f.value, nil
+}
+
+func (f wireField) bytes() ([]byte, error) {
+	if f.wireType != wireBytes {
+		return nil, fmt.Errorf("expected bytes but found wire type %d", f.wireType)
+	}
+	return append([]byte{}, f.data...), nil
+}
+
+func (f wireField) message(unmarshal func(data []byte) error) error {
+	if f.wireType != wireBytes {
+		return fmt.Errorf("expected a message but found wire type %d", f.wireType)
+	}
+	return unmarshal(f.data)
+}
+
+// decodeWire calls fn for each field in data, in order. Unknown fields are passed as well,
+// it is up to fn to ignore them
+func decodeWire(data []byte, fn func(field int, f wireField) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errWireTruncated
+		}
+		data = data[n:]
+
+		field, f := int(tag>>3), wireField{wireType: int(tag & 7)}
+		if field == 0 {
+			return errors.New("invalid field number 0")
+		}
+
+		switch f.wireType {
+		case wireVarint:
+			if f.value, n = binary.Uvarint(data); n <= 0 {
+				return errWireTruncated
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return errWireTruncated
+			}
+			f.value, data = binary.LittleEndian.Uint64(data), data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return errWireTruncated
+			}
+			f.value, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errWireTruncated
+			}
+			f.data, data = data[n:n+int(size)], data[n+int(size):]
+		default:
+			return fmt.Errorf("unsupported wire type %d", f.wireType)
+		}
+
+		if err := fn(field, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
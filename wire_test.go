@@ -0,0 +1,297 @@
+package pbft
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func roundTripMessage(t *testing.T, msg *MessageReq) *MessageReq {
+	t.Helper()
+
+	data, err := msg.Marshal()
+	assert.NoError(t, err)
+
+	decoded := new(MessageReq)
+	assert.NoError(t, decoded.Unmarshal(data))
+	return decoded
+}
+
+func TestWire_MessageReq_RoundTrip(t *testing.T) {
+	prepares := []*MessageReq{
+		{Type: MessageReq_Prepare, From: "A", View: ViewMsg(3, 1), Hash: []byte{0x1}},
+		{Type: MessageReq_Prepare, From: "B", View: ViewMsg(3, 1), Hash: []byte{0x1}},
+	}
+	cert := &PreparedCertificate{Hash: []byte{0x1}, View: ViewMsg(3, 1), PrepareMessages: prepares}
+
+	cases := map[string]*MessageReq{
+		"RoundChange": {
+			Type: MessageReq_RoundChange,
+			From: "A",
+			View: ViewMsg(3, 2),
+		},
+		"RoundChange with certificate": {
+			Type:                   MessageReq_RoundChange,
+			From:                   "A",
+			View:                   ViewMsg(3, 2),
+			RoundChangeCertificate: &RoundChangeCertificate{PreparedCertificate: cert},
+		},
+		"RoundChange with empty certificate": {
+			Type:                   MessageReq_RoundChange,
+			From:                   "A",
+			View:                   ViewMsg(3, 2),
+			RoundChangeCertificate: &RoundChangeCertificate{},
+		},
+		"Preprepare": {
+			Type:     MessageReq_Preprepare,
+			From:     "A",
+			View:     ViewMsg(1, 0),
+			Hash:     []byte{0x1, 0x2},
+			Proposal: []byte{0x3, 0x4, 0x5},
+		},
+		"Preprepare with proposer seal": {
+			Type:         MessageReq_Preprepare,
+			From:         "A",
+			View:         ViewMsg(1, 0),
+			Hash:         []byte{0x1, 0x2},
+			Proposal:     []byte{0x3, 0x4, 0x5},
+			ProposerSeal: []byte{0x6, 0x7},
+		},
+		"Preprepare with commit certificate": {
+			Type:     MessageReq_Preprepare,
+			From:     "A",
+			View:     ViewMsg(5, 0),
+			Hash:     []byte{0x1},
+			Proposal: []byte{0x3},
+			CommitCertificate: &SealedProposal{
+				Proposal:       &Proposal{Data: []byte{0x4}, Time: time.Unix(1600000000, 5), Hash: []byte{0x2}, ParentHash: []byte{0x1}},
+				CommittedSeals: []CommittedSeal{{Signature: []byte{0x6}, NodeID: "A"}},
+				Proposer:       "B",
+				Number:         4,
+				Committers:     []NodeID{"A"},
+			},
+		},
+		"Preprepare with parent hash": {
+			Type:       MessageReq_Preprepare,
+			From:       "A",
+			View:       ViewMsg(5, 0),
+			Hash:       []byte{0x1},
+			Proposal:   []byte{0x3},
+			ParentHash: []byte{0x9},
+		},
+		"Preprepare with certificate": {
+			Type:                MessageReq_Preprepare,
+			From:                "A",
+			View:                ViewMsg(3, 2),
+			Hash:                []byte{0x1},
+			Proposal:            []byte{0x3},
+			PreparedCertificate: cert,
+		},
+		"Prepare": {
+			Type: MessageReq_Prepare,
+			From: "B",
+			View: ViewMsg(1, 0),
+			Hash: []byte{0x1, 0x2},
+		},
+		"Commit": {
+			Type: MessageReq_Commit,
+			From: "C",
+			View: ViewMsg(1, 0),
+			Hash: []byte{0x1, 0x2},
+			Seal: []byte{0x6, 0x7},
+		},
+		"Commit with votes": {
+			Type: MessageReq_Commit,
+			From: "A",
+			View: ViewMsg(1, 0),
+			Hash: []byte{0x1, 0x2},
+			Seal: []byte{0x6},
+			Votes: []*MessageReq{
+				{Type: MessageReq_Commit, From: "B", View: ViewMsg(1, 0), Hash: []byte{0x1, 0x2}, Seal: []byte{0x7}},
+				{Type: MessageReq_Commit, From: "C", View: ViewMsg(1, 0), Hash: []byte{0x1, 0x2}, Seal: []byte{0x8}},
+			},
+		},
+		"Empty seal and proposal": {
+			Type:     MessageReq_Commit,
+			From:     "C",
+			View:     ViewMsg(1, 0),
+			Hash:     []byte{},
+			Seal:     []byte{},
+			Proposal: []byte{},
+		},
+		"No view": {
+			Type: MessageReq_Prepare,
+			From: "B",
+			Hash: []byte{0x1},
+		},
+		"SyncRequest": {
+			Type:    MessageReq_SyncRequest,
+			From:    "D",
+			View:    ViewMsg(4, 0),
+			Heights: &HeightRange{From: 4, To: 35},
+		},
+		"SyncResponse": {
+			Type: MessageReq_SyncResponse,
+			From: "A",
+			View: ViewMsg(4, 0),
+			SealedProposals: []*SealedProposal{
+				{
+					Proposal:       &Proposal{Data: []byte{0x3}, Time: time.Unix(1600000000, 5), Hash: []byte{0x1}},
+					CommittedSeals: []CommittedSeal{{Signature: []byte{0x6}, NodeID: "A"}, {Signature: []byte{0x7}, NodeID: "B"}},
+					Proposer:       "B",
+					Number:         4,
+					Committers:     []NodeID{"A", "B"},
+				},
+				{
+					Proposal:       &Proposal{Data: []byte{}, Hash: []byte{0x2}},
+					AggregatedSeal: []byte{0x8},
+					Proposer:       "C",
+					Number:         5,
+				},
+			},
+		},
+	}
+
+	for name, msg := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, msg, roundTripMessage(t, msg))
+		})
+	}
+}
+
+func TestWire_MessageReq_OptionalFields(t *testing.T) {
+	// nil fields stay nil, they are not decoded as empty slices
+	decoded := roundTripMessage(t, &MessageReq{Type: MessageReq_Prepare, View: ViewMsg(1, 0), Hash: []byte{0x1}})
+	assert.Nil(t, decoded.Seal)
+	assert.Nil(t, decoded.Proposal)
+	assert.Nil(t, decoded.RoundChangeCertificate)
+	assert.Nil(t, decoded.PreparedCertificate)
+
+	// empty fields stay empty
+	decoded = roundTripMessage(t, &MessageReq{Type: MessageReq_Commit, View: ViewMsg(1, 0), Hash: []byte{0x1}, Seal: []byte{}})
+	assert.NotNil(t, decoded.Seal)
+	assert.Empty(t, decoded.Seal)
+}
+
+func TestWire_MessageReq_UnknownFieldsIgnored(t *testing.T) {
+	msg := &MessageReq{
+		Type: MessageReq_Commit,
+		From: "A",
+		View: ViewMsg(1, 0),
+		Hash: []byte{0x1},
+		Seal: []byte{0x2},
+	}
+	data, err := msg.Marshal()
+	assert.NoError(t, err)
+
+	// a newer version of the schema adds fields of every wire type
+	e := &wireEncoder{buf: data}
+	e.varint(100, 42)
+	e.bytes(101, []byte("future"))
+	e.message(102, func(e *wireEncoder) {
+		e.varint(1, 1)
+	})
+	e.tag(103, wireFixed64)
+	e.buf = append(e.buf, make([]byte, 8)...)
+	e.tag(104, wireFixed32)
+	e.buf = append(e.buf, make([]byte, 4)...)
+
+	decoded := new(MessageReq)
+	assert.NoError(t, decoded.Unmarshal(e.buf))
+	assert.Equal(t, msg, decoded)
+}
+
+func TestWire_MessageReq_Invalid(t *testing.T) {
+	valid, err := (&MessageReq{Type: MessageReq_Prepare, From: "A", View: ViewMsg(1, 0), Hash: []byte{0x1}}).Marshal()
+	assert.NoError(t, err)
+
+	t.Run("Truncated", func(t *testing.T) {
+		// the hash is the last field, cutting it leaves a field shorter than its length
+		assert.ErrorIs(t, new(MessageReq).Unmarshal(valid[:len(valid)-1]), errWireTruncated)
+		// a dangling tag with no value
+		assert.ErrorIs(t, new(MessageReq).Unmarshal(append(valid, 0x8)), errWireTruncated)
+	})
+
+	t.Run("Missing version", func(t *testing.T) {
+		e := &wireEncoder{}
+		e.varint(2, uint64(MessageReq_Prepare))
+		assert.ErrorIs(t, new(MessageReq).Unmarshal(e.buf), errWireVersion)
+	})
+
+	t.Run("Unknown type", func(t *testing.T) {
+		e := &wireEncoder{}
+		e.varint(1, WireVersion)
+		e.varint(2, 10)
+		assert.Error(t, new(MessageReq).Unmarshal(e.buf))
+	})
+
+	t.Run("Wrong wire type", func(t *testing.T) {
+		e := &wireEncoder{}
+		e.varint(1, WireVersion)
+		e.varint(6, 1)
+		assert.Error(t, new(MessageReq).Unmarshal(e.buf))
+	})
+
+	t.Run("Nil prepare message", func(t *testing.T) {
+		msg := &MessageReq{
+			Type:                MessageReq_Preprepare,
+			View:                ViewMsg(1, 0),
+			Hash:                []byte{0x1},
+			PreparedCertificate: &PreparedCertificate{PrepareMessages: []*MessageReq{nil}},
+		}
+		_, err := msg.Marshal()
+		assert.Error(t, err)
+	})
+
+	t.Run("Nil sealed proposal", func(t *testing.T) {
+		msg := &MessageReq{
+			Type:            MessageReq_SyncResponse,
+			View:            ViewMsg(1, 0),
+			SealedProposals: []*SealedProposal{nil},
+		}
+		_, err := msg.Marshal()
+		assert.Error(t, err)
+	})
+
+	t.Run("Nil vote", func(t *testing.T) {
+		msg := &MessageReq{
+			Type:  MessageReq_Prepare,
+			View:  ViewMsg(1, 0),
+			Hash:  []byte{0x1},
+			Votes: []*MessageReq{nil},
+		}
+		_, err := msg.Marshal()
+		assert.Error(t, err)
+	})
+}
+
+func TestWire_View_RoundTrip(t *testing.T) {
+	for _, v := range []*View{ViewMsg(0, 0), ViewMsg(1, 0), ViewMsg(0, 1), ViewMsg(^uint64(0), 7)} {
+		data, err := v.Marshal()
+		assert.NoError(t, err)
+
+		decoded := new(View)
+		assert.NoError(t, decoded.Unmarshal(data))
+		assert.Equal(t, v, decoded)
+	}
+}
+
+func TestWire_Proposal_RoundTrip(t *testing.T) {
+	cases := []*Proposal{
+		{Data: []byte{0x1, 0x2}, Time: time.Unix(1650000000, 123), Hash: []byte{0x3}},
+		{Data: []byte{}, Hash: []byte{}},
+		{Time: time.Unix(-10, 5)},
+		{},
+	}
+	for _, p := range cases {
+		data, err := p.Marshal()
+		assert.NoError(t, err)
+
+		decoded := new(Proposal)
+		assert.NoError(t, decoded.Unmarshal(data))
+		assert.Equal(t, p.Data, decoded.Data)
+		assert.Equal(t, p.Hash, decoded.Hash)
+		assert.True(t, p.Time.Equal(decoded.Time))
+	}
+}